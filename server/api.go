@@ -329,6 +329,15 @@ func getStartOffset(req *client.SubscribeRequest, log commitlog.CommitLog) (int6
 			fmt.Sprintf("Unknown StartPosition %s", req.StartPosition))
 	}
 
+	// Offsets outside of the log are capped to it, so a subscription starting
+	// past the end of the log waits for new messages.
+	if next := log.NewestOffset() + 1; startOffset > next {
+		startOffset = next
+	}
+	if oldest := log.OldestOffset(); startOffset < oldest {
+		startOffset = oldest
+	}
+
 	// If log is empty, next offset will be 0.
 	if startOffset < 0 {
 		startOffset = 0
@@ -0,0 +1,112 @@
+package commitlog
+
+import "sync/atomic"
+
+// ackSet tracks which delivered offsets have not been acknowledged and how
+// many times each was delivered. It holds a delivery count for each offset
+// from base onward, where zero means the offset was acked or never
+// delivered, e.g. because compaction removed it. The counts are trimmed as
+// the lowest offsets are acked, so their size is bounded by the distance
+// between the lowest unacked offset and the latest delivery.
+type ackSet struct {
+	base     int64
+	attempts []int
+}
+
+// deliver records the given offset as delivered but unacked and returns the
+// number of times it has been delivered, counting redeliveries of an unacked
+// offset. New offsets must be delivered in increasing order. Redelivering an
+// acked offset does not mark it unacked again.
+func (a *ackSet) deliver(offset int64) int {
+	if len(a.attempts) == 0 {
+		a.base = offset
+	}
+	idx := offset - a.base
+	if idx < 0 {
+		return 1
+	}
+	if idx < int64(len(a.attempts)) {
+		if a.attempts[idx] == 0 {
+			return 1
+		}
+		a.attempts[idx]++
+		return a.attempts[idx]
+	}
+	for int64(len(a.attempts)) < idx {
+		a.attempts = append(a.attempts, 0)
+	}
+	a.attempts = append(a.attempts, 1)
+	return 1
+}
+
+// ack marks the given offset as acked. This is a no-op if the offset was not
+// delivered or was already acked.
+func (a *ackSet) ack(offset int64) {
+	idx := offset - a.base
+	if idx < 0 || idx >= int64(len(a.attempts)) {
+		return
+	}
+	a.attempts[idx] = 0
+	// Trim acked offsets from the front.
+	n := 0
+	for n < len(a.attempts) && a.attempts[n] == 0 {
+		n++
+	}
+	a.attempts = a.attempts[n:]
+	a.base += int64(n)
+}
+
+// lowest returns the lowest unacked offset or -1 if every delivered offset
+// has been acked.
+func (a *ackSet) lowest() int64 {
+	if len(a.attempts) == 0 {
+		return -1
+	}
+	return a.base
+}
+
+// Ack acknowledges the message at the given offset, indicating it has been
+// processed. This is only meaningful for Readers created with the TrackAcks
+// option and is a no-op for offsets which were not delivered or were already
+// acked. Unlike ReadMessage, Ack is safe to call concurrently.
+func (r *Reader) Ack(offset int64) {
+	r.acksMu.Lock()
+	r.acks.ack(offset)
+	r.acksMu.Unlock()
+}
+
+// LowestUnacked returns the lowest offset delivered by ReadMessage which has
+// not been acked. If every delivered message has been acked, this returns the
+// offset of the next message to read. This is where redelivery should start
+// when a consumer reconnects. It is safe to call concurrently.
+func (r *Reader) LowestUnacked() int64 {
+	r.acksMu.Lock()
+	defer r.acksMu.Unlock()
+	if lowest := r.acks.lowest(); lowest != -1 {
+		return lowest
+	}
+	return atomic.LoadInt64(&r.offset)
+}
+
+// DeliveryAttempt returns the number of times the last message returned by
+// ReadMessage has been delivered, e.g. 2 for its first redelivery, so that
+// consumers can implement retry and dead-letter policies. Redeliveries are
+// only counted for Readers created with the TrackAcks option while the
+// message is unacked, e.g. when the Reader is repositioned at LowestUnacked
+// or a transaction is aborted. Otherwise, this returns 1. It returns 0 if no
+// message has been returned.
+func (r *Reader) DeliveryAttempt() int {
+	return r.attempt
+}
+
+// trackDelivery records the given offset as delivered, counting the delivery
+// attempt if the Reader tracks acks.
+func (r *Reader) trackDelivery(offset int64) {
+	if !r.opts.TrackAcks {
+		r.attempt = 1
+		return
+	}
+	r.acksMu.Lock()
+	r.attempt = r.acks.deliver(offset)
+	r.acksMu.Unlock()
+}
@@ -0,0 +1,142 @@
+package commitlog
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAckSet(t *testing.T) {
+	var acks ackSet
+	require.Equal(t, int64(-1), acks.lowest())
+
+	// Offset 3 is never delivered, e.g. because it was compacted.
+	for _, offset := range []int64{1, 2, 4, 5} {
+		acks.deliver(offset)
+	}
+	require.Equal(t, int64(1), acks.lowest())
+
+	acks.ack(2)
+	require.Equal(t, int64(1), acks.lowest())
+	acks.ack(1)
+	require.Equal(t, int64(4), acks.lowest())
+	acks.ack(1)
+	acks.ack(100)
+	require.Equal(t, int64(4), acks.lowest())
+	acks.ack(5)
+	acks.ack(4)
+	require.Equal(t, int64(-1), acks.lowest())
+
+	acks.deliver(6)
+	require.Equal(t, int64(6), acks.lowest())
+}
+
+// Ensure ackSet counts redeliveries of unacked offsets only.
+func TestAckSetAttempts(t *testing.T) {
+	var acks ackSet
+	for _, offset := range []int64{1, 2, 3} {
+		require.Equal(t, 1, acks.deliver(offset))
+	}
+	acks.ack(2)
+	require.Equal(t, 2, acks.deliver(1))
+	require.Equal(t, 1, acks.deliver(2))
+	require.Equal(t, 2, acks.deliver(3))
+	require.Equal(t, 3, acks.deliver(3))
+	require.Equal(t, int64(1), acks.lowest())
+
+	acks.ack(1)
+	require.Equal(t, 1, acks.deliver(1))
+	require.Equal(t, int64(3), acks.lowest())
+}
+
+// Ensure Readers tracking acks report the delivery attempt of messages
+// redelivered after repositioning at the lowest unacked offset.
+func TestReaderDeliveryAttempt(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	for i := 0; i < 3; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(2)
+
+	r, err := l.NewReaderWithOptions(0, ReaderOptions{TrackAcks: true})
+	require.NoError(t, err)
+	require.Equal(t, 0, r.DeliveryAttempt())
+	d := NewDeliveryReader(r)
+	read := func(offset int64, attempt int) {
+		delivery, err := d.Read(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, offset, delivery.Offset)
+		require.Equal(t, attempt, delivery.Attempt)
+		require.Equal(t, attempt, r.DeliveryAttempt())
+	}
+	read(0, 1)
+	read(1, 1)
+	read(2, 1)
+	r.Ack(1)
+
+	for attempt := 2; attempt <= 3; attempt++ {
+		_, err = r.SeekBy(context.Background(), r.LowestUnacked(), nil)
+		require.NoError(t, err)
+		read(0, attempt)
+		read(1, 1)
+		read(2, attempt)
+	}
+}
+
+func TestReaderAck(t *testing.T) {
+	opts := Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+
+	numMsgs := 5
+	for i := 0; i < numMsgs; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(int64(numMsgs - 1))
+
+	store := NewFileCursorStore(filepath.Join(opts.Path, "cursor"))
+	readerOpts := ReaderOptions{Cursor: store, TrackAcks: true}
+	r, err := l.NewReaderWithOptions(0, readerOpts)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), r.LowestUnacked())
+
+	headers := make([]byte, 28)
+	for i := 0; i < 4; i++ {
+		_, _, _, _, err := r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+	}
+	require.Equal(t, int64(0), r.LowestUnacked())
+	r.Ack(0)
+	r.Ack(2)
+	require.Equal(t, int64(1), r.LowestUnacked())
+	r.Ack(1)
+	r.Ack(3)
+	// Every delivered message is acked, so this is the next offset to read.
+	require.Equal(t, int64(4), r.LowestUnacked())
+
+	// Read the last message without acking it and reconnect.
+	_, _, _, _, err = r.ReadMessage(context.Background(), headers)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), r.LowestUnacked())
+	r, err = l.NewReaderWithOptions(0, readerOpts)
+	require.NoError(t, err)
+	msg, offset, _, _, err := r.ReadMessage(context.Background(), headers)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), offset)
+	require.Equal(t, []byte("4"), msg.Value())
+	require.NoError(t, l.Close())
+}
@@ -0,0 +1,24 @@
+package commitlog
+
+// Allocator allocates the buffers which a Reader reads message payloads into,
+// giving control over their lifetime, e.g. an arena from which the payloads
+// of a short-lived stream are freed in bulk once it ends.
+type Allocator interface {
+	// Alloc returns a buffer of length n.
+	Alloc(n int) []byte
+
+	// Reset frees every buffer allocated so far. It is called by
+	// Reader.Close, after which messages returned by the Reader must no
+	// longer be used.
+	Reset()
+}
+
+// heapAllocator is the default Allocator, which allocates buffers on the heap
+// and leaves them to the garbage collector.
+type heapAllocator struct{}
+
+func (heapAllocator) Alloc(n int) []byte {
+	return make([]byte, n)
+}
+
+func (heapAllocator) Reset() {}
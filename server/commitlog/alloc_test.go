@@ -0,0 +1,65 @@
+package commitlog
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// arena is an Allocator which allocates buffers from a single slab.
+type arena struct {
+	slab   []byte
+	allocs int
+	resets int
+}
+
+func (a *arena) Alloc(n int) []byte {
+	if len(a.slab)+n > cap(a.slab) {
+		return make([]byte, n)
+	}
+	a.allocs++
+	a.slab = a.slab[:len(a.slab)+n]
+	return a.slab[len(a.slab)-n : len(a.slab) : len(a.slab)]
+}
+
+func (a *arena) Reset() {
+	a.slab = a.slab[:0]
+	a.resets++
+}
+
+// Ensure Readers read payloads into buffers from their Allocator and reset it
+// when closed.
+func TestReaderAllocator(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	numMsgs := 5
+	for i := 0; i < numMsgs; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(int64(numMsgs - 1))
+
+	a := &arena{slab: make([]byte, 0, 4096)}
+	r, err := l.NewReaderWithOptions(0, ReaderOptions{Allocator: a})
+	require.NoError(t, err)
+	headers := make([]byte, 28)
+	for i := 0; i < numMsgs; i++ {
+		msg, offset, _, _, err := r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+		require.Equal(t, int64(i), offset)
+		require.Equal(t, []byte(strconv.Itoa(i)), msg.Value())
+	}
+	require.Equal(t, numMsgs, a.allocs)
+	require.NotEmpty(t, a.slab)
+
+	require.NoError(t, r.Close())
+	require.Equal(t, 1, a.resets)
+	require.Empty(t, a.slab)
+}
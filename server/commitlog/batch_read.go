@@ -0,0 +1,55 @@
+package commitlog
+
+import (
+	"context"
+	"fmt"
+)
+
+// MessageError is a failure to read or decode the message at Offset which is
+// isolated to that message, so reading continues with the following message.
+type MessageError struct {
+	Offset int64
+	Err    error
+}
+
+// Error returns a string describing the failure.
+func (e *MessageError) Error() string {
+	return fmt.Sprintf("failed to read message at offset %d: %v", e.Offset, e.Err)
+}
+
+// Cause returns the error the message failed with.
+func (e *MessageError) Cause() error {
+	return e.Err
+}
+
+// ReadMessages reads up to max messages from the Reader, blocking until max
+// messages are read, the context is canceled, or a read fails. A message whose
+// transform fails under TransformAbort does not fail the batch. Instead, a
+// MessageError for it is returned alongside the messages read, and it counts
+// toward max. Any other read failure, such as io.EOF at the end of the stream,
+// a canceled context, or a closed log, stops the batch and is returned along
+// with the messages and message errors read before it.
+func (r *Reader) ReadMessages(ctx context.Context, max int) ([]ReadResult, []*MessageError, error) {
+	var (
+		results  []ReadResult
+		failures []*MessageError
+		headers  = make([]byte, msgSetHeaderLen)
+	)
+	for len(results)+len(failures) < max {
+		msg, offset, timestamp, leaderEpoch, err := r.ReadMessage(ctx, headers)
+		if transformErr, ok := err.(*ErrTransform); ok {
+			failures = append(failures, &MessageError{Offset: transformErr.Offset, Err: transformErr.Err})
+			continue
+		}
+		if err != nil {
+			return results, failures, err
+		}
+		results = append(results, ReadResult{
+			Message:     msg,
+			Offset:      offset,
+			Timestamp:   timestamp,
+			LeaderEpoch: leaderEpoch,
+		})
+	}
+	return results, failures, nil
+}
@@ -0,0 +1,58 @@
+package commitlog
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// Ensure ReadMessages returns messages whose transform fails as message errors
+// without failing the batch and stops at the end of the stream.
+func TestReaderReadMessages(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	appendToLog(t, l, []keyValue{
+		{value: []byte("a")},
+		{value: []byte("bad")},
+		{value: []byte("c")},
+		{value: []byte("d")},
+	}, true)
+	eos, err := l.CloseStream()
+	require.NoError(t, err)
+	l.SetHighWatermark(eos)
+
+	transformErr := errors.New("transform failed")
+	r, err := l.NewReaderWithOptions(0, ReaderOptions{
+		Transform: func(msg SerializedMessage) (SerializedMessage, error) {
+			if string(msg.Value()) == "bad" {
+				return nil, transformErr
+			}
+			return msg, nil
+		},
+	})
+	require.NoError(t, err)
+
+	results, failures, err := r.ReadMessages(context.Background(), 3)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	require.Equal(t, int64(0), results[0].Offset)
+	require.Equal(t, []byte("a"), results[0].Message.Value())
+	require.Equal(t, int64(2), results[1].Offset)
+	require.Equal(t, []*MessageError{{Offset: 1, Err: transformErr}}, failures)
+	require.Equal(t, transformErr, errors.Cause(failures[0]))
+
+	// The end of the stream stops the batch.
+	results, failures, err = r.ReadMessages(context.Background(), 3)
+	require.Equal(t, io.EOF, err)
+	require.Len(t, results, 1)
+	require.Equal(t, int64(3), results[0].Offset)
+	require.Empty(t, failures)
+}
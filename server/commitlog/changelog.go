@@ -0,0 +1,58 @@
+package commitlog
+
+import "context"
+
+// KeyedMessage is a message read by a ChangelogReader, split into its key and
+// value.
+type KeyedMessage struct {
+	Key       []byte
+	Value     []byte
+	Offset    int64
+	Timestamp int64
+
+	// Tombstone indicates the message has a nil value, meaning its key has
+	// been deleted.
+	Tombstone bool
+}
+
+// ChangelogReader wraps a Reader to consume a partition as a changelog of
+// key-value updates, e.g. to materialize the latest value for each key. This
+// pairs with log compaction, which retains the latest message for each key.
+// Messages without a key carry no update and are skipped. Like Reader, it
+// should not be used concurrently.
+type ChangelogReader struct {
+	reader  *Reader
+	headers []byte
+}
+
+// NewChangelogReader creates a new ChangelogReader which reads messages from
+// the given Reader.
+func NewChangelogReader(reader *Reader) *ChangelogReader {
+	return &ChangelogReader{
+		reader:  reader,
+		headers: make([]byte, msgSetHeaderLen),
+	}
+}
+
+// Read reads the next keyed message, blocking until one is available. A nil
+// value, i.e. a tombstone, is distinct from an empty value.
+func (c *ChangelogReader) Read(ctx context.Context) (*KeyedMessage, error) {
+	for {
+		msg, offset, timestamp, _, err := c.reader.ReadMessage(ctx, c.headers)
+		if err != nil {
+			return nil, err
+		}
+		key := msg.Key()
+		if key == nil {
+			continue
+		}
+		value := msg.Value()
+		return &KeyedMessage{
+			Key:       key,
+			Value:     value,
+			Offset:    offset,
+			Timestamp: timestamp,
+			Tombstone: value == nil,
+		}, nil
+	}
+}
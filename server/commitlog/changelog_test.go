@@ -0,0 +1,55 @@
+package commitlog
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Ensure ChangelogReader splits messages into keys and values, represents
+// tombstones, and skips keyless messages.
+func TestChangelogReader(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1000,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	msgs := []*Message{
+		{Key: []byte("a"), Value: []byte("1")},
+		{Value: []byte("keyless")},
+		{Key: []byte("b"), Value: []byte{}},
+		{Key: []byte("a")},
+	}
+	_, err := l.Append(msgs)
+	require.NoError(t, err)
+	l.SetHighWatermark(int64(len(msgs) - 1))
+
+	reader, err := l.NewReader(0, false)
+	require.NoError(t, err)
+	r := NewChangelogReader(reader)
+
+	msg, err := r.Read(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []byte("a"), msg.Key)
+	require.Equal(t, []byte("1"), msg.Value)
+	require.Equal(t, int64(0), msg.Offset)
+	require.False(t, msg.Tombstone)
+
+	// An empty value is not a tombstone.
+	msg, err = r.Read(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []byte("b"), msg.Key)
+	require.Equal(t, int64(2), msg.Offset)
+	require.NotNil(t, msg.Value)
+	require.False(t, msg.Tombstone)
+
+	msg, err = r.Read(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []byte("a"), msg.Key)
+	require.Nil(t, msg.Value)
+	require.Equal(t, int64(3), msg.Offset)
+	require.True(t, msg.Tombstone)
+}
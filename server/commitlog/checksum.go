@@ -0,0 +1,37 @@
+package commitlog
+
+// StreamChecksumInit is the checksum of a stream before any message, i.e. the
+// FNV-1a 64-bit offset basis.
+const StreamChecksumInit uint64 = 14695981039346656037
+
+const fnvPrime64 = 1099511628211
+
+// UpdateStreamChecksum returns the rolling checksum of a stream whose checksum
+// was sum followed by a message with the given value. The checksum is FNV-1a
+// over each value prefixed by its length, so values are not confused when
+// their boundaries shift. Producers can compute it over the values they
+// publish, starting from StreamChecksumInit, and compare it with
+// Reader.StreamChecksum to verify a consumer saw exactly the same bytes.
+func UpdateStreamChecksum(sum uint64, value []byte) uint64 {
+	var size [4]byte
+	encoding.PutUint32(size[:], uint32(len(value)))
+	for _, b := range size {
+		sum ^= uint64(b)
+		sum *= fnvPrime64
+	}
+	for _, b := range value {
+		sum ^= uint64(b)
+		sum *= fnvPrime64
+	}
+	return sum
+}
+
+// StreamChecksum returns the rolling checksum, as computed by
+// UpdateStreamChecksum, of the values of every message returned by the
+// Reader as stored in the log, i.e. before any Transform. Placeholders
+// returned when filling gaps are not included, and messages rewound by an
+// aborted transaction are removed. The checksum restarts from
+// StreamChecksumInit when the Reader is otherwise repositioned, e.g. by Seek.
+func (r *Reader) StreamChecksum() uint64 {
+	return r.checksum
+}
@@ -0,0 +1,62 @@
+package commitlog
+
+import (
+	"context"
+	"hash/fnv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Ensure the Reader's stream checksum matches the checksum computed over the
+// published values and is restored when a transaction is aborted.
+func TestReaderStreamChecksum(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	values := [][]byte{[]byte("foo"), nil, []byte("bar"), []byte("baz")}
+	expected := StreamChecksumInit
+	for _, value := range values {
+		_, err := l.Append([]*Message{{Value: value}})
+		require.NoError(t, err)
+		expected = UpdateStreamChecksum(expected, value)
+	}
+	l.SetHighWatermark(int64(len(values) - 1))
+
+	r, err := l.NewReader(0, false)
+	require.NoError(t, err)
+	require.Equal(t, StreamChecksumInit, r.StreamChecksum())
+	headers := make([]byte, 28)
+	_, _, _, _, err = r.ReadMessage(context.Background(), headers)
+	require.NoError(t, err)
+	afterFirst := r.StreamChecksum()
+
+	txn, err := r.ReadTxn(context.Background(), 3)
+	require.NoError(t, err)
+	require.Equal(t, expected, r.StreamChecksum())
+	require.NoError(t, txn.Abort())
+	require.Equal(t, afterFirst, r.StreamChecksum())
+
+	for range values[1:] {
+		_, _, _, _, err = r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+	}
+	require.Equal(t, expected, r.StreamChecksum())
+}
+
+// Ensure the stream checksum is FNV-1a over length-prefixed values, so values
+// whose boundaries shift have different checksums.
+func TestUpdateStreamChecksum(t *testing.T) {
+	h := fnv.New64a()
+	h.Write([]byte{0, 0, 0, 3})
+	h.Write([]byte("foo"))
+	require.Equal(t, h.Sum64(), UpdateStreamChecksum(StreamChecksumInit, []byte("foo")))
+
+	a := UpdateStreamChecksum(UpdateStreamChecksum(StreamChecksumInit, []byte("fo")), []byte("o"))
+	b := UpdateStreamChecksum(UpdateStreamChecksum(StreamChecksumInit, []byte("f")), []byte("oo"))
+	require.NotEqual(t, a, b)
+}
@@ -0,0 +1,29 @@
+package commitlog
+
+import (
+	"context"
+	"time"
+)
+
+const defaultCommitFloorInterval = 10 * time.Millisecond
+
+// waitCommitFloor blocks until the Reader's CommitFloor reaches the given
+// offset or the context is done.
+func (r *Reader) waitCommitFloor(ctx context.Context, offset int64) error {
+	interval := r.opts.CommitFloorInterval
+	if interval <= 0 {
+		interval = defaultCommitFloorInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for offset > r.opts.CommitFloor() {
+		select {
+		case <-ticker.C:
+		case <-r.log.closed:
+			return ErrLogClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package commitlog
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Ensure Readers with a CommitFloor block at the floor, even below the HW,
+// and resume once it advances without losing the held message.
+func TestReaderCommitFloor(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1000,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	for i := 0; i < 5; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(4)
+
+	floor := int64(1)
+	r, err := l.NewReaderWithOptions(0, ReaderOptions{
+		CommitFloor:         func() int64 { return atomic.LoadInt64(&floor) },
+		CommitFloorInterval: time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	headers := make([]byte, 28)
+	read := func(ctx context.Context) (int64, error) {
+		_, offset, _, _, err := r.ReadMessage(ctx, headers)
+		return offset, err
+	}
+	for i := int64(0); i <= 1; i++ {
+		offset, err := read(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, i, offset)
+	}
+
+	// The read blocks at the floor and returns when the context is done.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = read(ctx)
+	require.Equal(t, context.DeadlineExceeded, err)
+
+	// The message read before the context was done is returned once the
+	// floor advances.
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		atomic.StoreInt64(&floor, 3)
+	}()
+	for i := int64(2); i <= 3; i++ {
+		offset, err := read(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, i, offset)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = read(ctx)
+	require.Equal(t, context.DeadlineExceeded, err)
+}
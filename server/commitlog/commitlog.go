@@ -2,10 +2,12 @@
 package commitlog
 
 import (
+	"context"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -19,8 +21,47 @@ import (
 	"github.com/liftbridge-io/liftbridge/server/logger"
 )
 
-// ErrSegmentNotFound is returned if the segment could not be found.
-var ErrSegmentNotFound = errors.New("segment not found")
+var (
+	// ErrSegmentNotFound is returned if the segment could not be found.
+	ErrSegmentNotFound = errors.New("segment not found")
+
+	// ErrInvalidOffset is returned if a reader is created with an offset that
+	// cannot be a valid log offset, such as a negative offset.
+	ErrInvalidOffset = errors.New("invalid offset")
+
+	// ErrOffsetOutOfRange is returned if a reader is created with an offset
+	// outside of the log, i.e. less than the base offset of its first segment
+	// or greater than the log end offset.
+	ErrOffsetOutOfRange = errors.New("offset out of range")
+
+	// ErrLogClosed is returned by Readers waiting for data when the log is
+	// closed.
+	ErrLogClosed = errors.New("log has been closed")
+
+	// ErrGapTooLarge is returned by a Reader filling gaps when a gap in the
+	// log's offsets is larger than it is allowed to fill.
+	ErrGapTooLarge = errors.New("offset gap too large to fill")
+
+	// ErrDeadlineExceeded is returned by a Reader once its total deadline has
+	// passed.
+	ErrDeadlineExceeded = errors.New("reader deadline exceeded")
+
+	// ErrTemporary indicates a transient storage error which may succeed if
+	// retried. See RetryPolicy.
+	ErrTemporary = errors.New("temporary storage error")
+
+	// ErrSequenceGap is returned by a Reader verifying producer sequences
+	// when a producer's sequence numbers were skipped.
+	ErrSequenceGap = errors.New("producer sequence gap")
+
+	// ErrSequenceDuplicate is returned by a Reader verifying producer
+	// sequences when a producer's sequence number was already read.
+	ErrSequenceDuplicate = errors.New("duplicate producer sequence")
+
+	// ErrSearchBudgetExceeded is returned by lookups given a SearchBudget when
+	// the lookup cannot complete within the budget.
+	ErrSearchBudgetExceeded = errors.New("search budget exceeded")
+)
 
 const (
 	logFileSuffix               = ".log"
@@ -29,6 +70,11 @@ const (
 	defaultMaxSegmentBytes      = 1073741824
 	defaultHWCheckpointInterval = 5 * time.Second
 	defaultCleanerInterval      = 5 * time.Minute
+	defaultHWNotifyWindow       = 250 * time.Microsecond
+	cloneChunkBytes             = 1 << 20
+	minMaxBatchBytes            = 1 << 20
+	defaultMaxGapFill           = 10000
+	segmentRollsBufferSize      = 64
 )
 
 // commitLog implements the CommitLog interface, which is a durable write-ahead
@@ -39,28 +85,38 @@ type commitLog struct {
 	compactCleaner   *compactCleaner
 	name             string
 	mu               sync.RWMutex
+	cleanMu          sync.Mutex
 	hw               int64
 	closed           chan struct{}
 	segments         []*segment
 	vActiveSegment   *segment
 	hwWaiters        map[contextReader]chan struct{}
+	hwNotifyTimer    *time.Timer
 	leaderEpochCache *leaderEpochCache
+	generation       uint64
+	loader           *segmentLoader
+	rolls            chan int64
 }
 
 // Options contains settings for configuring a commitLog.
 type Options struct {
-	Name                 string        // commitLog name
-	Path                 string        // Path to log directory
-	MaxSegmentBytes      int64         // Max bytes a Segment can contain before creating a new one
-	MaxLogBytes          int64         // Retention by bytes
-	MaxLogMessages       int64         // Retention by messages
-	MaxLogAge            time.Duration // Retention by age
-	Compact              bool          // Run compaction on log clean
-	CompactMaxGoroutines int           // Max number of goroutines to use in a log compaction
-	CleanerInterval      time.Duration // Frequency to enforce retention policy
-	HWCheckpointInterval time.Duration // Frequency to checkpoint HW to disk
-	LogRollTime          time.Duration // Max time before a new log segment is rolled out.
-	Logger               logger.Logger
+	Name                  string        // commitLog name
+	Path                  string        // Path to log directory
+	MaxSegmentBytes       int64         // Max bytes a Segment can contain before creating a new one
+	MaxLogBytes           int64         // Retention by bytes
+	MaxLogMessages        int64         // Retention by messages
+	MaxLogAge             time.Duration // Retention by age
+	Compact               bool          // Run compaction on log clean
+	CompactMaxGoroutines  int           // Max number of goroutines to use in a log compaction
+	CompactTombstoneGrace time.Duration // Age after which compaction removes tombstones, 0 retains them
+	CleanerInterval       time.Duration // Frequency to enforce retention policy
+	HWCheckpointInterval  time.Duration // Frequency to checkpoint HW to disk
+	LogRollTime           time.Duration // Max time before a new log segment is rolled out.
+	HWNotifyWindow        time.Duration // Window to coalesce HW updates before waking readers, adding up to this much commit-to-read latency, negative disables
+	DebugReaderLeaks      bool          // Warn when Readers are garbage collected while waiting for data
+	MaxLoadedSegments     int           // Load existing segments lazily, keeping at most this many open, 0 loads them eagerly
+	HeaderFormat          HeaderFormat  // Format of message set headers written to segments, HeaderFormatFixed by default
+	Logger                logger.Logger
 }
 
 // New creates a new CommitLog and starts a background goroutine which
@@ -84,6 +140,9 @@ func New(opts Options) (CommitLog, error) {
 	if opts.CleanerInterval == 0 {
 		opts.CleanerInterval = defaultCleanerInterval
 	}
+	if opts.HWNotifyWindow == 0 {
+		opts.HWNotifyWindow = defaultHWNotifyWindow
+	}
 
 	cleanerOpts := deleteCleanerOptions{
 		Name:   opts.Path,
@@ -95,9 +154,10 @@ func New(opts Options) (CommitLog, error) {
 	cleaner := newDeleteCleaner(cleanerOpts)
 
 	compactCleanerOpts := compactCleanerOptions{
-		Name:          opts.Name,
-		Logger:        opts.Logger,
-		MaxGoroutines: opts.CompactMaxGoroutines,
+		Name:           opts.Name,
+		Logger:         opts.Logger,
+		MaxGoroutines:  opts.CompactMaxGoroutines,
+		TombstoneGrace: opts.CompactTombstoneGrace,
 	}
 	compactCleaner := newCompactCleaner(compactCleanerOpts)
 
@@ -116,6 +176,10 @@ func New(opts Options) (CommitLog, error) {
 		closed:           make(chan struct{}),
 		hwWaiters:        make(map[contextReader]chan struct{}),
 		leaderEpochCache: epochCache,
+		rolls:            make(chan int64, segmentRollsBufferSize),
+	}
+	if opts.MaxLoadedSegments > 0 {
+		l.loader = newSegmentLoader(opts.MaxLoadedSegments)
 	}
 
 	if err := l.init(); err != nil {
@@ -155,6 +219,9 @@ func (l *commitLog) init() error {
 }
 
 func (l *commitLog) open() error {
+	if err := l.recoverMerges(); err != nil {
+		return err
+	}
 	files, err := ioutil.ReadDir(l.Path)
 	if err != nil {
 		return errors.Wrap(err, "read dir failed")
@@ -178,6 +245,13 @@ func (l *commitLog) open() error {
 			if err != nil {
 				return err
 			}
+			if l.loader != nil {
+				// Defer opening the segment until it is accessed so that
+				// opening a log with many segments is fast.
+				l.segments = append(l.segments,
+					newLazySegment(l.Path, int64(baseOffset), l.MaxSegmentBytes, file.Size(), l.loader))
+				continue
+			}
 			segment, err := newSegment(l.Path, int64(baseOffset), l.MaxSegmentBytes, false, "")
 			if err != nil {
 				return err
@@ -204,6 +278,10 @@ func (l *commitLog) open() error {
 		l.segments = append(l.segments, segment)
 	}
 	activeSegment := l.segments[len(l.segments)-1]
+	// The active segment is written to, so it is never unloaded.
+	if err := activeSegment.detach(); err != nil {
+		return err
+	}
 	atomic.StorePointer((*unsafe.Pointer)(unsafe.Pointer(&l.vActiveSegment)),
 		unsafe.Pointer(activeSegment))
 	return nil
@@ -227,6 +305,109 @@ func (l *commitLog) Append(msgs []*Message) ([]int64, error) {
 	return l.append(segment, ms, entries)
 }
 
+// AppendCompressed writes the given batch of messages to the log as a single
+// compressed batch frame and returns their corresponding offsets in the log.
+// Each message is still assigned its own offset, and Readers transparently
+// expand the frame into its inner messages, but the batch occupies a single
+// index entry, so it is indexed, replicated, retained, and compacted as a
+// unit. Batches should not span leader epochs, and their messages may take at
+// most the max segment size, or 1MB if that is smaller. This is most effective
+// for batches of small, similar messages.
+func (l *commitLog) AppendCompressed(msgs []*Message) ([]int64, error) {
+	if len(msgs) == 0 {
+		return nil, errors.New("no messages to append")
+	}
+	if _, err := l.checkAndPerformSplit(); err != nil {
+		return nil, err
+	}
+	var (
+		segment          = l.activeSegment()
+		basePosition     = segment.Position()
+		baseOffset       = segment.NextOffset()
+		ms, entries, err = newCompressedMessageSetFromProto(baseOffset, basePosition, l.maxBatchBytes(), msgs)
+	)
+	if err != nil {
+		return nil, err
+	}
+	// The frame is assigned the offset of its last message, so a new leader
+	// epoch needs to be assigned the offset of the first message instead.
+	if epoch := msgs[0].LeaderEpoch; epoch > l.leaderEpochCache.LastLeaderEpoch() {
+		if err := l.leaderEpochCache.Assign(epoch, baseOffset); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := l.append(segment, ms, entries); err != nil {
+		return nil, err
+	}
+	offsets := make([]int64, len(msgs))
+	for i := range msgs {
+		offsets[i] = baseOffset + int64(i)
+	}
+	return offsets, nil
+}
+
+// maxBatchBytes returns the maximum size of the inner message set of a
+// compressed batch frame, which bounds the memory used to decompress one. This
+// is the max segment size, but at least minMaxBatchBytes since a single batch
+// may be larger than a small segment.
+func (l *commitLog) maxBatchBytes() int64 {
+	if l.MaxSegmentBytes < minMaxBatchBytes {
+		return minMaxBatchBytes
+	}
+	return l.MaxSegmentBytes
+}
+
+// AppendMessage writes the given message to the log and returns the offset
+// and timestamp assigned to it. If the message has no timestamp set, it is
+// stamped with the current time. Any readers waiting for data are notified
+// once the message has been written.
+func (l *commitLog) AppendMessage(msg *Message) (int64, int64, error) {
+	offsets, timestamps, err := l.AppendBatch([]*Message{msg})
+	if err != nil {
+		return 0, 0, err
+	}
+	return offsets[0], timestamps[0], nil
+}
+
+// AppendBatch writes the given batch of messages to the log and returns their
+// corresponding offsets and timestamps. Messages with no timestamp set are
+// stamped with the current time, which is done on copies, so the given
+// messages are left unchanged. The batch is written to the active segment as a
+// single message set in one write, so uncommitted readers waiting for data are
+// notified once for the entire batch rather than once per message, and
+// committed readers are notified once when the HW advances past it.
+func (l *commitLog) AppendBatch(msgs []*Message) ([]int64, []int64, error) {
+	var (
+		now        = timestamp()
+		stamped    = make([]*Message, len(msgs))
+		timestamps = make([]int64, len(msgs))
+	)
+	for i, msg := range msgs {
+		m := *msg
+		if m.Timestamp == 0 {
+			m.Timestamp = now
+		}
+		stamped[i] = &m
+		timestamps[i] = m.Timestamp
+	}
+	offsets, err := l.Append(stamped)
+	if err != nil {
+		return nil, nil, err
+	}
+	return offsets, timestamps, nil
+}
+
+// CloseStream appends an end-of-stream marker to the log and returns its
+// offset. Committed readers return io.EOF once they read the marker rather
+// than waiting for more data, allowing finite streams to be consumed like
+// files.
+func (l *commitLog) CloseStream() (int64, error) {
+	offset, _, err := l.AppendMessage(&Message{
+		Attributes: AttrControl | AttrEndOfStream,
+	})
+	return offset, err
+}
+
 // AppendMessageSet writes the given message set data to the log and returns
 // the corresponding offsets in the log.
 func (l *commitLog) AppendMessageSet(ms []byte) ([]int64, error) {
@@ -242,6 +423,9 @@ func (l *commitLog) AppendMessageSet(ms []byte) ([]int64, error) {
 }
 
 func (l *commitLog) append(segment *segment, ms []byte, entries []*entry) ([]int64, error) {
+	if l.HeaderFormat != HeaderFormatFixed && len(entries) > 0 {
+		ms, entries = encodeMessageSet(ms, l.HeaderFormat, entries[0].Position)
+	}
 	if err := segment.WriteMessageSet(ms, entries); err != nil {
 		return nil, err
 	}
@@ -280,12 +464,28 @@ func (l *commitLog) OldestOffset() int64 {
 // OffsetForTimestamp returns the earliest offset whose timestamp is greater
 // than or equal to the given timestamp.
 func (l *commitLog) OffsetForTimestamp(timestamp int64) (int64, error) {
+	return l.offsetForTimestamp(timestamp, nil)
+}
+
+// OffsetForTimestampWithBudget is like OffsetForTimestamp but returns
+// ErrSearchBudgetExceeded rather than examining more segments or index
+// entries than the budget allows.
+func (l *commitLog) OffsetForTimestampWithBudget(timestamp int64, budget SearchBudget) (int64, error) {
+	b := newSearchBudget(budget)
+	offset, err := l.offsetForTimestamp(timestamp, b)
+	if b.exceeded() {
+		return 0, ErrSearchBudgetExceeded
+	}
+	return offset, err
+}
+
+func (l *commitLog) offsetForTimestamp(timestamp int64, budget *searchBudget) (int64, error) {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
 
 	// Find the first segment whose base timestamp is greater than the given
 	// timestamp.
-	idx, err := findSegmentIndexByTimestamp(l.segments, timestamp)
+	idx, err := findSegmentIndexByTimestamp(l.segments, timestamp, budget)
 	if err != nil {
 		return 0, errors.Wrap(err, "failed to find log segment for timestamp")
 	}
@@ -298,7 +498,7 @@ func (l *commitLog) OffsetForTimestamp(timestamp int64) (int64, error) {
 	} else {
 		seg = l.segments[idx-1]
 	}
-	entry, err := seg.findEntryByTimestamp(timestamp)
+	entry, err := seg.findEntryByTimestamp(timestamp, budget)
 	if err == nil {
 		return entry.Offset, nil
 	}
@@ -311,7 +511,7 @@ func (l *commitLog) OffsetForTimestamp(timestamp int64) (int64, error) {
 	// beyond the end of the log so return the next offset.
 	if idx < len(l.segments) {
 		seg = l.segments[idx]
-		entry, err := seg.findEntryByTimestamp(timestamp)
+		entry, err := seg.findEntryByTimestamp(timestamp, budget)
 		if err != nil {
 			return 0, errors.Wrap(err, "failed to find log entry for timestamp")
 		}
@@ -320,18 +520,775 @@ func (l *commitLog) OffsetForTimestamp(timestamp int64) (int64, error) {
 	return l.segments[len(l.segments)-1].NextOffset(), nil
 }
 
+// OffsetForTimestampFloor returns the latest offset whose timestamp is less
+// than or equal to the given timestamp. This is the complement of
+// OffsetForTimestamp, which returns the earliest offset at or after the
+// timestamp. Where OffsetForTimestamp starts replay with the first message
+// written after a point in time, this starts it with the last message written
+// before it, i.e. the state as of that time. If the timestamp is before the
+// oldest message, the oldest offset is returned. The result never exceeds the
+// HW, so if the timestamp is after the newest committed message, the HW is
+// returned. If the log is empty, the log end offset is returned.
+func (l *commitLog) OffsetForTimestampFloor(timestamp int64) (int64, error) {
+	return l.offsetForTimestampFloor(timestamp, nil)
+}
+
+// OffsetForTimestampFloorWithBudget is like OffsetForTimestampFloor but
+// returns ErrSearchBudgetExceeded rather than examining more segments or index
+// entries than the budget allows, e.g. when many empty segments would
+// otherwise be searched.
+func (l *commitLog) OffsetForTimestampFloorWithBudget(timestamp int64, budget SearchBudget) (int64, error) {
+	b := newSearchBudget(budget)
+	offset, err := l.offsetForTimestampFloor(timestamp, b)
+	if b.exceeded() {
+		return 0, ErrSearchBudgetExceeded
+	}
+	return offset, err
+}
+
+func (l *commitLog) offsetForTimestampFloor(timestamp int64, budget *searchBudget) (int64, error) {
+	hw := l.HighWatermark()
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.segments[0].FirstOffset() == -1 {
+		return l.segments[0].NextOffset(), nil
+	}
+	// Find the first segment whose base timestamp is greater than the given
+	// timestamp. The floor is in a segment before it.
+	idx, err := findSegmentIndexByTimestamp(l.segments, timestamp, budget)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to find log segment for timestamp")
+	}
+	offset := l.segments[0].FirstOffset()
+	for i := idx - 1; i >= 0; i-- {
+		entry, err := l.segments[i].findEntryByTimestampFloor(timestamp, budget)
+		if err == ErrEntryNotFound {
+			// The segment is empty, so search the previous one.
+			continue
+		}
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to find log entry for timestamp")
+		}
+		offset = entry.Offset
+		break
+	}
+	if hw != -1 && offset > hw {
+		offset = hw
+	}
+	return offset, nil
+}
+
+// Latest returns the most recent committed message, i.e. the message at the
+// HW, along with its offset and timestamp. Control records, such as an
+// end-of-stream marker, and placeholders are skipped, so the latest message
+// preceding them is returned instead. If no message has been committed yet, it
+// blocks until one is or the context is canceled, in which case the context
+// error is returned.
+func (l *commitLog) Latest(ctx context.Context) (SerializedMessage, int64, int64, error) {
+	for next := int64(0); ; {
+		if err := l.WaitForCommit(ctx, next); err != nil {
+			return nil, 0, 0, err
+		}
+		hw := l.HighWatermark()
+		msgs, offsets, timestamps, _, err := l.readReverse(ctx, hw+1, 1)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		if len(msgs) > 0 {
+			return msgs[0], offsets[0], timestamps[0], nil
+		}
+		// Only control records have been committed, so wait for the next
+		// message.
+		next = hw + 1
+	}
+}
+
+// WaitForCommit blocks until the given offset is committed, i.e. the HW is at
+// or past it, the context is canceled, in which case the context error is
+// returned, or the log is closed, in which case ErrLogClosed is returned.
+func (l *commitLog) WaitForCommit(ctx context.Context, offset int64) error {
+	var (
+		waiter = &committedReader{cl: l}
+		hw     = l.HighWatermark()
+		err    error
+	)
+	for hw < offset {
+		if hw, err = waiter.waitForHW(ctx, hw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadAfter waits for the given offset, e.g. that of a message just written,
+// to be committed using WaitForCommit and then reads the message at that
+// offset, or the next retained message if it was removed by compaction. This
+// provides read-your-writes consistency for a producer which must observe its
+// own write. The headersBuf is used as in Reader.ReadMessage.
+func (l *commitLog) ReadAfter(ctx context.Context, writtenOffset int64, headersBuf []byte) (
+	SerializedMessage, int64, int64, uint64, error) {
+
+	if err := l.WaitForCommit(ctx, writtenOffset); err != nil {
+		return nil, 0, 0, 0, err
+	}
+	r, err := l.NewReader(writtenOffset, false)
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
+	return r.ReadMessage(ctx, headersBuf)
+}
+
+// NewReaderCommittedLastDuration creates a new committed Reader which starts
+// at the earliest message whose timestamp is within the given duration of the
+// current time, as determined by OffsetForTimestamp, and then tails the log.
+// If the log spans less than the duration, the Reader starts at the oldest
+// message, and if no message is that recent, it waits for the next one.
+func (l *commitLog) NewReaderCommittedLastDuration(d time.Duration) (*Reader, error) {
+	offset, err := l.OffsetForTimestamp(timestamp() - int64(d))
+	if err != nil {
+		return nil, err
+	}
+	if oldest := l.OldestOffset(); offset < oldest {
+		offset = oldest
+	}
+	return l.NewReader(offset, false)
+}
+
+// NewReaderUncommittedUntil creates a new uncommitted Reader starting at the
+// given offset which stops at the given log end offset (LEO), i.e. it returns
+// the messages before leo and then an ErrEndOfStream rather than waiting for
+// more data. If the log has not yet been written up to leo, the Reader waits
+// for the remaining messages to be appended before returning the error. This
+// is useful for catching up to a follower whose LEO is known in advance.
+func (l *commitLog) NewReaderUncommittedUntil(offset, leo int64) (*Reader, error) {
+	r, err := l.NewReader(offset, true)
+	if err != nil {
+		return nil, err
+	}
+	r.until = &leo
+	return r, nil
+}
+
+// NewReaderForTimestampFloor creates a new Reader using the provided
+// ReaderOptions which starts at the latest offset whose timestamp is less than
+// or equal to the given timestamp as determined by OffsetForTimestampFloor.
+func (l *commitLog) NewReaderForTimestampFloor(timestamp int64, opts ReaderOptions) (*Reader, error) {
+	offset, err := l.OffsetForTimestampFloor(timestamp)
+	if err != nil {
+		return nil, err
+	}
+	return l.NewReaderWithOptions(offset, opts)
+}
+
+// ResolveOffset returns the base offset of the segment containing the given
+// offset and the position of the offset within that segment's log file. If
+// the offset was removed by compaction, the position of the next retained
+// offset in the segment is returned. ErrSegmentNotFound is returned if no
+// segment contains the offset.
+func (l *commitLog) ResolveOffset(offset int64) (int64, int64, error) {
+	return l.resolveOffset(offset, nil)
+}
+
+// ResolveOffsetWithBudget is like ResolveOffset but returns
+// ErrSearchBudgetExceeded rather than examining more index entries than the
+// budget allows.
+func (l *commitLog) ResolveOffsetWithBudget(offset int64, budget SearchBudget) (int64, int64, error) {
+	b := newSearchBudget(budget)
+	base, position, err := l.resolveOffset(offset, b)
+	if b.exceeded() {
+		return 0, 0, ErrSearchBudgetExceeded
+	}
+	return base, position, err
+}
+
+func (l *commitLog) resolveOffset(offset int64, budget *searchBudget) (int64, int64, error) {
+	seg, contains := findSegmentContains(l.Segments(), offset)
+	if !contains {
+		return 0, 0, ErrSegmentNotFound
+	}
+	entry, err := seg.findEntry(offset, budget)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to find log entry for offset")
+	}
+	return seg.BaseOffset, entry.Position, nil
+}
+
+// ScanOffsets walks the log starting at the given offset and invokes fn with
+// the offset, timestamp, and segment-relative position of each message. Only
+// message headers are read, payloads are skipped using the message size,
+// making this considerably cheaper than reading the log for building external
+// indexes. Scanning stops at the end of the log, when the context is
+// canceled, or when fn returns an error, which is then returned.
+func (l *commitLog) ScanOffsets(ctx context.Context, from int64,
+	fn func(offset, timestamp, position int64) error) error {
+
+	var (
+		segments = l.Segments()
+		buf      = make(messageSet, maxMsgSetHeaderLen)
+	)
+	_, idx := findSegment(segments, from)
+	for _, seg := range segments[idx:] {
+		var (
+			position = int64(0)
+			end      = seg.Position()
+		)
+		if seg.BaseOffset <= from {
+			entry, err := seg.findEntry(from, nil)
+			if err == ErrEntryNotFound {
+				continue
+			}
+			if err != nil {
+				return errors.Wrap(err, "failed to find log entry for offset")
+			}
+			position = entry.Position
+		}
+		for position < end {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			header, err := readHeaderAt(seg, buf, position)
+			if err != nil {
+				return errors.Wrap(err, "failed to read message header")
+			}
+			if header.Offset() >= from {
+				if err := fn(header.Offset(), header.Timestamp(), position); err != nil {
+					return err
+				}
+			}
+			position += int64(len(header)) + int64(header.Size())
+		}
+	}
+	return nil
+}
+
+// IndexEntry describes a message in the log as recorded by its segment's
+// index.
+type IndexEntry struct {
+	// BaseOffset is the base offset of the segment containing the message.
+	BaseOffset int64
+
+	// Offset is the offset of the message.
+	Offset int64
+
+	// Position is the byte position of the message within its segment's log
+	// file.
+	Position int64
+
+	// Size is the size of the message in bytes, including its message set
+	// header.
+	Size int32
+
+	// Timestamp is the timestamp of the message.
+	Timestamp int64
+}
+
+// IndexEntries returns the index entries for the messages in the log whose
+// offsets are between the given offsets, inclusive, stitched together across
+// segments. The range is clamped to the offsets present in the log. Only the
+// indexes are read, making this useful for tooling which needs message sizes
+// or positions without reading payloads.
+func (l *commitLog) IndexEntries(from, to int64) ([]IndexEntry, error) {
+	var (
+		segments = l.Segments()
+		entries  = []IndexEntry{}
+	)
+	if oldest := l.OldestOffset(); from < oldest {
+		from = oldest
+	}
+	if newest := l.NewestOffset(); to > newest {
+		to = newest
+	}
+	if from > to || from < 0 {
+		return entries, nil
+	}
+	_, idx := findSegment(segments, from)
+	for _, seg := range segments[idx:] {
+		if seg.BaseOffset > to {
+			break
+		}
+		segEntries, err := seg.entriesRange(from, to)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read index entries")
+		}
+		for _, e := range segEntries {
+			entries = append(entries, IndexEntry{
+				BaseOffset: seg.BaseOffset,
+				Offset:     e.Offset,
+				Position:   e.Position,
+				Size:       e.Size,
+				Timestamp:  e.Timestamp,
+			})
+		}
+	}
+	return entries, nil
+}
+
+// SparseIndex returns an index entry roughly every byteInterval bytes across
+// the log, starting with its first message, like Kafka's sparse offset
+// index. An entry is sampled once at least byteInterval bytes of the log
+// follow the previously sampled entry, so external tools can seek
+// approximately by offset and then scan forward a bounded number of bytes.
+// Only the segment indexes are read.
+func (l *commitLog) SparseIndex(byteInterval int64) ([]IndexEntry, error) {
+	if byteInterval <= 0 {
+		return nil, errors.Errorf("invalid byte interval %d", byteInterval)
+	}
+	var (
+		entries = []IndexEntry{}
+		logPos  = int64(0)
+		next    = int64(0)
+	)
+	for _, seg := range l.Segments() {
+		n := int(seg.MessageCount())
+		for i := 0; i < n; i++ {
+			e, err := seg.entryAt(i)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to read index entry")
+			}
+			if logPos+e.Position < next {
+				continue
+			}
+			entries = append(entries, IndexEntry{
+				BaseOffset: seg.BaseOffset,
+				Offset:     e.Offset,
+				Position:   e.Position,
+				Size:       e.Size,
+				Timestamp:  e.Timestamp,
+			})
+			next = logPos + e.Position + byteInterval
+		}
+		logPos += seg.Position()
+	}
+	return entries, nil
+}
+
+// ByteSize returns the number of bytes occupied in the log by the messages
+// whose offsets are between the given offsets, inclusive, including their
+// message set headers. The range is clamped to the offsets present in the
+// log. Segments entirely within the range are counted using their size, and
+// only the segments at either end of the range are searched, so the cost is
+// proportional to the number of segments rather than messages.
+func (l *commitLog) ByteSize(from, to int64) (int64, error) {
+	segments := l.Segments()
+	if oldest := l.OldestOffset(); from < oldest {
+		from = oldest
+	}
+	if newest := l.NewestOffset(); to > newest {
+		to = newest
+	}
+	if from > to || from < 0 {
+		return 0, nil
+	}
+	var (
+		_, idx = findSegment(segments, from)
+		size   int64
+	)
+	for _, seg := range segments[idx:] {
+		if seg.BaseOffset > to {
+			break
+		}
+		var (
+			start = int64(0)
+			end   = seg.Position()
+		)
+		if seg.BaseOffset < from {
+			entry, err := seg.findEntry(from, nil)
+			if err == ErrEntryNotFound {
+				continue
+			}
+			if err != nil {
+				return 0, errors.Wrap(err, "failed to find log entry for offset")
+			}
+			start = entry.Position
+		}
+		if seg.NextOffset() > to+1 {
+			entry, err := seg.findEntry(to+1, nil)
+			if err != nil && err != ErrEntryNotFound {
+				return 0, errors.Wrap(err, "failed to find log entry for offset")
+			}
+			if err == nil {
+				end = entry.Position
+			}
+		}
+		if end > start {
+			size += end - start
+		}
+	}
+	return size, nil
+}
+
+// SegmentBoundary describes the first message of a segment.
+type SegmentBoundary struct {
+	BaseOffset int64 // Base offset of the segment
+	Offset     int64 // Offset of the first message in the segment
+	Timestamp  int64 // Timestamp of the first message in the segment
+}
+
+// SegmentBoundaryMessages returns the offset and timestamp of the first
+// message in each non-empty segment, in order. Only the message set header at
+// the start of each segment is read, so this is cheap regardless of segment
+// sizes and provides a coarse mapping between offsets and time, e.g. for
+// approximate seeking by timestamp.
+func (l *commitLog) SegmentBoundaryMessages() ([]SegmentBoundary, error) {
+	var (
+		segments   = l.Segments()
+		boundaries = make([]SegmentBoundary, 0, len(segments))
+		buf        = make(messageSet, maxMsgSetHeaderLen)
+	)
+	for _, seg := range segments {
+		if seg.Position() == 0 {
+			continue
+		}
+		header, err := readHeaderAt(seg, buf, 0)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read first message header of segment %d",
+				seg.BaseOffset)
+		}
+		boundaries = append(boundaries, SegmentBoundary{
+			BaseOffset: seg.BaseOffset,
+			Offset:     header.Offset(),
+			Timestamp:  header.Timestamp(),
+		})
+	}
+	return boundaries, nil
+}
+
+// ReadPageReverse returns up to limit committed messages whose offsets are
+// less than before, newest first, along with their offsets and timestamps,
+// e.g. for a log viewer which shows the newest messages and loads older ones
+// on demand. The segments are walked backwards using their indexes, so the
+// cost depends on the page size rather than the position in the log. Inner
+// messages of compressed batches are returned individually, while control
+// records and placeholders are skipped. Like Readers, it returns an error rather than a message
+// whose CRC does not match. The returned
+// cursor is the before offset for the next, older page, or -1 once the oldest
+// message in the log has been returned.
+func (l *commitLog) ReadPageReverse(before int64, limit int) (
+	[]SerializedMessage, []int64, []int64, int64, error) {
+
+	if limit <= 0 {
+		return nil, nil, nil, 0, errors.Errorf("invalid page limit %d", limit)
+	}
+	return l.readReverse(context.Background(), before, limit)
+}
+
+// TailReverse returns the last n committed messages, newest first, along with
+// their offsets and timestamps, e.g. to see the most recent messages when
+// triaging errors. The log is read backwards from the HW segment using the
+// segment indexes like ReadPageReverse, so the cost depends on n rather than
+// the size of the log. Like ReadPageReverse, control records and placeholders
+// are skipped. Fewer than n messages are returned if the log has fewer
+// committed messages. The context is checked as each index entry is
+// read.
+func (l *commitLog) TailReverse(ctx context.Context, n int) ([]SerializedMessage, []int64, []int64, error) {
+	if n <= 0 {
+		return nil, nil, nil, errors.Errorf("invalid message count %d", n)
+	}
+	msgs, offsets, timestamps, _, err := l.readReverse(ctx, l.HighWatermark()+1, n)
+	return msgs, offsets, timestamps, err
+}
+
+// readReverse implements ReadPageReverse, stopping if the context is done.
+func (l *commitLog) readReverse(ctx context.Context, before int64, limit int) (
+	[]SerializedMessage, []int64, []int64, int64, error) {
+
+	if hw := l.HighWatermark(); before > hw+1 {
+		before = hw + 1
+	}
+	var (
+		segments   = l.Segments()
+		msgs       = make([]SerializedMessage, 0, limit)
+		offsets    = make([]int64, 0, limit)
+		timestamps = make([]int64, 0, limit)
+		oldest     = l.OldestOffset()
+	)
+	_, idx := findSegment(segments, before-1)
+	if idx == len(segments) {
+		idx--
+	}
+LOOP:
+	for ; idx >= 0; idx-- {
+		seg := segments[idx]
+		// The first entry at or after before may be a batch frame containing
+		// messages before it, so start the walk with it.
+		i, n := seg.searchIndex(before)
+		if i == n {
+			i--
+		}
+		for ; i >= 0; i-- {
+			if err := ctx.Err(); err != nil {
+				return nil, nil, nil, 0, err
+			}
+			e, err := seg.entryAt(i)
+			if err != nil {
+				return nil, nil, nil, 0, errors.Wrap(err, "failed to read index entry")
+			}
+			ms := make(messageSet, e.Size)
+			if _, err := seg.ReadAt(ms, e.Position); err != nil {
+				return nil, nil, nil, 0, errors.Wrap(err, "failed to read message")
+			}
+			if msg := ms.Message(); msg.IsCompressed() {
+				if err := msg.checkCRC(); err != nil {
+					return nil, nil, nil, 0, errors.Wrapf(err, "batch frame at offset %d", e.Offset)
+				}
+				if ms, err = decompressMessageSet(msg, l.maxBatchBytes()); err != nil {
+					return nil, nil, nil, 0, err
+				}
+			}
+			// Collect the message set's messages newest first.
+			var batch []messageSet
+			for rest := ms; len(rest) > 0; rest = rest[rest.HeaderLen()+int(rest.Size()):] {
+				batch = append(batch, rest)
+			}
+			for j := len(batch) - 1; j >= 0; j-- {
+				if batch[j].Offset() >= before {
+					continue
+				}
+				msg := batch[j].Message()
+				if err := msg.checkCRC(); err != nil {
+					return nil, nil, nil, 0, errors.Wrapf(err, "message at offset %d", batch[j].Offset())
+				}
+				// Control records, such as end-of-stream markers, and
+				// placeholders, e.g. replicated from a Reader filling gaps,
+				// are not data.
+				if msg.HasAttributes(AttrControl | AttrPlaceholder) {
+					continue
+				}
+				msgs = append(msgs, msg)
+				offsets = append(offsets, batch[j].Offset())
+				timestamps = append(timestamps, batch[j].Timestamp())
+				if len(msgs) == limit {
+					break LOOP
+				}
+			}
+		}
+	}
+	next := int64(-1)
+	if len(offsets) > 0 && offsets[len(offsets)-1] > oldest {
+		next = offsets[len(offsets)-1]
+	}
+	return msgs, offsets, timestamps, next, nil
+}
+
+// LogStats is a snapshot of the state of a log.
+type LogStats struct {
+	OldestOffset      int64 // Offset of the first message in the log, -1 if empty
+	HighWatermark     int64 // Offset of the last committed message, -1 if none
+	NewestOffset      int64 // Offset of the last message in the log, -1 if empty
+	SegmentCount      int   // Number of segments in the log
+	TotalBytes        int64 // Size of the segment log files in bytes
+	ActiveSegmentSize int64 // Size of the active segment log file in bytes
+	HWWaiters         int   // Number of readers waiting for the HW to advance
+}
+
+// Stats returns a snapshot of the state of the log. The segments and HW are
+// read in a single critical section, so the stats are consistent with each
+// other even if a segment rolls concurrently, unlike separate calls to
+// OldestOffset, HighWatermark, and so on. Appends to the active segment may
+// still proceed concurrently, so the snapshot may be stale by the time it is
+// used.
+func (l *commitLog) Stats() LogStats {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	var (
+		active = l.segments[len(l.segments)-1]
+		stats  = LogStats{
+			OldestOffset:  l.segments[0].FirstOffset(),
+			HighWatermark: l.hw,
+			NewestOffset:  active.NextOffset() - 1,
+			SegmentCount:  len(l.segments),
+			HWWaiters:     len(l.hwWaiters),
+		}
+	)
+	for _, seg := range l.segments {
+		stats.TotalBytes += seg.Position()
+	}
+	stats.ActiveSegmentSize = active.Position()
+	return stats
+}
+
+// CompactionStats returns stats describing the effect of the last log
+// compaction, or zero values if the log has not been compacted.
+func (l *commitLog) CompactionStats() CompactionStats {
+	return l.compactCleaner.Stats()
+}
+
+// Clone copies the messages in the log from the given offset onward into a
+// new log in the given directory and returns it. The clone has the same
+// options as this log except for its path, and its first segment is based at
+// the first copied offset, so messages keep their offsets, leader epochs, and
+// timestamps. The high watermark is carried over if it is within the copied
+// range. The offset is clamped to the offsets present in the log, and the
+// directory must not already contain files. The copy is done on raw message
+// sets without decoding messages. If the context is canceled, the partial
+// clone is deleted and the context error is returned.
+func (l *commitLog) Clone(ctx context.Context, dir string, fromOffset int64) (CommitLog, error) {
+	if fromOffset < 0 {
+		return nil, ErrInvalidOffset
+	}
+	if files, err := ioutil.ReadDir(dir); err == nil && len(files) > 0 {
+		return nil, errors.New("clone directory is not empty")
+	} else if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "read dir failed")
+	}
+	if oldest := l.OldestOffset(); fromOffset < oldest {
+		fromOffset = oldest
+	}
+	if next := l.activeSegment().NextOffset(); fromOffset > next {
+		fromOffset = next
+	}
+
+	// Create the first segment up front so the clone is based at the first
+	// copied offset rather than 0.
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "mkdir failed")
+	}
+	first, err := newSegment(dir, fromOffset, l.MaxSegmentBytes, true, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := first.Close(); err != nil {
+		return nil, err
+	}
+	opts := l.Options
+	opts.Path = dir
+	clone, err := New(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := l.cloneInto(ctx, clone, fromOffset); err != nil {
+		// Removing the partial clone is best-effort, and the copy error is
+		// the one worth reporting.
+		clone.Delete() // nolint: errcheck
+		return nil, err
+	}
+	if hw := l.HighWatermark(); hw >= fromOffset {
+		if newest := clone.NewestOffset(); hw > newest {
+			hw = newest
+		}
+		clone.SetHighWatermark(hw)
+	}
+	return clone, nil
+}
+
+// cloneInto appends the message sets in the log from the given offset onward
+// to the given log, in chunks of up to cloneChunkBytes. A batch frame
+// containing messages preceding the offset is split to copy only the rest.
+func (l *commitLog) cloneInto(ctx context.Context, clone CommitLog, from int64) error {
+	var (
+		segments = l.Segments()
+		buf      = make(messageSet, maxMsgSetHeaderLen)
+		_, idx   = findSegment(segments, from)
+	)
+	for _, seg := range segments[idx:] {
+		var (
+			position = int64(0)
+			end      = seg.Position()
+		)
+		if seg.BaseOffset < from {
+			entry, err := seg.findEntry(from, nil)
+			if err == ErrEntryNotFound {
+				continue
+			}
+			if err != nil {
+				return errors.Wrap(err, "failed to find log entry for offset")
+			}
+			position = entry.Position
+			// A batch frame is stored at the offset of its last inner
+			// message, so it may contain messages preceding the offset.
+			if entry.Offset > from {
+				n, err := l.cloneStraddling(clone, seg, entry, from)
+				if err != nil {
+					return err
+				}
+				position += n
+			}
+		}
+		for position < end {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			// Extend the chunk message by message so that it only contains
+			// whole message sets.
+			chunkEnd := position
+			for chunkEnd < end && chunkEnd-position < cloneChunkBytes {
+				header, err := readHeaderAt(seg, buf, chunkEnd)
+				if err != nil {
+					return errors.Wrap(err, "failed to read message header")
+				}
+				chunkEnd += int64(len(header)) + int64(header.Size())
+			}
+			chunk := make([]byte, chunkEnd-position)
+			if _, err := seg.ReadAt(chunk, position); err != nil {
+				return errors.Wrap(err, "failed to read message set")
+			}
+			if _, err := clone.AppendMessageSet(chunk); err != nil {
+				return errors.Wrap(err, "failed to append message set to clone")
+			}
+			position = chunkEnd
+		}
+	}
+	return nil
+}
+
+// cloneStraddling appends the inner messages at or following the offset of
+// the batch frame at the given entry of the segment to the given log, and
+// returns the number of bytes of the segment copied this way, which is 0 if
+// the message set at the entry is not a batch frame.
+func (l *commitLog) cloneStraddling(clone CommitLog, seg *segment, e *entry, from int64) (int64, error) {
+	ms := make(messageSet, e.Size)
+	if _, err := seg.ReadAt(ms, e.Position); err != nil {
+		return 0, errors.Wrap(err, "failed to read message set")
+	}
+	if !ms.Message().IsCompressed() {
+		return 0, nil
+	}
+	frame, _, err := trimCompressedMessageSet(ms, from, 0, l.maxBatchBytes())
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to split batch frame")
+	}
+	if frame != nil {
+		if _, err := clone.AppendMessageSet(frame); err != nil {
+			return 0, errors.Wrap(err, "failed to append message set to clone")
+		}
+	}
+	return int64(e.Size), nil
+}
+
 // SetHighWatermark sets the high watermark on the log. All messages up to and
 // including the high watermark are considered committed.
 func (l *commitLog) SetHighWatermark(hw int64) {
 	l.mu.Lock()
 	if hw > l.hw {
 		l.hw = hw
-		l.notifyHWWaiters()
+		l.scheduleHWNotify()
 	}
 	l.mu.Unlock()
 	// TODO: should we flush the HW to disk here?
 }
 
+// scheduleHWNotify notifies readers waiting on the HW once the HW notify
+// window elapses. HW updates made within the window are coalesced into a
+// single wakeup, at which point readers see the latest HW. This must be called
+// while holding the log lock.
+func (l *commitLog) scheduleHWNotify() {
+	if l.HWNotifyWindow < 0 {
+		l.notifyHWWaiters()
+		return
+	}
+	if l.hwNotifyTimer != nil {
+		// A notification is already pending.
+		return
+	}
+	l.hwNotifyTimer = time.AfterFunc(l.HWNotifyWindow, func() {
+		l.mu.Lock()
+		l.hwNotifyTimer = nil
+		l.notifyHWWaiters()
+		l.mu.Unlock()
+	})
+}
+
 // OverrideHighWatermark sets the high watermark on the log using the given
 // value, even if the value is less than the current HW. This is used for unit
 // testing purposes.
@@ -342,24 +1299,57 @@ func (l *commitLog) OverrideHighWatermark(hw int64) {
 	l.mu.Unlock()
 }
 
+// notifyHWWaiters wakes all readers waiting on the HW in order of their
+// priority, highest first. This must be called while holding the log lock.
 func (l *commitLog) notifyHWWaiters() {
-	for r, ch := range l.hwWaiters {
-		close(ch)
+	for _, r := range l.hwWaitersByPriority() {
+		close(l.hwWaiters[r])
 		delete(l.hwWaiters, r)
 	}
 }
 
-func (l *commitLog) waitForHW(r contextReader, hw int64) <-chan struct{} {
+// hwWaitersByPriority returns the readers waiting on the HW sorted by
+// priority, highest first. This must be called while holding the log lock.
+func (l *commitLog) hwWaitersByPriority() []contextReader {
+	waiters := make([]contextReader, 0, len(l.hwWaiters))
+	for r := range l.hwWaiters {
+		waiters = append(waiters, r)
+	}
+	sort.SliceStable(waiters, func(i, j int) bool {
+		return waiterPriority(waiters[i]) > waiterPriority(waiters[j])
+	})
+	return waiters
+}
+
+// prioritizedWaiter is implemented by waiters which have a wakeup priority.
+type prioritizedWaiter interface {
+	waitPriority() int
+}
+
+// waiterPriority returns the wakeup priority of the given waiter, defaulting
+// to zero.
+func waiterPriority(r contextReader) int {
+	if p, ok := r.(prioritizedWaiter); ok {
+		return p.waitPriority()
+	}
+	return 0
+}
+
+// waitForHW registers and returns a channel which is closed when the HW
+// changes from the given value along with the current HW. If the HW has
+// already changed, the channel is closed immediately.
+func (l *commitLog) waitForHW(r contextReader, hw int64) (<-chan struct{}, int64) {
 	wait := make(chan struct{})
 	l.mu.Lock()
+	current := l.hw
 	// Check if HW has changed.
-	if l.hw != hw {
+	if current != hw {
 		close(wait)
 	} else {
 		l.hwWaiters[r] = wait
 	}
 	l.mu.Unlock()
-	return wait
+	return wait, current
 }
 
 func (l *commitLog) removeHWWaiter(r contextReader) {
@@ -396,25 +1386,90 @@ func (l *commitLog) LastLeaderEpoch() uint64 {
 	return l.leaderEpochCache.LastLeaderEpoch()
 }
 
+// ActiveSegment returns a snapshot of the segment currently being appended
+// to. Because the segment may roll concurrently with appends, the returned
+// info may already be stale by the time it is used.
+func (l *commitLog) ActiveSegment() SegmentInfo {
+	return l.activeSegment().Info()
+}
+
+// SegmentFileForOffset returns the path and base offset of the segment log
+// file containing the given offset, e.g. for operators inspecting or
+// repairing the message by hand. Because segments may be compacted, the
+// offset is only guaranteed to be within the file's bounds, not present in
+// it. Segments may also be rolled, compacted, or deleted concurrently, so the
+// file may be replaced by the time it is used. ErrInvalidOffset is returned
+// for offsets outside the log's range.
+func (l *commitLog) SegmentFileForOffset(offset int64) (string, int64, error) {
+	seg, contains := findSegmentContains(l.Segments(), offset)
+	if !contains {
+		return "", 0, errors.Wrapf(ErrInvalidOffset, "offset %d is not in the log", offset)
+	}
+	return seg.logPath(), seg.BaseOffset, nil
+}
+
+// SegmentRolls returns a channel which receives the base offset of each new
+// segment rolled, e.g. to upload sealed segments to cold storage. The channel
+// is buffered, and rolls which occur while the buffer is full are dropped
+// rather than blocking appends, so consumers which fall behind should check
+// Segments to catch up. The channel is closed when the log is closed. All
+// calls return the same channel, so rolls are received by only one consumer.
+func (l *commitLog) SegmentRolls() <-chan int64 {
+	return l.rolls
+}
+
+// OpenSegmentCount returns the number of segments in the log whose files are
+// open. Each open segment holds a file descriptor for its log file and another
+// for its index. By default, Readers share the log's segment files rather than
+// opening their own, so the number of readers or how many segments they have
+// read across does not affect file descriptor usage. Readers created with
+// MaxOpenSegments hold up to that many files of their own, which are reported
+// by their OpenSegmentCount rather than counted here. With MaxLoadedSegments,
+// segments which have not been loaded or were unloaded are not counted.
+func (l *commitLog) OpenSegmentCount() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	count := 0
+	for _, seg := range l.segments {
+		seg.RLock()
+		if !seg.closed && !seg.unloaded {
+			count++
+		}
+		seg.RUnlock()
+	}
+	return count
+}
+
 func (l *commitLog) activeSegment() *segment {
 	return (*segment)(atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(&l.vActiveSegment))))
 }
 
 // Close closes each log segment file and stops the background goroutine
-// checkpointing the high watermark to disk.
+// checkpointing the high watermark to disk. The background goroutines are
+// stopped and the segments closed even if the final high watermark checkpoint
+// fails, in which case the checkpoint error is returned. Closing a log that is
+// already closed is a no-op.
 func (l *commitLog) Close() error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
-	if err := l.checkpointHW(); err != nil {
-		return err
+	select {
+	case <-l.closed:
+		return nil
+	default:
 	}
 	close(l.closed)
+	close(l.rolls)
+	if l.hwNotifyTimer != nil {
+		l.hwNotifyTimer.Stop()
+		l.hwNotifyTimer = nil
+	}
+	err := l.checkpointHW()
 	for _, segment := range l.segments {
-		if err := segment.Close(); err != nil {
-			return err
+		if closeErr := segment.Close(); closeErr != nil && err == nil {
+			err = closeErr
 		}
 	}
-	return nil
+	return err
 }
 
 // Delete closes the log and removes all data associated with it from the
@@ -482,9 +1537,23 @@ func (l *commitLog) Truncate(offset int64) error {
 				if err := newSegment.WriteMessageSet(ms, []*entry{e}); err != nil {
 					return err
 				}
-			} else {
-				break
+				continue
+			}
+			if ms.Message().IsCompressed() {
+				// A batch frame is stored at the offset of its last inner
+				// message, so it may contain messages preceding the offset.
+				// Split the frame to retain them.
+				frame, entries, err := truncateCompressedMessageSet(ms, offset, newSegment.Position(), l.maxBatchBytes())
+				if err != nil {
+					return err
+				}
+				if frame != nil {
+					if err := newSegment.WriteMessageSet(frame, entries); err != nil {
+						return err
+					}
+				}
 			}
+			break
 		}
 		if err = newSegment.Replace(seg); err != nil {
 			return err
@@ -492,6 +1561,11 @@ func (l *commitLog) Truncate(offset int64) error {
 		segments[idx] = newSegment
 	}
 	activeSegment := segments[len(segments)-1]
+	// A preceding segment may become the active one, so it must no longer be
+	// unloaded.
+	if err := activeSegment.detach(); err != nil {
+		return err
+	}
 	atomic.StorePointer((*unsafe.Pointer)(unsafe.Pointer(&l.vActiveSegment)),
 		unsafe.Pointer(activeSegment))
 	l.segments = segments
@@ -512,6 +1586,26 @@ func (l *commitLog) NotifyLEO(waiter interface{}, leo int64) <-chan struct{} {
 	return l.activeSegment().WaitForLEO(waiter, leo)
 }
 
+// Roll seals the active segment and starts a new one, regardless of whether
+// the active segment is full or LogRollTime has passed. This is a no-op if
+// the active segment is empty or another roll occurs concurrently.
+func (l *commitLog) Roll() error {
+	activeSegment := l.activeSegment()
+	if activeSegment.IsEmpty() {
+		return nil
+	}
+	if err := l.split(activeSegment); err != nil {
+		// ErrSegmentExists indicates another thread has already performed
+		// the segment split.
+		if err == ErrSegmentExists {
+			return nil
+		}
+		return err
+	}
+	activeSegment.Seal()
+	return nil
+}
+
 // checkAndPerformSplit determines if a new log segment should be rolled out
 // either because the active segment is full or LogRollTime has passed since
 // the first message was written to it. It then performs the split if eligible,
@@ -559,10 +1653,28 @@ func (l *commitLog) split(oldActiveSegment *segment) error {
 	l.mu.Lock()
 	segments := append(l.segments, segment)
 	l.segments = segments
+	l.notifyRoll(offset)
 	l.mu.Unlock()
 	return nil
 }
 
+// notifyRoll sends the base offset of a newly rolled segment on the rolls
+// channel unless the log is closed, in which case the channel is closed too.
+// This must be called while holding the log lock, which Close holds while
+// closing the channel.
+func (l *commitLog) notifyRoll(offset int64) {
+	select {
+	case <-l.closed:
+		return
+	default:
+	}
+	select {
+	case l.rolls <- offset:
+	default:
+		// Never block appends on a slow consumer.
+	}
+}
+
 func (l *commitLog) cleanerLoop() {
 	ticker := time.NewTicker(l.CleanerInterval)
 	defer ticker.Stop()
@@ -594,6 +1706,8 @@ func (l *commitLog) cleanerLoop() {
 
 // Clean applies retention and compaction rules against the log, if applicable.
 func (l *commitLog) Clean() error {
+	l.cleanMu.Lock()
+	defer l.cleanMu.Unlock()
 	l.mu.RLock()
 	oldSegments := l.segments
 	l.mu.RUnlock()
@@ -601,6 +1715,41 @@ func (l *commitLog) Clean() error {
 	if err != nil {
 		return err
 	}
+	return l.replaceCleaned(oldSegments, cleaned, epochCache)
+}
+
+// Compact rewrites the log segments, excluding the active segment, such that
+// they retain only the latest message for each key as determined by keyFn. If
+// keyFn is nil, the message key is used. Messages after the HW are never
+// removed. Readers positioned in a rewritten segment transparently re-resolve
+// their position against the new segment.
+func (l *commitLog) Compact(ctx context.Context, keyFn KeyFunc) error {
+	if keyFn == nil {
+		keyFn = messageKey
+	}
+	l.cleanMu.Lock()
+	defer l.cleanMu.Unlock()
+	l.mu.RLock()
+	oldSegments := l.segments
+	l.mu.RUnlock()
+	compacted, epochCache, err := l.compactCleaner.CompactByKey(
+		ctx, l.HighWatermark(), oldSegments, keyFn)
+	if compacted == nil {
+		return err
+	}
+	// Compacted segments have replaced the originals, so the result must be
+	// applied even if compaction stopped early due to the context.
+	if replaceErr := l.replaceCleaned(oldSegments, compacted, epochCache); replaceErr != nil {
+		return replaceErr
+	}
+	return err
+}
+
+// replaceCleaned replaces the given segments with the cleaned ones, rebasing
+// any segments which were added while cleaning.
+func (l *commitLog) replaceCleaned(oldSegments, cleaned []*segment,
+	epochCache *leaderEpochCache) (err error) {
+
 	l.mu.Lock()
 	newSegments := l.segments
 	if len(newSegments) > len(oldSegments) {
@@ -622,14 +1771,233 @@ func (l *commitLog) Clean() error {
 	return err
 }
 
+// EnforceRetention deletes segments whose newest message is older than maxAge
+// and the oldest segments as needed to bring the total log size under
+// maxBytes. A limit of zero disables it. The active segment is never deleted.
+// Readers positioned in a deleted segment will receive ErrOffsetRetained. This
+// returns the number of segments deleted.
+func (l *commitLog) EnforceRetention(maxAge time.Duration, maxBytes int64) (int, error) {
+	opts := deleteCleanerOptions{
+		Name:   l.Path,
+		Logger: l.Logger,
+	}
+	opts.Retention.Age = maxAge
+	opts.Retention.Bytes = maxBytes
+	cleaner := newDeleteCleaner(opts)
+
+	l.cleanMu.Lock()
+	defer l.cleanMu.Unlock()
+	l.mu.RLock()
+	oldSegments := l.segments
+	l.mu.RUnlock()
+	cleaned, err := cleaner.Clean(oldSegments)
+	if err != nil {
+		return 0, err
+	}
+	deleted := len(oldSegments) - len(cleaned)
+	return deleted, l.replaceCleaned(oldSegments, cleaned, nil)
+}
+
+// NewSegment creates an empty segment with the given base offset to be built
+// and then swapped into the log with SwapSegments. If it is not swapped in, it
+// should be discarded with Delete.
+func (l *commitLog) NewSegment(baseOffset int64) (*Segment, error) {
+	if baseOffset < 0 {
+		return nil, errors.Errorf("invalid base offset %d", baseOffset)
+	}
+	seg, err := newSegment(l.Path, baseOffset, l.MaxSegmentBytes, true, stagedSuffix)
+	if err != nil {
+		return nil, err
+	}
+	return &Segment{segment: seg, format: l.HeaderFormat}, nil
+}
+
+// SwapSegments atomically replaces the log's sealed segments with the given
+// segments created by NewSegment, e.g. segments rebuilt by compaction in the
+// background, so that readers see either the old set or the new one, never a
+// mix. The segments must be non-empty, ordered by offset without overlapping,
+// and end before the active segment. Every sealed segment whose base offset is
+// less than the next offset of the last given segment is replaced, while
+// later ones, such as segments rolled since the new set was built, are kept.
+// Replaced segments are closed and marked replaced, and the log's generation
+// is bumped, which causes readers to re-resolve their position against the
+// new set before their next read. The new segments are sealed and their files
+// moved into place of the replaced segments' files. Like MergeSmallSegments,
+// an intent is recorded first, so a swap interrupted by a crash is completed
+// when the log is reopened. The given segments are left unchanged if this
+// fails before they are swapped in.
+func (l *commitLog) SwapSegments(segments []*Segment) error {
+	if len(segments) == 0 {
+		return errors.New("no segments to swap in")
+	}
+	staged := make([]*segment, len(segments))
+	for i, seg := range segments {
+		if seg.swapped {
+			return errors.Errorf("segment %d has already been swapped in", i)
+		}
+		if seg.segment.path != l.Path || seg.segment.suffix != stagedSuffix {
+			return errors.Errorf("segment %d was not created by the log", i)
+		}
+		if seg.segment.IsEmpty() {
+			return errors.Errorf("segment %d is empty", i)
+		}
+		if i > 0 && seg.BaseOffset() < staged[i-1].NextOffset() {
+			return errors.Errorf("segment %d is not ordered by offset", i)
+		}
+		staged[i] = seg.segment
+	}
+	end := staged[len(staged)-1].NextOffset()
+
+	// cleanMu keeps cleaners and merges from replacing segments for the whole
+	// swap, but mu is only held to snapshot the segments and to swap the new
+	// set in, so appends and reads aren't blocked by the file I/O.
+	l.cleanMu.Lock()
+	defer l.cleanMu.Unlock()
+	l.mu.RLock()
+	var (
+		current = l.segments
+		active  = current[len(current)-1]
+	)
+	l.mu.RUnlock()
+	if end > active.BaseOffset {
+		return errors.New("segments overlap the active segment")
+	}
+
+	// Each replaced segment's files are deleted by the new segment with the
+	// largest base offset not greater than its own, or the first if there is
+	// none, unless its files are overwritten by a new segment with the same
+	// base offset.
+	var (
+		runs     = make([][]*segment, len(staged))
+		replaced []*segment
+	)
+	for i, seg := range staged {
+		runs[i] = []*segment{seg}
+	}
+	for _, seg := range current[:len(current)-1] {
+		if seg.BaseOffset >= end {
+			break
+		}
+		replaced = append(replaced, seg)
+		i := sort.Search(len(staged), func(i int) bool {
+			return staged[i].BaseOffset > seg.BaseOffset
+		}) - 1
+		if i < 0 {
+			i = 0
+		}
+		if staged[i].BaseOffset != seg.BaseOffset {
+			runs[i] = append(runs[i], seg)
+		}
+	}
+	removeIntents := func(n int) {
+		for _, seg := range staged[:n] {
+			os.Remove(mergeIntentPath(l.Path, seg.BaseOffset)) // nolint: errcheck
+		}
+	}
+	for i, run := range runs {
+		if err := writeMergeIntent(l.Path, run, staged[i]); err != nil {
+			removeIntents(i)
+			return errors.Wrap(err, "failed to record swap intent")
+		}
+	}
+
+	if ok, err := l.swapStaged(staged, replaced, end); err != nil {
+		if !ok {
+			removeIntents(len(staged))
+		}
+		return err
+	}
+	for _, seg := range segments {
+		seg.swapped = true
+	}
+	for i, seg := range staged {
+		seg.Seal()
+		if err := finishMerge(l.Path, runs[i], seg); err != nil {
+			return errors.Wrap(err, "failed to finish swap")
+		}
+	}
+	return nil
+}
+
+// swapStaged swaps the staged segments into the log in place of the replaced
+// segments, keeping the sealed segments which follow them and the active
+// segment. The segments may have been rolled since replaced was snapshotted,
+// but if they were truncated, so that replaced is no longer a prefix of them
+// or the staged segments overlap the active segment, nothing is swapped. It
+// indicates if the staged segments were swapped in, which they may have been
+// even if an error is returned.
+func (l *commitLog) swapStaged(staged, replaced []*segment, end int64) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.segments) <= len(replaced) {
+		return false, errors.New("segments changed during swap")
+	}
+	for i, seg := range replaced {
+		if l.segments[i] != seg {
+			return false, errors.New("segments changed during swap")
+		}
+	}
+	if end > l.activeSegment().BaseOffset {
+		return false, errors.New("segments overlap the active segment")
+	}
+	swapped := make([]*segment, 0, len(staged)+len(l.segments)-len(replaced))
+	swapped = append(swapped, staged...)
+	swapped = append(swapped, l.segments[len(replaced):]...)
+	// The recorded intents complete the swap when the log is reopened if
+	// closing the replaced segments fails.
+	return true, l.swapSegments(swapped)
+}
+
+// swapSegments replaces the log's segments with the given set, which must end
+// with the active segment so that concurrent appends are not lost. Segments no
+// longer in the set are closed and marked replaced, and the log's generation
+// is bumped. The segment files are left to the caller. This must be called
+// with cleanMu and mu held.
+func (l *commitLog) swapSegments(segments []*segment) error {
+	if segments[len(segments)-1] != l.activeSegment() {
+		return errors.New("last segment is not the active segment")
+	}
+	retained := make(map[*segment]struct{}, len(segments))
+	for _, seg := range segments {
+		retained[seg] = struct{}{}
+	}
+	old := l.segments
+	l.segments = segments
+	l.generation++
+	for _, seg := range old {
+		if _, ok := retained[seg]; ok {
+			continue
+		}
+		seg.Lock()
+		seg.replaced = true
+		err := seg.close()
+		seg.Unlock()
+		if err != nil {
+			return errors.Wrap(err, "failed to close swapped segment")
+		}
+	}
+	return l.leaderEpochCache.ClearEarliest(segments[0].BaseOffset)
+}
+
+// Generation returns the log's segment generation, which is bumped whenever
+// SwapSegments or MergeSmallSegments replaces the set of segments.
+func (l *commitLog) Generation() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.generation
+}
+
 // rebaseSegments adds the segments in from to the end of the slice of segments
 // in to and adds any leader epoch offsets to the given leaderEpochCache.
 func (l *commitLog) rebaseSegments(from, to []*segment, epochCache *leaderEpochCache) []*segment {
 	to = append(to, from...)
 	// Rebase any leader epoch offsets also. We don't check the error returned
 	// here because Rebase can't return an error since epochCache is not
-	// file-backed.
-	epochCache.Rebase(l.leaderEpochCache, from[0].BaseOffset) // nolint: errcheck
+	// file-backed. If compaction did not run, there is no epochCache to
+	// rebase onto.
+	if epochCache != nil {
+		epochCache.Rebase(l.leaderEpochCache, from[0].BaseOffset) // nolint: errcheck
+	}
 	return to
 }
 
@@ -642,7 +2010,7 @@ func (l *commitLog) clean(segments []*segment) ([]*segment, *leaderEpochCache, e
 		return nil, nil, err
 	}
 	var epochCache *leaderEpochCache
-	if l.Compact {
+	if l.Options.Compact {
 		cleaned, epochCache, err = l.compactCleaner.Compact(l.HighWatermark(), cleaned)
 		if err != nil {
 			return nil, nil, err
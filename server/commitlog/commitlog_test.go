@@ -2,13 +2,20 @@ package commitlog
 
 import (
 	"context"
+	"io"
 	"io/ioutil"
+	"math"
 	"os"
+	"path/filepath"
 	"strconv"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
+
+	"github.com/liftbridge-io/liftbridge/server/proto"
 )
 
 var (
@@ -82,6 +89,62 @@ func TestAppendMessageSet(t *testing.T) {
 	}
 }
 
+func TestAppendMessage(t *testing.T) {
+	timestampBefore := timestamp
+	timestamp = func() int64 {
+		return 10
+	}
+	defer func() {
+		timestamp = timestampBefore
+	}()
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	r, err := l.NewReader(0, true)
+	require.NoError(t, err)
+
+	// Start reading before anything has been appended to ensure the reader is
+	// woken up by the append.
+	type result struct {
+		msg       SerializedMessage
+		offset    int64
+		timestamp int64
+		err       error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		headers := make([]byte, 28)
+		msg, offset, timestamp, _, err := r.ReadMessage(context.Background(), headers)
+		ch <- result{msg, offset, timestamp, err}
+	}()
+
+	msg := &Message{Value: []byte("hello")}
+	offset, ts, err := l.AppendMessage(msg)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), offset)
+	require.Equal(t, int64(10), ts)
+
+	select {
+	case res := <-ch:
+		require.NoError(t, res.err)
+		require.Equal(t, int64(0), res.offset)
+		require.Equal(t, int64(10), res.timestamp)
+		compareMessages(t, msg, res.msg)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Reader was not woken up by append")
+	}
+
+	// Messages with a timestamp set should retain it.
+	offset, ts, err = l.AppendMessage(&Message{Value: []byte("world"), Timestamp: 42})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), offset)
+	require.Equal(t, int64(42), ts)
+}
+
 func TestCommitLogRecover(t *testing.T) {
 	for _, test := range segmentSizeTests {
 		t.Run(test.name, func(t *testing.T) {
@@ -171,6 +234,132 @@ func TestOverrideHighWatermark(t *testing.T) {
 	require.Equal(t, int64(90), l.HighWatermark())
 }
 
+// Ensure HW updates made within the HW notify window result in a single
+// waiter wakeup at the highest HW.
+func TestSetHighWatermarkCoalescesNotifications(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:           tempDir(t),
+		HWNotifyWindow: 50 * time.Millisecond,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	r := &mockContextReader{}
+	wait, _ := l.waitForHW(r, -1)
+	for i := 0; i < 5; i++ {
+		l.SetHighWatermark(int64(i))
+	}
+
+	select {
+	case <-wait:
+		t.Fatal("Waiter notified before HW notify window elapsed")
+	default:
+	}
+
+	select {
+	case <-wait:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected waiter to be notified")
+	}
+	require.Equal(t, int64(4), l.HighWatermark())
+
+	// OverrideHighWatermark notifies waiters immediately.
+	wait, _ = l.waitForHW(r, 4)
+	l.OverrideHighWatermark(2)
+	select {
+	case <-wait:
+	default:
+		t.Fatal("Expected waiter to be notified")
+	}
+}
+
+// Ensure HW waiters are woken in order of priority.
+func TestHWWaiterPriority(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:           tempDir(t),
+		HWNotifyWindow: -1,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	var (
+		priorities = []int{0, 5, -1, 10, 5}
+		waits      = make([]<-chan struct{}, len(priorities))
+	)
+	for i, priority := range priorities {
+		r, err := l.NewReaderWithOptions(0, ReaderOptions{Priority: priority})
+		require.NoError(t, err)
+		waits[i], _ = l.waitForHW(r.ctxReader, -1)
+	}
+	// Readers without a priority default to zero.
+	mockWait, _ := l.waitForHW(&mockContextReader{}, -1)
+
+	l.mu.Lock()
+	var order []int
+	for _, r := range l.hwWaitersByPriority() {
+		order = append(order, waiterPriority(r))
+	}
+	l.mu.Unlock()
+	require.Equal(t, []int{10, 5, 5, 0, 0, -1}, order)
+
+	l.SetHighWatermark(0)
+	for _, wait := range append(waits, mockWait) {
+		select {
+		case <-wait:
+		default:
+			t.Fatal("Expected waiter to be notified")
+		}
+	}
+}
+
+// Ensure HW updates notify waiters immediately when the HW notify window is
+// disabled.
+func TestSetHighWatermarkNotifyWindowDisabled(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:           tempDir(t),
+		HWNotifyWindow: -1,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	wait, _ := l.waitForHW(&mockContextReader{}, -1)
+	l.SetHighWatermark(0)
+	select {
+	case <-wait:
+	default:
+		t.Fatal("Expected waiter to be notified")
+	}
+}
+
+// Ensure HW updates are coalesced by default.
+func TestSetHighWatermarkNotifyWindowDefault(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{Path: tempDir(t)})
+	defer cleanup()
+	defer l.Close()
+
+	require.Equal(t, defaultHWNotifyWindow, l.HWNotifyWindow)
+
+	// Holding the log lock keeps the pending notification from firing.
+	wait, _ := l.waitForHW(&mockContextReader{}, -1)
+	l.mu.Lock()
+	l.hw = 0
+	l.scheduleHWNotify()
+	pending := l.hwNotifyTimer != nil
+	select {
+	case <-wait:
+		t.Fatal("Waiter notified before HW notify window elapsed")
+	default:
+	}
+	l.mu.Unlock()
+	require.True(t, pending)
+
+	select {
+	case <-wait:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected waiter to be notified")
+	}
+}
+
 func BenchmarkCommitLog(b *testing.B) {
 	var err error
 	l, cleanup := setup(b)
@@ -183,6 +372,185 @@ func BenchmarkCommitLog(b *testing.B) {
 	}
 }
 
+func TestAppendBatch(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1000,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	batch := []*Message{
+		{Value: []byte("one"), Timestamp: 1},
+		{Value: []byte("two")},
+		{Value: []byte("three"), Timestamp: 3},
+	}
+	offsets, timestamps, err := l.AppendBatch(batch)
+	require.NoError(t, err)
+	require.Equal(t, []int64{0, 1, 2}, offsets)
+	require.Equal(t, int64(1), timestamps[0])
+	require.NotZero(t, timestamps[1])
+	require.Equal(t, int64(3), timestamps[2])
+	require.Equal(t, int64(2), l.NewestOffset())
+
+	// The given messages are not stamped.
+	require.Equal(t, int64(0), batch[1].Timestamp)
+}
+
+// BenchmarkCommittedReadersSlowHW measures many committed readers tailing a
+// log whose HW advances one message at a time.
+func BenchmarkCommittedReadersSlowHW(b *testing.B) {
+	l, cleanup := setupWithOptions(b, Options{
+		Path:            tempDir(b),
+		MaxSegmentBytes: 1 << 30,
+		HWNotifyWindow:  -1,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	const numReaders = 100
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		wg          sync.WaitGroup
+		read        = make(chan struct{}, numReaders)
+	)
+	defer cancel()
+	for i := 0; i < numReaders; i++ {
+		r, err := l.NewReader(0, false)
+		require.NoError(b, err)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			headers := make([]byte, 28)
+			for {
+				if _, _, _, _, err := r.ReadMessage(ctx, headers); err != nil {
+					return
+				}
+				read <- struct{}{}
+			}
+		}()
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := l.Append([]*Message{{Value: []byte("hi")}})
+		require.NoError(b, err)
+		l.SetHighWatermark(int64(i))
+		for j := 0; j < numReaders; j++ {
+			<-read
+		}
+	}
+	b.StopTimer()
+	cancel()
+	wg.Wait()
+}
+
+// Ensure AppendBatch wakes a waiting reader once for the entire batch, while
+// appending the messages one at a time wakes it once per message.
+func TestAppendBatchWakeups(t *testing.T) {
+	for _, test := range appendWakeupTests {
+		t.Run(test.name, func(t *testing.T) {
+			l, cleanup := setupWithOptions(t, Options{
+				Path:            tempDir(t),
+				MaxSegmentBytes: 1 << 20,
+			})
+			defer cleanup()
+			defer l.Close()
+
+			require.Equal(t, test.expected, countAppendWakeups(t, l, test.appends(l)))
+			require.Equal(t, int64(len(wakeupBatch)-1), l.NewestOffset())
+		})
+	}
+}
+
+// BenchmarkAppendBatchWakeups compares the number of times a waiting reader
+// is woken up when a batch of messages is appended with AppendBatch versus one
+// message at a time with AppendMessage.
+func BenchmarkAppendBatchWakeups(b *testing.B) {
+	for _, bm := range appendWakeupTests {
+		bm := bm
+		b.Run(bm.name, func(b *testing.B) {
+			l, cleanup := setupWithOptions(b, Options{
+				Path:            tempDir(b),
+				MaxSegmentBytes: 1 << 30,
+			})
+			defer cleanup()
+			defer l.Close()
+
+			var (
+				appends = bm.appends(l)
+				wakeups int
+			)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				wakeups += countAppendWakeups(b, l, appends)
+			}
+			b.StopTimer()
+
+			if wakeups != bm.expected*b.N {
+				b.Fatalf("Expected %d wakeups, got %d", bm.expected*b.N, wakeups)
+			}
+			b.ReportMetric(float64(wakeups)/float64(b.N), "wakeups/batch")
+		})
+	}
+}
+
+var wakeupBatch = func() []*Message {
+	batch := make([]*Message, 10)
+	for i := range batch {
+		batch[i] = &Message{Value: []byte(strconv.Itoa(i)), Timestamp: 1}
+	}
+	return batch
+}()
+
+var appendWakeupTests = []struct {
+	name     string
+	appends  func(l *commitLog) []func() error
+	expected int
+}{
+	{"batch", func(l *commitLog) []func() error {
+		return []func() error{func() error {
+			_, _, err := l.AppendBatch(wakeupBatch)
+			return err
+		}}
+	}, 1},
+	{"messages", func(l *commitLog) []func() error {
+		appends := make([]func() error, len(wakeupBatch))
+		for i, msg := range wakeupBatch {
+			msg := msg
+			appends[i] = func() error {
+				_, _, err := l.AppendMessage(msg)
+				return err
+			}
+		}
+		return appends
+	}, len(wakeupBatch)},
+}
+
+// countAppendWakeups performs the given appends and returns the number of
+// times a reader waiting for data on the active segment was woken up.
+func countAppendWakeups(t require.TestingT, l *commitLog, appends []func() error) int {
+	var (
+		segment = l.activeSegment()
+		waiter  = new(struct{})
+		wakeups int
+	)
+	// The waiter is registered for every append, so each notification of
+	// waiters is counted.
+	wait := segment.WaitForData(waiter, segment.Position())
+	for _, write := range appends {
+		require.NoError(t, write())
+		select {
+		case <-wait:
+			wakeups++
+			wait = segment.WaitForData(waiter, segment.Position())
+		default:
+		}
+	}
+	segment.removeWaiter(waiter)
+	return wakeups
+}
+
 func TestOffsets(t *testing.T) {
 	l, cleanup := setupWithOptions(t, Options{
 		Path:            tempDir(t),
@@ -215,6 +583,30 @@ func TestDelete(t *testing.T) {
 	require.True(t, os.IsNotExist(err))
 }
 
+// Ensure Close stops the background goroutines and closes the segments even
+// if checkpointing the high watermark fails, and that closing again is a
+// no-op.
+func TestCloseCheckpointFailure(t *testing.T) {
+	l, cleanup := setup(t)
+	defer cleanup()
+
+	_, err := l.Append(msgs)
+	require.NoError(t, err)
+
+	// Remove the log directory so the final checkpoint can't be written.
+	require.NoError(t, os.RemoveAll(l.Path))
+
+	require.Error(t, l.Close())
+	select {
+	case <-l.closed:
+	default:
+		t.Fatal("Expected log to be closed")
+	}
+	_, ok := <-l.SegmentRolls()
+	require.False(t, ok)
+	require.NoError(t, l.Close())
+}
+
 func TestCleaner(t *testing.T) {
 	l, cleanup := setup(t)
 	defer l.Close()
@@ -301,6 +693,92 @@ func TestCleanerDeleteLeaderEpochOffsets(t *testing.T) {
 	require.Equal(t, int64(14), l.LastOffsetForLeaderEpoch(3))
 }
 
+// Ensure EnforceRetention deletes the oldest segments to bring the log under
+// the byte limit without deleting the active segment.
+func TestEnforceRetentionBytes(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	for i := 0; i < 10; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	numSegments := len(l.Segments())
+	require.True(t, numSegments > 2)
+
+	// Position a reader in the oldest segment.
+	r, err := l.NewReader(0, true)
+	require.NoError(t, err)
+	headers := make([]byte, 28)
+	_, _, _, _, err = r.ReadMessage(context.Background(), headers)
+	require.NoError(t, err)
+
+	// Retain only the active segment and the one before it.
+	active := l.activeSegment()
+	limit := active.Position() + l.Segments()[numSegments-2].Position()
+	deleted, err := l.EnforceRetention(0, limit)
+	require.NoError(t, err)
+	require.Equal(t, numSegments-2, deleted)
+	require.Len(t, l.Segments(), 2)
+	require.Equal(t, l.Segments()[0].BaseOffset, l.OldestOffset())
+	require.Equal(t, active, l.activeSegment())
+
+	// The reader should be notified its data was removed.
+	_, _, _, _, err = r.ReadMessage(context.Background(), headers)
+	require.Equal(t, ErrOffsetRetained, errors.Cause(err))
+
+	// The active segment is never deleted.
+	deleted, err = l.EnforceRetention(0, 1)
+	require.NoError(t, err)
+	require.Equal(t, 1, deleted)
+	require.Len(t, l.Segments(), 1)
+	require.Equal(t, active, l.activeSegment())
+}
+
+// Ensure EnforceRetention deletes segments whose newest message is older than
+// the max age.
+func TestEnforceRetentionAge(t *testing.T) {
+	computeTTLBefore := computeTTL
+	computeTTL = func(age time.Duration) int64 {
+		return 5
+	}
+	defer func() {
+		computeTTL = computeTTLBefore
+	}()
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	for i := 0; i < 10; i++ {
+		_, err := l.Append([]*Message{{
+			Value:     []byte(strconv.Itoa(i)),
+			Timestamp: int64(i),
+		}})
+		require.NoError(t, err)
+	}
+
+	// Segments whose newest message is older than the TTL are deleted.
+	deleted, err := l.EnforceRetention(time.Minute, 0)
+	require.NoError(t, err)
+	require.True(t, deleted > 0)
+	for _, seg := range l.Segments()[:len(l.Segments())-1] {
+		require.True(t, seg.lastWriteTime >= 5)
+	}
+	require.Equal(t, int64(9), l.NewestOffset())
+
+	// Running again should be a no-op.
+	deleted, err = l.EnforceRetention(time.Minute, 0)
+	require.NoError(t, err)
+	require.Equal(t, 0, deleted)
+}
+
 // Ensure Clean replaces leader epoch offsets in the cache when segments are
 // compacted.
 func TestCleanerReplaceLeaderEpochOffsets(t *testing.T) {
@@ -381,6 +859,7 @@ func TestOffsetForTimestamp(t *testing.T) {
 	}
 	l, cleanup := setupWithOptions(t, opts)
 	defer cleanup()
+	defer l.Close()
 
 	// Append some messages.
 	numMsgs := 10
@@ -425,90 +904,1253 @@ func TestOffsetForTimestamp(t *testing.T) {
 	require.Equal(t, int64(3), offset)
 }
 
-// Ensure Truncate removes log entries up to the given offset and that the
-// leader epoch cache is also truncated.
-func TestTruncate(t *testing.T) {
+func TestOffsetForTimestampFloor(t *testing.T) {
 	l, cleanup := setupWithOptions(t, Options{
 		Path:            tempDir(t),
-		MaxSegmentBytes: 6,
+		MaxSegmentBytes: 50,
 	})
-	defer l.Close()
 	defer cleanup()
+	defer l.Close()
 
-	// Add some messages.
-	for i := 0; i < 5; i++ {
-		_, err := l.Append([]*Message{{
-			Value:       []byte(strconv.Itoa(i)),
-			Timestamp:   time.Now().UnixNano(),
-			LeaderEpoch: 1,
-		}})
-		require.NoError(t, err)
-	}
+	offset, err := l.OffsetForTimestampFloor(10)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), offset)
 
-	for i := 0; i < 5; i++ {
+	numMsgs := 10
+	for i := 0; i < numMsgs; i++ {
 		_, err := l.Append([]*Message{{
-			Value:       []byte(strconv.Itoa(i + 5)),
-			Timestamp:   time.Now().UnixNano(),
-			LeaderEpoch: 2,
+			Value:     []byte(strconv.Itoa(i)),
+			Timestamp: int64((i + 1) * 10),
 		}})
 		require.NoError(t, err)
 	}
-
-	for i := 0; i < 5; i++ {
-		_, err := l.Append([]*Message{{
-			Value:       []byte(strconv.Itoa(i + 10)),
-			Timestamp:   time.Now().UnixNano(),
-			LeaderEpoch: 3,
-		}})
+	require.True(t, len(l.Segments()) > 1)
+	l.SetHighWatermark(6)
+
+	tests := []struct {
+		timestamp int64
+		expected  int64
+	}{
+		{5, 0},    // Before the oldest message.
+		{10, 0},   // Exact match.
+		{25, 1},   // Between messages.
+		{30, 2},   // Exact match.
+		{70, 6},   // The HW.
+		{90, 6},   // After the HW.
+		{1000, 6}, // After the newest message.
+	}
+	for _, test := range tests {
+		offset, err := l.OffsetForTimestampFloor(test.timestamp)
 		require.NoError(t, err)
+		require.Equal(t, test.expected, offset, "timestamp %d", test.timestamp)
 	}
 
-	require.Equal(t, int64(14), l.NewestOffset())
-	require.Equal(t, 3, len(l.leaderEpochCache.epochOffsets))
-	require.Equal(t, uint64(3), l.LastLeaderEpoch())
-	require.Equal(t, int64(0), l.LastOffsetForLeaderEpoch(0))
-	require.Equal(t, int64(5), l.LastOffsetForLeaderEpoch(1))
-	require.Equal(t, int64(10), l.LastOffsetForLeaderEpoch(2))
-	require.Equal(t, int64(14), l.LastOffsetForLeaderEpoch(3))
-
-	require.NoError(t, l.Truncate(7))
-
-	require.Equal(t, int64(6), l.NewestOffset())
-	require.Equal(t, 2, len(l.leaderEpochCache.epochOffsets))
-	require.Equal(t, uint64(2), l.LastLeaderEpoch())
-	require.Equal(t, int64(0), l.LastOffsetForLeaderEpoch(0))
-	require.Equal(t, int64(5), l.LastOffsetForLeaderEpoch(1))
+	r, err := l.NewReaderForTimestampFloor(45, ReaderOptions{})
+	require.NoError(t, err)
+	msg, offset, _, _, err := r.ReadMessage(context.Background(), make([]byte, 28))
+	require.NoError(t, err)
+	require.Equal(t, int64(3), offset)
+	require.Equal(t, []byte("3"), msg.Value())
 }
 
-// Ensure NotifyLEO returns a closed channel when the given offset is not the
-// current log end offset.
-func TestNotifyLEOMismatch(t *testing.T) {
-	l, cleanup := setup(t)
-	defer l.Close()
+// Ensure lookups given a search budget return ErrSearchBudgetExceeded when
+// they examine more segments or index entries than allowed and otherwise
+// match the unbounded lookups.
+func TestSearchBudget(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1,
+	})
 	defer cleanup()
+	defer l.Close()
 
-	// Add some messages.
-	for i := 0; i < 5; i++ {
+	// Each message is in its own segment.
+	for i := 0; i < 10; i++ {
 		_, err := l.Append([]*Message{{
-			Value:       []byte(strconv.Itoa(i)),
-			Timestamp:   time.Now().UnixNano(),
-			LeaderEpoch: 1,
+			Value:     []byte(strconv.Itoa(i)),
+			Timestamp: int64(i * 10),
 		}})
 		require.NoError(t, err)
 	}
+	l.SetHighWatermark(9)
+	require.Len(t, l.Segments(), 10)
 
-	// Get current log end offset and then add another message.
-	leo := l.NewestOffset()
-	_, err := l.Append([]*Message{{
-		Value:       []byte(strconv.Itoa(5)),
-		Timestamp:   time.Now().UnixNano(),
-		LeaderEpoch: 1,
-	}})
+	base, position, err := l.ResolveOffsetWithBudget(5, SearchBudget{MaxSegments: 1})
+	require.NoError(t, err)
+	expectedBase, expectedPosition, err := l.ResolveOffset(5)
 	require.NoError(t, err)
+	require.Equal(t, expectedBase, base)
+	require.Equal(t, expectedPosition, position)
+
+	// Finding the segment for a timestamp reads the first index entry of
+	// each segment probed.
+	_, err = l.OffsetForTimestampWithBudget(50, SearchBudget{MaxEntries: 2})
+	require.Equal(t, ErrSearchBudgetExceeded, err)
+	offset, err := l.OffsetForTimestampWithBudget(50, SearchBudget{})
+	require.NoError(t, err)
+	require.Equal(t, int64(5), offset)
 
-	// Notify LEO should return a closed channel because the LEO is different
-	// than the expected LEO.
-	waiter := struct{}{}
+	// A timestamp between segments searches the earlier segment before
+	// finding the offset in the next one.
+	_, err = l.OffsetForTimestampWithBudget(45, SearchBudget{MaxSegments: 1})
+	require.Equal(t, ErrSearchBudgetExceeded, err)
+	offset, err = l.OffsetForTimestampWithBudget(45, SearchBudget{MaxSegments: 2})
+	require.NoError(t, err)
+	require.Equal(t, int64(5), offset)
+
+	offset, err = l.OffsetForTimestampFloorWithBudget(45, SearchBudget{MaxSegments: 1})
+	require.NoError(t, err)
+	require.Equal(t, int64(4), offset)
+	_, err = l.OffsetForTimestampFloorWithBudget(45, SearchBudget{MaxEntries: 1})
+	require.Equal(t, ErrSearchBudgetExceeded, err)
+}
+
+// Ensure ResolveOffset returns the segment and log file position of each
+// offset and ErrSegmentNotFound for offsets outside of the log.
+func TestResolveOffset(t *testing.T) {
+	opts := Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+	defer l.Close()
+
+	numMsgs := 10
+	for i := 0; i < numMsgs; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	segments := l.Segments()
+	require.True(t, len(segments) > 1)
+
+	for i := 0; i < numMsgs; i++ {
+		baseOffset, position, err := l.ResolveOffset(int64(i))
+		require.NoError(t, err)
+		seg := findSegmentByBaseOffset(segments, baseOffset)
+		require.NotNil(t, seg)
+		require.Equal(t, baseOffset, seg.BaseOffset)
+
+		// The message set header at the position should contain the offset.
+		buf := make([]byte, 8)
+		_, err = seg.ReadAt(buf, position)
+		require.NoError(t, err)
+		require.Equal(t, int64(i), int64(proto.Encoding.Uint64(buf)))
+	}
+
+	_, _, err := l.ResolveOffset(int64(numMsgs))
+	require.Equal(t, ErrSegmentNotFound, err)
+	_, _, err = l.ResolveOffset(-1)
+	require.Equal(t, ErrSegmentNotFound, err)
+}
+
+// Ensure ScanOffsets invokes the callback with the offset, timestamp, and
+// position of each message starting at the given offset.
+func TestScanOffsets(t *testing.T) {
+	opts := Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+	defer l.Close()
+
+	numMsgs := 10
+	for i := 0; i < numMsgs; i++ {
+		_, err := l.Append([]*Message{{
+			Value:     []byte(strconv.Itoa(i)),
+			Timestamp: int64(i * 10),
+		}})
+		require.NoError(t, err)
+	}
+	require.True(t, len(l.Segments()) > 1)
+
+	expected := int64(3)
+	err := l.ScanOffsets(context.Background(), 3, func(offset, timestamp, position int64) error {
+		require.Equal(t, expected, offset)
+		require.Equal(t, expected*10, timestamp)
+		_, expectedPosition, err := l.ResolveOffset(offset)
+		require.NoError(t, err)
+		require.Equal(t, expectedPosition, position)
+		expected++
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(numMsgs), expected)
+
+	// Errors returned by the callback stop the scan.
+	stop := errors.New("stop")
+	scanned := 0
+	err = l.ScanOffsets(context.Background(), 0, func(offset, timestamp, position int64) error {
+		scanned++
+		return stop
+	})
+	require.Equal(t, stop, err)
+	require.Equal(t, 1, scanned)
+
+	// Scanning past the end of the log is a no-op.
+	err = l.ScanOffsets(context.Background(), int64(numMsgs), func(offset, timestamp, position int64) error {
+		t.Fatal("Unexpected message")
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+// Ensure ActiveSegment returns the state of the segment currently being
+// appended to.
+func TestActiveSegment(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	info := l.ActiveSegment()
+	require.Equal(t, SegmentInfo{
+		BaseOffset: 0,
+		NextOffset: 0,
+		Size:       0,
+		MaxBytes:   100,
+		Remaining:  100,
+	}, info)
+
+	numMsgs := 10
+	for i := 0; i < numMsgs; i++ {
+		_, err := l.Append([]*Message{{
+			Value:     []byte(strconv.Itoa(i)),
+			Timestamp: int64(i + 1),
+		}})
+		require.NoError(t, err)
+	}
+
+	segments := l.Segments()
+	require.True(t, len(segments) > 1)
+	active := segments[len(segments)-1]
+	info = l.ActiveSegment()
+	require.Equal(t, active.BaseOffset, info.BaseOffset)
+	require.Equal(t, int64(numMsgs), info.NextOffset)
+	require.Equal(t, active.Position(), info.Size)
+	require.Equal(t, int64(100), info.MaxBytes)
+	require.Equal(t, info.MaxBytes-info.Size, info.Remaining)
+	require.False(t, info.Sealed)
+	require.NotZero(t, info.FirstWriteTime)
+}
+
+// Ensure SegmentFileForOffset returns the segment log file containing an
+// offset and errors for offsets outside the log.
+func TestSegmentFileForOffset(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	_, _, err := l.SegmentFileForOffset(0)
+	require.Equal(t, ErrInvalidOffset, errors.Cause(err))
+
+	for i := 0; i < 3; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	for _, seg := range l.Segments() {
+		path, baseOffset, err := l.SegmentFileForOffset(seg.BaseOffset)
+		require.NoError(t, err)
+		require.Equal(t, seg.BaseOffset, baseOffset)
+		require.Equal(t, seg.logPath(), path)
+		_, err = os.Stat(path)
+		require.NoError(t, err)
+	}
+	for _, offset := range []int64{-1, 3} {
+		_, _, err := l.SegmentFileForOffset(offset)
+		require.Equal(t, ErrInvalidOffset, errors.Cause(err))
+	}
+}
+
+// Ensure SegmentRolls receives the base offset of each rolled segment, drops
+// rolls once its buffer is full, and is closed when the log is closed.
+func TestSegmentRolls(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1,
+	})
+	defer cleanup()
+
+	rolls := l.SegmentRolls()
+	for i := 0; i < 3; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	require.NoError(t, l.Roll())
+	for _, expected := range []int64{1, 2, 3} {
+		require.Equal(t, expected, <-rolls)
+	}
+
+	for i := 0; i < segmentRollsBufferSize+1; i++ {
+		require.NoError(t, l.Roll())
+		_, err := l.Append([]*Message{{Value: []byte("foo")}})
+		require.NoError(t, err)
+	}
+	require.Len(t, rolls, segmentRollsBufferSize)
+
+	require.NoError(t, l.Close())
+	received := 0
+	for range rolls {
+		received++
+	}
+	require.Equal(t, segmentRollsBufferSize, received)
+
+	// Rolling after the log is closed doesn't send on the closed channel.
+	require.NotPanics(t, func() {
+		l.Roll()                                     // nolint: errcheck
+		l.Append([]*Message{{Value: []byte("foo")}}) // nolint: errcheck
+		l.Append([]*Message{{Value: []byte("foo")}}) // nolint: errcheck
+	})
+}
+
+func TestOpenSegmentCount(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 50,
+	})
+	defer cleanup()
+
+	require.Equal(t, 1, l.OpenSegmentCount())
+	for i := 0; i < 10; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	numSegments := len(l.Segments())
+	require.True(t, numSegments > 1)
+	require.Equal(t, numSegments, l.OpenSegmentCount())
+
+	// Reading across every segment does not open any additional files.
+	r, err := l.NewReader(0, true)
+	require.NoError(t, err)
+	headers := make([]byte, 28)
+	for i := 0; i < 10; i++ {
+		_, _, _, _, err := r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+	}
+	require.Equal(t, numSegments, l.OpenSegmentCount())
+
+	require.NoError(t, l.Close())
+	require.Equal(t, 0, l.OpenSegmentCount())
+}
+
+// Ensures SegmentBoundaryMessages returns the first message of each segment.
+func TestSegmentBoundaryMessages(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	boundaries, err := l.SegmentBoundaryMessages()
+	require.NoError(t, err)
+	require.Empty(t, boundaries)
+
+	for i := 0; i < 10; i++ {
+		_, err := l.Append([]*Message{{
+			Value:     []byte(strconv.Itoa(i)),
+			Timestamp: int64(100 + i),
+		}})
+		require.NoError(t, err)
+	}
+	require.NoError(t, l.Roll())
+
+	// The empty active segment is skipped.
+	segments := l.Segments()
+	boundaries, err = l.SegmentBoundaryMessages()
+	require.NoError(t, err)
+	require.Len(t, boundaries, len(segments)-1)
+	for i, boundary := range boundaries {
+		base := segments[i].BaseOffset
+		require.Equal(t, SegmentBoundary{
+			BaseOffset: base,
+			Offset:     base,
+			Timestamp:  100 + base,
+		}, boundary)
+	}
+}
+
+// Ensure ReadPageReverse pages backwards through committed messages across
+// segments and compressed batches and ends at the oldest offset.
+func TestReadPageReverse(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	for i := 0; i < 6; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i)), Timestamp: int64(i)}})
+		require.NoError(t, err)
+	}
+	_, err := l.AppendCompressed([]*Message{
+		{Value: []byte("6"), Timestamp: 6},
+		{Value: []byte("7"), Timestamp: 7},
+		{Value: []byte("8"), Timestamp: 8},
+	})
+	require.NoError(t, err)
+	_, err = l.Append([]*Message{{Value: []byte("9"), Timestamp: 9}})
+	require.NoError(t, err)
+	l.SetHighWatermark(8)
+	require.True(t, len(l.Segments()) > 1)
+
+	var (
+		before = int64(100)
+		pages  [][]int64
+	)
+	for before != -1 {
+		msgs, offsets, timestamps, next, err := l.ReadPageReverse(before, 4)
+		require.NoError(t, err)
+		for i, offset := range offsets {
+			require.Equal(t, []byte(strconv.FormatInt(offset, 10)), msgs[i].Value())
+			require.Equal(t, offset, timestamps[i])
+		}
+		pages = append(pages, offsets)
+		before = next
+	}
+	require.Equal(t, [][]int64{{8, 7, 6, 5}, {4, 3, 2, 1}, {0}}, pages)
+
+	// A page can end within a compressed batch.
+	_, offsets, _, next, err := l.ReadPageReverse(8, 1)
+	require.NoError(t, err)
+	require.Equal(t, []int64{7}, offsets)
+	require.Equal(t, int64(7), next)
+
+	_, _, _, _, err = l.ReadPageReverse(5, 0)
+	require.Error(t, err)
+}
+
+// Ensure ReadPageReverse skips control records and returns an error for a
+// message whose CRC does not match, like Readers.
+func TestReadPageReverseControlAndCorrupt(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	appendToLog(t, l, []keyValue{{value: []byte("0")}, {value: []byte("1")}}, false)
+	_, err := l.CloseStream()
+	require.NoError(t, err)
+	_, err = l.Append([]*Message{{Value: []byte("3"), Attributes: AttrControl}})
+	require.NoError(t, err)
+	appendToLog(t, l, []keyValue{{value: []byte("4")}}, false)
+	l.SetHighWatermark(4)
+
+	msgs, offsets, _, next, err := l.ReadPageReverse(5, 3)
+	require.NoError(t, err)
+	require.Equal(t, []int64{4, 1, 0}, offsets)
+	require.Equal(t, []byte("4"), msgs[0].Value())
+	require.Equal(t, int64(-1), next)
+
+	corruptMessage(t, l, 1)
+	_, _, _, _, err = l.ReadPageReverse(5, 3)
+	require.Error(t, err)
+	_, offsets, _, _, err = l.ReadPageReverse(5, 1)
+	require.NoError(t, err)
+	require.Equal(t, []int64{4}, offsets)
+}
+
+// Ensure TailReverse returns the last committed messages newest first and
+// stops at the oldest offset in the log.
+func TestTailReverse(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	msgs, offsets, timestamps, err := l.TailReverse(context.Background(), 3)
+	require.NoError(t, err)
+	require.Empty(t, msgs)
+	require.Empty(t, offsets)
+	require.Empty(t, timestamps)
+
+	for i := 0; i < 6; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i)), Timestamp: int64(i)}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(4)
+
+	msgs, offsets, timestamps, err = l.TailReverse(context.Background(), 3)
+	require.NoError(t, err)
+	require.Equal(t, []int64{4, 3, 2}, offsets)
+	require.Equal(t, []int64{4, 3, 2}, timestamps)
+	for i, offset := range offsets {
+		require.Equal(t, []byte(strconv.FormatInt(offset, 10)), msgs[i].Value())
+	}
+
+	// Delete the oldest segments so that fewer than n messages remain.
+	deleted, err := l.EnforceRetention(0, 3*l.Segments()[0].Position())
+	require.NoError(t, err)
+	require.True(t, deleted > 0)
+	oldest := l.OldestOffset()
+	require.True(t, oldest > 0 && oldest < 4)
+	_, offsets, _, err = l.TailReverse(context.Background(), 10)
+	require.NoError(t, err)
+	expected := []int64{}
+	for offset := int64(4); offset >= oldest; offset-- {
+		expected = append(expected, offset)
+	}
+	require.Equal(t, expected, offsets)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, _, err = l.TailReverse(ctx, 3)
+	require.Equal(t, context.Canceled, err)
+
+	_, _, _, err = l.TailReverse(context.Background(), 0)
+	require.Error(t, err)
+}
+
+// Ensure TailReverse skips placeholder messages, which are not data.
+func TestTailReversePlaceholders(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	_, err := l.Append([]*Message{
+		{Value: []byte("0")},
+		{Attributes: AttrPlaceholder},
+		{Value: []byte("2")},
+		{Attributes: AttrPlaceholder},
+	})
+	require.NoError(t, err)
+	l.SetHighWatermark(3)
+
+	msgs, offsets, _, err := l.TailReverse(context.Background(), 3)
+	require.NoError(t, err)
+	require.Equal(t, []int64{2, 0}, offsets)
+	require.Equal(t, []byte("2"), msgs[0].Value())
+	require.Equal(t, []byte("0"), msgs[1].Value())
+}
+
+// Ensures Stats returns a snapshot of the log consistent with its segments
+// and HW.
+func TestLogStats(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+		// Notify HW waiters immediately so that the reader isn't woken by a
+		// pending notification and briefly stops waiting.
+		HWNotifyWindow: -1,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	require.Equal(t, LogStats{
+		OldestOffset:  -1,
+		HighWatermark: -1,
+		NewestOffset:  -1,
+		SegmentCount:  1,
+	}, l.Stats())
+
+	for i := 0; i < 10; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(6)
+
+	r, err := l.NewReader(7, false)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.ReadMessage(ctx, make([]byte, 28))
+	require.Eventually(t, func() bool { return l.Stats().HWWaiters == 1 },
+		time.Second, time.Millisecond)
+
+	segments := l.Segments()
+	totalBytes := int64(0)
+	for _, seg := range segments {
+		totalBytes += seg.Position()
+	}
+	stats := l.Stats()
+	require.Equal(t, LogStats{
+		OldestOffset:      0,
+		HighWatermark:     6,
+		NewestOffset:      9,
+		SegmentCount:      len(segments),
+		TotalBytes:        totalBytes,
+		ActiveSegmentSize: segments[len(segments)-1].Position(),
+		HWWaiters:         1,
+	}, stats)
+	require.True(t, stats.SegmentCount > 1)
+}
+
+func TestIndexEntries(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 50,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	entries, err := l.IndexEntries(0, 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 0)
+
+	numMsgs := 10
+	for i := 0; i < numMsgs; i++ {
+		_, err := l.Append([]*Message{{
+			Value:     []byte(strconv.Itoa(i)),
+			Timestamp: int64(i + 1),
+		}})
+		require.NoError(t, err)
+	}
+	require.True(t, len(l.Segments()) > 1)
+
+	// The range is clamped to the log and stitched across segments.
+	entries, err = l.IndexEntries(-5, 100)
+	require.NoError(t, err)
+	require.Len(t, entries, numMsgs)
+	for i, e := range entries {
+		require.Equal(t, int64(i), e.Offset)
+		require.Equal(t, int64(i+1), e.Timestamp)
+
+		// Each entry matches the message it describes.
+		seg, _ := findSegment(l.Segments(), e.Offset)
+		require.Equal(t, seg.BaseOffset, e.BaseOffset)
+		data := make([]byte, e.Size)
+		_, err := seg.ReadAt(data, e.Position)
+		require.NoError(t, err)
+		ms := messageSet(data)
+		require.Equal(t, e.Offset, ms.Offset())
+		require.Equal(t, []byte(strconv.Itoa(i)), ms.Message().Value())
+	}
+
+	entries, err = l.IndexEntries(3, 6)
+	require.NoError(t, err)
+	require.Len(t, entries, 4)
+	require.Equal(t, int64(3), entries[0].Offset)
+	require.Equal(t, int64(6), entries[3].Offset)
+
+	entries, err = l.IndexEntries(6, 3)
+	require.NoError(t, err)
+	require.Len(t, entries, 0)
+}
+
+// Ensure SparseIndex samples an index entry every byte interval across
+// segments.
+func TestSparseIndex(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 200,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	for i := 0; i < 20; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i % 10))}})
+		require.NoError(t, err)
+	}
+	require.True(t, len(l.Segments()) > 1)
+	all, err := l.IndexEntries(0, 19)
+	require.NoError(t, err)
+	size := int64(all[0].Size)
+
+	// Every entry is sampled with an interval of at most one message.
+	entries, err := l.SparseIndex(size)
+	require.NoError(t, err)
+	require.Equal(t, all, entries)
+
+	entries, err = l.SparseIndex(3 * size)
+	require.NoError(t, err)
+	require.Len(t, entries, 7)
+	for i, e := range entries {
+		require.Equal(t, all[3*i], e)
+	}
+
+	_, err = l.SparseIndex(0)
+	require.Error(t, err)
+}
+
+func TestByteSize(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	size, err := l.ByteSize(0, 10)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), size)
+
+	numMsgs := 20
+	for i := 0; i < numMsgs; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	require.True(t, len(l.Segments()) > 2)
+
+	// The size matches the sum of the index entry sizes for every range.
+	entries, err := l.IndexEntries(0, int64(numMsgs-1))
+	require.NoError(t, err)
+	for from := int64(-1); from <= int64(numMsgs); from++ {
+		for to := from; to <= int64(numMsgs); to++ {
+			var expected int64
+			for _, e := range entries {
+				if e.Offset >= from && e.Offset <= to {
+					expected += int64(e.Size)
+				}
+			}
+			size, err := l.ByteSize(from, to)
+			require.NoError(t, err)
+			require.Equal(t, expected, size, "from %d to %d", from, to)
+		}
+	}
+}
+
+// Ensure Latest returns the message at the HW, waiting for one to be committed
+// if the log has none.
+func TestLatest(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, _, _, err := l.Latest(ctx)
+	require.Equal(t, context.DeadlineExceeded, err)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		for i := 0; i < 3; i++ {
+			_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i)), Timestamp: int64(i)}})
+			require.NoError(t, err)
+		}
+		l.SetHighWatermark(1)
+	}()
+	msg, offset, timestamp, err := l.Latest(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(1), offset)
+	require.Equal(t, int64(1), timestamp)
+	require.Equal(t, []byte("1"), msg.Value())
+
+	l.SetHighWatermark(2)
+	msg, offset, _, err = l.Latest(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(2), offset)
+	require.Equal(t, []byte("2"), msg.Value())
+
+	// An end-of-stream marker at the HW is skipped.
+	eos, err := l.CloseStream()
+	require.NoError(t, err)
+	l.SetHighWatermark(eos)
+	msg, offset, _, err = l.Latest(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(2), offset)
+	require.Equal(t, []byte("2"), msg.Value())
+}
+
+// Ensure Latest waits for a message to be committed when only control records
+// have been.
+func TestLatestControlOnly(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	_, err := l.Append([]*Message{{Attributes: AttrControl}})
+	require.NoError(t, err)
+	l.SetHighWatermark(0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, _, _, err = l.Latest(ctx)
+	require.Equal(t, context.DeadlineExceeded, err)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		appendToLog(t, l, []keyValue{{value: []byte("1")}}, true)
+	}()
+	msg, offset, _, err := l.Latest(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(1), offset)
+	require.Equal(t, []byte("1"), msg.Value())
+}
+
+// Ensures ReadAfter blocks until the written offset is committed and then
+// returns the message at that offset.
+func TestReadAfter(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	for i := 0; i < 5; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(1)
+
+	// The offset is not committed, so the read times out.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	headers := make([]byte, 28)
+	_, _, _, _, err := l.ReadAfter(ctx, 3, headers)
+	require.Equal(t, context.DeadlineExceeded, err)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		l.SetHighWatermark(2)
+		time.Sleep(10 * time.Millisecond)
+		l.SetHighWatermark(4)
+	}()
+	msg, offset, _, _, err := l.ReadAfter(context.Background(), 3, headers)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), offset)
+	require.Equal(t, []byte("3"), msg.Value())
+	require.True(t, l.HighWatermark() >= 3)
+
+	// Offsets already committed are read immediately.
+	require.NoError(t, l.WaitForCommit(context.Background(), 1))
+}
+
+// Ensure NewReaderCommittedLastDuration starts at the earliest message within
+// the duration of the current time, clamped to the oldest message.
+func TestNewReaderCommittedLastDuration(t *testing.T) {
+	timestampBefore := timestamp
+	timestamp = func() int64 {
+		return int64(100 * time.Second)
+	}
+	defer func() {
+		timestamp = timestampBefore
+	}()
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	for i := 0; i < 10; i++ {
+		_, err := l.Append([]*Message{{
+			Value:     []byte(strconv.Itoa(i)),
+			Timestamp: int64(time.Duration(91+i) * time.Second),
+		}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(9)
+
+	headers := make([]byte, 28)
+	for _, test := range []struct {
+		d        time.Duration
+		expected int64
+	}{
+		{5 * time.Second, 4},
+		{30 * time.Second, 0},
+	} {
+		r, err := l.NewReaderCommittedLastDuration(test.d)
+		require.NoError(t, err)
+		_, offset, _, _, err := r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+		require.Equal(t, test.expected, offset)
+	}
+
+	// If no message is recent enough, the reader waits for the next one.
+	timestamp = func() int64 {
+		return int64(200 * time.Second)
+	}
+	r, err := l.NewReaderCommittedLastDuration(5 * time.Second)
+	require.NoError(t, err)
+	_, err = l.Append([]*Message{{Value: []byte("10")}})
+	require.NoError(t, err)
+	l.SetHighWatermark(10)
+	_, offset, _, _, err := r.ReadMessage(context.Background(), headers)
+	require.NoError(t, err)
+	require.Equal(t, int64(10), offset)
+}
+
+// Ensures NewReaderUncommittedUntil returns the messages before the LEO,
+// waiting for any not yet written, and then ErrEndOfStream.
+func TestNewReaderUncommittedUntil(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	for i := 0; i < 3; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+
+	r, err := l.NewReaderUncommittedUntil(1, 5)
+	require.NoError(t, err)
+	headers := make([]byte, 28)
+	for i := int64(1); i < 3; i++ {
+		_, offset, _, _, err := r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+		require.Equal(t, i, offset)
+	}
+
+	// The LEO is beyond the current data, so the reader waits for it.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		for i := 3; i < 6; i++ {
+			l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		}
+	}()
+	for i := int64(3); i < 5; i++ {
+		msg, offset, _, _, err := r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+		require.Equal(t, i, offset)
+		require.Equal(t, []byte(strconv.Itoa(int(i))), msg.Value())
+	}
+	_, _, _, _, err = r.ReadMessage(context.Background(), headers)
+	require.Equal(t, &ErrEndOfStream{LastOffset: 4}, err)
+	_, _, _, _, err = r.ReadMessage(context.Background(), headers)
+	require.Equal(t, &ErrEndOfStream{LastOffset: 4}, err)
+	require.Equal(t, io.EOF, errors.Cause(err))
+}
+
+// Ensure Clone copies messages from the given offset onward into a new log
+// based at that offset, preserving offsets and leaving the original intact.
+func TestClone(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	numMsgs := 20
+	for i := 0; i < numMsgs; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i)), LeaderEpoch: uint64(i / 10)}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(14)
+	require.True(t, len(l.Segments()) > 2)
+
+	dir := tempDir(t)
+	defer remove(t, dir)
+	clone, err := l.Clone(context.Background(), dir, 7)
+	require.NoError(t, err)
+	defer clone.Close()
+
+	require.Equal(t, int64(7), clone.OldestOffset())
+	require.Equal(t, int64(numMsgs-1), clone.NewestOffset())
+	require.Equal(t, int64(14), clone.HighWatermark())
+	require.Equal(t, int64(10), clone.LastOffsetForLeaderEpoch(0))
+	require.True(t, len(clone.(*commitLog).Segments()) > 1)
+	require.Equal(t, int64(7), clone.(*commitLog).Segments()[0].BaseOffset)
+
+	r, err := clone.NewReader(7, true)
+	require.NoError(t, err)
+	headers := make([]byte, 28)
+	for i := 7; i < numMsgs; i++ {
+		msg, offset, _, epoch, err := r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+		require.Equal(t, int64(i), offset)
+		require.Equal(t, uint64(i/10), epoch)
+		require.Equal(t, []byte(strconv.Itoa(i)), msg.Value())
+	}
+
+	// The clone is independent of the original.
+	_, err = clone.Append([]*Message{{Value: []byte("clone")}})
+	require.NoError(t, err)
+	require.Equal(t, int64(numMsgs), clone.NewestOffset())
+	require.Equal(t, int64(numMsgs-1), l.NewestOffset())
+
+	// The clone directory must be empty.
+	_, err = l.Clone(context.Background(), dir, 0)
+	require.Error(t, err)
+
+	// Canceling the context removes the partial clone.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	dir2 := tempDir(t)
+	defer remove(t, dir2)
+	_, err = l.Clone(ctx, dir2, 0)
+	require.Equal(t, context.Canceled, err)
+	_, err = os.Stat(dir2)
+	require.True(t, os.IsNotExist(err))
+}
+
+// Ensure Clone from an offset inside a compressed batch frame copies only the
+// frame's messages at or following the offset.
+func TestCloneCompressedBatch(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1024,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	_, err := l.Append([]*Message{{Value: []byte("0"), Timestamp: 0}})
+	require.NoError(t, err)
+	_, err = l.AppendCompressed([]*Message{
+		{Value: []byte("1"), Timestamp: 1},
+		{Value: []byte("2"), Timestamp: 2},
+		{Value: []byte("3"), Timestamp: 3},
+		{Value: []byte("4"), Timestamp: 4},
+	})
+	require.NoError(t, err)
+	_, err = l.Append([]*Message{{Value: []byte("5"), Timestamp: 5}})
+	require.NoError(t, err)
+
+	dir := tempDir(t)
+	defer remove(t, dir)
+	clone, err := l.Clone(context.Background(), dir, 2)
+	require.NoError(t, err)
+	defer clone.Close()
+	require.Equal(t, int64(5), clone.NewestOffset())
+
+	// The clone's frame starts at the clone's base offset.
+	ms, _, err := newSegmentScanner(clone.(*commitLog).Segments()[0]).Scan()
+	require.NoError(t, err)
+	require.True(t, ms.Message().IsCompressed())
+	inner, err := decompressMessageSet(ms.Message(), minMaxBatchBytes)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), inner.Offset())
+
+	r, err := clone.NewReader(2, true)
+	require.NoError(t, err)
+	headers := make([]byte, 28)
+	for i := int64(2); i < 6; i++ {
+		msg, offset, timestamp, _, err := r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+		require.Equal(t, i, offset)
+		require.Equal(t, i, timestamp)
+		require.Equal(t, []byte(strconv.FormatInt(i, 10)), msg.Value())
+	}
+}
+
+// Ensure a Reader with MaxOpenSegments keeps at most that many segment files
+// of its own open, transparently reopening them when it returns to their
+// segments, including after the segments are merged.
+func TestReaderMaxOpenSegments(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 50,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	numMsgs := 10
+	for i := 0; i < numMsgs; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(int64(numMsgs - 1))
+	numSegments := len(l.Segments())
+	require.True(t, numSegments > 3)
+
+	r, err := l.NewReaderWithOptions(0, ReaderOptions{MaxOpenSegments: 2})
+	require.NoError(t, err)
+	headers := make([]byte, 28)
+	read := func(from, to int) {
+		for i := from; i < to; i++ {
+			msg, offset, _, _, err := r.ReadMessage(context.Background(), headers)
+			require.NoError(t, err)
+			require.Equal(t, int64(i), offset)
+			require.Equal(t, []byte(strconv.Itoa(i)), msg.Value())
+			require.True(t, r.OpenSegmentCount() <= 2)
+		}
+	}
+	read(0, numMsgs)
+	require.Equal(t, 2, r.OpenSegmentCount())
+	require.Equal(t, numSegments, l.OpenSegmentCount())
+
+	// Rereading from the start reopens the closed files.
+	require.NoError(t, r.reposition(0))
+	read(0, 5)
+	require.NoError(t, l.MergeSmallSegments(1024))
+	read(5, numMsgs)
+
+	require.NoError(t, r.Close())
+	require.Equal(t, 0, r.OpenSegmentCount())
+}
+
+// Ensure Roll seals the active segment and starts a new one and that readers
+// transition across the new segment boundary.
+func TestRoll(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1000,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	// Rolling an empty active segment is a no-op.
+	require.NoError(t, l.Roll())
+	require.Len(t, l.Segments(), 1)
+
+	appendToLog(t, l, []keyValue{{value: []byte("a")}, {value: []byte("b")}}, true)
+	r, err := l.NewReader(0, false)
+	require.NoError(t, err)
+
+	require.NoError(t, l.Roll())
+	segments := l.Segments()
+	require.Len(t, segments, 2)
+	require.True(t, segments[0].Sealed())
+	require.Equal(t, int64(2), segments[1].BaseOffset)
+	require.Equal(t, int64(2), l.ActiveSegment().BaseOffset)
+
+	// Rolling again without new writes is a no-op.
+	require.NoError(t, l.Roll())
+	require.Len(t, l.Segments(), 2)
+
+	appendToLog(t, l, []keyValue{{value: []byte("c")}}, true)
+	headers := make([]byte, 28)
+	for i := 0; i < 3; i++ {
+		_, offset, _, _, err := r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+		require.Equal(t, int64(i), offset)
+	}
+}
+
+// Ensure Truncate removes log entries up to the given offset and that the
+// leader epoch cache is also truncated.
+func TestTruncate(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 6,
+	})
+	defer l.Close()
+	defer cleanup()
+
+	// Add some messages.
+	for i := 0; i < 5; i++ {
+		_, err := l.Append([]*Message{{
+			Value:       []byte(strconv.Itoa(i)),
+			Timestamp:   time.Now().UnixNano(),
+			LeaderEpoch: 1,
+		}})
+		require.NoError(t, err)
+	}
+
+	for i := 0; i < 5; i++ {
+		_, err := l.Append([]*Message{{
+			Value:       []byte(strconv.Itoa(i + 5)),
+			Timestamp:   time.Now().UnixNano(),
+			LeaderEpoch: 2,
+		}})
+		require.NoError(t, err)
+	}
+
+	for i := 0; i < 5; i++ {
+		_, err := l.Append([]*Message{{
+			Value:       []byte(strconv.Itoa(i + 10)),
+			Timestamp:   time.Now().UnixNano(),
+			LeaderEpoch: 3,
+		}})
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, int64(14), l.NewestOffset())
+	require.Equal(t, 3, len(l.leaderEpochCache.epochOffsets))
+	require.Equal(t, uint64(3), l.LastLeaderEpoch())
+	require.Equal(t, int64(0), l.LastOffsetForLeaderEpoch(0))
+	require.Equal(t, int64(5), l.LastOffsetForLeaderEpoch(1))
+	require.Equal(t, int64(10), l.LastOffsetForLeaderEpoch(2))
+	require.Equal(t, int64(14), l.LastOffsetForLeaderEpoch(3))
+
+	require.NoError(t, l.Truncate(7))
+
+	require.Equal(t, int64(6), l.NewestOffset())
+	require.Equal(t, 2, len(l.leaderEpochCache.epochOffsets))
+	require.Equal(t, uint64(2), l.LastLeaderEpoch())
+	require.Equal(t, int64(0), l.LastOffsetForLeaderEpoch(0))
+	require.Equal(t, int64(5), l.LastOffsetForLeaderEpoch(1))
+}
+
+// Ensure Truncate at an offset inside a compressed batch frame retains the
+// frame's messages preceding the offset.
+func TestTruncateCompressedBatch(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1024,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	_, err := l.Append([]*Message{{Value: []byte("0"), Timestamp: 0}})
+	require.NoError(t, err)
+	_, err = l.AppendCompressed([]*Message{
+		{Value: []byte("1"), Timestamp: 1},
+		{Value: []byte("2"), Timestamp: 2},
+		{Value: []byte("3"), Timestamp: 3},
+		{Value: []byte("4"), Timestamp: 4},
+	})
+	require.NoError(t, err)
+	_, err = l.Append([]*Message{{Value: []byte("5"), Timestamp: 5}})
+	require.NoError(t, err)
+
+	require.NoError(t, l.Truncate(3))
+	require.Equal(t, int64(2), l.NewestOffset())
+
+	// New messages follow the retained part of the frame.
+	offsets, err := l.Append([]*Message{{Value: []byte("3"), Timestamp: 3}})
+	require.NoError(t, err)
+	require.Equal(t, []int64{3}, offsets)
+
+	r, err := l.NewReader(0, true)
+	require.NoError(t, err)
+	headers := make([]byte, 28)
+	for i := int64(0); i < 4; i++ {
+		msg, offset, timestamp, _, err := r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+		require.Equal(t, i, offset)
+		require.Equal(t, i, timestamp)
+		require.Equal(t, []byte(strconv.FormatInt(i, 10)), msg.Value())
+	}
+
+	// Truncating at the start of a frame drops it entirely.
+	require.NoError(t, l.Truncate(1))
+	require.Equal(t, int64(0), l.NewestOffset())
+}
+
+// Ensure NotifyLEO returns a closed channel when the given offset is not the
+// current log end offset.
+func TestNotifyLEOMismatch(t *testing.T) {
+	l, cleanup := setup(t)
+	defer l.Close()
+	defer cleanup()
+
+	// Add some messages.
+	for i := 0; i < 5; i++ {
+		_, err := l.Append([]*Message{{
+			Value:       []byte(strconv.Itoa(i)),
+			Timestamp:   time.Now().UnixNano(),
+			LeaderEpoch: 1,
+		}})
+		require.NoError(t, err)
+	}
+
+	// Get current log end offset and then add another message.
+	leo := l.NewestOffset()
+	_, err := l.Append([]*Message{{
+		Value:       []byte(strconv.Itoa(5)),
+		Timestamp:   time.Now().UnixNano(),
+		LeaderEpoch: 1,
+	}})
+	require.NoError(t, err)
+
+	// Notify LEO should return a closed channel because the LEO is different
+	// than the expected LEO.
+	waiter := struct{}{}
 	ch := l.NotifyLEO(waiter, leo)
 	select {
 	case <-ch:
@@ -614,3 +2256,297 @@ func tempDir(t require.TestingT) string {
 func remove(t require.TestingT, path string) {
 	require.NoError(t, os.RemoveAll(path))
 }
+
+// Ensure SwapSegments atomically replaces the segments, that readers resume
+// from their position in the new set, that invalid sets are rejected, and
+// that the new segments' files are in place when the log is reopened.
+func TestSwapSegments(t *testing.T) {
+	opts := Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+	defer l.Close()
+
+	for i := 0; i < 10; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(9)
+	segments := l.Segments()
+	require.Len(t, segments, 10)
+
+	r, err := l.NewReader(0, false)
+	require.NoError(t, err)
+	headers := make([]byte, 28)
+	for i := 0; i < 2; i++ {
+		_, offset, _, _, err := r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+		require.Equal(t, int64(i), offset)
+	}
+
+	// Rebuild the sealed segments as a single segment retaining only the
+	// even offsets.
+	rebuilt, err := l.NewSegment(0)
+	require.NoError(t, err)
+	for offset := int64(0); offset < 9; offset += 2 {
+		require.NoError(t, rebuilt.Write(offset, &Message{Value: []byte(strconv.FormatInt(offset, 10))}))
+	}
+	require.Error(t, rebuilt.Write(4, &Message{}))
+	require.Equal(t, int64(9), rebuilt.Info().NextOffset)
+	_, err = l.NewSegment(0)
+	require.Equal(t, ErrSegmentExists, err)
+
+	// The new set must be non-empty, ordered, and end before the active
+	// segment.
+	overlapping, err := l.NewSegment(9)
+	require.NoError(t, err)
+	require.NoError(t, overlapping.Write(9, &Message{}))
+	empty, err := l.NewSegment(20)
+	require.NoError(t, err)
+	require.Error(t, l.SwapSegments(nil))
+	require.Error(t, l.SwapSegments([]*Segment{rebuilt, overlapping}))
+	require.Error(t, l.SwapSegments([]*Segment{overlapping, rebuilt}))
+	require.Error(t, l.SwapSegments([]*Segment{rebuilt, empty}))
+	require.NoError(t, overlapping.Delete())
+	require.NoError(t, empty.Delete())
+	require.Equal(t, uint64(0), l.Generation())
+
+	require.NoError(t, l.SwapSegments([]*Segment{rebuilt}))
+	require.Equal(t, uint64(1), l.Generation())
+	require.Len(t, l.Segments(), 2)
+	require.True(t, l.Segments()[0].Sealed())
+	_, err = segments[1].ReadAt(make([]byte, 1), 0)
+	require.Equal(t, ErrSegmentReplaced, err)
+	require.Error(t, rebuilt.Write(10, &Message{}))
+	require.Error(t, rebuilt.Delete())
+	require.Error(t, l.SwapSegments([]*Segment{rebuilt}))
+
+	for _, expected := range []int64{2, 4, 6, 8, 9} {
+		msg, offset, _, _, err := r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+		require.Equal(t, expected, offset)
+		require.Equal(t, []byte(strconv.FormatInt(expected, 10)), msg.Value())
+	}
+
+	// Only the swapped in and active segments' files remain, and segments
+	// left staged are removed when the log is reopened.
+	_, err = l.NewSegment(50)
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+	files, err := ioutil.ReadDir(opts.Path)
+	require.NoError(t, err)
+	var names []string
+	for _, file := range files {
+		if ext := filepath.Ext(file.Name()); ext == logSuffix || ext == indexSuffix {
+			names = append(names, file.Name())
+		}
+	}
+	require.Equal(t, []string{
+		"00000000000000000000.index", "00000000000000000000.log",
+		"00000000000000000009.index", "00000000000000000009.log",
+	}, names)
+
+	l2, err := New(opts)
+	require.NoError(t, err)
+	defer l2.Close()
+	files, err = ioutil.ReadDir(opts.Path)
+	require.NoError(t, err)
+	for _, file := range files {
+		require.False(t, isStagedSuffix(filepath.Ext(file.Name())), file.Name())
+	}
+	r, err = l2.NewReader(0, true)
+	require.NoError(t, err)
+	for _, expected := range []int64{0, 2, 4, 6, 8, 9} {
+		msg, offset, _, _, err := r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+		require.Equal(t, expected, offset)
+		require.Equal(t, []byte(strconv.FormatInt(expected, 10)), msg.Value())
+	}
+}
+
+// Ensure segments truncated between SwapSegments snapshotting the segments
+// to replace and swapping in the new set are not swapped out, since the swap
+// would otherwise bring back truncated messages.
+func TestSwapSegmentsTruncated(t *testing.T) {
+	opts := Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+	defer l.Close()
+
+	for i := 0; i < 5; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	rebuilt, err := l.NewSegment(0)
+	require.NoError(t, err)
+	require.NoError(t, rebuilt.Write(0, &Message{Value: []byte("0")}))
+	require.NoError(t, rebuilt.Write(2, &Message{Value: []byte("2")}))
+	replaced := l.segments[:3]
+
+	require.NoError(t, l.Truncate(2))
+	ok, err := l.swapStaged([]*segment{rebuilt.segment}, replaced, 3)
+	require.Error(t, err)
+	require.False(t, ok)
+	require.Equal(t, uint64(0), l.Generation())
+	require.Equal(t, int64(1), l.NewestOffset())
+}
+
+// Ensure a log written with varint message set headers is read, scanned,
+// recovered, truncated, compacted, cloned, and replicated like one written
+// with fixed headers, that it takes less space, and that it remains readable
+// when reopened with fixed headers, leaving segments with both formats.
+func TestHeaderFormatVarint(t *testing.T) {
+	opts := Options{
+		Path:         tempDir(t),
+		HeaderFormat: HeaderFormatVarint,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+
+	var (
+		ctx      = context.Background()
+		numMsgs  = 12
+		expected = make([][]byte, numMsgs)
+	)
+	for i := range expected {
+		expected[i] = []byte(strconv.Itoa(i))
+	}
+	_, err := l.Append([]*Message{{Value: expected[0], Timestamp: 100, LeaderEpoch: 1}})
+	require.NoError(t, err)
+	_, _, err = l.AppendBatch([]*Message{
+		{Value: expected[1], Timestamp: 101, LeaderEpoch: 1},
+		{Value: expected[2], Timestamp: 102, LeaderEpoch: 1},
+	})
+	require.NoError(t, err)
+	_, err = l.AppendCompressed([]*Message{
+		{Value: expected[3], Timestamp: 103, LeaderEpoch: 1},
+		{Value: expected[4], Timestamp: 104, LeaderEpoch: 1},
+	})
+	require.NoError(t, err)
+	ms, _, err := newMessageSetFromProto(5, 0, []*Message{{Value: expected[5], Timestamp: 105, LeaderEpoch: 2}})
+	require.NoError(t, err)
+	_, err = l.AppendMessageSet(ms)
+	require.NoError(t, err)
+	l.SetHighWatermark(5)
+
+	fixed, cleanupFixed := setupWithOptions(t, Options{Path: tempDir(t)})
+	defer cleanupFixed()
+	defer fixed.Close()
+
+	// Readers return the headers in the fixed format, so they can be
+	// replicated to a log which uses it.
+	for _, uncommitted := range []bool{false, true} {
+		r, err := l.NewReader(0, uncommitted)
+		require.NoError(t, err)
+		headers := make([]byte, msgSetHeaderLen)
+		for i := 0; i <= 5; i++ {
+			msg, offset, timestamp, epoch, err := r.ReadMessage(ctx, headers)
+			require.NoError(t, err)
+			require.Equal(t, int64(i), offset)
+			require.Equal(t, int64(100+i), timestamp)
+			require.Equal(t, uint64(1+i/5), epoch)
+			require.Equal(t, expected[i], msg.Value())
+			require.Equal(t, offset, messageSet(headers).Offset())
+			require.Equal(t, int32(len(msg)), messageSet(headers).Size())
+			if !uncommitted {
+				_, err := fixed.AppendMessageSet(append(headers, msg...))
+				require.NoError(t, err)
+			}
+		}
+	}
+	require.True(t, l.activeSegment().Position() < fixed.activeSegment().Position())
+	require.Equal(t, int64(5), fixed.NewestOffset())
+	require.Equal(t, int64(5), fixed.LastOffsetForLeaderEpoch(1))
+
+	var scanned []int64
+	require.NoError(t, l.ScanOffsets(ctx, 0, func(offset, timestamp, position int64) error {
+		scanned = append(scanned, offset)
+		return nil
+	}))
+	require.Equal(t, []int64{0, 1, 2, 4, 5}, scanned)
+
+	it, err := l.HeaderIterator(ctx, 0)
+	require.NoError(t, err)
+	var iterated []int64
+	for it.Next() {
+		iterated = append(iterated, it.Offset())
+	}
+	require.NoError(t, it.Err())
+	require.Equal(t, scanned, iterated)
+
+	result, err := l.Scrub(ctx, func(offset int64, err error) {
+		require.NoError(t, err)
+	})
+	require.NoError(t, err)
+	require.Equal(t, ScrubResult{Scanned: 5}, result)
+
+	// The index is rebuilt from the varint headers.
+	seg := l.activeSegment()
+	entries, err := seg.entriesRange(0, math.MaxInt64)
+	require.NoError(t, err)
+	require.NoError(t, seg.RebuildIndex())
+	rebuilt, err := seg.entriesRange(0, math.MaxInt64)
+	require.NoError(t, err)
+	require.Equal(t, entries, rebuilt)
+
+	// Reopen the log with fixed headers and append more messages.
+	require.NoError(t, l.Close())
+	opts.HeaderFormat = HeaderFormatFixed
+	reopened, err := New(opts)
+	require.NoError(t, err)
+	l = reopened.(*commitLog)
+	defer l.Close()
+	for i := 6; i < numMsgs; i++ {
+		_, err := l.Append([]*Message{{Value: expected[i], Timestamp: int64(100 + i), LeaderEpoch: 2}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(int64(numMsgs - 1))
+
+	readAll := func(log CommitLog, from int64) {
+		r, err := log.NewReader(from, false)
+		require.NoError(t, err)
+		headers := make([]byte, msgSetHeaderLen)
+		for i := from; i < int64(numMsgs); i++ {
+			msg, offset, _, _, err := r.ReadMessage(ctx, headers)
+			require.NoError(t, err)
+			require.Equal(t, i, offset)
+			require.Equal(t, expected[i], msg.Value())
+		}
+	}
+	readAll(l, 0)
+
+	dir := tempDir(t)
+	defer remove(t, dir)
+	clone, err := l.Clone(ctx, dir, 2)
+	require.NoError(t, err)
+	defer clone.Close()
+	readAll(clone, 2)
+
+	result, err = l.Scrub(ctx, func(offset int64, err error) {
+		require.NoError(t, err)
+	})
+	require.NoError(t, err)
+	require.Equal(t, ScrubResult{Scanned: 11}, result)
+
+	// Messages without keys are all retained by compaction.
+	require.NoError(t, l.Compact(ctx, nil))
+	readAll(l, 0)
+
+	// Truncating splits the varint batch frame.
+	require.NoError(t, l.Truncate(4))
+	require.Equal(t, int64(3), l.NewestOffset())
+	r, err := l.NewReader(0, true)
+	require.NoError(t, err)
+	headers := make([]byte, msgSetHeaderLen)
+	for i := 0; i <= 3; i++ {
+		msg, offset, _, _, err := r.ReadMessage(ctx, headers)
+		require.NoError(t, err)
+		require.Equal(t, int64(i), offset)
+		require.Equal(t, expected[i], msg.Value())
+	}
+}
@@ -1,6 +1,7 @@
 package commitlog
 
 import (
+	"context"
 	"sync"
 	"time"
 
@@ -11,18 +12,49 @@ import (
 
 const defaultCompactMaxGoroutines = 10
 
+// KeyFunc extracts the key used to compact a message. Messages for which it
+// returns nil are never removed by compaction.
+type KeyFunc func(SerializedMessage) []byte
+
+// messageKey is the default KeyFunc which compacts on the message key.
+func messageKey(msg SerializedMessage) []byte {
+	return msg.Key()
+}
+
 // compactCleanerOptions contains configuration settings for the
 // compactCleaner.
 type compactCleanerOptions struct {
-	Logger        logger.Logger
-	Name          string
-	MaxGoroutines int
+	Logger         logger.Logger
+	Name           string
+	MaxGoroutines  int
+	TombstoneGrace time.Duration
+}
+
+// CompactionStats describes the effect of the last log compaction.
+type CompactionStats struct {
+	// OriginalMessages is the number of messages in the compacted segments
+	// before compaction.
+	OriginalMessages int64
+
+	// RetainedMessages is the number of messages in the compacted segments
+	// after compaction.
+	RetainedMessages int64
+
+	// BytesReclaimed is the number of bytes by which compaction shrank the
+	// compacted segments.
+	BytesReclaimed int64
+
+	// LastCompactionTime is the time the last compaction finished, or the
+	// zero time if the log has not been compacted.
+	LastCompactionTime time.Time
 }
 
 // compactCleaner implements the compaction policy which replaces segments with
 // compacted ones, i.e. retaining only the last message for a given key.
 type compactCleaner struct {
 	compactCleanerOptions
+	statsMu sync.RWMutex
+	stats   CompactionStats
 }
 
 // NewCompactCleaner returns a new cleaner which performs log compaction by
@@ -32,7 +64,14 @@ func newCompactCleaner(opts compactCleanerOptions) *compactCleaner {
 	if opts.MaxGoroutines == 0 {
 		opts.MaxGoroutines = defaultCompactMaxGoroutines
 	}
-	return &compactCleaner{opts}
+	return &compactCleaner{compactCleanerOptions: opts}
+}
+
+// Stats returns the stats of the last compaction.
+func (c *compactCleaner) Stats() CompactionStats {
+	c.statsMu.RLock()
+	defer c.statsMu.RUnlock()
+	return c.stats
 }
 
 // Compact performs log compaction by rewriting segments such that they contain
@@ -44,19 +83,46 @@ func newCompactCleaner(opts compactCleanerOptions) *compactCleaner {
 func (c *compactCleaner) Compact(hw int64, segments []*segment) ([]*segment,
 	*leaderEpochCache, error) {
 
+	return c.CompactByKey(context.Background(), hw, segments, messageKey)
+}
+
+// CompactByKey performs log compaction like Compact but uses the given KeyFunc
+// to determine each message's key. If the context is canceled partway through,
+// the segments which have not yet been compacted are retained as-is and the
+// context error is returned along with the resulting segments, which must
+// still be applied since compacted segments replace their originals.
+func (c *compactCleaner) CompactByKey(ctx context.Context, hw int64, segments []*segment,
+	keyFn KeyFunc) ([]*segment, *leaderEpochCache, error) {
+
 	if len(segments) <= 1 {
 		return segments, nil, nil
 	}
+	if err := ctx.Err(); err != nil {
+		return segments, nil, err
+	}
 
 	c.Logger.Debugf("Compacting log %s", c.Name)
 	before := time.Now()
-	compacted, epochCache, removed, err := c.compact(hw, segments)
+	compacted, epochCache, stats, err := c.compact(ctx, hw, segments, keyFn)
+	if err == nil || err == context.Canceled || err == context.DeadlineExceeded {
+		// Segments compacted before a cancellation have still been
+		// rewritten, so record their stats.
+		stats.LastCompactionTime = time.Now()
+		c.statsMu.Lock()
+		c.stats = stats
+		c.statsMu.Unlock()
+	}
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return compacted, epochCache, err
+	}
 	if err == nil {
 		c.Logger.Debugf("Finished compacting log %s\n"+
 			"\tMessages Removed: %d\n"+
+			"\tBytes Reclaimed: %d\n"+
 			"\tSegments: %d -> %d\n"+
 			"\tDuration: %s",
-			c.Name, removed, len(segments), len(compacted), time.Since(before))
+			c.Name, stats.OriginalMessages-stats.RetainedMessages, stats.BytesReclaimed,
+			len(segments), len(compacted), time.Since(before))
 	}
 
 	return compacted, epochCache, errors.Wrap(err, "failed to compact log")
@@ -82,30 +148,46 @@ func (k *keyOffset) get() int64 {
 	return k.offset
 }
 
-func (c *compactCleaner) compact(hw int64, segments []*segment) ([]*segment,
-	*leaderEpochCache, int, error) {
+func (c *compactCleaner) compact(ctx context.Context, hw int64, segments []*segment,
+	keyFn KeyFunc) ([]*segment, *leaderEpochCache, CompactionStats, error) {
 
 	// Compact messages up to the last segment or HW, whichever is first, by
 	// scanning keys and retaining only the latest.
 	// TODO: Implement option for configuring minimum compaction lag.
 	var (
-		compacted  = make([]*segment, 0, len(segments))
-		epochCache = newLeaderEpochCacheNoFile(c.Name, c.Logger)
-		removed    = 0
-		keyOffsets = c.scanKeys(hw, segments)
+		compacted       = make([]*segment, 0, len(segments))
+		epochCache      = newLeaderEpochCacheNoFile(c.Name, c.Logger)
+		stats           CompactionStats
+		keyOffsets      = c.scanKeys(hw, segments, keyFn)
+		tombstoneCutoff = int64(-1)
+		ctxErr          error
 	)
+	if c.TombstoneGrace > 0 {
+		tombstoneCutoff = computeTTL(c.TombstoneGrace)
+	}
 
 	// Write new segments. Skip the last segment since we will not compact it.
 	// TODO: Join segments that are below the bytes limit.
 	for _, seg := range segments[:len(segments)-1] {
-		cleaned, msgsRemoved, err := c.cleanSegment(seg, keyOffsets, hw, epochCache)
+		// If the context was canceled, retain the remaining segments as-is.
+		if ctxErr == nil {
+			ctxErr = ctx.Err()
+		}
+		if ctxErr != nil {
+			if err := assignLeaderEpochs(seg, epochCache); err != nil {
+				return nil, nil, stats, err
+			}
+			compacted = append(compacted, seg)
+			continue
+		}
+		cleaned, err := c.cleanSegment(
+			seg, keyOffsets, hw, tombstoneCutoff, keyFn, epochCache, &stats)
 		if err != nil {
-			return nil, nil, 0, err
+			return nil, nil, stats, err
 		}
 		if cleaned != nil {
 			compacted = append(compacted, cleaned)
 		}
-		removed += msgsRemoved
 	}
 
 	// Add the last segment back in to the compacted list.
@@ -113,34 +195,43 @@ func (c *compactCleaner) compact(hw int64, segments []*segment) ([]*segment,
 	compacted = append(compacted, last)
 
 	// Maintain start offset for each new leader epoch for the last segment.
-	ss := newSegmentScanner(last)
+	if err := assignLeaderEpochs(last, epochCache); err != nil {
+		return nil, nil, stats, err
+	}
+
+	return compacted, epochCache, stats, ctxErr
+}
+
+// assignLeaderEpochs adds the start offset of each new leader epoch in the
+// given segment to the leaderEpochCache.
+func assignLeaderEpochs(seg *segment, epochCache *leaderEpochCache) error {
+	ss := newSegmentScanner(seg)
 	for ms, _, err := ss.Scan(); err == nil; ms, _, err = ss.Scan() {
 		leaderEpoch := ms.LeaderEpoch()
 		if leaderEpoch > epochCache.LastLeaderEpoch() {
 			if err := epochCache.Assign(leaderEpoch, ms.Offset()); err != nil {
-				return nil, nil, 0, err
+				return err
 			}
 		}
 	}
-
-	return compacted, epochCache, removed, nil
+	return nil
 }
 
-func (c *compactCleaner) cleanSegment(seg *segment, keyOffsets *sync.Map, hw int64,
-	epochCache *leaderEpochCache) (*segment, int, error) {
+func (c *compactCleaner) cleanSegment(seg *segment, keyOffsets *sync.Map, hw,
+	tombstoneCutoff int64, keyFn KeyFunc, epochCache *leaderEpochCache,
+	stats *CompactionStats) (*segment, error) {
 
 	cleaned, err := seg.Cleaned()
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
-	var (
-		ss      = newSegmentScanner(seg)
-		removed = 0
-	)
+	ss := newSegmentScanner(seg)
 	for ms, _, err := ss.Scan(); err == nil; ms, _, err = ss.Scan() {
+		stats.OriginalMessages++
 		var (
 			offset       = ms.Offset()
-			key          = ms.Message().Key()
+			msg          = ms.Message()
+			key          = keyFn(msg)
 			leaderEpoch  = ms.LeaderEpoch()
 			latest, ok   = keyOffsets.Load(string(key))
 			latestOffset int64
@@ -150,35 +241,39 @@ func (c *compactCleaner) cleanSegment(seg *segment, keyOffsets *sync.Map, hw int
 		}
 
 		// Retain all messages with no keys and last message for each key.
-		// Also retain all messages after the HW.
-		if key == nil || offset == latestOffset || offset >= hw {
+		// Also retain all messages after the HW. Tombstones, i.e. the last
+		// message for a key with a nil value, are removed once they are older
+		// than the tombstone grace period.
+		expiredTombstone := tombstoneCutoff != -1 && offset < hw &&
+			msg.Value() == nil && ms.Timestamp() < tombstoneCutoff
+		if key == nil || (offset == latestOffset && !expiredTombstone) || offset >= hw {
 			entries := entriesForMessageSet(cleaned.Position(), ms)
 			if err := cleaned.WriteMessageSet(ms, entries); err != nil {
-				return nil, removed, err
+				return nil, err
 			}
+			stats.RetainedMessages++
 			// Maintain start offset for each new leader epoch.
 			if leaderEpoch > epochCache.LastLeaderEpoch() {
 				if err := epochCache.Assign(leaderEpoch, offset); err != nil {
-					return nil, removed, err
+					return nil, err
 				}
 			}
-		} else {
-			removed++
 		}
 	}
+	stats.BytesReclaimed += seg.Position() - cleaned.Position()
 
 	if cleaned.IsEmpty() {
 		// If the new segment is empty, remove it along with the old one.
-		return nil, removed, cleanupEmptySegment(cleaned, seg)
+		return nil, cleanupEmptySegment(cleaned, seg)
 	}
 	// Otherwise replace the old segment with the compacted one.
 	if err = cleaned.Replace(seg); err != nil {
-		return nil, removed, err
+		return nil, err
 	}
-	return cleaned, removed, nil
+	return cleaned, nil
 }
 
-func (c *compactCleaner) scanKeys(hw int64, segments []*segment) *sync.Map {
+func (c *compactCleaner) scanKeys(hw int64, segments []*segment, keyFn KeyFunc) *sync.Map {
 	var (
 		wg            sync.WaitGroup
 		keyOffsets    = new(sync.Map)
@@ -191,7 +286,7 @@ func (c *compactCleaner) scanKeys(hw int64, segments []*segment) *sync.Map {
 
 	wg.Add(numGoroutines)
 	for i := 0; i < numGoroutines; i++ {
-		go c.scanSegments(hw, segmentC, &wg, keyOffsets)
+		go c.scanSegments(hw, segmentC, &wg, keyOffsets, keyFn)
 	}
 
 	for _, seg := range segments {
@@ -203,7 +298,9 @@ func (c *compactCleaner) scanKeys(hw int64, segments []*segment) *sync.Map {
 	return keyOffsets
 }
 
-func (c *compactCleaner) scanSegments(hw int64, ch <-chan *segment, wg *sync.WaitGroup, keyOffsets *sync.Map) {
+func (c *compactCleaner) scanSegments(hw int64, ch <-chan *segment, wg *sync.WaitGroup,
+	keyOffsets *sync.Map, keyFn KeyFunc) {
+
 LOOP:
 	for seg := range ch {
 		ss := newSegmentScanner(seg)
@@ -213,7 +310,7 @@ LOOP:
 				break LOOP
 			}
 			curr, loaded := keyOffsets.LoadOrStore(
-				string(ms.Message().Key()), &keyOffset{offset: offset})
+				string(keyFn(ms.Message())), &keyOffset{offset: offset})
 			if loaded {
 				curr.(*keyOffset).set(offset)
 			}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math/rand"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -53,6 +54,7 @@ func TestCompactCleaner(t *testing.T) {
 	}
 	l, cleanup := setupWithOptions(t, opts)
 	defer cleanup()
+	defer l.Close()
 
 	// Append some messages.
 	entries := []keyValue{
@@ -82,7 +84,7 @@ func TestCompactCleaner(t *testing.T) {
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	r, err := l.NewReader(0, true)
+	r, err := l.NewReader(l.OldestOffset(), true)
 	require.NoError(t, err)
 	headers := make([]byte, 28)
 	for _, exp := range expected {
@@ -102,6 +104,7 @@ func TestCompactCleanerHW(t *testing.T) {
 	}
 	l, cleanup := setupWithOptions(t, opts)
 	defer cleanup()
+	defer l.Close()
 
 	// Append some messages.
 	entries := []keyValue{
@@ -135,7 +138,7 @@ func TestCompactCleanerHW(t *testing.T) {
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	r, err := l.NewReader(0, true)
+	r, err := l.NewReader(l.OldestOffset(), true)
 	require.NoError(t, err)
 	headers := make([]byte, 28)
 	for _, exp := range expected {
@@ -155,6 +158,7 @@ func TestCompactCleanerNoKeys(t *testing.T) {
 	}
 	l, cleanup := setupWithOptions(t, opts)
 	defer cleanup()
+	defer l.Close()
 
 	// Append some messages.
 	entries := []keyValue{
@@ -188,6 +192,205 @@ func TestCompactCleanerNoKeys(t *testing.T) {
 	}
 }
 
+// Ensure Compact uses the provided KeyFunc to determine message keys.
+func TestCommitLogCompactKeyFunc(t *testing.T) {
+	opts := Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+	defer l.Close()
+
+	// Append keyless messages whose key is embedded in the value.
+	entries := []keyValue{
+		{nil, []byte("a1")},
+		{nil, []byte("b1")},
+		{nil, []byte("a2")},
+		{nil, []byte("a3")},
+		{nil, []byte("b2")},
+		{nil, []byte("c1")},
+		{nil, []byte("c2")},
+		{nil, []byte("d1")},
+		{nil, []byte("a4")},
+		{nil, []byte("c3")},
+	}
+	appendToLog(t, l, entries, true)
+
+	keyFn := func(msg SerializedMessage) []byte {
+		return msg.Value()[:1]
+	}
+	require.NoError(t, l.Compact(context.Background(), keyFn))
+
+	expected := []*expectedMsg{
+		{Offset: 4, Msg: &Message{Value: []byte("b2")}},
+		{Offset: 7, Msg: &Message{Value: []byte("d1")}},
+		{Offset: 8, Msg: &Message{Value: []byte("a4")}},
+		// This one is present because it's in the active segment.
+		{Offset: 9, Msg: &Message{Value: []byte("c3")}},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, err := l.NewReader(l.OldestOffset(), true)
+	require.NoError(t, err)
+	headers := make([]byte, 28)
+	for _, exp := range expected {
+		msg, offset, _, _, err := r.ReadMessage(ctx, headers)
+		require.NoError(t, err)
+		require.Equal(t, exp.Offset, offset)
+		compareMessages(t, exp.Msg, msg)
+	}
+}
+
+// Ensure Compact removes tombstones older than the tombstone grace period.
+func TestCommitLogCompactTombstoneGrace(t *testing.T) {
+	computeTTLBefore := computeTTL
+	computeTTL = func(age time.Duration) int64 {
+		return 100
+	}
+	defer func() {
+		computeTTL = computeTTLBefore
+	}()
+	opts := Options{
+		Path:                  tempDir(t),
+		MaxSegmentBytes:       100,
+		CompactTombstoneGrace: time.Minute,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+	defer l.Close()
+
+	entries := []keyValue{
+		{[]byte("foo"), []byte("first")},
+		{[]byte("bar"), []byte("first")},
+		{[]byte("foo"), []byte("second")},
+		{[]byte("foo"), []byte("third")},
+		{[]byte("bar"), []byte("second")},
+		{[]byte("baz"), []byte("first")},
+		{[]byte("baz"), []byte("second")},
+		{[]byte("qux"), []byte("first")},
+		{[]byte("foo"), nil},
+		{[]byte("baz"), []byte("third")},
+		{[]byte("zap"), []byte("first")},
+		{[]byte("zip"), []byte("first")},
+	}
+	appendToLog(t, l, entries, true)
+
+	require.NoError(t, l.Compact(context.Background(), nil))
+
+	expected := []*expectedMsg{
+		{Offset: 4, Msg: &Message{Key: []byte("bar"), Value: []byte("second")}},
+		{Offset: 7, Msg: &Message{Key: []byte("qux"), Value: []byte("first")}},
+		{Offset: 9, Msg: &Message{Key: []byte("baz"), Value: []byte("third")}},
+		{Offset: 10, Msg: &Message{Key: []byte("zap"), Value: []byte("first")}},
+		{Offset: 11, Msg: &Message{Key: []byte("zip"), Value: []byte("first")}},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, err := l.NewReader(l.OldestOffset(), true)
+	require.NoError(t, err)
+	headers := make([]byte, 28)
+	for _, exp := range expected {
+		msg, offset, _, _, err := r.ReadMessage(ctx, headers)
+		require.NoError(t, err)
+		require.Equal(t, exp.Offset, offset)
+		compareMessages(t, exp.Msg, msg)
+	}
+}
+
+// Ensure CompactionStats reports the messages and bytes removed by the last
+// compaction.
+func TestCompactionStats(t *testing.T) {
+	opts := Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+	defer l.Close()
+
+	require.Equal(t, CompactionStats{}, l.CompactionStats())
+
+	entries := []keyValue{
+		{[]byte("foo"), []byte("first")},
+		{[]byte("bar"), []byte("first")},
+		{[]byte("foo"), []byte("second")},
+		{[]byte("foo"), []byte("third")},
+		{[]byte("bar"), []byte("second")},
+		{[]byte("baz"), []byte("first")},
+		{[]byte("baz"), []byte("second")},
+		{[]byte("qux"), []byte("first")},
+		{[]byte("foo"), []byte("fourth")},
+		{[]byte("baz"), []byte("third")},
+	}
+	appendToLog(t, l, entries, true)
+
+	// Only the segments before the active segment are compacted.
+	var (
+		segments      = l.Segments()
+		originalMsgs  int64
+		originalBytes int64
+	)
+	for _, seg := range segments[:len(segments)-1] {
+		originalMsgs += seg.MessageCount()
+		originalBytes += seg.Position()
+	}
+
+	before := time.Now()
+	require.NoError(t, l.Compact(context.Background(), nil))
+
+	var (
+		retainedMsgs  int64
+		retainedBytes int64
+	)
+	segments = l.Segments()
+	for _, seg := range segments[:len(segments)-1] {
+		retainedMsgs += seg.MessageCount()
+		retainedBytes += seg.Position()
+	}
+	stats := l.CompactionStats()
+	require.Equal(t, originalMsgs, stats.OriginalMessages)
+	require.Equal(t, retainedMsgs, stats.RetainedMessages)
+	require.True(t, stats.RetainedMessages < stats.OriginalMessages)
+	require.Equal(t, originalBytes-retainedBytes, stats.BytesReclaimed)
+	require.False(t, stats.LastCompactionTime.Before(before))
+}
+
+// Ensure Compact leaves the log untouched when the context is canceled.
+func TestCommitLogCompactCanceled(t *testing.T) {
+	opts := Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+	defer l.Close()
+
+	entries := []keyValue{
+		{[]byte("foo"), []byte("first")},
+		{[]byte("foo"), []byte("second")},
+		{[]byte("foo"), []byte("third")},
+		{[]byte("foo"), []byte("fourth")},
+	}
+	appendToLog(t, l, entries, true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.Equal(t, context.Canceled, l.Compact(ctx, nil))
+
+	r, err := l.NewReader(0, true)
+	require.NoError(t, err)
+	headers := make([]byte, 28)
+	for i, entry := range entries {
+		msg, offset, _, _, err := r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+		require.Equal(t, int64(i), offset)
+		require.Equal(t, entry.value, msg.Value())
+	}
+}
+
 // Ensure neither log truncation nor compaction fail when run concurrently.
 func TestCompactCleanerTruncateConcurrent(t *testing.T) {
 	opts := Options{
@@ -197,6 +400,7 @@ func TestCompactCleanerTruncateConcurrent(t *testing.T) {
 	}
 	l, cleanup := setupWithOptions(t, opts)
 	defer cleanup()
+	defer l.Close()
 
 	stop := make(chan bool)
 	wait := make(chan bool)
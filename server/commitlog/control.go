@@ -0,0 +1,110 @@
+package commitlog
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const controlBufferSize = 16
+
+// ControlOp is an operation sent to a Reader through its control channel.
+type ControlOp int
+
+const (
+	// ControlSeek repositions the Reader at the command's Offset.
+	ControlSeek ControlOp = iota
+
+	// ControlPause pauses the Reader, blocking reads until it is resumed.
+	ControlPause
+
+	// ControlResume resumes a paused Reader.
+	ControlResume
+
+	// ControlSetRateLimit limits the Reader to returning one message per the
+	// command's Interval. An Interval of zero or less removes the limit.
+	ControlSetRateLimit
+)
+
+// ControlCmd is a command sent to a Reader through its control channel.
+type ControlCmd struct {
+	Op       ControlOp
+	Offset   int64         // Offset to seek to for ControlSeek
+	Interval time.Duration // Minimum interval between messages for ControlSetRateLimit
+}
+
+// Control returns a channel on which commands can be sent to the Reader while
+// it is in use, e.g. by an interactive consumer, to reposition, pause, resume,
+// or rate limit it without recreating it. Commands may be sent from any
+// goroutine. They are applied in order by ReadMessage at the start of the next
+// read, i.e. at a message boundary, so they are never applied in the middle of
+// reading a message. A command sent while a read is blocked waiting for data
+// takes effect on the following read. Sends block once the channel's buffer
+// fills until a read applies the pending commands.
+func (r *Reader) Control() chan<- ControlCmd {
+	return r.control
+}
+
+// applyControl applies the commands pending on the Reader's control channel.
+// If the Reader is paused, this blocks until it is resumed or the context is
+// canceled.
+func (r *Reader) applyControl(ctx context.Context) error {
+	for {
+		var cmd ControlCmd
+		if r.paused {
+			select {
+			case cmd = <-r.control:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		} else {
+			select {
+			case cmd = <-r.control:
+			default:
+				return r.waitRateLimit(ctx)
+			}
+		}
+		switch cmd.Op {
+		case ControlSeek:
+			if err := r.seek(cmd.Offset); err != nil {
+				return err
+			}
+		case ControlPause:
+			r.paused = true
+		case ControlResume:
+			r.paused = false
+		case ControlSetRateLimit:
+			r.rateLimit = cmd.Interval
+		default:
+			return errors.Errorf("unknown control op %d", cmd.Op)
+		}
+	}
+}
+
+// seek repositions the Reader at the given offset.
+func (r *Reader) seek(offset int64) error {
+	if offset < 0 {
+		return errors.Wrapf(ErrInvalidOffset, "offset %d is negative", offset)
+	}
+	return errors.Wrap(r.reposition(offset), "failed to seek reader")
+}
+
+// waitRateLimit blocks until the Reader's rate limit allows another message
+// to be read.
+func (r *Reader) waitRateLimit(ctx context.Context) error {
+	if r.rateLimit <= 0 {
+		return nil
+	}
+	if wait := time.Until(r.lastRead.Add(r.rateLimit)); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	r.lastRead = time.Now()
+	return nil
+}
@@ -0,0 +1,72 @@
+package commitlog
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Ensure commands sent on a Reader's control channel are applied at the next
+// read.
+func TestReaderControl(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	numMsgs := 10
+	for i := 0; i < numMsgs; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(int64(numMsgs - 1))
+
+	r, err := l.NewReader(0, false)
+	require.NoError(t, err)
+	headers := make([]byte, 28)
+	read := func(ctx context.Context) (int64, error) {
+		_, offset, _, _, err := r.ReadMessage(ctx, headers)
+		return offset, err
+	}
+
+	offset, err := read(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(0), offset)
+
+	// Seek forward.
+	r.Control() <- ControlCmd{Op: ControlSeek, Offset: 7}
+	offset, err = read(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(7), offset)
+
+	// Reads block while paused.
+	r.Control() <- ControlCmd{Op: ControlPause}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = read(ctx)
+	require.Equal(t, context.DeadlineExceeded, err)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		r.Control() <- ControlCmd{Op: ControlSeek, Offset: 2}
+		r.Control() <- ControlCmd{Op: ControlResume}
+	}()
+	offset, err = read(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(2), offset)
+
+	// Rate limited reads wait for the interval between messages.
+	r.Control() <- ControlCmd{Op: ControlSetRateLimit, Interval: 20 * time.Millisecond}
+	_, err = read(context.Background())
+	require.NoError(t, err)
+	start := time.Now()
+	offset, err = read(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(4), offset)
+	require.True(t, time.Since(start) >= 20*time.Millisecond)
+}
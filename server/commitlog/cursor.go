@@ -0,0 +1,126 @@
+package commitlog
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	atomic_file "github.com/natefinch/atomic"
+	"github.com/pkg/errors"
+
+	"github.com/liftbridge-io/liftbridge/server/proto"
+)
+
+// cursorV0 is version 0 of the proto.Cursor format.
+const cursorV0 = 0
+
+// CursorStore durably stores a Reader's position in the log so that a
+// consumer can resume where it left off after a restart or crash. The stored
+// cursor is always the absolute offset of the next message to read, never a
+// byte position, so a resumed Reader always starts on a message boundary.
+type CursorStore interface {
+	// Commit durably stores the offset of the next message to read.
+	Commit(offset int64) error
+
+	// Load returns the last committed offset or -1 if no offset has been
+	// committed.
+	Load() (int64, error)
+}
+
+// FileCursorStore is a CursorStore which stores the cursor in a file. Each
+// commit atomically replaces the file, so a crash never leaves a partially
+// written cursor behind.
+type FileCursorStore struct {
+	path string
+}
+
+// NewFileCursorStore creates a new FileCursorStore which stores the cursor in
+// the file at the given path.
+func NewFileCursorStore(path string) *FileCursorStore {
+	return &FileCursorStore{path: path}
+}
+
+// Commit durably stores the offset of the next message to read.
+func (f *FileCursorStore) Commit(offset int64) error {
+	r := strings.NewReader(strconv.FormatInt(offset, 10))
+	return atomic_file.WriteFile(f.path, r)
+}
+
+// Load returns the last committed offset or -1 if no offset has been
+// committed.
+func (f *FileCursorStore) Load() (int64, error) {
+	b, err := ioutil.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return -1, nil
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "read cursor file failed")
+	}
+	offset, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return 0, errors.Wrap(err, "parse cursor file failed")
+	}
+	return offset, nil
+}
+
+// Cursor returns a resume token describing the Reader's position which can be
+// passed to NewReaderFromCursor, possibly by a client in another language, to
+// resume reading at the next message to be read. Like the committed cursor,
+// this accounts for unacknowledged messages if TrackAcks is enabled.
+func (r *Reader) Cursor() *proto.Cursor {
+	cursor := &proto.Cursor{
+		Version:       cursorV0,
+		Offset:        r.cursor(),
+		SegmentBase:   -1,
+		HighWatermark: r.log.HighWatermark(),
+		Mode:          proto.ReaderMode_COMMITTED,
+	}
+	switch {
+	case r.opts.Uncommitted:
+		cursor.Mode = proto.ReaderMode_UNCOMMITTED
+	case r.opts.Snapshot:
+		cursor.Mode = proto.ReaderMode_SNAPSHOT
+		if r.snapshotHW != nil {
+			cursor.HighWatermark = *r.snapshotHW
+		}
+	}
+	// If the offset has not been written yet, it will be in the active
+	// segment.
+	segments := r.log.Segments()
+	seg, idx := findSegment(segments, cursor.Offset)
+	if seg == nil && idx > 0 {
+		seg = segments[idx-1]
+	}
+	if seg != nil {
+		cursor.SegmentBase = seg.BaseOffset
+	}
+	return cursor
+}
+
+// NewReaderFromCursor creates a new Reader which resumes reading at the
+// position described by the given cursor using the provided ReaderOptions.
+// The cursor's mode takes precedence over the Uncommitted and Snapshot
+// options, and a snapshot Reader remains bounded by the cursor's HW. If a
+// CursorStore is provided, it is committed to but not loaded from. This
+// returns an error if the cursor version is not supported.
+func (l *commitLog) NewReaderFromCursor(cursor *proto.Cursor, opts ReaderOptions) (*Reader, error) {
+	if cursor.Version > cursorV0 {
+		return nil, errors.Errorf("unsupported cursor version %d", cursor.Version)
+	}
+	var snapshotHW *int64
+	opts.Uncommitted = false
+	opts.Snapshot = false
+	switch cursor.Mode {
+	case proto.ReaderMode_COMMITTED:
+	case proto.ReaderMode_UNCOMMITTED:
+		opts.Uncommitted = true
+	case proto.ReaderMode_SNAPSHOT:
+		opts.Snapshot = true
+		hw := cursor.HighWatermark
+		snapshotHW = &hw
+	default:
+		return nil, errors.Errorf("unknown cursor reader mode %s", cursor.Mode)
+	}
+	return l.newReader(cursor.Offset, opts, snapshotHW)
+}
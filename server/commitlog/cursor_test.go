@@ -0,0 +1,227 @@
+package commitlog
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	pkgErrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/liftbridge-io/liftbridge/server/proto"
+)
+
+// crashingContextReader serves reads from the underlying contextReader until
+// limit bytes have been read and then fails, simulating a crash in the middle
+// of a read.
+type crashingContextReader struct {
+	reader contextReader
+	limit  int
+}
+
+func (c *crashingContextReader) Read(ctx context.Context, p []byte) (int, error) {
+	if len(p) > c.limit {
+		return 0, errors.New("crashed")
+	}
+	c.limit -= len(p)
+	return c.reader.Read(ctx, p)
+}
+
+func TestFileCursorStore(t *testing.T) {
+	dir := tempDir(t)
+	defer remove(t, dir)
+
+	store := NewFileCursorStore(filepath.Join(dir, "cursor"))
+	offset, err := store.Load()
+	require.NoError(t, err)
+	require.Equal(t, int64(-1), offset)
+
+	require.NoError(t, store.Commit(42))
+	offset, err = store.Load()
+	require.NoError(t, err)
+	require.Equal(t, int64(42), offset)
+}
+
+// Ensures a Reader killed in the middle of reading a message resumes from the
+// next unread message without skipping or redelivering any.
+func TestReaderCursorResume(t *testing.T) {
+	opts := Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+
+	numMsgs := 10
+	for i := 0; i < numMsgs; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(int64(numMsgs - 1))
+
+	store := NewFileCursorStore(filepath.Join(opts.Path, "cursor"))
+	r, err := l.NewReaderWithOptions(0, ReaderOptions{Cursor: store})
+	require.NoError(t, err)
+	headers := make([]byte, 28)
+	for i := 0; i < 4; i++ {
+		_, offset, _, _, err := r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+		require.Equal(t, int64(i), offset)
+	}
+
+	// Crash after reading the headers and part of the next message.
+	r.ctxReader = &crashingContextReader{reader: r.ctxReader, limit: 30}
+	_, _, _, _, err = r.ReadMessage(context.Background(), headers)
+	require.Error(t, err)
+	cursor, err := store.Load()
+	require.NoError(t, err)
+	require.Equal(t, int64(4), cursor)
+
+	// Restart the log and resume from the cursor.
+	require.NoError(t, l.Close())
+	log, err := New(opts)
+	require.NoError(t, err)
+	defer log.Close()
+
+	r, err = log.NewReaderWithOptions(0, ReaderOptions{Cursor: store})
+	require.NoError(t, err)
+	for i := 4; i < numMsgs; i++ {
+		msg, offset, _, _, err := r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+		require.Equal(t, int64(i), offset)
+		require.Equal(t, []byte(strconv.Itoa(i)), msg.Value())
+	}
+	cursor, err = store.Load()
+	require.NoError(t, err)
+	require.Equal(t, int64(numMsgs), cursor)
+}
+
+// Ensures a Reader resumes from a proto cursor, including after the cursor is
+// serialized, and that a snapshot Reader remains bounded by the cursor's HW.
+func TestReaderProtoCursor(t *testing.T) {
+	opts := Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+	defer l.Close()
+
+	numMsgs := 10
+	for i := 0; i < numMsgs; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(5)
+
+	r, err := l.NewReaderWithOptions(0, ReaderOptions{Snapshot: true})
+	require.NoError(t, err)
+	headers := make([]byte, 28)
+	for i := 0; i < 3; i++ {
+		_, _, _, _, err := r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(int64(numMsgs - 1))
+
+	cursor := r.Cursor()
+	require.Equal(t, int64(3), cursor.Offset)
+	require.Equal(t, int64(5), cursor.HighWatermark)
+	require.Equal(t, proto.ReaderMode_SNAPSHOT, cursor.Mode)
+	seg, _ := findSegment(l.Segments(), 3)
+	require.Equal(t, seg.BaseOffset, cursor.SegmentBase)
+
+	buf, err := cursor.Marshal()
+	require.NoError(t, err)
+	decoded := new(proto.Cursor)
+	require.NoError(t, decoded.Unmarshal(buf))
+	require.Equal(t, cursor, decoded)
+
+	r, err = l.NewReaderFromCursor(decoded, ReaderOptions{})
+	require.NoError(t, err)
+	for i := 3; i <= 5; i++ {
+		msg, offset, _, _, err := r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+		require.Equal(t, int64(i), offset)
+		require.Equal(t, []byte(strconv.Itoa(i)), msg.Value())
+	}
+	_, _, _, _, err = r.ReadMessage(context.Background(), headers)
+	require.Equal(t, io.EOF, pkgErrors.Cause(err))
+
+	// Cursors from a newer version are rejected.
+	_, err = l.NewReaderFromCursor(&proto.Cursor{Version: cursorV0 + 1}, ReaderOptions{})
+	require.Error(t, err)
+}
+
+// countingCursorStore counts the commits made to a CursorStore.
+type countingCursorStore struct {
+	CursorStore
+	commits int
+}
+
+func (c *countingCursorStore) Commit(offset int64) error {
+	c.commits++
+	return c.CursorStore.Commit(offset)
+}
+
+// Ensures auto-commit commits the cursor every CommitEvery messages or once
+// the CommitInterval elapses, and that Close commits the final position.
+func TestReaderCursorAutoCommit(t *testing.T) {
+	opts := Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+	defer l.Close()
+
+	numMsgs := 10
+	for i := 0; i < numMsgs; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(int64(numMsgs - 1))
+
+	store := &countingCursorStore{CursorStore: NewFileCursorStore(filepath.Join(opts.Path, "cursor"))}
+	r, err := l.NewReaderWithOptions(0, ReaderOptions{
+		Cursor:         store,
+		CommitEvery:    3,
+		CommitInterval: time.Hour,
+	})
+	require.NoError(t, err)
+	headers := make([]byte, 28)
+	read := func(n int) {
+		for i := 0; i < n; i++ {
+			_, _, _, _, err := r.ReadMessage(context.Background(), headers)
+			require.NoError(t, err)
+		}
+	}
+
+	read(7)
+	require.Equal(t, 2, store.commits)
+	cursor, err := store.Load()
+	require.NoError(t, err)
+	require.Equal(t, int64(6), cursor)
+
+	// The interval elapsing also triggers a commit.
+	r.opts.CommitInterval = time.Millisecond
+	time.Sleep(2 * time.Millisecond)
+	read(1)
+	require.Equal(t, 3, store.commits)
+	cursor, err = store.Load()
+	require.NoError(t, err)
+	require.Equal(t, int64(8), cursor)
+
+	// Close commits the remaining position, but only if it changed.
+	r.opts.CommitInterval = time.Hour
+	read(1)
+	require.NoError(t, r.Close())
+	require.NoError(t, r.Close())
+	require.Equal(t, 4, store.commits)
+	cursor, err = store.Load()
+	require.NoError(t, err)
+	require.Equal(t, int64(9), cursor)
+}
@@ -0,0 +1,70 @@
+package commitlog
+
+import "container/list"
+
+const defaultDedupeWindow = 1000
+
+// keyWindow remembers the keys of recently read messages for deduplication.
+// Keys are evicted in the order they were first seen once they fall out of
+// the window, so the memory used is bounded by the window size.
+type keyWindow struct {
+	window int64
+	maxAge int64
+	order  *list.List
+	keys   map[string]*list.Element
+}
+
+type windowEntry struct {
+	key       string
+	offset    int64
+	timestamp int64
+}
+
+func newKeyWindow(window int64, maxAge int64) *keyWindow {
+	if window <= 0 {
+		window = defaultDedupeWindow
+	}
+	return &keyWindow{
+		window: window,
+		maxAge: maxAge,
+		order:  list.New(),
+		keys:   make(map[string]*list.Element),
+	}
+}
+
+// seen indicates if the given key was first seen within the window preceding
+// the message with the given offset and timestamp. If it was not, the key is
+// recorded as first seen at this message.
+func (w *keyWindow) seen(key []byte, offset, timestamp int64) bool {
+	w.evict(offset, timestamp)
+	if _, ok := w.keys[string(key)]; ok {
+		return true
+	}
+	w.keys[string(key)] = w.order.PushBack(&windowEntry{
+		key:       string(key),
+		offset:    offset,
+		timestamp: timestamp,
+	})
+	if int64(w.order.Len()) > w.window {
+		w.remove(w.order.Front())
+	}
+	return false
+}
+
+// evict removes the keys which fall outside the window preceding the message
+// with the given offset and timestamp.
+func (w *keyWindow) evict(offset, timestamp int64) {
+	for e := w.order.Front(); e != nil; e = w.order.Front() {
+		entry := e.Value.(*windowEntry)
+		if offset-entry.offset <= w.window &&
+			(w.maxAge <= 0 || timestamp-entry.timestamp <= w.maxAge) {
+			return
+		}
+		w.remove(e)
+	}
+}
+
+func (w *keyWindow) remove(e *list.Element) {
+	delete(w.keys, e.Value.(*windowEntry).key)
+	w.order.Remove(e)
+}
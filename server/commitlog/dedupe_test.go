@@ -0,0 +1,63 @@
+package commitlog
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Ensure Readers with DedupeKeyFn return the first occurrence of each key and
+// skip later occurrences within the window.
+func TestReaderDedupe(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1000,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	keys := []string{"a", "b", "a", "", "c", "", "b", "a", "c"}
+	for i, key := range keys {
+		msg := &Message{Value: []byte(key), Timestamp: int64(i)}
+		if key != "" {
+			msg.Key = []byte(key)
+		}
+		_, err := l.Append([]*Message{msg})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(int64(len(keys) - 1))
+
+	read := func(opts ReaderOptions) []int64 {
+		r, err := l.NewReaderWithOptions(0, opts)
+		require.NoError(t, err)
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		headers := make([]byte, 28)
+		offsets := []int64{}
+		for {
+			_, offset, _, _, err := r.ReadMessage(ctx, headers)
+			if err != nil {
+				require.Equal(t, context.DeadlineExceeded, ctx.Err())
+				return offsets
+			}
+			offsets = append(offsets, offset)
+		}
+	}
+
+	keyFn := func(msg SerializedMessage) []byte { return msg.Key() }
+
+	// Keyless messages are never skipped.
+	require.Equal(t, []int64{0, 1, 3, 4, 5},
+		read(ReaderOptions{DedupeKeyFn: keyFn}))
+
+	// Keys first seen more than the window before are no longer duplicates.
+	require.Equal(t, []int64{0, 1, 3, 4, 5, 6, 7},
+		read(ReaderOptions{DedupeKeyFn: keyFn, DedupeWindow: 4}))
+
+	// Keys first seen on messages older than the max age are no longer
+	// duplicates, while the retry at offset 2 still is.
+	require.Equal(t, []int64{0, 1, 3, 4, 5, 6, 7, 8},
+		read(ReaderOptions{DedupeKeyFn: keyFn, DedupeMaxAge: 3}))
+}
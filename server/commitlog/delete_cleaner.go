@@ -106,7 +106,7 @@ func (c *deleteCleaner) applyMessagesLimit(segments []*segment) ([]*segment, err
 			// deletion. We will delete some segments but return an error. This
 			// should probably mark segments for deletion, remove them from the
 			// read path, and then delete them asynchronously.
-			if err := segments[i].Delete(); err != nil {
+			if err := segments[i].Expire(); err != nil {
 				return nil, err
 			}
 		}
@@ -141,7 +141,7 @@ func (c *deleteCleaner) applyBytesLimit(segments []*segment) ([]*segment, error)
 				// error. This should probably mark segments for deletion,
 				// remove them from the read path, and then delete them
 				// asynchronously.
-				if err := segments[i].Delete(); err != nil {
+				if err := segments[i].Expire(); err != nil {
 					return nil, err
 				}
 			}
@@ -165,12 +165,12 @@ func (c *deleteCleaner) applyAgeLimit(segments []*segment) ([]*segment, error) {
 	// Delete all segments whose last-written timestamp is less than the TTL
 	// with the exception of the active (last) segment.
 	for i, seg := range segments {
-		if i != len(segments)-1 && seg.lastWriteTime < ttl {
+		if i != len(segments)-1 && seg.LastWriteTime() < ttl {
 			// TODO: There is an edge case here where we fail partway through
 			// deletion. We will delete some segments but return an error. This
 			// should probably mark segments for deletion, remove them from the
 			// read path, and then delete them asynchronously.
-			if err := seg.Delete(); err != nil {
+			if err := seg.Expire(); err != nil {
 				return nil, err
 			}
 		} else {
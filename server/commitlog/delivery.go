@@ -0,0 +1,60 @@
+package commitlog
+
+import "context"
+
+// Delivery is a message read by a DeliveryReader along with its delivery ID.
+type Delivery struct {
+	// ID is the message's position in the sequence of messages delivered by
+	// the DeliveryReader, starting at 0. Unlike offsets, which may have gaps
+	// due to compaction or filtering, IDs are dense.
+	ID int64
+
+	// Attempt is the number of times the message has been delivered by the
+	// underlying Reader, see Reader.DeliveryAttempt.
+	Attempt int
+
+	Message     SerializedMessage
+	Offset      int64
+	Timestamp   int64
+	LeaderEpoch uint64
+}
+
+// DeliveryReader wraps a Reader to assign each message it returns a
+// monotonically increasing delivery ID for downstream systems which need a
+// gap-free sequence. IDs are derived on read and are not stored, so they are
+// only meaningful for a single DeliveryReader. Like Reader, it should not be
+// used concurrently.
+type DeliveryReader struct {
+	reader  *Reader
+	headers []byte
+	nextID  int64
+}
+
+// NewDeliveryReader creates a new DeliveryReader which reads messages from the
+// given Reader.
+func NewDeliveryReader(reader *Reader) *DeliveryReader {
+	return &DeliveryReader{
+		reader:  reader,
+		headers: make([]byte, msgSetHeaderLen),
+	}
+}
+
+// Read reads the next message, blocking until one is available. A delivery
+// ID is only consumed when a message is returned, so errors do not introduce
+// gaps.
+func (d *DeliveryReader) Read(ctx context.Context) (*Delivery, error) {
+	msg, offset, timestamp, leaderEpoch, err := d.reader.ReadMessage(ctx, d.headers)
+	if err != nil {
+		return nil, err
+	}
+	delivery := &Delivery{
+		ID:          d.nextID,
+		Attempt:     d.reader.DeliveryAttempt(),
+		Message:     msg,
+		Offset:      offset,
+		Timestamp:   timestamp,
+		LeaderEpoch: leaderEpoch,
+	}
+	d.nextID++
+	return delivery, nil
+}
@@ -0,0 +1,41 @@
+package commitlog
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Ensure DeliveryReader assigns dense delivery IDs to messages whose offsets
+// have gaps after compaction.
+func TestDeliveryReader(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	entries := []keyValue{
+		{[]byte("foo"), []byte("first")},
+		{[]byte("bar"), []byte("first")},
+		{[]byte("foo"), []byte("second")},
+		{[]byte("bar"), []byte("second")},
+		{[]byte("baz"), []byte("first")},
+		{[]byte("foo"), []byte("third")},
+	}
+	appendToLog(t, l, entries, true)
+	require.NoError(t, l.Compact(context.Background(), nil))
+
+	reader, err := l.NewReader(l.OldestOffset(), true)
+	require.NoError(t, err)
+	r := NewDeliveryReader(reader)
+	for id, offset := range []int64{3, 4, 5} {
+		delivery, err := r.Read(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, int64(id), delivery.ID)
+		require.Equal(t, offset, delivery.Offset)
+		require.Equal(t, entries[offset].value, delivery.Message.Value())
+	}
+}
@@ -0,0 +1,82 @@
+package commitlog
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// FollowingReader is a Reader which follows a partition across changes of the
+// CommitLog backing it, such as when the log is closed and reopened on a
+// leadership change. When the current log is closed, FollowingReader fetches
+// the partition's current log and transparently resumes reading from it at
+// the offset following the last message returned, so consumers see a
+// continuous sequence of offsets. Like Reader, it should not be used
+// concurrently.
+type FollowingReader struct {
+	fetch  func() (CommitLog, error)
+	opts   ReaderOptions
+	log    CommitLog
+	reader *Reader
+}
+
+// NewFollowingReader creates a new FollowingReader starting at the given
+// offset using the provided ReaderOptions. The fetch function is called to get
+// the partition's current CommitLog, both initially and each time the log
+// being read is closed.
+func NewFollowingReader(fetch func() (CommitLog, error), offset int64,
+	opts ReaderOptions) (*FollowingReader, error) {
+
+	log, err := fetch()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch log")
+	}
+	reader, err := log.NewReaderWithOptions(offset, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &FollowingReader{
+		fetch:  fetch,
+		opts:   opts,
+		log:    log,
+		reader: reader,
+	}, nil
+}
+
+// ReadMessage reads a single message, blocking until one is available, in the
+// same way as Reader.ReadMessage. If the log is closed, the current log is
+// fetched and, if it is a different log, reading resumes from it. If it is the
+// same log, the error is returned.
+func (f *FollowingReader) ReadMessage(ctx context.Context, headersBuf []byte) (
+	SerializedMessage, int64, int64, uint64, error) {
+
+	for {
+		msg, offset, timestamp, leaderEpoch, err := f.reader.ReadMessage(ctx, headersBuf)
+		if err == nil {
+			return msg, offset, timestamp, leaderEpoch, nil
+		}
+		if cause := errors.Cause(err); cause != ErrLogClosed && cause != ErrSegmentClosed {
+			return nil, 0, 0, 0, err
+		}
+		log, fetchErr := f.fetch()
+		if fetchErr != nil {
+			return nil, 0, 0, 0, errors.Wrap(fetchErr, "failed to fetch log")
+		}
+		if log == f.log {
+			return nil, 0, 0, 0, err
+		}
+		// Resume at the offset following the last message returned.
+		reader, err := log.NewReaderWithOptions(f.Offset(), f.opts)
+		if err != nil {
+			return nil, 0, 0, 0, errors.Wrap(err, "failed to create reader for new log")
+		}
+		f.log = log
+		f.reader = reader
+	}
+}
+
+// Offset returns the offset of the next message to be read.
+func (f *FollowingReader) Offset() int64 {
+	return atomic.LoadInt64(&f.reader.offset)
+}
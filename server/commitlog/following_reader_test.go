@@ -0,0 +1,82 @@
+package commitlog
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// Ensure FollowingReader resumes reading from a reopened log at the offset
+// following the last message returned.
+func TestFollowingReader(t *testing.T) {
+	opts := Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+	for i := 0; i < 5; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(4)
+
+	var (
+		mu      sync.Mutex
+		current CommitLog = l
+	)
+	fetch := func() (CommitLog, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return current, nil
+	}
+	r, err := NewFollowingReader(fetch, 0, ReaderOptions{})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	headers := make([]byte, 28)
+	for i := 0; i < 5; i++ {
+		msg, offset, _, _, err := r.ReadMessage(ctx, headers)
+		require.NoError(t, err)
+		require.Equal(t, int64(i), offset)
+		require.Equal(t, []byte(strconv.Itoa(i)), msg.Value())
+	}
+
+	// Replace the log while the reader is waiting for data.
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		require.NoError(t, current.Close())
+		newLog, err := New(opts)
+		require.NoError(t, err)
+		for i := 5; i < 8; i++ {
+			_, err := newLog.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+			require.NoError(t, err)
+		}
+		newLog.SetHighWatermark(7)
+		current = newLog
+	}()
+
+	for i := 5; i < 8; i++ {
+		msg, offset, _, _, err := r.ReadMessage(ctx, headers)
+		require.NoError(t, err)
+		require.Equal(t, int64(i), offset)
+		require.Equal(t, []byte(strconv.Itoa(i)), msg.Value())
+	}
+	require.Equal(t, int64(8), r.Offset())
+
+	// If the fetched log is the closed log, the error is returned. The log is
+	// closed before reading, so the read fails on the closed segment.
+	mu.Lock()
+	require.NoError(t, current.Close())
+	mu.Unlock()
+	_, _, _, _, err = r.ReadMessage(ctx, headers)
+	require.Equal(t, ErrSegmentClosed, errors.Cause(err))
+}
@@ -0,0 +1,166 @@
+package commitlog
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type frame struct {
+	msg         SerializedMessage
+	offset      int64
+	timestamp   int64
+	leaderEpoch uint64
+}
+
+func readFrames(t *testing.T, l *commitLog, n int) []frame {
+	r, err := l.NewReader(0, true)
+	require.NoError(t, err)
+	var (
+		frames  = make([]frame, n)
+		headers = make([]byte, 28)
+	)
+	for i := range frames {
+		msg, offset, timestamp, leaderEpoch, err := r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+		frames[i] = frame{msg, offset, timestamp, leaderEpoch}
+	}
+	return frames
+}
+
+// Ensures frames round trip in both formats and that varint frames are
+// smaller than fixed frames.
+func TestFrameRoundTrip(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1024,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	numMsgs := 10
+	for i := 0; i < numMsgs; i++ {
+		_, err := l.Append([]*Message{{
+			Value:       []byte(strconv.Itoa(i)),
+			Timestamp:   int64(1000 + i),
+			LeaderEpoch: 42,
+		}})
+		require.NoError(t, err)
+	}
+	frames := readFrames(t, l, numMsgs)
+
+	sizes := map[byte]int{}
+	for _, format := range []byte{FrameFormatFixed, FrameFormatVarint} {
+		buf := new(bytes.Buffer)
+		w, err := NewFrameWriter(buf, format)
+		require.NoError(t, err)
+		for _, f := range frames {
+			require.NoError(t, w.WriteMessage(f.msg, f.offset, f.timestamp, f.leaderEpoch))
+		}
+		sizes[format] = buf.Len()
+
+		r := NewFrameReader(buf)
+		for _, f := range frames {
+			msg, offset, timestamp, leaderEpoch, err := r.ReadMessage()
+			require.NoError(t, err)
+			require.Equal(t, f, frame{msg, offset, timestamp, leaderEpoch})
+		}
+		_, _, _, _, err = r.ReadMessage()
+		require.Equal(t, io.EOF, err)
+	}
+	require.True(t, sizes[FrameFormatVarint] < sizes[FrameFormatFixed])
+}
+
+// Ensures a stream may mix frame formats.
+func TestFrameMixedFormats(t *testing.T) {
+	msg := SerializedMessage("hello")
+	buf := new(bytes.Buffer)
+	w, err := NewFrameWriter(buf, FrameFormatFixed)
+	require.NoError(t, err)
+	require.NoError(t, w.WriteMessage(msg, 5, 100, 1))
+	require.NoError(t, w.SetFormat(FrameFormatVarint))
+	require.NoError(t, w.WriteMessage(msg, 6, 90, 1))
+
+	r := NewFrameReader(buf)
+	_, offset, timestamp, _, err := r.ReadMessage()
+	require.NoError(t, err)
+	require.Equal(t, int64(5), offset)
+	require.Equal(t, int64(100), timestamp)
+	read, offset, timestamp, leaderEpoch, err := r.ReadMessage()
+	require.NoError(t, err)
+	require.Equal(t, msg, read)
+	require.Equal(t, int64(6), offset)
+	require.Equal(t, int64(90), timestamp)
+	require.Equal(t, uint64(1), leaderEpoch)
+}
+
+func TestFrameErrors(t *testing.T) {
+	_, err := NewFrameWriter(new(bytes.Buffer), 2)
+	require.Error(t, err)
+
+	_, _, _, _, err = NewFrameReader(bytes.NewReader([]byte{2})).ReadMessage()
+	require.Error(t, err)
+
+	buf := new(bytes.Buffer)
+	w, err := NewFrameWriter(buf, FrameFormatVarint)
+	require.NoError(t, err)
+	require.NoError(t, w.WriteMessage(SerializedMessage("hello"), 0, 0, 0))
+	truncated := buf.Bytes()[:buf.Len()-1]
+	_, _, _, _, err = NewFrameReader(bytes.NewReader(truncated)).ReadMessage()
+	require.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+// Ensures messages copied from a Reader as varint frames carry single-byte
+// offset deltas and are reconstructed with their absolute offsets.
+func TestFrameCopyFrames(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1024,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	numMsgs := 10
+	for i := 0; i < numMsgs; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i)), Timestamp: 7}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(int64(numMsgs - 1))
+
+	r, err := l.NewReader(3, false)
+	require.NoError(t, err)
+	buf := new(bytes.Buffer)
+	w, err := NewFrameWriter(buf, FrameFormatVarint)
+	require.NoError(t, err)
+	n, err := w.CopyFrames(context.Background(), r, numMsgs-3)
+	require.NoError(t, err)
+	require.Equal(t, numMsgs-3, n)
+
+	fr := NewFrameReader(bytes.NewReader(buf.Bytes()))
+	var prevEnd int
+	for i := 3; i < numMsgs; i++ {
+		msg, offset, _, _, err := fr.ReadMessage()
+		require.NoError(t, err)
+		require.Equal(t, int64(i), offset)
+		require.Equal(t, []byte(strconv.Itoa(i)), msg.Value())
+
+		if i > 3 {
+			// Format byte, then a single-byte offset delta of 1.
+			frame := buf.Bytes()[prevEnd:]
+			require.Equal(t, FrameFormatVarint, frame[0])
+			require.Equal(t, byte(2), frame[1]) // Zigzag encoding of 1.
+		}
+		prevEnd = buf.Len() - fr.r.Buffered()
+	}
+
+	// Copying stops with the read error once the context is done.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	n, err = w.CopyFrames(ctx, r, 1)
+	require.Error(t, err)
+	require.Equal(t, 0, n)
+}
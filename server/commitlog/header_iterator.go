@@ -0,0 +1,121 @@
+package commitlog
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// HeaderIterator scans the message-set headers of a log without reading
+// message payloads, e.g. to compute message rates and sizes over a live log.
+// Each header is read into a single reused buffer and the payload is skipped,
+// so iterating allocates nothing except when moving to another segment.
+// Compressed batch frames are reported as a single message with the frame's
+// header, i.e. the offset and timestamp of its last inner message and the
+// size of the frame. Uncommitted messages are included. Like Reader, it
+// should not be used concurrently.
+type HeaderIterator struct {
+	ctx     context.Context
+	log     *commitLog
+	segment *segment
+	pos     int64
+	next    int64
+	buf     messageSet
+	header  messageSet
+	err     error
+}
+
+// HeaderIterator creates a new HeaderIterator starting at the given offset.
+func (l *commitLog) HeaderIterator(ctx context.Context, offset int64) (*HeaderIterator, error) {
+	if offset < 0 {
+		return nil, errors.Wrapf(ErrInvalidOffset, "offset %d is negative", offset)
+	}
+	return &HeaderIterator{
+		ctx:  ctx,
+		log:  l,
+		next: offset,
+		buf:  make(messageSet, maxMsgSetHeaderLen),
+	}, nil
+}
+
+// Next advances the iterator to the next message's header. It returns false
+// once the end of the log is reached, the context is done, or an error
+// occurs, which is then returned by Err. Since the log may still be written
+// to, calling Next again after reaching the end resumes the scan with any
+// messages appended since.
+func (h *HeaderIterator) Next() bool {
+	if h.err != nil {
+		return false
+	}
+	if err := h.ctx.Err(); err != nil {
+		h.err = err
+		return false
+	}
+	for {
+		if h.segment == nil {
+			seg, _ := findSegment(h.log.Segments(), h.next)
+			if seg == nil {
+				return false
+			}
+			e, err := seg.findEntry(h.next, nil)
+			if err == ErrEntryNotFound {
+				// The segment's index has no entry yet for the next offset.
+				return false
+			}
+			if err != nil {
+				h.err = errors.Wrap(err, "failed to find index entry")
+				return false
+			}
+			h.segment = seg
+			h.pos = e.Position
+		}
+		// Check if the segment is the active one before reading so that a
+		// segment rolled after a read hits its end is not skipped past
+		// early. Segments opened from disk are not sealed, so Sealed can't
+		// be used for this.
+		active := h.segment == h.log.activeSegment()
+		header, err := readHeaderAt(h.segment, h.buf, h.pos)
+		switch {
+		case err == nil:
+			h.header = header
+			h.pos += int64(len(header)) + int64(header.Size())
+			h.next = header.Offset() + 1
+			return true
+		case err == io.EOF:
+			if active {
+				return false
+			}
+			// Move on to the next segment.
+			h.segment = nil
+		case err == ErrSegmentReplaced || err == ErrOffsetRetained:
+			// The segment was compacted, merged, or deleted by retention, so
+			// find where the next offset is now.
+			h.segment = nil
+		default:
+			h.err = errors.Wrap(err, "failed to read message header")
+			return false
+		}
+	}
+}
+
+// Offset returns the offset of the current message.
+func (h *HeaderIterator) Offset() int64 {
+	return h.header.Offset()
+}
+
+// Timestamp returns the timestamp of the current message.
+func (h *HeaderIterator) Timestamp() int64 {
+	return h.header.Timestamp()
+}
+
+// Size returns the size in bytes of the current message, excluding its
+// message-set header.
+func (h *HeaderIterator) Size() int32 {
+	return h.header.Size()
+}
+
+// Err returns the error which stopped the iterator, if any.
+func (h *HeaderIterator) Err() error {
+	return h.err
+}
@@ -0,0 +1,91 @@
+package commitlog
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Ensure HeaderIterator scans message headers across segments and resumes
+// with messages appended after it reaches the end of the log.
+func TestHeaderIterator(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1,
+	})
+	defer cleanup()
+
+	values := []string{"a", "bb", "ccc", "dddd"}
+	for i, value := range values[:3] {
+		_, err := l.Append([]*Message{{Value: []byte(value), Timestamp: int64(i)}})
+		require.NoError(t, err)
+	}
+	require.Len(t, l.Segments(), 3)
+
+	it, err := l.HeaderIterator(context.Background(), 0)
+	require.NoError(t, err)
+	require.True(t, it.Next())
+	// Only the value lengths differ between the messages.
+	overhead := it.Size() - int32(len(values[0]))
+	scan := func(expected ...int64) {
+		for _, offset := range expected {
+			require.True(t, it.Next())
+			require.Equal(t, offset, it.Offset())
+			require.Equal(t, offset, it.Timestamp())
+			require.Equal(t, overhead+int32(len(values[offset])), it.Size())
+		}
+		require.False(t, it.Next())
+		require.NoError(t, it.Err())
+	}
+	scan(1, 2)
+
+	_, err = l.Append([]*Message{{Value: []byte(values[3]), Timestamp: 3}})
+	require.NoError(t, err)
+	scan(3)
+
+	// Segments opened from disk are scanned too.
+	require.NoError(t, l.Close())
+	reopened, err := New(Options{Path: l.Path, MaxSegmentBytes: 1})
+	require.NoError(t, err)
+	defer reopened.Close()
+	it, err = reopened.HeaderIterator(context.Background(), 0)
+	require.NoError(t, err)
+	scan(0, 1, 2, 3)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	it, err = reopened.HeaderIterator(ctx, 0)
+	require.NoError(t, err)
+	require.False(t, it.Next())
+	require.Equal(t, context.Canceled, it.Err())
+}
+
+// Ensure HeaderIterator does not allocate per message within a segment.
+func TestHeaderIteratorAllocs(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1024 * 1024,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	for i := 0; i < 100; i++ {
+		_, err := l.Append([]*Message{{Value: []byte("foo")}})
+		require.NoError(t, err)
+	}
+
+	scan := func(limit int) (count int) {
+		it, err := l.HeaderIterator(context.Background(), 0)
+		require.NoError(t, err)
+		for count < limit && it.Next() {
+			count++
+		}
+		return count
+	}
+	require.Equal(t, 100, scan(1000))
+	// Positioning the iterator allocates, but reading each header does not.
+	first := testing.AllocsPerRun(10, func() { scan(1) })
+	all := testing.AllocsPerRun(10, func() { scan(1000) })
+	require.Equal(t, first, all)
+}
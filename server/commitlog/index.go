@@ -139,6 +139,27 @@ func (idx *index) writeEntries(entries []*entry) (err error) {
 	return nil
 }
 
+// reset replaces the contents of the index with the given entries.
+func (idx *index) reset(entries []*entry) error {
+	b := new(bytes.Buffer)
+	for _, entry := range entries {
+		relEntry := newRelEntry(entry, idx.baseOffset)
+		if err := binary.Write(b, proto.Encoding, relEntry); err != nil {
+			return errors.Wrap(err, "binary write failed")
+		}
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	// Zero out the existing contents so that stale entries past the new end
+	// of the index are not picked up when the index is reopened.
+	for i := range idx.mmap {
+		idx.mmap[i] = 0
+	}
+	idx.writeAt(b.Bytes(), 0)
+	idx.position = int64(b.Len())
+	return nil
+}
+
 // ReadEntryAtFileOffset is used to read an index entry at the given
 // byte offset of the index file. ReadEntryAtLogOffset is generally
 // more useful for higher level use.
@@ -221,6 +242,17 @@ func (idx *index) Close() error {
 	return idx.file.Close()
 }
 
+// unmap closes the index and unmaps it, after which it must no longer be
+// used.
+func (idx *index) unmap() error {
+	if err := idx.Close(); err != nil {
+		return err
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return idx.mmap.UnsafeUnmap()
+}
+
 // Shrink truncates the memory-mapped index file to the size of its contents.
 func (idx *index) Shrink() error {
 	idx.mu.RLock()
@@ -268,25 +300,3 @@ func (idx *index) InitializePosition() (*entry, error) {
 	}
 	return entry, nil
 }
-
-type indexScanner struct {
-	idx    *index
-	entry  *entry
-	offset int64
-}
-
-func newIndexScanner(idx *index) *indexScanner {
-	return &indexScanner{idx: idx, entry: &entry{}}
-}
-
-func (s *indexScanner) Scan() (*entry, error) {
-	err := s.idx.ReadEntryAtLogOffset(s.entry, s.offset)
-	if err != nil {
-		return nil, err
-	}
-	if s.entry.Offset == 0 && s.offset != 0 {
-		return nil, io.EOF
-	}
-	s.offset++
-	return s.entry, err
-}
@@ -1,7 +1,25 @@
 package commitlog
 
+import (
+	"context"
+	"time"
+
+	"github.com/liftbridge-io/liftbridge/server/proto"
+)
+
+// Compactor performs log compaction using a custom key extractor.
+type Compactor interface {
+	// Compact rewrites the log segments, excluding the active segment, such
+	// that they retain only the latest message for each key as determined by
+	// keyFn. If keyFn is nil, the message key is used.
+	Compact(ctx context.Context, keyFn KeyFunc) error
+}
+
 // CommitLog is the durable write-ahead log interface used to back each stream.
 type CommitLog interface {
+	Compactor
+	Iterable
+
 	// Delete closes the log and removes all data associated with it from the
 	// filesystem.
 	Delete() error
@@ -11,6 +29,43 @@ type CommitLog interface {
 	// log. Otherwise, it will only return committed messages.
 	NewReader(offset int64, uncommitted bool) (*Reader, error)
 
+	// NewReaderWithOptions creates a new Reader starting at the given offset
+	// using the provided ReaderOptions.
+	NewReaderWithOptions(offset int64, opts ReaderOptions) (*Reader, error)
+
+	// NewSharedReader creates a new committed SharedReader starting at the
+	// given offset which is safe for concurrent use.
+	NewSharedReader(offset int64) (*SharedReader, error)
+
+	// NewReaderCompactedSnapshot creates a new committed Reader which first
+	// returns the latest message for every key up to the current HW, as
+	// determined by keyFn, and then all messages committed afterwards.
+	NewReaderCompactedSnapshot(ctx context.Context, keyFn KeyFunc) (*Reader, error)
+
+	// NewReaderEffective creates a new committed Reader starting at the given
+	// offset which reads the log as if it were fully compacted, returning
+	// only the latest message for each key up to the current HW.
+	NewReaderEffective(ctx context.Context, offset int64, keyFn KeyFunc) (*Reader, error)
+
+	// NewReaderByAttribute creates a new committed Reader starting at the
+	// given offset which only returns messages whose attributes, masked with
+	// mask, equal value.
+	NewReaderByAttribute(offset int64, mask, value int8) (*Reader, error)
+
+	// NewReaderByEpoch creates a new committed Reader starting at the given
+	// offset which only returns messages written in the given leader epoch.
+	NewReaderByEpoch(offset int64, epoch uint64) (*Reader, error)
+
+	// NewWindowedReader creates a new WindowedReader starting at the given
+	// offset which groups committed messages into time windows of the given
+	// duration based on their timestamps.
+	NewWindowedReader(ctx context.Context, offset int64, window time.Duration) (*WindowedReader, error)
+
+	// NewSubscriptionRegistry creates a new SubscriptionRegistry which tracks
+	// committed Readers by subscription ID, removing subscriptions left unused
+	// for longer than the given TTL.
+	NewSubscriptionRegistry(ttl time.Duration) *SubscriptionRegistry
+
 	// Truncate removes all messages from the log starting at the given offset.
 	Truncate(offset int64) error
 
@@ -26,6 +81,99 @@ type CommitLog interface {
 	// greater than or equal to the given timestamp.
 	OffsetForTimestamp(timestamp int64) (int64, error)
 
+	// OffsetForTimestampFloor returns the latest offset whose timestamp is
+	// less than or equal to the given timestamp, never exceeding the HW. If
+	// the timestamp is before the oldest message, the oldest offset is
+	// returned.
+	OffsetForTimestampFloor(timestamp int64) (int64, error)
+
+	// OffsetForTimestampWithBudget is like OffsetForTimestamp but returns
+	// ErrSearchBudgetExceeded if the lookup exceeds the budget.
+	OffsetForTimestampWithBudget(timestamp int64, budget SearchBudget) (int64, error)
+
+	// OffsetForTimestampFloorWithBudget is like OffsetForTimestampFloor but
+	// returns ErrSearchBudgetExceeded if the lookup exceeds the budget.
+	OffsetForTimestampFloorWithBudget(timestamp int64, budget SearchBudget) (int64, error)
+
+	// Latest returns the most recent committed message along with its offset
+	// and timestamp, blocking until a message is committed if the log has
+	// none.
+	Latest(ctx context.Context) (SerializedMessage, int64, int64, error)
+
+	// WaitForCommit blocks until the given offset is committed.
+	WaitForCommit(ctx context.Context, offset int64) error
+
+	// ReadAfter waits for the given offset to be committed and then reads
+	// the message at that offset.
+	ReadAfter(ctx context.Context, writtenOffset int64, headersBuf []byte) (
+		SerializedMessage, int64, int64, uint64, error)
+
+	// NewReaderCommittedLastDuration creates a new committed Reader starting
+	// at the earliest message whose timestamp is within the given duration of
+	// the current time.
+	NewReaderCommittedLastDuration(d time.Duration) (*Reader, error)
+
+	// SegmentBoundaryMessages returns the offset and timestamp of the first
+	// message in each non-empty segment.
+	SegmentBoundaryMessages() ([]SegmentBoundary, error)
+
+	// ReadPageReverse returns up to limit committed messages whose offsets
+	// are less than before, newest first, along with their offsets,
+	// timestamps, and the cursor for the next, older page.
+	ReadPageReverse(before int64, limit int) ([]SerializedMessage, []int64, []int64, int64, error)
+
+	// TailReverse returns the last n committed messages, newest first, along
+	// with their offsets and timestamps.
+	TailReverse(ctx context.Context, n int) ([]SerializedMessage, []int64, []int64, error)
+
+	// HeaderIterator creates a new HeaderIterator starting at the given
+	// offset which scans message headers without reading payloads.
+	HeaderIterator(ctx context.Context, offset int64) (*HeaderIterator, error)
+
+	// Stats returns a consistent snapshot of the state of the log.
+	Stats() LogStats
+
+	// NewReaderFromCursor creates a new Reader which resumes reading at the
+	// position described by the given cursor.
+	NewReaderFromCursor(cursor *proto.Cursor, opts ReaderOptions) (*Reader, error)
+
+	// NewReaderUncommittedUntil creates a new uncommitted Reader starting at
+	// the given offset which returns an ErrEndOfStream once it reaches the
+	// given log end offset rather than waiting for more data.
+	NewReaderUncommittedUntil(offset, leo int64) (*Reader, error)
+
+	// NewReaderForTimestampFloor creates a new Reader starting at the offset
+	// returned by OffsetForTimestampFloor for the given timestamp.
+	NewReaderForTimestampFloor(timestamp int64, opts ReaderOptions) (*Reader, error)
+
+	// ResolveOffset returns the base offset of the segment containing the
+	// given offset and the position of the offset within the segment's log
+	// file.
+	ResolveOffset(offset int64) (int64, int64, error)
+
+	// ResolveOffsetWithBudget is like ResolveOffset but returns
+	// ErrSearchBudgetExceeded if the lookup exceeds the budget.
+	ResolveOffsetWithBudget(offset int64, budget SearchBudget) (int64, int64, error)
+
+	// ScanOffsets walks the log starting at the given offset and invokes fn
+	// with the offset, timestamp, and segment-relative position of each
+	// message, reading only message headers.
+	ScanOffsets(ctx context.Context, from int64, fn func(offset, timestamp, position int64) error) error
+
+	// IndexEntries returns the index entries for the messages in the log
+	// whose offsets are between the given offsets, inclusive, clamped to the
+	// offsets present in the log.
+	IndexEntries(from, to int64) ([]IndexEntry, error)
+
+	// SparseIndex returns an index entry roughly every byteInterval bytes
+	// across the log, starting with its first message.
+	SparseIndex(byteInterval int64) ([]IndexEntry, error)
+
+	// ByteSize returns the number of bytes, including message set headers,
+	// occupied by the messages whose offsets are between the given offsets,
+	// inclusive, clamped to the offsets present in the log.
+	ByteSize(from, to int64) (int64, error)
+
 	// SetHighWatermark sets the high watermark on the log. All messages up to
 	// and including the high watermark are considered committed.
 	SetHighWatermark(hw int64)
@@ -53,6 +201,25 @@ type CommitLog interface {
 	// corresponding offsets in the log.
 	Append(msg []*Message) ([]int64, error)
 
+	// AppendMessage writes the given message to the log and returns the
+	// offset and timestamp assigned to it. If the message has no timestamp
+	// set, it is stamped with the current time.
+	AppendMessage(msg *Message) (int64, int64, error)
+
+	// AppendBatch writes the given batch of messages to the log and returns
+	// their corresponding offsets and timestamps. Readers waiting for data
+	// are notified once for the entire batch.
+	AppendBatch(msgs []*Message) ([]int64, []int64, error)
+
+	// AppendCompressed writes the given batch of messages to the log as a
+	// single compressed batch frame and returns their corresponding offsets
+	// in the log. Readers transparently expand the frame into its messages.
+	AppendCompressed(msgs []*Message) ([]int64, error)
+
+	// CloseStream appends an end-of-stream marker to the log and returns its
+	// offset. Committed readers return io.EOF once they read the marker.
+	CloseStream() (int64, error)
+
 	// AppendMessageSet writes the given message set data to the log and
 	// returns the corresponding offsets in the log.
 	AppendMessageSet(ms []byte) ([]int64, error)
@@ -61,6 +228,65 @@ type CommitLog interface {
 	// applicable.
 	Clean() error
 
+	// EnforceRetention deletes segments whose newest message is older than
+	// maxAge and the oldest segments as needed to bring the total log size
+	// under maxBytes, never deleting the active segment. It returns the
+	// number of segments deleted.
+	EnforceRetention(maxAge time.Duration, maxBytes int64) (int, error)
+
+	// Roll seals the active segment and starts a new one even if the active
+	// segment has not reached its size limit. This is a no-op if the active
+	// segment is empty.
+	Roll() error
+
+	// MergeSmallSegments concatenates runs of adjacent sealed segments
+	// smaller than targetSize bytes into single segments of at most
+	// targetSize bytes without changing offsets.
+	MergeSmallSegments(targetSize int64) error
+
+	// NewSegment creates an empty segment with the given base offset to be
+	// built and then swapped into the log with SwapSegments.
+	NewSegment(baseOffset int64) (*Segment, error)
+
+	// SwapSegments atomically replaces the sealed segments whose base offsets
+	// are less than the next offset of the last given segment with the given
+	// segments, which must be non-empty, ordered by offset, and end before
+	// the active segment.
+	SwapSegments(segments []*Segment) error
+
+	// ActiveSegment returns a snapshot of the segment currently being
+	// appended to. The segment may roll concurrently, so the returned info
+	// may be stale.
+	ActiveSegment() SegmentInfo
+
+	// SegmentFileForOffset returns the path and base offset of the segment
+	// log file containing the given offset.
+	SegmentFileForOffset(offset int64) (string, int64, error)
+
+	// Scrub reads every committed message in the log, verifying its header
+	// and CRC, and reports problems to fn without stopping at the first one.
+	// It returns the number of messages scanned and found bad.
+	Scrub(ctx context.Context, fn func(offset int64, err error)) (ScrubResult, error)
+
+	// CompactionStats returns stats describing the effect of the last log
+	// compaction.
+	CompactionStats() CompactionStats
+
+	// Clone copies the messages in the log from the given offset onward into
+	// a new log in the given directory, preserving their offsets, and returns
+	// the new log.
+	Clone(ctx context.Context, dir string, fromOffset int64) (CommitLog, error)
+
+	// SegmentRolls returns a channel which receives the base offset of each
+	// new segment rolled. Rolls are dropped if the channel's buffer is full,
+	// and the channel is closed when the log is closed.
+	SegmentRolls() <-chan int64
+
+	// OpenSegmentCount returns the number of segments in the log whose files
+	// are open. Readers share the log's segment files unless created with
+	// MaxOpenSegments, so this does not grow with the number of readers.
+	OpenSegmentCount() int
+
 	// NotifyLEO registers and returns a channel which is closed when messages
 	// past the given log end offset are added to the log. If the given offset
 	// is no longer the log end offset, the channel is closed immediately.
@@ -0,0 +1,65 @@
+//go:build go1.23
+// +build go1.23
+
+package commitlog
+
+import (
+	"context"
+	"io"
+	"iter"
+
+	"github.com/pkg/errors"
+)
+
+// Iterable is implemented by logs which can be ranged over with a Go 1.23
+// range-over-func loop.
+type Iterable interface {
+	// All returns an iterator over the committed messages in the log starting
+	// at the given offset, yielding each message with its offset, along with
+	// a function returning the error which stopped the iteration, if any.
+	All(ctx context.Context, offset int64) (iter.Seq2[int64, SerializedMessage], func() error)
+}
+
+// All returns an iterator over the committed messages in the log starting at
+// the given offset, yielding each message with its offset, e.g.
+//
+//	messages, errFn := log.All(ctx, 0)
+//	for offset, msg := range messages {
+//		...
+//	}
+//	if err := errFn(); err != nil {
+//		...
+//	}
+//
+// Like a committed Reader, the iterator waits for messages to be committed
+// once it reaches the HW, so iteration only ends when the loop breaks, the
+// context is done, or the end of the stream is reached, e.g. an end-of-stream
+// marker. The returned function reports the error which stopped the most
+// recent iteration, which is nil if the loop broke or the end of the stream
+// was reached. Each iteration reads the log anew from the given offset.
+func (l *commitLog) All(ctx context.Context, offset int64) (iter.Seq2[int64, SerializedMessage], func() error) {
+	var err error
+	messages := func(yield func(int64, SerializedMessage) bool) {
+		err = nil
+		r, e := l.NewReader(offset, false)
+		if e != nil {
+			err = errors.Wrap(e, "failed to create reader")
+			return
+		}
+		headers := make([]byte, msgSetHeaderLen)
+		for {
+			msg, offset, _, _, e := r.ReadMessage(ctx, headers)
+			if errors.Cause(e) == io.EOF {
+				return
+			}
+			if e != nil {
+				err = e
+				return
+			}
+			if !yield(offset, msg) {
+				return
+			}
+		}
+	}
+	return messages, func() error { return err }
+}
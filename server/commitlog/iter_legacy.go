@@ -0,0 +1,9 @@
+//go:build !go1.23
+// +build !go1.23
+
+package commitlog
+
+// Iterable is implemented by logs which can be ranged over with a Go 1.23
+// range-over-func loop. Range-over-func iteration is not available before Go
+// 1.23, so this is empty.
+type Iterable interface{}
@@ -0,0 +1,67 @@
+//go:build go1.23
+// +build go1.23
+
+package commitlog
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// Ensure All iterates over committed messages, stops when the loop breaks or
+// the stream ends, and reports the error which stopped it otherwise.
+func TestAll(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	for i := 0; i < 5; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(3)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	messages, errFn := l.All(ctx, 1)
+	expected := int64(1)
+	for offset, msg := range messages {
+		require.Equal(t, expected, offset)
+		require.Equal(t, strconv.FormatInt(offset, 10), string(msg.Value()))
+		expected++
+	}
+	// Offset 4 is not committed, so the iterator waits until the context is
+	// done.
+	require.Equal(t, int64(4), expected)
+	require.Equal(t, context.DeadlineExceeded, errors.Cause(errFn()))
+
+	// Breaking out of the loop is not an error.
+	messages, errFn = l.All(context.Background(), 1)
+	for offset := range messages {
+		require.Equal(t, int64(1), offset)
+		break
+	}
+	require.NoError(t, errFn())
+
+	// The end of the stream ends the iteration.
+	l.SetHighWatermark(4)
+	eos, err := l.CloseStream()
+	require.NoError(t, err)
+	l.SetHighWatermark(eos)
+	messages, errFn = l.All(context.Background(), 3)
+	expected = 3
+	for offset := range messages {
+		require.Equal(t, expected, offset)
+		expected++
+	}
+	require.Equal(t, int64(5), expected)
+	require.NoError(t, errFn())
+}
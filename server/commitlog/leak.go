@@ -0,0 +1,39 @@
+package commitlog
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// trackLeak sets a finalizer on the Reader which logs a warning if it is
+// garbage collected while still registered as a waiter on the log or one of
+// its segments. This indicates the Reader was abandoned while waiting for
+// data, e.g. because its context was never canceled, leaking its waiter
+// registration. The warning includes the stack where the Reader was created.
+func (l *commitLog) trackLeak(r *Reader) {
+	stack := debug.Stack()
+	runtime.SetFinalizer(r, func(r *Reader) {
+		if r.ctxReader != nil && l.isWaiter(r.ctxReader) {
+			l.Logger.Warnf("Reader for log %s garbage collected while waiting for data, created at:\n%s",
+				l.Path, stack)
+		}
+	})
+}
+
+// isWaiter indicates if the contextReader is registered as a waiter for the
+// HW or for data in a segment.
+func (l *commitLog) isWaiter(r contextReader) bool {
+	l.mu.RLock()
+	_, ok := l.hwWaiters[r]
+	segments := l.segments
+	l.mu.RUnlock()
+	if ok {
+		return true
+	}
+	for _, seg := range segments {
+		if seg.isWaiter(r) {
+			return true
+		}
+	}
+	return false
+}
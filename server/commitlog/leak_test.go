@@ -0,0 +1,73 @@
+package commitlog
+
+import (
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/liftbridge-io/liftbridge/server/logger"
+)
+
+// warnLogger records warnings logged.
+type warnLogger struct {
+	logger.Logger
+	mu    sync.Mutex
+	warns []string
+}
+
+func (w *warnLogger) Warnf(format string, args ...interface{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.warns = append(w.warns, format)
+}
+
+func (w *warnLogger) numWarns() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.warns)
+}
+
+// Ensure a warning is logged when a Reader is garbage collected while still
+// registered as a waiter, but not otherwise.
+func TestReaderLeakDetector(t *testing.T) {
+	log := &warnLogger{Logger: logger.NewLogger(0)}
+	l, cleanup := setupWithOptions(t, Options{
+		Path:             tempDir(t),
+		MaxSegmentBytes:  100,
+		DebugReaderLeaks: true,
+		Logger:           log,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	// A Reader which is not waiting is not reported.
+	func() {
+		_, err := l.NewReader(0, false)
+		require.NoError(t, err)
+	}()
+	for i := 0; i < 5; i++ {
+		runtime.GC()
+		time.Sleep(time.Millisecond)
+	}
+	require.Equal(t, 0, log.numWarns())
+
+	// Abandon a Reader registered as a HW waiter.
+	func() {
+		r, err := l.NewReader(0, false)
+		require.NoError(t, err)
+		l.waitForHW(r.ctxReader, l.HighWatermark())
+	}()
+	// Poll rather than use require.Eventually, whose ticks can overlap the
+	// slow GC condition and send on its closed channel.
+	deadline := time.Now().Add(time.Second)
+	for log.numWarns() == 0 && time.Now().Before(deadline) {
+		runtime.GC()
+		time.Sleep(time.Millisecond)
+	}
+	require.Equal(t, 1, log.numWarns())
+	require.True(t, strings.Contains(log.warns[0], "garbage collected while waiting"))
+}
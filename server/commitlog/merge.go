@@ -0,0 +1,323 @@
+package commitlog
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	atomic_file "github.com/natefinch/atomic"
+	"github.com/pkg/errors"
+)
+
+// mergeIntentSuffix is the suffix of the file recording the intent of a merge.
+const mergeIntentSuffix = ".merging"
+
+// MergeSmallSegments concatenates runs of adjacent sealed segments smaller
+// than targetSize bytes into single segments of at most targetSize bytes,
+// e.g. to reduce the file descriptors and per-segment read overhead of a log
+// fragmented by retention or compaction. Offsets, positions within messages,
+// and leader epochs are unchanged, and each merged segment's index is rebuilt
+// from those of the segments it replaces. The active segment is never
+// merged. Like SwapSegments, the log's generation is bumped, so readers
+// re-resolve their position against the merged segments. If merging fails,
+// the log is left unchanged, and a merge interrupted by a crash is completed
+// when the log is reopened. This blocks cleaning while it runs, so it is meant
+// to be called in the background.
+func (l *commitLog) MergeSmallSegments(targetSize int64) error {
+	if targetSize <= 0 {
+		return errors.Errorf("invalid target segment size %d", targetSize)
+	}
+	l.cleanMu.Lock()
+	defer l.cleanMu.Unlock()
+
+	segments := l.Segments()
+	runs := mergeRuns(segments[:len(segments)-1], targetSize)
+	if len(runs) == 0 {
+		return nil
+	}
+	merged := make([]*segment, 0, len(runs))
+	for _, run := range runs {
+		seg, err := mergeSegments(run)
+		if err != nil {
+			// Nothing has been swapped in yet, so the log is unchanged.
+			deleteSegments(merged)
+			return errors.Wrapf(err, "failed to merge segments starting at %d", run[0].BaseOffset)
+		}
+		merged = append(merged, seg)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.commitMerges(runs, merged)
+}
+
+// commitMerges swaps the merged segments into the log in place of their runs
+// and then moves their files into place. A run is skipped if any of its
+// segments were removed from the log while it was being merged, e.g. by a
+// truncation. Before anything is changed, an intent listing the segments to
+// delete is recorded for each run, so a merge interrupted by a crash is
+// completed when the log is reopened rather than leaving overlapping
+// segments. This must be called with cleanMu and mu held.
+func (l *commitLog) commitMerges(runs [][]*segment, merged []*segment) error {
+	inLog := make(map[*segment]struct{}, len(l.segments))
+	for _, seg := range l.segments {
+		inLog[seg] = struct{}{}
+	}
+	var (
+		replaced  = make(map[*segment]*segment)
+		committed []int
+	)
+	for i, run := range runs {
+		intact := true
+		for _, seg := range run {
+			if _, ok := inLog[seg]; !ok {
+				intact = false
+				break
+			}
+		}
+		if !intact {
+			merged[i].Delete() // nolint: errcheck
+			continue
+		}
+		if err := writeMergeIntent(l.Path, run, merged[i]); err != nil {
+			for _, j := range committed {
+				os.Remove(mergeIntentPath(l.Path, runs[j][0].BaseOffset)) // nolint: errcheck
+			}
+			deleteSegments(merged[i:])
+			for _, j := range committed {
+				merged[j].Delete() // nolint: errcheck
+			}
+			return errors.Wrap(err, "failed to record merge intent")
+		}
+		committed = append(committed, i)
+		for _, seg := range run {
+			replaced[seg] = nil
+		}
+		replaced[run[0]] = merged[i]
+	}
+	if len(committed) == 0 {
+		return nil
+	}
+
+	swapped := make([]*segment, 0, len(l.segments))
+	for _, seg := range l.segments {
+		m, ok := replaced[seg]
+		if !ok {
+			swapped = append(swapped, seg)
+		} else if m != nil {
+			swapped = append(swapped, m)
+		}
+	}
+	if err := l.swapSegments(swapped); err != nil {
+		// The recorded intents complete the merges when the log is reopened.
+		return err
+	}
+	for _, i := range committed {
+		if err := finishMerge(l.Path, runs[i], merged[i]); err != nil {
+			return errors.Wrap(err, "failed to finish merge")
+		}
+	}
+	return nil
+}
+
+// mergeRuns returns the runs of at least two adjacent segments smaller than
+// targetSize whose combined size does not exceed it.
+func mergeRuns(segments []*segment, targetSize int64) [][]*segment {
+	var (
+		runs [][]*segment
+		run  []*segment
+		size int64
+	)
+	for _, seg := range segments {
+		position := seg.Position()
+		if len(run) > 0 && size+position > targetSize {
+			if len(run) > 1 {
+				runs = append(runs, run)
+			}
+			run, size = nil, 0
+		}
+		if position >= targetSize {
+			continue
+		}
+		run = append(run, seg)
+		size += position
+	}
+	if len(run) > 1 {
+		runs = append(runs, run)
+	}
+	return runs
+}
+
+// mergeSegments concatenates the given adjacent segments into a new sealed
+// segment whose files are moved into place of those of the first one once the
+// merge is committed.
+func mergeSegments(run []*segment) (*segment, error) {
+	merged, err := run[0].Merged()
+	if err != nil {
+		return nil, err
+	}
+	for _, seg := range run {
+		if err := appendSegment(merged, seg); err != nil {
+			merged.Delete() // nolint: errcheck
+			return nil, err
+		}
+	}
+	merged.Seal()
+	return merged, nil
+}
+
+// appendSegment writes the messages and index entries of seg to the end of
+// merged.
+func appendSegment(merged, seg *segment) error {
+	if seg.IsEmpty() {
+		return nil
+	}
+	ms := make([]byte, seg.Position())
+	if _, err := seg.ReadAt(ms, 0); err != nil {
+		return errors.Wrap(err, "failed to read segment")
+	}
+	entries, err := seg.entriesRange(seg.BaseOffset, math.MaxInt64)
+	if err != nil {
+		return errors.Wrap(err, "failed to read segment index")
+	}
+	position := merged.Position()
+	for _, e := range entries {
+		e.Position += position
+	}
+	return errors.Wrap(merged.WriteMessageSet(ms, entries), "failed to write merged segment")
+}
+
+// deleteSegments deletes the given segments, ignoring any errors since it is
+// only used to clean up after a failed operation.
+func deleteSegments(segments []*segment) {
+	for _, seg := range segments {
+		seg.Delete() // nolint: errcheck
+	}
+}
+
+// mergeIntentPath returns the path of the intent recorded for the merge into
+// the segment with the given base offset.
+func mergeIntentPath(path string, baseOffset int64) string {
+	return filepath.Join(path, fmt.Sprintf(fileFormat, baseOffset, mergeIntentSuffix))
+}
+
+// writeMergeIntent records the suffix of the merged segment's files and the
+// base offsets of the segments in the run which are deleted once it is merged.
+func writeMergeIntent(path string, run []*segment, merged *segment) error {
+	var buf bytes.Buffer
+	buf.WriteString(merged.suffix)
+	buf.WriteByte('\n')
+	for _, seg := range run[1:] {
+		buf.WriteString(strconv.FormatInt(seg.BaseOffset, 10))
+		buf.WriteByte('\n')
+	}
+	return atomic_file.WriteFile(mergeIntentPath(path, run[0].BaseOffset), &buf)
+}
+
+// finishMerge deletes the files of every segment of the run but the first,
+// moves the merged segment's files into place of the first's, and removes the
+// merge's intent. The merged segment's files stay open while they are renamed,
+// so it remains readable throughout.
+func finishMerge(path string, run []*segment, merged *segment) error {
+	rest := make([]int64, len(run)-1)
+	for i, seg := range run[1:] {
+		rest[i] = seg.BaseOffset
+	}
+	merged.Lock()
+	defer merged.Unlock()
+	if err := completeMerge(path, run[0].BaseOffset, merged.suffix, rest); err != nil {
+		return err
+	}
+	merged.suffix = ""
+	return nil
+}
+
+// completeMerge performs the file operations of finishing the merge into the
+// segment with the given base offset, whose files have the given suffix until
+// then, of the segments with the rest base offsets. It is idempotent, so a
+// merge can be completed after a crash interrupted it at any point.
+func completeMerge(path string, baseOffset int64, suffix string, rest []int64) error {
+	for _, offset := range rest {
+		seg := &segment{path: path, BaseOffset: offset}
+		for _, file := range []string{seg.logPath(), seg.indexPath()} {
+			if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	var (
+		from = &segment{path: path, BaseOffset: baseOffset, suffix: suffix}
+		to   = &segment{path: path, BaseOffset: baseOffset}
+	)
+	if err := os.Rename(from.indexPath(), to.indexPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(from.logPath(), to.logPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Remove(mergeIntentPath(path, baseOffset))
+}
+
+// isStagedSuffix indicates if the file suffix is that of a segment built to be
+// moved into place of other segments, i.e. a merged segment or one built for
+// SwapSegments.
+func isStagedSuffix(suffix string) bool {
+	return suffix == mergedSuffix || suffix == stagedSuffix
+}
+
+// recoverMerges completes any merges, including those of segments swapped in
+// by SwapSegments, whose intent was recorded but which were interrupted
+// before they finished, and removes the files of merged and staged segments
+// which were never committed. This must be called before the log's segments
+// are opened.
+func (l *commitLog) recoverMerges() error {
+	files, err := ioutil.ReadDir(l.Path)
+	if err != nil {
+		return errors.Wrap(err, "read dir failed")
+	}
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), mergeIntentSuffix) {
+			continue
+		}
+		baseOffset, err := strconv.ParseInt(strings.TrimSuffix(file.Name(), mergeIntentSuffix), 10, 64)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(filepath.Join(l.Path, file.Name()))
+		if err != nil {
+			return errors.Wrap(err, "read merge intent failed")
+		}
+		lines := strings.Fields(string(data))
+		if len(lines) == 0 || !isStagedSuffix(lines[0]) {
+			return errors.Errorf("invalid merge intent %s", file.Name())
+		}
+		var rest []int64
+		for _, line := range lines[1:] {
+			offset, err := strconv.ParseInt(line, 10, 64)
+			if err != nil {
+				return errors.Wrap(err, "parse merge intent failed")
+			}
+			rest = append(rest, offset)
+		}
+		if err := completeMerge(l.Path, baseOffset, lines[0], rest); err != nil {
+			return errors.Wrap(err, "failed to complete merge")
+		}
+	}
+	files, err = ioutil.ReadDir(l.Path)
+	if err != nil {
+		return errors.Wrap(err, "read dir failed")
+	}
+	for _, file := range files {
+		if isStagedSuffix(filepath.Ext(file.Name())) {
+			if err := os.Remove(filepath.Join(l.Path, file.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package commitlog
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Ensure MergeSmallSegments merges runs of small sealed segments without
+// changing offsets, that readers continue across the merge, and that the
+// merged log is reloaded intact.
+func TestMergeSmallSegments(t *testing.T) {
+	opts := Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+
+	numMsgs := 10
+	for i := 0; i < numMsgs; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(int64(numMsgs - 1))
+	segments := l.Segments()
+	require.Len(t, segments, numMsgs)
+	size := segments[0].Position()
+
+	r, err := l.NewReader(0, false)
+	require.NoError(t, err)
+	headers := make([]byte, 28)
+	_, offset, _, _, err := r.ReadMessage(context.Background(), headers)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), offset)
+
+	// Merge the nine sealed segments into segments of three.
+	require.NoError(t, l.MergeSmallSegments(3*size))
+	merged := l.Segments()
+	require.Len(t, merged, 4)
+	for i, base := range []int64{0, 3, 6, 9} {
+		require.Equal(t, base, merged[i].BaseOffset)
+	}
+	require.Equal(t, segments[9], merged[3])
+	require.Equal(t, uint64(1), l.Generation())
+
+	// Merging again is a no-op since the merged segments are not small.
+	require.NoError(t, l.MergeSmallSegments(3*size))
+	require.Len(t, l.Segments(), 4)
+
+	for i := 1; i < numMsgs; i++ {
+		msg, offset, _, _, err := r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+		require.Equal(t, int64(i), offset)
+		require.Equal(t, []byte(strconv.Itoa(i)), msg.Value())
+	}
+	require.Equal(t, int64(9), l.NewestOffset())
+	_, position, err := l.ResolveOffset(5)
+	require.NoError(t, err)
+	require.Equal(t, 2*size, position)
+
+	// The merged segments are reloaded when the log is reopened.
+	require.NoError(t, l.Close())
+	reopened, err := New(opts)
+	require.NoError(t, err)
+	defer reopened.Close()
+	require.Len(t, reopened.(*commitLog).Segments(), 4)
+	r, err = reopened.NewReader(0, true)
+	require.NoError(t, err)
+	for i := 0; i < numMsgs; i++ {
+		msg, offset, _, _, err := r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+		require.Equal(t, int64(i), offset)
+		require.Equal(t, []byte(strconv.Itoa(i)), msg.Value())
+	}
+}
+
+// Ensure a merge interrupted after its intent was recorded is completed when
+// the log is reopened, and that merged segments which were never committed
+// are removed.
+func TestMergeSmallSegmentsRecovery(t *testing.T) {
+	opts := Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+
+	numMsgs := 10
+	for i := 0; i < numMsgs; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	segments := l.Segments()
+	size := segments[0].Position()
+	runs := mergeRuns(segments[:len(segments)-1], 3*size)
+	require.Len(t, runs, 3)
+
+	// Record the first merge's intent and crash after deleting only one of
+	// the merged segments.
+	committed, err := mergeSegments(runs[0])
+	require.NoError(t, err)
+	require.NoError(t, writeMergeIntent(l.Path, runs[0], committed))
+	require.NoError(t, os.Remove(runs[0][1].logPath()))
+
+	// Crash before recording the second merge's intent.
+	uncommitted, err := mergeSegments(runs[1])
+	require.NoError(t, err)
+
+	require.NoError(t, committed.Close())
+	require.NoError(t, uncommitted.Close())
+	require.NoError(t, l.Close())
+
+	reopened, err := New(opts)
+	require.NoError(t, err)
+	defer reopened.Close()
+	recovered := reopened.(*commitLog).Segments()
+	require.Len(t, recovered, 8)
+	for i, base := range []int64{0, 3, 4, 5, 6, 7, 8, 9} {
+		require.Equal(t, base, recovered[i].BaseOffset)
+	}
+	files, err := ioutil.ReadDir(opts.Path)
+	require.NoError(t, err)
+	for _, file := range files {
+		require.False(t, isStagedSuffix(filepath.Ext(file.Name())), file.Name())
+		require.False(t, strings.HasSuffix(file.Name(), mergeIntentSuffix), file.Name())
+	}
+
+	r, err := reopened.NewReader(0, true)
+	require.NoError(t, err)
+	headers := make([]byte, 28)
+	for i := 0; i < numMsgs; i++ {
+		msg, offset, _, _, err := r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+		require.Equal(t, int64(i), offset)
+		require.Equal(t, []byte(strconv.Itoa(i)), msg.Value())
+	}
+}
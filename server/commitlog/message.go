@@ -2,6 +2,7 @@ package commitlog
 
 import (
 	"errors"
+	"fmt"
 	"hash/crc32"
 
 	client "github.com/liftbridge-io/liftbridge-api/go"
@@ -9,6 +10,25 @@ import (
 
 var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
 
+// Message attribute flags.
+const (
+	// AttrControl indicates the message is a control record written by the
+	// log rather than user data.
+	AttrControl int8 = 1 << 0
+
+	// AttrEndOfStream indicates the message is an end-of-stream marker. It is
+	// always set along with AttrControl.
+	AttrEndOfStream int8 = 1 << 1
+
+	// AttrCompressed indicates the message is a batch frame whose value is a
+	// compressed message set containing multiple inner messages.
+	AttrCompressed int8 = 1 << 2
+
+	// AttrPlaceholder indicates the message is a placeholder returned by a
+	// Reader filling a gap in offsets rather than a message in the log.
+	AttrPlaceholder int8 = 1 << 3
+)
+
 // Message is the object that gets serialized and written to the log.
 type Message struct {
 	Crc        int32
@@ -99,6 +119,33 @@ func (m SerializedMessage) Attributes() int8 {
 	return int8(m[5])
 }
 
+// checkCRC returns an error if the CRC32 digest of the message does not match
+// its contents, indicating the data is corrupted.
+func (m SerializedMessage) checkCRC() error {
+	crc := m.Crc()
+	if c := crc32.Checksum(m[4:], crc32cTable); crc != c {
+		return fmt.Errorf("Read corrupted data, expected CRC: 0x%08x, got: 0x%08x", crc, c)
+	}
+	return nil
+}
+
+// HasAttributes indicates if any of the given attribute flags are set on the
+// message.
+func (m SerializedMessage) HasAttributes(attrs int8) bool {
+	return m.Attributes()&attrs != 0
+}
+
+// IsEndOfStream indicates if the message is an end-of-stream marker.
+func (m SerializedMessage) IsEndOfStream() bool {
+	eos := AttrControl | AttrEndOfStream
+	return m.Attributes()&eos == eos
+}
+
+// IsCompressed indicates if the message is a compressed batch frame.
+func (m SerializedMessage) IsCompressed() bool {
+	return m.Attributes()&AttrCompressed != 0
+}
+
 // Key returns the message key.
 func (m SerializedMessage) Key() []byte {
 	start, end, size := m.keyOffsets()
@@ -160,3 +207,19 @@ func (m SerializedMessage) valueOffsets() (start, end, size int32) {
 	}
 	return
 }
+
+// toMessage decodes the SerializedMessage into a Message with the given
+// timestamp and leader epoch from its message set header. The returned
+// Message references the underlying bytes of the SerializedMessage.
+func (m SerializedMessage) toMessage(timestamp int64, leaderEpoch uint64) *Message {
+	return &Message{
+		Crc:         int32(m.Crc()),
+		MagicByte:   m.MagicByte(),
+		Attributes:  m.Attributes(),
+		Key:         m.Key(),
+		Value:       m.Value(),
+		Headers:     m.Headers(),
+		Timestamp:   timestamp,
+		LeaderEpoch: leaderEpoch,
+	}
+}
@@ -2,10 +2,12 @@ package commitlog
 
 import (
 	"bytes"
+	"compress/flate"
 	"context"
 	"encoding/binary"
-	"fmt"
-	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math"
 
 	"github.com/pkg/errors"
 )
@@ -16,13 +18,41 @@ const (
 	leaderEpochPos  = 16
 	sizePos         = 24
 	msgSetHeaderLen = 28
+
+	// maxMsgSetHeaderLen is the largest size of a message set header in any
+	// HeaderFormat, i.e. that of a varint header with the largest values.
+	maxMsgSetHeaderLen = 2 + 3*binary.MaxVarintLen64 + binary.MaxVarintLen32
 )
 
+// HeaderFormat is the format of the message set headers written to a log's
+// segments. The first byte of every header is the version byte selecting its
+// format, so readers handle segments containing either format, and the format
+// of a log can be changed when it is reopened.
+type HeaderFormat uint8
+
+const (
+	// HeaderFormatFixed is the 28-byte header containing the offset,
+	// timestamp, leader epoch, and message size as big-endian integers. Its
+	// version byte is the most significant byte of the offset, which is 0
+	// for any offset below 2^56.
+	HeaderFormatFixed HeaderFormat = iota
+
+	// HeaderFormatVarint is a header containing its version byte and its
+	// length followed by the offset, timestamp, leader epoch, and message
+	// size as varints. This typically takes 14 to 18 bytes, saving space for
+	// high-volume topics of small messages.
+	HeaderFormatVarint
+)
+
+// errInvalidHeader is returned when a message set header has an unknown
+// format or is malformed.
+var errInvalidHeader = errors.New("invalid message set header")
+
 type messageSet []byte
 
 func entriesForMessageSet(basePos int64, ms []byte) []*entry {
 	entries := []*entry{}
-	if len(ms) <= msgSetHeaderLen {
+	if len(ms) < 2 || len(ms) <= messageSet(ms).HeaderLen() {
 		return entries
 	}
 	var n int64
@@ -33,21 +63,128 @@ func entriesForMessageSet(basePos int64, ms []byte) []*entry {
 			offset      = m.Offset()
 			timestamp   = m.Timestamp()
 			leaderEpoch = m.LeaderEpoch()
-			size        = m.Size()
+			size        = int32(m.HeaderLen()) + m.Size()
 		)
 		entries = append(entries, &entry{
 			Offset:      offset,
 			Timestamp:   timestamp,
 			LeaderEpoch: leaderEpoch,
 			Position:    basePos + relPos,
-			Size:        size + msgSetHeaderLen,
+			Size:        size,
 		})
-		n += msgSetHeaderLen + int64(size)
-		ms = ms[msgSetHeaderLen+size:]
+		n += int64(size)
+		ms = ms[size:]
 	}
 	return entries
 }
 
+// encodeMessageSet returns the message sets with their headers re-encoded in
+// the given format along with their entries starting at basePos.
+func encodeMessageSet(ms messageSet, format HeaderFormat, basePos int64) (messageSet, []*entry) {
+	var (
+		out    = make(messageSet, 0, len(ms))
+		header = make([]byte, maxMsgSetHeaderLen)
+	)
+	for rest := ms; len(rest) > 0; {
+		n := putHeader(header, format, rest.Offset(), rest.Timestamp(), rest.LeaderEpoch(), rest.Size())
+		out = append(out, header[:n]...)
+		out = append(out, rest.Message()...)
+		rest = rest[rest.HeaderLen()+int(rest.Size()):]
+	}
+	return out, entriesForMessageSet(basePos, out)
+}
+
+// putHeader encodes a message set header in the given format into buf, which
+// must have a length of at least maxMsgSetHeaderLen, and returns its length.
+func putHeader(buf []byte, format HeaderFormat, offset, timestamp int64, leaderEpoch uint64, size int32) int {
+	if format != HeaderFormatVarint {
+		encoding.PutUint64(buf[offsetPos:], uint64(offset))
+		encoding.PutUint64(buf[timestampPos:], uint64(timestamp))
+		encoding.PutUint64(buf[leaderEpochPos:], leaderEpoch)
+		encoding.PutUint32(buf[sizePos:], uint32(size))
+		return msgSetHeaderLen
+	}
+	buf[0] = byte(HeaderFormatVarint)
+	n := 2
+	n += binary.PutUvarint(buf[n:], uint64(offset))
+	n += binary.PutVarint(buf[n:], timestamp)
+	n += binary.PutUvarint(buf[n:], leaderEpoch)
+	n += binary.PutUvarint(buf[n:], uint64(size))
+	buf[1] = byte(n)
+	return n
+}
+
+// decodeVarintHeader decodes the fields of a varint message set header.
+func decodeVarintHeader(header []byte) (offset, timestamp int64, leaderEpoch uint64, size int32, err error) {
+	if len(header) < 2 || int(header[1]) < 2 || len(header) < int(header[1]) {
+		return 0, 0, 0, 0, errInvalidHeader
+	}
+	var (
+		fields [4]uint64
+		rest   = header[2:header[1]]
+	)
+	for i := range fields {
+		var n int
+		if i == 1 {
+			var v int64
+			v, n = binary.Varint(rest)
+			fields[i] = uint64(v)
+		} else {
+			fields[i], n = binary.Uvarint(rest)
+		}
+		if n <= 0 {
+			return 0, 0, 0, 0, errInvalidHeader
+		}
+		rest = rest[n:]
+	}
+	if len(rest) != 0 || fields[0] > math.MaxInt64 || fields[3] > math.MaxInt32 {
+		return 0, 0, 0, 0, errInvalidHeader
+	}
+	return int64(fields[0]), int64(fields[1]), fields[2], int32(fields[3]), nil
+}
+
+// checkHeader returns io.ErrUnexpectedEOF if b does not contain a whole
+// message set header and errInvalidHeader if the header is malformed.
+func checkHeader(b []byte) error {
+	if len(b) == 0 {
+		return io.ErrUnexpectedEOF
+	}
+	switch HeaderFormat(b[0]) {
+	case HeaderFormatFixed:
+		if len(b) < msgSetHeaderLen {
+			return io.ErrUnexpectedEOF
+		}
+		return nil
+	case HeaderFormatVarint:
+		if len(b) < 2 || len(b) < int(b[1]) {
+			return io.ErrUnexpectedEOF
+		}
+		_, _, _, _, err := decodeVarintHeader(b)
+		return err
+	default:
+		return errInvalidHeader
+	}
+}
+
+// readHeaderAt reads the message set header at the given position into buf,
+// which must have a length of at least maxMsgSetHeaderLen, and returns it.
+// io.EOF is returned if there is no data at the position, io.ErrUnexpectedEOF
+// if the header is truncated, and errInvalidHeader if it is malformed.
+func readHeaderAt(r io.ReaderAt, buf messageSet, pos int64) (messageSet, error) {
+	n, err := r.ReadAt(buf[:maxMsgSetHeaderLen], pos)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	header := buf[:n]
+	if err := checkHeader(header); err != nil {
+		return nil, err
+	}
+	return header[:header.HeaderLen()], nil
+}
+
 func newMessageSetFromProto(baseOffset, basePos int64, msgs []*Message) (
 	messageSet, []*entry, error) {
 
@@ -97,55 +234,274 @@ func newMessageSetFromProto(baseOffset, basePos int64, msgs []*Message) (
 	return buf.Bytes(), entries, nil
 }
 
+// newCompressedMessageSetFromProto creates a message set containing a single
+// batch frame whose value is the flate-compressed message set of the given
+// messages. Like Kafka's compressed wrapper messages, the frame is assigned the
+// offset of the last inner message, so there is a single entry for the frame
+// and the log end offset advances past every inner message. The frame takes
+// the timestamp and leader epoch of the last inner message. The inner message
+// set may be at most limit bytes, so that it can be decompressed.
+func newCompressedMessageSetFromProto(baseOffset, basePos, limit int64, msgs []*Message) (
+	messageSet, []*entry, error) {
+
+	inner, _, err := newMessageSetFromProto(baseOffset, 0, msgs)
+	if err != nil {
+		return nil, nil, err
+	}
+	if int64(len(inner)) > limit {
+		return nil, nil, errors.Errorf("batch of %d bytes exceeds %d bytes", len(inner), limit)
+	}
+	return compressMessageSet(inner, basePos)
+}
+
+// compressMessageSet creates a message set containing a single batch frame
+// whose value is the flate-compressed inner message set. The frame takes the
+// offset, timestamp, and leader epoch of the last inner message.
+func compressMessageSet(inner messageSet, basePos int64) (messageSet, []*entry, error) {
+	buf := new(bytes.Buffer)
+	w, err := flate.NewWriter(buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := w.Write(inner); err != nil {
+		return nil, nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, nil, err
+	}
+	last := inner
+	for {
+		size := msgSetHeaderLen + int(last.Size())
+		if size >= len(last) {
+			break
+		}
+		last = last[size:]
+	}
+	frame := &Message{
+		Attributes:  AttrCompressed,
+		Value:       buf.Bytes(),
+		Timestamp:   last.Timestamp(),
+		LeaderEpoch: last.LeaderEpoch(),
+	}
+	return newMessageSetFromProto(last.Offset(), basePos, []*Message{frame})
+}
+
+// truncateCompressedMessageSet returns a message set containing a batch frame
+// with only the inner messages of the given batch frame preceding offset, or
+// nil if there are none. The frame's inner message set may be at most limit
+// bytes.
+func truncateCompressedMessageSet(frame messageSet, offset, basePos, limit int64) (messageSet, []*entry, error) {
+	inner, err := decompressMessageSet(frame.Message(), limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	n := 0
+	for rest := inner; len(rest) > 0 && rest.Offset() < offset; {
+		size := msgSetHeaderLen + int(rest.Size())
+		n += size
+		rest = rest[size:]
+	}
+	if n == 0 {
+		return nil, nil, nil
+	}
+	return compressMessageSet(inner[:n], basePos)
+}
+
+// trimCompressedMessageSet returns a message set containing a batch frame with
+// only the inner messages of the given batch frame at or following offset, or
+// nil if there are none. The frame's inner message set may be at most limit
+// bytes.
+func trimCompressedMessageSet(frame messageSet, offset, basePos, limit int64) (messageSet, []*entry, error) {
+	inner, err := decompressMessageSet(frame.Message(), limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	for len(inner) > 0 && inner.Offset() < offset {
+		inner = inner[msgSetHeaderLen+int(inner.Size()):]
+	}
+	if len(inner) == 0 {
+		return nil, nil, nil
+	}
+	return compressMessageSet(inner, basePos)
+}
+
+// decompressMessageSet returns the inner message set of the given compressed
+// batch frame. Since a small frame can decompress to an arbitrarily large
+// message set, e.g. if it is corrupt, an error is returned rather than
+// decompressing more than limit bytes.
+func decompressMessageSet(frame SerializedMessage, limit int64) (messageSet, error) {
+	r := flate.NewReader(bytes.NewReader(frame.Value()))
+	defer r.Close()
+	ms, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decompress batch")
+	}
+	if int64(len(ms)) > limit {
+		return nil, errors.Errorf("failed to decompress batch: exceeds %d bytes", limit)
+	}
+	// Validate the framing so that the inner messages can be safely sliced.
+	for rest := messageSet(ms); len(rest) > 0; {
+		if len(rest) < msgSetHeaderLen || rest.Size() < 0 ||
+			int64(len(rest)) < msgSetHeaderLen+int64(rest.Size()) {
+			return nil, errors.New("failed to decompress batch: truncated message set")
+		}
+		rest = rest[msgSetHeaderLen+rest.Size():]
+	}
+	return ms, nil
+}
+
 // readMessage reads a single message from the reader or blocks until one is
 // available. It returns the Message in addition to its offset, timestamp, and
 // leader epoch. This may return uncommitted messages if the reader was created
-// with the uncommitted flag set to true.
-func readMessage(ctx context.Context, reader contextReader, headersBuf []byte) (SerializedMessage, int64, int64, uint64, error) {
-	if _, err := reader.Read(ctx, headersBuf); err != nil {
-		return nil, 0, 0, 0, errors.Wrap(err, "failed to read message headers")
+// with the uncommitted flag set to true. If zeroCopy is true and the reader
+// supports it, the message may be a view into a memory-mapped segment rather
+// than a copy. Otherwise, the message is read into a buffer from the allocator.
+// The length of the message set header as stored in the log is also returned.
+// The message CRC is not checked, callers should use checkCRC.
+func readMessage(ctx context.Context, reader contextReader, headersBuf []byte,
+	zeroCopy bool, alloc Allocator) (SerializedMessage, int64, int64, uint64, int, error) {
+
+	offset, timestamp, leaderEpoch, size, headerLen, err := readHeader(ctx, reader, headersBuf)
+	if err != nil {
+		return nil, 0, 0, 0, 0, err
 	}
-	var (
-		offset      = int64(encoding.Uint64(headersBuf[offsetPos:]))
-		timestamp   = int64(encoding.Uint64(headersBuf[timestampPos:]))
-		leaderEpoch = encoding.Uint64(headersBuf[leaderEpochPos:])
-		size        = encoding.Uint32(headersBuf[sizePos:])
-		buf         = make([]byte, int(size))
-	)
+	if vr, ok := reader.(viewReader); ok && zeroCopy {
+		view, ok, err := vr.readView(int64(size))
+		if err != nil {
+			return nil, 0, 0, 0, 0, errors.Wrap(err, "failed to read message payload view")
+		}
+		if ok {
+			return SerializedMessage(view), offset, timestamp, leaderEpoch, headerLen, nil
+		}
+	}
+	buf := alloc.Alloc(int(size))
 	if _, err := reader.Read(ctx, buf); err != nil {
-		return nil, 0, 0, 0, errors.Wrap(err, "failed to ready message payload")
+		return nil, 0, 0, 0, 0, errors.Wrap(err, "failed to ready message payload")
 	}
-	m := SerializedMessage(buf)
-	// Check the CRC on the message.
-	crc := m.Crc()
-	if c := crc32.Checksum(m[4:], crc32cTable); crc != c {
-		// If the CRC doesn't match, data on disk is corrupted which means the
-		// server is in an unrecoverable state.
-		panic(fmt.Errorf("Read corrupted data, expected CRC: 0x%08x, got: 0x%08x", crc, c))
+	return SerializedMessage(buf), offset, timestamp, leaderEpoch, headerLen, nil
+}
+
+// readHeader reads a message set header in either HeaderFormat from the reader
+// and returns its fields and length. The header is read into headersBuf,
+// which must have a length of at least msgSetHeaderLen and is left containing
+// the header in HeaderFormatFixed, so callers relaying it, e.g. to replicate
+// the message, can rely on a single format.
+func readHeader(ctx context.Context, reader contextReader, headersBuf []byte) (
+	offset, timestamp int64, leaderEpoch uint64, size int32, n int, err error) {
+
+	// Read the version byte and, for varint headers, the header length
+	// first so that no bytes past the header are read.
+	if _, err := reader.Read(ctx, headersBuf[:2]); err != nil {
+		return 0, 0, 0, 0, 0, errors.Wrap(err, "failed to read message headers")
+	}
+	switch HeaderFormat(headersBuf[0]) {
+	case HeaderFormatFixed:
+		if _, err := reader.Read(ctx, headersBuf[2:msgSetHeaderLen]); err != nil {
+			return 0, 0, 0, 0, 0, errors.Wrap(err, "failed to read message headers")
+		}
+		size = int32(encoding.Uint32(headersBuf[sizePos:]))
+		if size < 0 {
+			return 0, 0, 0, 0, 0, errInvalidHeader
+		}
+		return int64(encoding.Uint64(headersBuf[offsetPos:])),
+			int64(encoding.Uint64(headersBuf[timestampPos:])),
+			encoding.Uint64(headersBuf[leaderEpochPos:]),
+			size, msgSetHeaderLen, nil
+	case HeaderFormatVarint:
+		n = int(headersBuf[1])
+		if n < 2 {
+			return 0, 0, 0, 0, 0, errInvalidHeader
+		}
+		header := headersBuf
+		if n > len(headersBuf) {
+			header = make([]byte, n)
+			copy(header, headersBuf[:2])
+		}
+		if _, err := reader.Read(ctx, header[2:n]); err != nil {
+			return 0, 0, 0, 0, 0, errors.Wrap(err, "failed to read message headers")
+		}
+		offset, timestamp, leaderEpoch, size, err = decodeVarintHeader(header[:n])
+		if err != nil {
+			return 0, 0, 0, 0, 0, err
+		}
+		putHeader(headersBuf, HeaderFormatFixed, offset, timestamp, leaderEpoch, size)
+		return offset, timestamp, leaderEpoch, size, n, nil
+	default:
+		return 0, 0, 0, 0, 0, errInvalidHeader
+	}
+}
+
+// WriteMessage writes the message to the writer in the message set format
+// read by readMessage, i.e. a 28-byte header containing the given offset and
+// timestamp, the message's leader epoch, and the size of the encoded message,
+// followed by the encoded message.
+func WriteMessage(w io.Writer, msg *Message, offset, timestamp int64) error {
+	data, err := encode(msg)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode message")
+	}
+	header := make([]byte, msgSetHeaderLen)
+	encoding.PutUint64(header[offsetPos:], uint64(offset))
+	encoding.PutUint64(header[timestampPos:], uint64(timestamp))
+	encoding.PutUint64(header[leaderEpochPos:], msg.LeaderEpoch)
+	encoding.PutUint32(header[sizePos:], uint32(len(data)))
+	if _, err := w.Write(header); err != nil {
+		return errors.Wrap(err, "failed to write message header")
+	}
+	if _, err := w.Write(data); err != nil {
+		return errors.Wrap(err, "failed to write message")
+	}
+	return nil
+}
+
+// HeaderLen returns the length of the message set's header.
+func (ms messageSet) HeaderLen() int {
+	if len(ms) > 1 && HeaderFormat(ms[0]) == HeaderFormatVarint {
+		return int(ms[1])
 	}
-	return m, offset, timestamp, leaderEpoch, nil
+	return msgSetHeaderLen
 }
 
 func (ms messageSet) Offset() int64 {
+	if HeaderFormat(ms[0]) == HeaderFormatVarint {
+		offset, _, _, _, _ := decodeVarintHeader(ms)
+		return offset
+	}
 	return int64(encoding.Uint64(ms[offsetPos : offsetPos+8]))
 }
 
 func (ms messageSet) Timestamp() int64 {
+	if HeaderFormat(ms[0]) == HeaderFormatVarint {
+		_, timestamp, _, _, _ := decodeVarintHeader(ms)
+		return timestamp
+	}
 	return int64(encoding.Uint64(ms[timestampPos : timestampPos+8]))
 }
 
 func (ms messageSet) LeaderEpoch() uint64 {
+	if HeaderFormat(ms[0]) == HeaderFormatVarint {
+		_, _, leaderEpoch, _, _ := decodeVarintHeader(ms)
+		return leaderEpoch
+	}
 	return encoding.Uint64(ms[leaderEpochPos : leaderEpochPos+8])
 }
 
 func (ms messageSet) Size() int32 {
+	if HeaderFormat(ms[0]) == HeaderFormatVarint {
+		_, _, _, size, _ := decodeVarintHeader(ms)
+		return size
+	}
 	return int32(encoding.Uint32(ms[sizePos : sizePos+4]))
 }
 
 func (ms messageSet) Message() SerializedMessage {
-	if len(ms) <= msgSetHeaderLen {
+	if len(ms) == 0 {
+		return nil
+	}
+	headerLen := ms.HeaderLen()
+	if len(ms) <= headerLen {
 		return nil
 	}
-	size := ms.Size()
-	return SerializedMessage(ms[msgSetHeaderLen : msgSetHeaderLen+size])
+	size := int(ms.Size())
+	return SerializedMessage(ms[headerLen : headerLen+size])
 }
@@ -0,0 +1,53 @@
+package commitlog
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// streamReader adapts an io.Reader to a contextReader.
+type streamReader struct {
+	r io.Reader
+}
+
+func (s *streamReader) Read(ctx context.Context, p []byte) (int, error) {
+	return io.ReadFull(s.r, p)
+}
+
+// Ensures messages written with WriteMessage match the message set format
+// and round trip through readMessage.
+func TestWriteMessageRoundTrip(t *testing.T) {
+	msgs := []*Message{
+		{Key: []byte("foo"), Value: []byte("hello"), Timestamp: 10, LeaderEpoch: 1},
+		{Value: []byte("world"), Timestamp: 20, LeaderEpoch: 2,
+			Headers: map[string][]byte{"a": []byte("b")}},
+	}
+	buf := new(bytes.Buffer)
+	for i, msg := range msgs {
+		require.NoError(t, WriteMessage(buf, msg, int64(5+i), msg.Timestamp))
+	}
+	expected, _, err := newMessageSetFromProto(5, 0, msgs)
+	require.NoError(t, err)
+	require.Equal(t, []byte(expected), buf.Bytes())
+
+	var (
+		reader  = &streamReader{buf}
+		headers = make([]byte, msgSetHeaderLen)
+	)
+	for i, expected := range msgs {
+		msg, offset, timestamp, leaderEpoch, _, err := readMessage(context.Background(), reader, headers, false, heapAllocator{})
+		require.NoError(t, err)
+		require.NoError(t, msg.checkCRC())
+		require.Equal(t, int64(5+i), offset)
+		require.Equal(t, expected.Timestamp, timestamp)
+		require.Equal(t, expected.LeaderEpoch, leaderEpoch)
+		require.Equal(t, expected.Key, msg.Key())
+		require.Equal(t, expected.Value, msg.Value())
+	}
+	_, _, _, _, _, err = readMessage(context.Background(), reader, headers, false, heapAllocator{})
+	require.Error(t, err)
+}
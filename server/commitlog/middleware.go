@@ -0,0 +1,132 @@
+package commitlog
+
+import (
+	"context"
+	"time"
+)
+
+// MessageSource is a source of messages along with their offsets and
+// timestamps. Next blocks until a message is available or the context is
+// canceled. Both SharedReader and the source returned by Reader.Source are
+// MessageSources.
+type MessageSource interface {
+	Next(ctx context.Context) (SerializedMessage, int64, int64, error)
+}
+
+// MessageSourceFunc is an adapter allowing a function to be used as a
+// MessageSource.
+type MessageSourceFunc func(ctx context.Context) (SerializedMessage, int64, int64, error)
+
+// Next calls f(ctx).
+func (f MessageSourceFunc) Next(ctx context.Context) (SerializedMessage, int64, int64, error) {
+	return f(ctx)
+}
+
+// ReaderMiddleware wraps a MessageSource to add behavior such as filtering or
+// transforming messages.
+type ReaderMiddleware func(next MessageSource) MessageSource
+
+// Source returns a MessageSource which reads messages from the Reader. Like
+// the Reader, it should not be used concurrently.
+func (r *Reader) Source() MessageSource {
+	headers := make([]byte, msgSetHeaderLen)
+	return MessageSourceFunc(func(ctx context.Context) (SerializedMessage, int64, int64, error) {
+		msg, offset, timestamp, _, err := r.ReadMessage(ctx, headers)
+		return msg, offset, timestamp, err
+	})
+}
+
+// Chain wraps the given source with the given middleware and returns the
+// resulting MessageSource. Middleware is applied in the order given, so
+// messages flow from the source through the first middleware, then the
+// second, and so on, e.g. in Chain(source, Filter(f), Transform(t)), the
+// transform only sees messages which passed the filter. Errors flow in the
+// opposite direction and are returned by each middleware unchanged unless
+// documented otherwise.
+func Chain(source MessageSource, middleware ...ReaderMiddleware) MessageSource {
+	for _, m := range middleware {
+		source = m(source)
+	}
+	return source
+}
+
+// Filter returns ReaderMiddleware which skips messages for which fn returns
+// false.
+func Filter(fn func(msg SerializedMessage, offset int64) bool) ReaderMiddleware {
+	return func(next MessageSource) MessageSource {
+		return MessageSourceFunc(func(ctx context.Context) (SerializedMessage, int64, int64, error) {
+			for {
+				msg, offset, timestamp, err := next.Next(ctx)
+				if err != nil || fn(msg, offset) {
+					return msg, offset, timestamp, err
+				}
+			}
+		})
+	}
+}
+
+// Transform returns ReaderMiddleware which replaces each message with the
+// result of fn. If fn fails, an ErrTransform is returned, and the next call
+// continues with the following message.
+func Transform(fn func(SerializedMessage) (SerializedMessage, error)) ReaderMiddleware {
+	return func(next MessageSource) MessageSource {
+		return MessageSourceFunc(func(ctx context.Context) (SerializedMessage, int64, int64, error) {
+			msg, offset, timestamp, err := next.Next(ctx)
+			if err != nil {
+				return nil, 0, 0, err
+			}
+			transformed, err := fn(msg)
+			if err != nil {
+				return nil, 0, 0, &ErrTransform{Offset: offset, Err: err}
+			}
+			return transformed, offset, timestamp, nil
+		})
+	}
+}
+
+// Dedupe returns ReaderMiddleware which skips messages whose key, as returned
+// by keyFn, was already seen within the given number of preceding messages,
+// keeping the first occurrence. Messages for which keyFn returns nil are never
+// skipped. See the DedupeKeyFn Reader option.
+func Dedupe(keyFn KeyFunc, window int64) ReaderMiddleware {
+	return func(next MessageSource) MessageSource {
+		keys := newKeyWindow(window, 0)
+		return MessageSourceFunc(func(ctx context.Context) (SerializedMessage, int64, int64, error) {
+			for {
+				msg, offset, timestamp, err := next.Next(ctx)
+				if err != nil {
+					return nil, 0, 0, err
+				}
+				if key := keyFn(msg); key == nil || !keys.seen(key, offset, timestamp) {
+					return msg, offset, timestamp, nil
+				}
+			}
+		})
+	}
+}
+
+// RateLimit returns ReaderMiddleware which spaces messages at least the given
+// interval apart, blocking before reading the next message from the source
+// until the interval has elapsed. If the context is canceled while waiting,
+// the context error is returned.
+func RateLimit(interval time.Duration) ReaderMiddleware {
+	return func(next MessageSource) MessageSource {
+		var last time.Time
+		return MessageSourceFunc(func(ctx context.Context) (SerializedMessage, int64, int64, error) {
+			if wait := interval - time.Since(last); !last.IsZero() && wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					timer.Stop()
+					return nil, 0, 0, ctx.Err()
+				}
+			}
+			msg, offset, timestamp, err := next.Next(ctx)
+			if err == nil {
+				last = time.Now()
+			}
+			return msg, offset, timestamp, err
+		})
+	}
+}
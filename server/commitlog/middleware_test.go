@@ -0,0 +1,73 @@
+package commitlog
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+var _ MessageSource = (*SharedReader)(nil)
+
+// Ensure middleware is applied in the order given to Chain.
+func TestChain(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	keys := []string{"a", "b", "a", "c", "b", "d"}
+	for i, key := range keys {
+		_, err := l.Append([]*Message{{Key: []byte(key), Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(int64(len(keys) - 1))
+
+	r, err := l.NewReader(0, false)
+	require.NoError(t, err)
+	var transformed []int64
+	source := Chain(r.Source(),
+		Dedupe(func(msg SerializedMessage) []byte { return msg.Key() }, 10),
+		Filter(func(msg SerializedMessage, offset int64) bool { return offset != 3 }),
+		Transform(func(msg SerializedMessage) (SerializedMessage, error) {
+			value, err := strconv.Atoi(string(msg.Value()))
+			if err != nil {
+				return nil, err
+			}
+			transformed = append(transformed, int64(value))
+			if value == 5 {
+				return nil, errors.New("transform failed")
+			}
+			return msg, nil
+		}),
+		RateLimit(5*time.Millisecond),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	for _, expected := range []int64{0, 1} {
+		_, offset, _, err := source.Next(ctx)
+		require.NoError(t, err)
+		require.Equal(t, expected, offset)
+	}
+	require.True(t, time.Since(start) >= 5*time.Millisecond)
+	_, _, _, err = source.Next(ctx)
+	transformErr, ok := err.(*ErrTransform)
+	require.True(t, ok)
+	require.Equal(t, int64(5), transformErr.Offset)
+
+	// Only messages passing the dedupe and the filter are transformed.
+	require.Equal(t, []int64{0, 1, 5}, transformed)
+
+	// Cancellation returns the context error.
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, _, err = source.Next(canceled)
+	require.Equal(t, context.Canceled, errors.Cause(err))
+}
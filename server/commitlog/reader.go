@@ -3,8 +3,11 @@ package commitlog
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	pkgErrors "github.com/pkg/errors"
 )
@@ -13,34 +16,630 @@ type contextReader interface {
 	Read(context.Context, []byte) (int, error)
 }
 
+// viewReader is implemented by contextReaders which can return the next n
+// bytes as a read-only view into a memory-mapped segment rather than copying
+// them. It returns false if a view is not available, in which case nothing is
+// consumed.
+type viewReader interface {
+	readView(n int64) ([]byte, bool, error)
+}
+
+// segmentReader is implemented by contextReaders to return the segment they
+// are currently reading, which is nil if it is not yet known, and the byte
+// position within that segment of the next read.
+type segmentReader interface {
+	currentSegment() *segment
+	position() (*segment, int64)
+}
+
+// TransformPolicy determines how a Reader handles messages whose transform
+// fails.
+type TransformPolicy int
+
+const (
+	// TransformAbort causes ReadMessage to return an ErrTransform when a
+	// transform fails. Subsequent reads resume after the failed message.
+	TransformAbort TransformPolicy = iota
+
+	// TransformSkip causes the Reader to skip messages whose transform fails.
+	TransformSkip
+)
+
+// ErrTransform is returned by ReadMessage when the Reader's transform fails for
+// the message at Offset.
+type ErrTransform struct {
+	Offset int64
+	Err    error
+}
+
+// Error returns a string describing the transform failure.
+func (e *ErrTransform) Error() string {
+	return fmt.Sprintf("failed to transform message at offset %d: %v", e.Offset, e.Err)
+}
+
+// Cause returns the error returned by the transform.
+func (e *ErrTransform) Cause() error {
+	return e.Err
+}
+
+// ErrEndOfStream is returned by ReadMessage once a bounded Reader, i.e. one
+// created with NewReaderUncommittedUntil, NewReaderByEpoch, or the Snapshot
+// option, reaches its bound. LastOffset is the offset the Reader has read
+// through, distinguishing the end of a bounded read from a Reader which has
+// merely caught up. Its cause is io.EOF.
+type ErrEndOfStream struct {
+	LastOffset int64
+}
+
+// Error returns a string describing where the stream ended.
+func (e *ErrEndOfStream) Error() string {
+	return fmt.Sprintf("end of stream after offset %d", e.LastOffset)
+}
+
+// Cause returns io.EOF.
+func (e *ErrEndOfStream) Cause() error {
+	return io.EOF
+}
+
+// ReaderOptions contains settings for configuring a Reader.
+type ReaderOptions struct {
+	// Uncommitted, if true, causes the Reader to read uncommitted messages
+	// from the log. Otherwise, it will only return committed messages.
+	Uncommitted bool
+
+	// LagWarningThreshold is the number of messages the Reader can fall
+	// behind the HW before OnLagWarning is invoked. A value of zero or less
+	// disables lag warnings.
+	LagWarningThreshold int64
+
+	// OnLagWarning is invoked with the Reader's lag, i.e. the number of
+	// messages between the message just read and the HW, each time a read
+	// finds the lag exceeds LagWarningThreshold. This can be used to detect
+	// slow consumers at risk of losing data to retention. It is called
+	// synchronously from ReadMessage, so it should not block.
+	OnLagWarning func(lag int64)
+
+	// Transform, if set, is applied to each message read, e.g. to decrypt or
+	// redact it, and the transformed message is returned in its place. The
+	// stored message is not modified.
+	Transform func(SerializedMessage) (SerializedMessage, error)
+
+	// TransformErrorPolicy determines how messages whose transform fails are
+	// handled. Defaults to TransformAbort.
+	TransformErrorPolicy TransformPolicy
+
+	// OnLockHold, if set, is invoked with the time the Reader's internal
+	// mutex was held for each read from the log. Long hold times indicate
+	// slow reads performed while holding the lock. It is called while the
+	// lock is held, so it should not block.
+	OnLockHold func(time.Duration)
+
+	// DeadLetter, if set, is invoked with the raw bytes, offset, and error of
+	// messages which fail their CRC check or whose transform fails, and the
+	// Reader continues with the next message. This keeps a single poison
+	// message from halting a consumer. If not set, a CRC mismatch panics and
+	// transform failures are handled by TransformErrorPolicy.
+	DeadLetter func(raw []byte, offset int64, err error)
+
+	// ZeroCopy, if true, causes messages read from sealed segments to be
+	// returned as read-only views into the memory-mapped segment file rather
+	// than copies. Messages from the active segment are still copied. A view
+	// is only valid until its segment is closed, which happens when the log
+	// is closed or the segment is deleted by retention, replaced by
+	// compaction, or truncated, so messages must be copied if they are
+	// retained beyond that. Writing to a view will fault.
+	ZeroCopy bool
+
+	// Cursor, if set, is used to durably track the Reader's position. If a
+	// cursor has been committed, the Reader resumes from it rather than the
+	// offset it was created with. Each time ReadMessage has fully read a
+	// message and is about to return it, the offset of the next message is
+	// committed, so a Reader resumed after a crash never starts in the middle
+	// of a message and never skips or redelivers a returned message. If a
+	// commit fails, ReadMessage returns the error in place of the message,
+	// which is redelivered once a Reader is resumed from the cursor. To
+	// reduce the number of commits, see CommitInterval and CommitEvery.
+	Cursor CursorStore
+
+	// CommitInterval, if positive, enables auto-commit of the Cursor. Rather
+	// than committing on every read, the Reader commits once this interval
+	// has elapsed since the last commit or once CommitEvery messages have
+	// been read since then, whichever comes first. Messages read since the
+	// last commit are redelivered if a Reader is resumed from the cursor
+	// after a crash. Close commits any remaining position.
+	CommitInterval time.Duration
+
+	// CommitEvery, if positive, enables auto-commit of the Cursor after
+	// this many messages have been read since the last commit. See
+	// CommitInterval.
+	CommitEvery int64
+
+	// TrackAcks, if true, causes the Reader to track which delivered messages
+	// have been acknowledged with Ack, providing at-least-once delivery. When
+	// combined with Cursor, the lowest unacked offset is committed rather
+	// than the offset following the last message read, so a resumed Reader
+	// redelivers every message which was not acked. The cursor is committed
+	// as messages are read, not as they are acked.
+	TrackAcks bool
+
+	// TotalDeadline, if set, caps the total time the Reader can be used. Once
+	// the deadline passes, ReadMessage stops and returns ErrDeadlineExceeded,
+	// including when it is waiting for data, regardless of how much of the
+	// log is left to read. This is useful for time-boxed batch jobs.
+	TotalDeadline time.Time
+
+	// EOFGrace, if positive, bounds how long ReadMessage waits for data once
+	// the Reader has caught up. If nothing arrives within the grace period,
+	// ReadMessage returns io.EOF rather than blocking, giving "read until
+	// quiet" semantics, e.g. for batch windows. Unlike an end-of-stream
+	// marker, this does not end the stream, so reading can be retried.
+	EOFGrace time.Duration
+
+	// ResetOnLoss, if true, causes the Reader to seek to the oldest offset in
+	// the log and continue reading when the messages it was reading are
+	// removed by retention, rather than returning ErrOffsetRetained. This
+	// suits best-effort consumers which prefer to keep running over failing.
+	// Messages between the Reader's position and the oldest offset are
+	// skipped.
+	ResetOnLoss bool
+
+	// OnReset, if set, is invoked with the offset the Reader was going to
+	// read and the offset it was reset to each time ResetOnLoss resets the
+	// Reader.
+	OnReset func(from, to int64)
+
+	// Snapshot, if true, pins the HW of a committed Reader at the time it is
+	// created. The Reader only returns messages committed as of then and
+	// returns an ErrEndOfStream once it reaches the snapshot HW rather than
+	// waiting for the log to advance, giving a repeatable, consistent view of
+	// the log for analytical queries. This has no effect on uncommitted
+	// Readers.
+	Snapshot bool
+
+	// Priority orders when a committed Reader is woken relative to other
+	// Readers waiting for the same HW update, with higher values woken
+	// first, e.g. so that replication followers get data before analytics
+	// consumers under load. Priority is best-effort: it only affects the
+	// order in which waiting Readers are signaled, not which messages they
+	// read, and the Go scheduler may still run them in any order.
+	Priority int
+
+	// SkipAttributes is a bitmask of message attribute flags. Messages with
+	// any of the flags set are skipped, e.g. AttrControl lets normal
+	// consumers skip control records. End-of-stream markers still end the
+	// stream for committed Readers. Batch frames are always expanded, so
+	// AttrCompressed has no effect.
+	SkipAttributes int8
+
+	// BatchBytes is the total size of the messages in a batch delivered by
+	// BatchChannel at which the batch is delivered. A value of zero or less
+	// disables delivering batches by size.
+	BatchBytes int
+
+	// BatchLinger is the maximum time BatchChannel waits after reading the
+	// first message of a batch before delivering it. A value of zero or less
+	// disables delivering batches by time, in which case batches are only
+	// delivered once they reach BatchBytes.
+	BatchLinger time.Duration
+
+	// FillGaps, if true, causes the Reader to return a placeholder message
+	// for each offset missing from the log, e.g. due to compaction, before
+	// returning the message following the gap, so consumers which treat
+	// offsets as indexes see a dense offset space. Placeholders have the
+	// AttrPlaceholder attribute set. Because compaction can remove most of a
+	// log, gaps can be arbitrarily large, so gaps larger than MaxGapFill are
+	// not filled and ReadMessage instead returns ErrGapTooLarge once, after
+	// which the message following the gap is returned.
+	FillGaps bool
+
+	// PlaceholderFn, if set, returns the placeholder message for the given
+	// missing offset when FillGaps is enabled. The message timestamp and
+	// leader epoch are returned as those of the placeholder. Defaults to an
+	// empty message.
+	PlaceholderFn func(offset int64) *Message
+
+	// MaxGapFill is the largest gap, in offsets, filled with placeholders
+	// when FillGaps is enabled. Defaults to 10000.
+	MaxGapFill int64
+
+	// DedupeKeyFn, if set, causes the Reader to skip messages whose key, as
+	// returned by DedupeKeyFn, was already seen within the window preceding
+	// them, e.g. duplicates written by producer retries. The first
+	// occurrence of a key is returned and later ones within the window are
+	// skipped. Messages for which DedupeKeyFn returns nil are never skipped.
+	DedupeKeyFn KeyFunc
+
+	// DedupeWindow is the number of messages preceding a message within
+	// which a key is considered a duplicate when DedupeKeyFn is set. It
+	// bounds the number of keys remembered. Defaults to 1000.
+	DedupeWindow int64
+
+	// DedupeMaxAge, if positive, further limits deduplication to keys first
+	// seen on messages whose timestamps are within this duration of the
+	// message.
+	DedupeMaxAge time.Duration
+
+	// VerifyProducerSequence, if true, causes the Reader to track the
+	// sequence numbers of messages with producer sequence headers, as set by
+	// Message.SetProducerSequence, and report duplicate and missing messages.
+	// A duplicate is skipped and ErrSequenceDuplicate is returned in its
+	// place. If sequence numbers were skipped, ErrSequenceGap is returned and
+	// the message following the gap is returned by the next read.
+	VerifyProducerSequence bool
+
+	// MaxProducers is the number of recently seen producers whose sequence
+	// numbers are tracked when VerifyProducerSequence is set. Defaults to
+	// 1000.
+	MaxProducers int
+
+	// TimestampSource determines whether the returned timestamps are those
+	// stored in the log or those set by producers. Defaults to
+	// TimestampLogAppend.
+	TimestampSource TimestampSource
+
+	// TimestampUnit, if positive, is the unit to normalize returned Unix
+	// timestamps to, e.g. time.Millisecond. The unit of each timestamp is
+	// inferred from its magnitude, so producers may use seconds,
+	// milliseconds, microseconds, or nanoseconds. Otherwise, timestamps are
+	// returned in the unit they were stored in.
+	TimestampUnit time.Duration
+
+	// TimeShift, if set, rewrites returned timestamps to replay the log as if
+	// it were happening now. It is applied after TimestampSource and
+	// TimestampUnit.
+	TimeShift *TimeShift
+
+	// RetryPolicy determines how transient errors reading segments are
+	// retried before being returned. By default, they are not retried.
+	RetryPolicy RetryPolicy
+
+	// MaxOpenSegments, if positive, causes the Reader to read inactive
+	// segments through file handles of its own, keeping at most this many
+	// open. Once the limit is reached, the least recently read file is closed
+	// and is transparently reopened if the Reader returns to its segment.
+	// This bounds the file descriptors held on behalf of a Reader which has
+	// read across many segments, and reads don't load segments which the
+	// log has unloaded under MaxLoadedSegments. The active segment is always
+	// read through the log. Close closes the Reader's files.
+	MaxOpenSegments int
+
+	// OnClose, if set, is called with the offset of the last message
+	// returned by the Reader, or the offset preceding its starting offset if
+	// none was, when the Reader is closed or a read returns because its
+	// context was canceled, so consumers which persist their own position
+	// can record where they stopped. It is called at most once.
+	OnClose func(lastOffset int64)
+
+	// Allocator, if set, allocates the buffers message payloads are read
+	// into. Payloads of messages inside compressed batches and zero-copy
+	// views are not allocated from it. Defaults to allocating on the heap.
+	Allocator Allocator
+
+	// CommitFloor, if set, returns the highest offset the Reader may return,
+	// e.g. as decided by an external component gating consumption below the
+	// HW. Reads block once they reach the floor until it advances. Since
+	// the floor cannot signal when it advances, it is polled every
+	// CommitFloorInterval while blocked.
+	CommitFloor func() int64
+
+	// CommitFloorInterval is how often CommitFloor is polled while a read is
+	// blocked on it. Defaults to 10ms.
+	CommitFloorInterval time.Duration
+}
+
 // Reader reads messages atomically from a CommitLog. Readers should not be
 // used concurrently.
 type Reader struct {
-	ctxReader   contextReader
-	offset      int64
-	log         *commitLog
-	uncommitted bool
+	ctxReader  contextReader
+	offset     int64 // accessed atomically
+	log        *commitLog
+	opts       ReaderOptions
+	filter     func(msg SerializedMessage, offset int64) bool
+	epoch      *uint64
+	eos        bool
+	eosErr     error
+	batch      messageSet
+	progressMu sync.Mutex
+	progress   chan struct{}
+	acksMu     sync.Mutex
+	acks       ackSet
+	snapshotHW *int64
+	until      *int64
+	start      int64
+	startHW    int64
+	gap        *gapFill
+	dedupe     *keyWindow
+	sequences  *producerSequences
+	held       *record
+	read       readFunc
+	control    chan ControlCmd
+	paused     bool
+	rateLimit  time.Duration
+	lastRead   time.Time
+	toCommit   int64
+	lastCommit time.Time
+	lastBase   int64
+	lastPos    int64
+	txn        *Txn
+	gen        uint64
+	checksum   uint64
+	emitted    int64 // accessed atomically
+	closeOnce  sync.Once
+	shift      *shiftState
+	statsMu    sync.Mutex
+	stats      ReaderStats
+	attempt    int
+	files      *segmentFiles
 }
 
 // NewReader creates a new Reader starting at the given offset. If uncommitted
 // is true, the Reader will read uncommitted messages from the log. Otherwise,
 // it will only return committed messages.
 func (l *commitLog) NewReader(offset int64, uncommitted bool) (*Reader, error) {
+	return l.NewReaderWithOptions(offset, ReaderOptions{Uncommitted: uncommitted})
+}
+
+// NewReaderWithOptions creates a new Reader starting at the given offset
+// using the provided ReaderOptions.
+func (l *commitLog) NewReaderWithOptions(offset int64, opts ReaderOptions) (*Reader, error) {
+	if opts.Cursor != nil {
+		cursor, err := opts.Cursor.Load()
+		if err != nil {
+			return nil, pkgErrors.Wrap(err, "failed to load cursor")
+		}
+		if cursor != -1 {
+			offset = cursor
+		}
+	}
+	return l.newReader(offset, opts, nil)
+}
+
+// newReader creates a new Reader starting at the given offset using the
+// provided ReaderOptions. If snapshotHW is set, a snapshot Reader is bounded
+// by it rather than the current HW.
+func (l *commitLog) newReader(offset int64, opts ReaderOptions, snapshotHW *int64) (*Reader, error) {
+	if offset < 0 {
+		return nil, pkgErrors.Wrapf(ErrInvalidOffset, "offset %d is negative", offset)
+	}
+	// Offsets removed from the first segment by compaction are in range,
+	// and so is the log end offset so that readers can wait for the next
+	// message.
 	var (
-		ctxReader contextReader
-		err       error
+		first = l.Segments()[0].BaseOffset
+		next  = l.NewestOffset() + 1
 	)
-	if uncommitted {
-		ctxReader, err = l.newReaderUncommitted(offset)
-	} else {
-		ctxReader, err = l.newReaderCommitted(offset)
+	if offset < first || offset > next {
+		return nil, pkgErrors.Wrapf(ErrOffsetOutOfRange,
+			"offset %d is outside of the log's offsets [%d, %d]", offset, first, next)
+	}
+	r := &Reader{
+		offset:     offset,
+		log:        l,
+		opts:       opts,
+		snapshotHW: snapshotHW,
+		start:      offset,
+		startHW:    l.HighWatermark(),
+		control:    make(chan ControlCmd, controlBufferSize),
+		lastCommit: time.Now(),
+		lastBase:   -1,
+		lastPos:    -1,
+		checksum:   StreamChecksumInit,
+		emitted:    offset - 1,
+	}
+	if opts.Allocator == nil {
+		r.opts.Allocator = heapAllocator{}
+	}
+	if opts.DedupeKeyFn != nil {
+		r.dedupe = newKeyWindow(opts.DedupeWindow, int64(opts.DedupeMaxAge))
 	}
-	return &Reader{
-		ctxReader:   ctxReader,
-		offset:      offset,
-		log:         l,
-		uncommitted: uncommitted,
-	}, err
+	if opts.VerifyProducerSequence {
+		r.sequences = newProducerSequences(opts.MaxProducers)
+	}
+	if opts.MaxOpenSegments > 0 {
+		r.files = newSegmentFiles(l, opts.MaxOpenSegments)
+	}
+	if l.DebugReaderLeaks {
+		l.trackLeak(r)
+	}
+	err := r.initContextReader(offset)
+	return r, err
+}
+
+// reposition moves the Reader to the given offset, discarding all state tied
+// to the messages read before it: buffered batch and gap messages, a held
+// message, the end of the stream, the dedupe window, producer sequences, and
+// the stream checksum. Reading then resumes as if the Reader was created at
+// the offset.
+func (r *Reader) reposition(offset int64) error {
+	if err := r.initContextReader(offset); err != nil {
+		return err
+	}
+	r.batch = nil
+	r.gap = nil
+	r.held = nil
+	r.eos = false
+	r.eosErr = nil
+	if r.dedupe != nil {
+		r.dedupe = newKeyWindow(r.opts.DedupeWindow, int64(r.opts.DedupeMaxAge))
+	}
+	if r.sequences != nil {
+		r.sequences = newProducerSequences(r.opts.MaxProducers)
+	}
+	r.checksum = StreamChecksumInit
+	r.setOffset(offset)
+	return nil
+}
+
+// initContextReader initializes the underlying contextReader of the Reader to
+// start at the given offset.
+func (r *Reader) initContextReader(offset int64) (err error) {
+	// Capture the generation before resolving the segments so that a
+	// concurrent swap causes the position to be re-resolved.
+	r.gen = r.log.Generation()
+	switch {
+	case r.opts.Uncommitted:
+		r.ctxReader, err = r.log.newReaderUncommitted(offset)
+	case r.opts.Snapshot:
+		// Reinitializing the reader, e.g. after compaction, must not move
+		// the snapshot, so the HW is only captured once.
+		if r.snapshotHW == nil {
+			hw := r.log.HighWatermark()
+			r.snapshotHW = &hw
+		}
+		var ctxReader *committedReader
+		ctxReader, err = r.log.newReaderCommittedAt(offset, *r.snapshotHW)
+		if err == nil {
+			ctxReader.snapshot = true
+			r.ctxReader = ctxReader
+		}
+	default:
+		r.ctxReader, err = r.log.newReaderCommitted(offset)
+	}
+	switch ctxReader := r.ctxReader.(type) {
+	case *uncommittedReader:
+		ctxReader.mu.onHold = r.opts.OnLockHold
+		ctxReader.retry = r.opts.RetryPolicy
+		ctxReader.files = r.files
+	case *committedReader:
+		ctxReader.mu.onHold = r.opts.OnLockHold
+		ctxReader.priority = r.opts.Priority
+		ctxReader.retry = r.opts.RetryPolicy
+		ctxReader.files = r.files
+	}
+	return err
+}
+
+// NewReaderCompactedSnapshot creates a new committed Reader which first
+// returns a compacted snapshot of the log, i.e. the latest message for every
+// key as determined by keyFn, followed by all messages committed after the
+// snapshot was taken. The snapshot covers the log up to the HW at the time of
+// creation. Messages within the snapshot which have been superseded, have no
+// key, or are tombstones are skipped. Because the snapshot and tail are read
+// from the same position in the log, no message is missed or duplicated in the
+// transition between them. If keyFn is nil, the message key is used.
+func (l *commitLog) NewReaderCompactedSnapshot(ctx context.Context, keyFn KeyFunc) (*Reader, error) {
+	oldest := l.OldestOffset()
+	if oldest == -1 {
+		oldest = l.HighWatermark() + 1
+	}
+	reader, err := l.newReaderLatestByKey(ctx, oldest, keyFn, false)
+	return reader, pkgErrors.Wrap(err, "failed to scan log for snapshot")
+}
+
+// NewReaderEffective creates a new committed Reader starting at the given
+// offset which reads the log as if it were fully compacted. Up to the HW at
+// the time of creation, only the latest message for each key, as determined by
+// keyFn, is returned, and keys whose latest message is a tombstone are skipped
+// entirely. Messages without a key are always returned since compaction never
+// removes them. Messages committed after creation are all returned. If keyFn
+// is nil, the message key is used.
+//
+// The latest offset for every key between the given offset and the HW is held
+// in memory for the lifetime of the Reader, so memory use grows with the
+// number of distinct keys in that range.
+func (l *commitLog) NewReaderEffective(ctx context.Context, offset int64, keyFn KeyFunc) (*Reader, error) {
+	reader, err := l.newReaderLatestByKey(ctx, offset, keyFn, true)
+	return reader, pkgErrors.Wrap(err, "failed to scan log for effective reader")
+}
+
+// NewReaderByAttribute creates a new committed Reader starting at the given
+// offset which only returns messages whose attributes, masked with mask,
+// equal value, e.g. a mask of AttrControl and a value of 0 returns only
+// non-control records. Only the message's attributes byte is inspected, so
+// non-matching messages are skipped without decoding them. End-of-stream
+// markers still end the stream.
+func (l *commitLog) NewReaderByAttribute(offset int64, mask, value int8) (*Reader, error) {
+	reader, err := l.NewReader(offset, false)
+	if err != nil {
+		return nil, err
+	}
+	reader.filter = func(msg SerializedMessage, offset int64) bool {
+		return msg.Attributes()&mask == value
+	}
+	return reader, nil
+}
+
+// NewReaderByEpoch creates a new committed Reader starting at the given offset
+// which only returns messages written in the given leader epoch, e.g. to
+// isolate the data written by a particular leader when debugging divergence.
+// Messages from earlier epochs are skipped, and an ErrEndOfStream is returned
+// once a message from a later epoch is read.
+func (l *commitLog) NewReaderByEpoch(offset int64, epoch uint64) (*Reader, error) {
+	reader, err := l.NewReader(offset, false)
+	if err != nil {
+		return nil, err
+	}
+	reader.epoch = &epoch
+	return reader, nil
+}
+
+// newReaderLatestByKey creates a new committed Reader starting at the given
+// offset which, up to the current HW, only returns the latest message for
+// each key and skips keys whose latest message is a tombstone. If
+// keepKeyless is true, messages without a key are returned, otherwise they
+// are skipped. Messages after the HW are all returned.
+func (l *commitLog) newReaderLatestByKey(ctx context.Context, offset int64,
+	keyFn KeyFunc, keepKeyless bool) (*Reader, error) {
+
+	if keyFn == nil {
+		keyFn = messageKey
+	}
+	var (
+		hw     = l.HighWatermark()
+		latest = map[string]int64{}
+		err    error
+	)
+	if offset <= hw {
+		latest, err = l.scanLatestOffsets(ctx, offset, hw, keyFn)
+		if err != nil {
+			return nil, err
+		}
+	}
+	reader, err := l.NewReader(offset, false)
+	if err != nil {
+		return nil, err
+	}
+	reader.filter = func(msg SerializedMessage, offset int64) bool {
+		if offset > hw {
+			return true
+		}
+		key := keyFn(msg)
+		if key == nil {
+			return keepKeyless
+		}
+		if msg.Value() == nil {
+			return false
+		}
+		return latest[string(key)] == offset
+	}
+	return reader, nil
+}
+
+// scanLatestOffsets returns the latest offset for each key in the log between
+// the given offsets, inclusive.
+func (l *commitLog) scanLatestOffsets(ctx context.Context, from, to int64,
+	keyFn KeyFunc) (map[string]int64, error) {
+
+	reader, err := l.NewReader(from, true)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		latest  = map[string]int64{}
+		headers = make([]byte, msgSetHeaderLen)
+		offset  = from - 1
+		msg     SerializedMessage
+	)
+	for offset < to {
+		msg, offset, _, _, err = reader.ReadMessage(ctx, headers)
+		if err != nil {
+			return nil, err
+		}
+		if key := keyFn(msg); key != nil {
+			latest[string(key)] = offset
+		}
+	}
+	return latest, nil
 }
 
 // ReadMessage reads a single message from the underlying CommitLog or blocks
@@ -49,40 +648,696 @@ func (l *commitLog) NewReader(offset int64, uncommitted bool) (*Reader, error) {
 // the reader was created with the uncommitted flag set to true.
 //
 // ReadMessage should not be called concurrently, and the headersBuf slice
-// should have a capacity of at least 28.
+// should have a capacity of at least 28. It receives the message set header of
+// the message in HeaderFormatFixed regardless of the log's HeaderFormat.
+//
+// Committed readers return io.EOF once they have read an end-of-stream marker
+// appended with CloseStream, and bounded readers return an ErrEndOfStream once
+// they reach their bound. If the context is canceled while waiting for data,
+// the cause of the returned error is the context error, while io.EOF is
+// reserved for the end of data, i.e. an end-of-stream marker or the cause of
+// an ErrEndOfStream. If the log is closed while waiting for data, the cause is
+// ErrLogClosed.
 //
 // TODO: Should this just return a MessageSet directly instead of a Message and
 // the MessageSet header values?
 func (r *Reader) ReadMessage(ctx context.Context, headersBuf []byte) (SerializedMessage, int64, int64, uint64, error) {
-RETRY:
-	msg, offset, timestamp, leaderEpoch, err := readMessage(ctx, r.ctxReader, headersBuf)
+	msg, offset, timestamp, leaderEpoch, err := r.readMessage(ctx, headersBuf, r.opts.ZeroCopy)
+	r.recordEmitted(ctx, msg, offset, err)
+	return msg, offset, timestamp, leaderEpoch, err
+}
+
+// ReadMessageZeroCopy behaves like ReadMessage except, regardless of the
+// ZeroCopy option, messages from sealed segments are returned as read-only
+// views into the memory-mapped segment file. See ReaderOptions.ZeroCopy for
+// the lifetime of the returned message.
+func (r *Reader) ReadMessageZeroCopy(ctx context.Context, headersBuf []byte) (SerializedMessage, int64, int64, uint64, error) {
+	msg, offset, timestamp, leaderEpoch, err := r.readMessage(ctx, headersBuf, true)
+	r.recordEmitted(ctx, msg, offset, err)
+	return msg, offset, timestamp, leaderEpoch, err
+}
+
+// recordEmitted records the offset and size of a message returned by a read
+// or, if the read failed because the context was canceled, invokes the
+// OnClose hook.
+func (r *Reader) recordEmitted(ctx context.Context, msg SerializedMessage, offset int64, err error) {
+	if err == nil {
+		atomic.StoreInt64(&r.emitted, offset)
+		r.recordStats(len(msg))
+	} else if ctx.Err() != nil {
+		r.onClose()
+	}
+}
+
+// onClose invokes the OnClose hook, if set, the first time it is called.
+func (r *Reader) onClose() {
+	if r.opts.OnClose == nil {
+		return
+	}
+	r.closeOnce.Do(func() {
+		r.opts.OnClose(atomic.LoadInt64(&r.emitted))
+	})
+}
+
+// endStream ends the stream, causing subsequent reads to return err until the
+// Reader is repositioned.
+func (r *Reader) endStream(err error) error {
+	r.eos = true
+	r.eosErr = err
+	return err
+}
+
+// readMessage reads the next message through the Reader's pipeline, which
+// applies its options to the messages decoded from the log.
+func (r *Reader) readMessage(ctx context.Context, headersBuf []byte, zeroCopy bool) (
+	SerializedMessage, int64, int64, uint64, error) {
+
+	if err := r.applyControl(ctx); err != nil {
+		return nil, 0, 0, 0, err
+	}
+	if r.eos {
+		return nil, 0, 0, 0, r.eosErr
+	}
+	if deadline := r.opts.TotalDeadline; !deadline.IsZero() {
+		// Bound any wait for data by the deadline.
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	if r.read == nil {
+		// The pipeline is built on first read since some constructors set
+		// the filter or epoch after creating the Reader.
+		r.read = r.pipeline()
+	}
+	rec, err := r.read(ctx, headersBuf, zeroCopy)
+	if err == errFillGap {
+		return r.nextPlaceholder()
+	}
 	if err != nil {
-		if pkgErrors.Cause(err) == ErrSegmentReplaced {
-			// ErrSegmentReplaced indicates we attempted to read from a log
-			// segment that was replaced due to compaction, so reinitialize the
-			// contextReader and try again to read from the new segment.
-			if r.uncommitted {
-				r.ctxReader, err = r.log.newReaderUncommitted(r.offset)
+		return nil, 0, 0, 0, err
+	}
+	r.checksum = UpdateStreamChecksum(r.checksum, rec.stored.Value())
+	r.trackDelivery(rec.offset)
+	if err := r.maybeCommitCursor(); err != nil {
+		return nil, 0, 0, 0, err
+	}
+	return rec.msg, rec.offset, r.timestamp(rec.stored, rec.timestamp), rec.leaderEpoch, nil
+}
+
+// decode reads the next message from the log, expanding compressed batches
+// and advancing the Reader's offset past it. Corrupt messages are returned as
+// dead letters, and errFillGap is returned while the Reader is filling a gap
+// in offsets with placeholders.
+func (r *Reader) decode(ctx context.Context, headersBuf []byte, zeroCopy bool) (*record, error) {
+	for {
+		if r.deadlineExceeded() {
+			return nil, ErrDeadlineExceeded
+		}
+		if r.until != nil && atomic.LoadInt64(&r.offset) >= *r.until {
+			return nil, r.endStream(&ErrEndOfStream{LastOffset: *r.until - 1})
+		}
+		var (
+			msg         SerializedMessage
+			offset      int64
+			timestamp   int64
+			leaderEpoch uint64
+			err         error
+			batched     = len(r.batch) > 0
+		)
+		if r.gap != nil {
+			if r.gap.next < r.gap.rec.offset {
+				return nil, errFillGap
+			}
+			// The gap is filled, so resume with the message following it.
+			msg, offset, timestamp, leaderEpoch = r.gap.rec.msg, r.gap.rec.offset, r.gap.rec.timestamp, r.gap.rec.leaderEpoch
+			r.gap = nil
+			batched = true
+		} else if batched {
+			msg, offset, timestamp, leaderEpoch = r.nextBatched(headersBuf)
+		} else {
+			if gen := r.log.Generation(); gen != r.gen {
+				// The log's segments were swapped, so re-resolve the position
+				// against the new set before reading.
+				if err := r.initContextReader(r.offset); err != nil {
+					return nil, pkgErrors.Wrap(err, "failed to reinitialize reader")
+				}
+			}
+			var (
+				readCtx   = ctx
+				headerLen int
+			)
+			if r.opts.EOFGrace > 0 {
+				var cancel context.CancelFunc
+				readCtx, cancel = context.WithTimeout(ctx, r.opts.EOFGrace)
+				msg, offset, timestamp, leaderEpoch, headerLen, err = readMessage(readCtx, r.ctxReader, headersBuf, zeroCopy, r.opts.Allocator)
+				cancel()
 			} else {
-				r.ctxReader, err = r.log.newReaderCommitted(r.offset)
+				msg, offset, timestamp, leaderEpoch, headerLen, err = readMessage(ctx, r.ctxReader, headersBuf, zeroCopy, r.opts.Allocator)
 			}
 			if err != nil {
-				return nil, 0, 0, 0, pkgErrors.Wrap(err, "failed to reinitialize reader")
+				if readCtx != ctx && readCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+					// Nothing arrived within the grace period.
+					return nil, io.EOF
+				}
+				if pkgErrors.Cause(err) == ErrSegmentReplaced {
+					// ErrSegmentReplaced indicates we attempted to read from a log
+					// segment that was replaced due to compaction, so reinitialize the
+					// contextReader and try again to read from the new segment.
+					if err := r.initContextReader(r.offset); err != nil {
+						return nil, pkgErrors.Wrap(err, "failed to reinitialize reader")
+					}
+					continue
+				} else if pkgErrors.Cause(err) == ErrOffsetRetained && r.opts.ResetOnLoss {
+					if err := r.resetToOldest(); err != nil {
+						return nil, pkgErrors.Wrap(err, "failed to reset reader")
+					}
+					continue
+				} else if r.deadlineExceeded() {
+					return nil, ErrDeadlineExceeded
+				} else if r.snapshotHW != nil && pkgErrors.Cause(err) == io.EOF {
+					// The Reader reached the snapshot HW.
+					return nil, r.endStream(&ErrEndOfStream{LastOffset: *r.snapshotHW})
+				} else {
+					return nil, err
+				}
 			}
-			goto RETRY
-		} else {
-			return nil, 0, 0, 0, err
+			r.recordPosition(msg, headerLen)
+		}
+		if err := msg.checkCRC(); err != nil {
+			r.setOffset(offset + 1)
+			// If the CRC doesn't match, data on disk is corrupted.
+			return nil, &deadLetter{msg: msg, offset: offset, err: err, corrupt: true}
+		}
+		if !batched && msg.IsCompressed() {
+			// Expand the batch frame and return its inner messages one by one.
+			// The frame is assigned the offset of its last inner message, so the
+			// reader offset is advanced as each inner message is returned.
+			batch, err := decompressMessageSet(msg, r.log.maxBatchBytes())
+			if err != nil {
+				r.setOffset(offset + 1)
+				// The whole frame is skipped if it is dead-lettered, since its
+				// inner messages can't be recovered.
+				return nil, &deadLetter{msg: msg, offset: offset, err: err}
+			}
+			r.batch = batch
+			if offset >= r.offset {
+				// The reader may have been positioned at a frame containing
+				// messages before the offset to start reading from, so skip
+				// them.
+				for len(r.batch) > 0 && r.batch.Offset() < r.offset {
+					r.nextBatched(headersBuf)
+				}
+			}
+			continue
+		}
+		if r.until != nil && offset >= *r.until {
+			// Messages at or past the LEO, e.g. following a compacted gap, are
+			// out of bounds.
+			return nil, r.endStream(&ErrEndOfStream{LastOffset: *r.until - 1})
+		}
+		rec := &record{
+			msg:         msg,
+			stored:      msg,
+			offset:      offset,
+			timestamp:   timestamp,
+			leaderEpoch: leaderEpoch,
+		}
+		if expected := atomic.LoadInt64(&r.offset); r.opts.FillGaps && offset > expected {
+			// Hold on to the message until the gap preceding it is filled.
+			r.gap = &gapFill{next: expected, rec: rec}
+			if max := r.maxGapFill(); offset-expected > max {
+				r.gap.next = offset
+				r.setOffset(offset)
+				return nil, pkgErrors.Wrapf(ErrGapTooLarge,
+					"gap of %d offsets before offset %d exceeds %d", offset-expected, offset, max)
+			}
+			return nil, errFillGap
+		}
+		r.setOffset(offset + 1)
+		r.checkLag(offset)
+		if !r.opts.Uncommitted && msg.IsEndOfStream() {
+			return nil, r.endStream(io.EOF)
 		}
+		return rec, nil
 	}
-	r.offset = offset + 1
-	return msg, offset, timestamp, leaderEpoch, err
+}
+
+// resetToOldest repositions the Reader at the oldest offset in the log after
+// the data it was reading was removed by retention.
+func (r *Reader) resetToOldest() error {
+	from := atomic.LoadInt64(&r.offset)
+	to := r.log.OldestOffset()
+	if to == -1 {
+		// The log is empty, so wait for the next message.
+		to = r.log.NewestOffset() + 1
+	}
+	if err := r.reposition(to); err != nil {
+		return err
+	}
+	if r.opts.OnReset != nil {
+		r.opts.OnReset(from, to)
+	}
+	return nil
+}
+
+// SkipToNextSegment advances the Reader to the start of the segment following
+// the one containing the next offset to read and returns the new offset, i.e.
+// the base offset of that segment. This allows work to be distributed at
+// segment granularity, e.g. by assigning each segment to a different worker.
+// ErrSegmentNotFound is returned if there is no next segment.
+func (r *Reader) SkipToNextSegment() (int64, error) {
+	var (
+		offset       = atomic.LoadInt64(&r.offset)
+		segments     = r.log.Segments()
+		current, idx = findSegment(segments, offset)
+	)
+	if current == nil || idx+1 >= len(segments) {
+		return 0, pkgErrors.Wrapf(ErrSegmentNotFound, "no segment after offset %d", offset)
+	}
+	next := segments[idx+1].BaseOffset
+	if err := r.reposition(next); err != nil {
+		return 0, pkgErrors.Wrap(err, "failed to reposition reader")
+	}
+	return next, nil
+}
+
+// gapFill tracks a gap in offsets being filled with placeholders along with
+// the message following the gap.
+type gapFill struct {
+	next int64
+	rec  *record
+}
+
+// maxGapFill returns the largest gap the Reader fills with placeholders.
+func (r *Reader) maxGapFill() int64 {
+	if r.opts.MaxGapFill > 0 {
+		return r.opts.MaxGapFill
+	}
+	return defaultMaxGapFill
+}
+
+// nextPlaceholder returns a placeholder for the next offset in the gap being
+// filled.
+func (r *Reader) nextPlaceholder() (SerializedMessage, int64, int64, uint64, error) {
+	offset := r.gap.next
+	placeholder := &Message{}
+	if r.opts.PlaceholderFn != nil {
+		placeholder = r.opts.PlaceholderFn(offset)
+	}
+	m := *placeholder
+	m.Attributes |= AttrPlaceholder
+	msg, err := encode(&m)
+	if err != nil {
+		return nil, 0, 0, 0, pkgErrors.Wrap(err, "failed to encode placeholder")
+	}
+	r.gap.next++
+	r.setOffset(offset + 1)
+	r.lastBase, r.lastPos = -1, -1
+	r.attempt = 1
+	if err := r.maybeCommitCursor(); err != nil {
+		return nil, 0, 0, 0, err
+	}
+	return SerializedMessage(msg), offset, m.Timestamp, m.LeaderEpoch, nil
+}
+
+// maybeCommitCursor commits the Reader's cursor after a message is read unless
+// auto-commit is enabled and it is not yet due.
+func (r *Reader) maybeCommitCursor() error {
+	if r.opts.Cursor == nil || r.txn != nil {
+		return nil
+	}
+	r.toCommit++
+	var (
+		every    = r.opts.CommitEvery
+		interval = r.opts.CommitInterval
+	)
+	if every > 0 || interval > 0 {
+		due := (every > 0 && r.toCommit >= every) ||
+			(interval > 0 && time.Since(r.lastCommit) >= interval)
+		if !due {
+			return nil
+		}
+	}
+	return r.commitCursor()
+}
+
+// commitCursor commits the Reader's position to its cursor.
+func (r *Reader) commitCursor() error {
+	if err := r.opts.Cursor.Commit(r.cursor()); err != nil {
+		return pkgErrors.Wrap(err, "failed to commit cursor")
+	}
+	r.toCommit = 0
+	r.lastCommit = time.Now()
+	return nil
+}
+
+// Close commits the Reader's position to its cursor if any messages have been
+// read since the last commit, e.g. when auto-commit is enabled, invokes the
+// OnClose hook, closes the segment files it opened under MaxOpenSegments, and
+// resets its Allocator. A transaction in progress is not committed. The Reader
+// should not be used after it is closed.
+func (r *Reader) Close() error {
+	defer r.opts.Allocator.Reset()
+	r.onClose()
+	r.files.close()
+	if r.opts.Cursor == nil || r.txn != nil || r.toCommit == 0 {
+		return nil
+	}
+	return r.commitCursor()
+}
+
+// OpenSegmentCount returns the number of segment files the Reader has open of
+// its own under MaxOpenSegments, which never exceeds the limit. Without
+// MaxOpenSegments, the Reader shares the log's files, so this is 0.
+func (r *Reader) OpenSegmentCount() int {
+	return r.files.count()
+}
+
+// deadlineExceeded indicates if the Reader's total deadline has passed.
+func (r *Reader) deadlineExceeded() bool {
+	deadline := r.opts.TotalDeadline
+	return !deadline.IsZero() && !time.Now().Before(deadline)
+}
+
+// cursor returns the offset to commit to the Reader's CursorStore.
+func (r *Reader) cursor() int64 {
+	if r.opts.TrackAcks {
+		return r.LowestUnacked()
+	}
+	return atomic.LoadInt64(&r.offset)
+}
+
+// nextBatched removes and returns the next message buffered from a
+// compressed batch frame, copying its message set header into headersBuf just
+// like messages read directly from a segment.
+func (r *Reader) nextBatched(headersBuf []byte) (SerializedMessage, int64, int64, uint64) {
+	var (
+		ms   = r.batch
+		size = msgSetHeaderLen + ms.Size()
+	)
+	copy(headersBuf, ms[:msgSetHeaderLen])
+	r.batch = ms[size:]
+	return ms.Message(), ms.Offset(), ms.Timestamp(), ms.LeaderEpoch()
+}
+
+// ReadVectored reads raw message set data from the log, i.e. message set
+// headers followed by serialized messages, directly into the given buffers,
+// filling each buffer in turn before moving on to the next. This allows
+// message sets to be handed to writev-based network stacks without an
+// intermediate copy. It blocks until every buffer is filled, the context is
+// canceled, in which case the context error is returned, or the log is
+// closed, in which case ErrLogClosed is returned, along with the number of
+// bytes read. It observes the same HW boundary and segment-roll semantics as
+// ReadMessage. Bytes are not decoded, so ReadVectored does not advance the
+// Reader's offset and should not be mixed with ReadMessage on the same Reader.
+func (r *Reader) ReadVectored(ctx context.Context, bufs [][]byte) (int, error) {
+	total := 0
+	for _, buf := range bufs {
+		n, err := r.ctxReader.Read(ctx, buf)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// WaitUntil blocks until the Reader has consumed past the given offset or the
+// context is canceled, in which case the context error is returned. Unlike
+// the Reader's other methods, WaitUntil is safe to call concurrently with
+// reads, making it useful for synchronizing with a consumer.
+func (r *Reader) WaitUntil(ctx context.Context, offset int64) error {
+	for {
+		r.progressMu.Lock()
+		if atomic.LoadInt64(&r.offset) > offset {
+			r.progressMu.Unlock()
+			return nil
+		}
+		if r.progress == nil {
+			r.progress = make(chan struct{})
+		}
+		wait := r.progress
+		r.progressMu.Unlock()
+
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Progress returns the fraction, between 0 and 1, of the messages between the
+// Reader's starting offset and the HW at the time the Reader was created which
+// have been read. This is useful for reporting progress of a bounded backfill.
+// Once the Reader has read up to this HW, e.g. a tailing Reader which has
+// caught up, this returns 1. It is safe to call concurrently with reads.
+func (r *Reader) Progress() float64 {
+	total := r.startHW + 1 - r.start
+	if total <= 0 {
+		return 1
+	}
+	progress := float64(atomic.LoadInt64(&r.offset)-r.start) / float64(total)
+	if progress < 0 {
+		return 0
+	}
+	if progress > 1 {
+		return 1
+	}
+	return progress
+}
+
+// setOffset sets the next offset to read and wakes any goroutines in
+// WaitUntil.
+func (r *Reader) setOffset(offset int64) {
+	atomic.StoreInt64(&r.offset, offset)
+	r.progressMu.Lock()
+	if r.progress != nil {
+		close(r.progress)
+		r.progress = nil
+	}
+	r.progressMu.Unlock()
+}
+
+// ReadResult is the result of a read delivered by Reader.Channel.
+type ReadResult struct {
+	Message     SerializedMessage
+	Offset      int64
+	Timestamp   int64
+	LeaderEpoch uint64
+	Err         error
+}
+
+// Channel starts a goroutine which reads messages from the Reader and delivers
+// them, in order, on the returned channel, which buffers up to bufSize
+// results. If a read fails, including with ErrLogClosed when the log is closed
+// or io.EOF when an end-of-stream marker is read, a final ReadResult
+// containing the error is delivered. The channel is closed when the goroutine
+// exits, which happens after a read fails or when the context is canceled. The
+// Reader must not be used directly while the goroutine is running.
+func (r *Reader) Channel(ctx context.Context, bufSize int) <-chan ReadResult {
+	ch := make(chan ReadResult, bufSize)
+	go func() {
+		defer close(ch)
+		headers := make([]byte, msgSetHeaderLen)
+		for {
+			msg, offset, timestamp, leaderEpoch, err := r.ReadMessage(ctx, headers)
+			if err != nil && ctx.Err() != nil {
+				return
+			}
+			result := ReadResult{
+				Message:     msg,
+				Offset:      offset,
+				Timestamp:   timestamp,
+				LeaderEpoch: leaderEpoch,
+				Err:         err,
+			}
+			select {
+			case ch <- result:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// ReadBatch is a batch of reads delivered by Reader.BatchChannel. Err is set
+// on the final batch if a read failed, in which case Messages contains the
+// messages read before the failure.
+type ReadBatch struct {
+	Messages []ReadResult
+	Err      error
+}
+
+// BatchChannel starts a goroutine which reads messages from the Reader like
+// Channel but delivers them in batches on the returned channel, which buffers
+// up to bufSize batches. A batch is delivered once the total size of its
+// messages reaches the BatchBytes option or the BatchLinger option has
+// elapsed since its first message was read, whichever comes first. If neither
+// option is set, each message is delivered in its own batch. If a read fails,
+// the partial batch is delivered along with the error as a final batch, and
+// if the context is canceled, the partial batch is delivered along with the
+// context's error. The final batch is delivered even though the context is
+// canceled, so the channel should be drained until it is closed, which
+// happens when the goroutine exits. The Reader must not be used directly
+// while the goroutine is running.
+func (r *Reader) BatchChannel(ctx context.Context, bufSize int) <-chan ReadBatch {
+	var (
+		ch      = make(chan ReadBatch, bufSize)
+		results = r.Channel(ctx, 0)
+	)
+	go func() {
+		defer close(ch)
+		var (
+			batch  []ReadResult
+			size   int
+			linger <-chan time.Time
+			timer  *time.Timer
+		)
+		stop := func() {
+			if timer != nil {
+				timer.Stop()
+				timer, linger = nil, nil
+			}
+		}
+		flush := func(err error) bool {
+			stop()
+			select {
+			case ch <- ReadBatch{Messages: batch, Err: err}:
+			case <-ctx.Done():
+				return false
+			}
+			batch, size = nil, 0
+			return true
+		}
+		for {
+			select {
+			case result, ok := <-results:
+				if !ok {
+					// The context was canceled.
+					stop()
+					if len(batch) > 0 {
+						ch <- ReadBatch{Messages: batch, Err: ctx.Err()}
+					}
+					return
+				}
+				if result.Err != nil {
+					stop()
+					ch <- ReadBatch{Messages: batch, Err: result.Err}
+					return
+				}
+				batch = append(batch, result)
+				size += len(result.Message)
+				if (r.opts.BatchBytes > 0 && size < r.opts.BatchBytes) ||
+					(r.opts.BatchBytes <= 0 && r.opts.BatchLinger > 0) {
+					if timer == nil && r.opts.BatchLinger > 0 {
+						timer = time.NewTimer(r.opts.BatchLinger)
+						linger = timer.C
+					}
+					continue
+				}
+				if !flush(nil) {
+					return
+				}
+			case <-linger:
+				timer, linger = nil, nil
+				if !flush(nil) {
+					return
+				}
+			}
+		}
+	}()
+	return ch
+}
+
+// CurrentSegmentModTime returns the wall-clock time the segment the Reader is
+// currently reading was last written to, or the zero time if the Reader is
+// not yet positioned in a segment, e.g. a committed Reader waiting for the
+// first message to be committed. For segments which have not been written to
+// since the log was opened, this is the modification time of the segment
+// file. This is useful for tiered storage decisions, such as offloading
+// segments which are rarely written or read.
+func (r *Reader) CurrentSegmentModTime() time.Time {
+	sr, ok := r.ctxReader.(segmentReader)
+	if !ok {
+		return time.Time{}
+	}
+	seg := sr.currentSegment()
+	if seg == nil {
+		return time.Time{}
+	}
+	return seg.ModTime()
+}
+
+// LastPosition returns the location in the log of the last message returned
+// by ReadMessage as the base offset of its segment and the byte position of
+// its message set within the segment's log file. This allows external tools
+// to build position-based indexes of the log, like the one returned by
+// ResolveOffset. For messages from a compressed batch frame, this is the
+// position of the frame. If no message has been returned or the last message
+// was a gap placeholder, this returns -1 for both.
+func (r *Reader) LastPosition() (int64, int64) {
+	return r.lastBase, r.lastPos
+}
+
+// recordPosition records the location in the log of the message just read
+// from the underlying contextReader, whose message set header had the given
+// length. Message sets never span segments, so the message starts within the
+// segment being read.
+func (r *Reader) recordPosition(msg SerializedMessage, headerLen int) {
+	r.lastBase, r.lastPos = -1, -1
+	sr, ok := r.ctxReader.(segmentReader)
+	if !ok {
+		return
+	}
+	if seg, pos := sr.position(); seg != nil {
+		r.lastBase, r.lastPos = seg.BaseOffset, pos-int64(headerLen)-int64(len(msg))
+	}
+}
+
+// checkLag invokes the OnLagWarning callback if the number of messages
+// between the given offset and the HW exceeds the lag warning threshold.
+func (r *Reader) checkLag(offset int64) {
+	if r.opts.OnLagWarning == nil || r.opts.LagWarningThreshold <= 0 {
+		return
+	}
+	if lag := r.log.HighWatermark() - offset; lag > r.opts.LagWarningThreshold {
+		r.opts.OnLagWarning(lag)
+	}
+}
+
+// readerMutex is a mutex which optionally reports how long it was held.
+type readerMutex struct {
+	sync.Mutex
+	onHold   func(time.Duration)
+	acquired time.Time
+}
+
+func (m *readerMutex) Lock() {
+	m.Mutex.Lock()
+	if m.onHold != nil {
+		m.acquired = time.Now()
+	}
+}
+
+func (m *readerMutex) Unlock() {
+	if m.onHold != nil {
+		m.onHold(time.Since(m.acquired))
+	}
+	m.Mutex.Unlock()
 }
 
 type uncommittedReader struct {
-	cl  *commitLog
-	seg *segment
-	mu  sync.Mutex
-	pos int64
+	cl    *commitLog
+	seg   *segment
+	mu    readerMutex
+	pos   int64
+	retry RetryPolicy
+	files *segmentFiles
 }
 
 func (r *uncommittedReader) Read(ctx context.Context, p []byte) (n int, err error) {
@@ -97,7 +1352,7 @@ func (r *uncommittedReader) Read(ctx context.Context, p []byte) (n int, err erro
 
 LOOP:
 	for {
-		readSize, err = r.seg.ReadAt(p[n:], r.pos)
+		readSize, err = r.retry.readAt(ctx, r.files.readerAt(r.seg), p[n:], r.pos)
 		n += readSize
 		r.pos += int64(readSize)
 		if err != nil && err != io.EOF {
@@ -115,6 +1370,13 @@ LOOP:
 		if err == io.EOF && !waiting {
 			// Check if there are more segments.
 			nextSeg := findSegmentByBaseOffset(segments, r.seg.BaseOffset+1)
+			if nextSeg == nil && r.seg.Sealed() {
+				// No more data will be written to a sealed segment, so
+				// rather than waiting, reload the segments and move
+				// straight to the next one.
+				segments = r.cl.Segments()
+				nextSeg = findSegmentByBaseOffset(segments, r.seg.BaseOffset+1)
+			}
 			if nextSeg != nil {
 				r.seg = nextSeg
 				r.pos = 0
@@ -122,8 +1384,7 @@ LOOP:
 			}
 			// Otherwise, wait for segment to be written to (or split).
 			waiting = true
-			if !r.waitForData(ctx, r.seg) {
-				err = io.EOF
+			if err = r.waitForData(ctx, r.seg); err != nil {
 				break
 			}
 			// At this point, either the segment has more data or, if it was
@@ -140,8 +1401,7 @@ LOOP:
 		// If there are not enough segments to read, wait for new segment to be
 		// appended or the context to be canceled.
 		for nextSeg == nil {
-			if !r.waitForData(ctx, r.seg) {
-				err = io.EOF
+			if err = r.waitForData(ctx, r.seg); err != nil {
 				break LOOP
 			}
 			segments = r.cl.Segments()
@@ -155,30 +1415,73 @@ LOOP:
 	return n, err
 }
 
-func (r *uncommittedReader) waitForData(ctx context.Context, seg *segment) bool {
+func (r *uncommittedReader) readView(n int64) ([]byte, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	view, ok, err := r.seg.view(r.pos, n)
+	if ok {
+		r.pos += n
+	}
+	return view, ok, err
+}
+
+// waitForData waits for data to be written to the given segment past the
+// reader's position. This returns ErrLogClosed if the log is closed or the
+// context error if the context is canceled.
+func (r *uncommittedReader) waitForData(ctx context.Context, seg *segment) error {
 	wait := seg.WaitForData(r, r.pos)
 	select {
 	case <-r.cl.closed:
 		seg.removeWaiter(r)
-		return false
+		return ErrLogClosed
 	case <-ctx.Done():
 		seg.removeWaiter(r)
-		return false
+		return ctx.Err()
 	case <-wait:
-		return true
+		return nil
 	}
 }
 
+func (r *uncommittedReader) currentSegment() *segment {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.seg
+}
+
+func (r *uncommittedReader) position() (*segment, int64) {
+	// Bypass OnLockHold, which only reports holds by reads.
+	r.mu.Mutex.Lock()
+	defer r.mu.Mutex.Unlock()
+	return r.seg, r.pos
+}
+
 // newReaderUncommitted returns a contextReader which reads data from the log
 // starting at the given offset.
 func (l *commitLog) newReaderUncommitted(offset int64) (contextReader, error) {
 	seg, contains := findSegmentContains(l.Segments(), offset)
 	if seg == nil {
-		return nil, ErrSegmentNotFound
+		// If the offset is the log end offset, position the reader at the end
+		// of the active segment so that it waits for the next append.
+		active := l.activeSegment()
+		active.RLock()
+		next, position := active.BaseOffset, active.position
+		if active.lastOffset != -1 {
+			next = active.lastOffset + 1
+		}
+		active.RUnlock()
+		if offset != next {
+			return nil, pkgErrors.Wrapf(ErrSegmentNotFound,
+				"offset %d is beyond the log end offset %d", offset, next)
+		}
+		return &uncommittedReader{
+			cl:  l,
+			seg: active,
+			pos: position,
+		}, nil
 	}
 	position := int64(0)
 	if contains {
-		e, err := seg.findEntry(offset)
+		e, err := seg.findEntryOrRebuild(offset)
 		if err != nil {
 			return nil, err
 		}
@@ -192,13 +1495,35 @@ func (l *commitLog) newReaderUncommitted(offset int64) (contextReader, error) {
 }
 
 type committedReader struct {
-	cl    *commitLog
-	seg   *segment
-	hwSeg *segment
-	mu    sync.Mutex
-	pos   int64
-	hwPos int64
-	hw    int64
+	cl       *commitLog
+	seg      *segment
+	hwSeg    *segment
+	mu       readerMutex
+	pos      int64
+	hwPos    int64
+	hw       int64
+	next     int64 // offset to start at once the HW reaches it if seg is nil
+	snapshot bool
+	priority int
+	retry    RetryPolicy
+	files    *segmentFiles
+}
+
+func (r *committedReader) currentSegment() *segment {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.seg
+}
+
+func (r *committedReader) position() (*segment, int64) {
+	// Bypass OnLockHold, which only reports holds by reads.
+	r.mu.Mutex.Lock()
+	defer r.mu.Mutex.Unlock()
+	return r.seg, r.pos
+}
+
+func (r *committedReader) waitPriority() int {
+	return r.priority
 }
 
 func (r *committedReader) Read(ctx context.Context, p []byte) (n int, err error) {
@@ -210,16 +1535,18 @@ func (r *committedReader) Read(ctx context.Context, p []byte) (n int, err error)
 	// either empty or the offset overflows the HW. This means we need to wait
 	// for data.
 	if r.seg == nil {
-		offset := r.hw + 1 // We want to read the next committed message.
-		hw := r.cl.HighWatermark()
-		for hw == r.hw {
-			// The HW has not changed, so wait for it to update.
-			if !r.waitForHW(ctx, hw) {
-				err = io.EOF
-				return
+		if r.snapshot {
+			return 0, io.EOF
+		}
+		// Wait until the offset the reader starts at is committed. This is
+		// not necessarily the message following the HW the reader was
+		// created with since it may start further ahead.
+		offset := r.next
+		hw := r.hw
+		for hw < offset {
+			if hw, err = r.waitForHW(ctx, hw); err != nil {
+				return 0, err
 			}
-			// Sync the HW.
-			hw = r.cl.HighWatermark()
 		}
 		r.hw = hw
 		segments = r.cl.Segments()
@@ -233,7 +1560,7 @@ func (r *committedReader) Read(ctx context.Context, p []byte) (n int, err error)
 		if r.seg == nil {
 			return 0, ErrSegmentNotFound
 		}
-		entry, err := r.seg.findEntry(offset)
+		entry, err := r.seg.findEntryOrRebuild(offset)
 		if err != nil {
 			return 0, err
 		}
@@ -254,7 +1581,7 @@ LOOP:
 			// If we're reading from the HW segment, read up to the HW pos.
 			lim = min(lim, r.hwPos-r.pos)
 		}
-		readSize, err = r.seg.ReadAt(p[n:lim], r.pos)
+		readSize, err = r.retry.readAt(ctx, r.files.readerAt(r.seg), p[n:int64(n)+lim], r.pos)
 		n += readSize
 		r.pos += int64(readSize)
 		if err != nil && err != io.EOF {
@@ -280,21 +1607,22 @@ LOOP:
 			continue
 		}
 
-		// We hit the HW, so sync the latest.
-		hw := r.cl.HighWatermark()
-		for hw == r.hw {
-			// The HW has not changed, so wait for it to update.
-			if !r.waitForHW(ctx, hw) {
-				err = io.EOF
-				break LOOP
-			}
-			// Sync the HW.
-			hw = r.cl.HighWatermark()
+		// We hit the HW. Snapshot readers end here, otherwise sync the
+		// latest.
+		if r.snapshot {
+			err = io.EOF
+			break
+		}
+		var (
+			hw, hwPos int64
+			hwIdx     int
+		)
+		if hw, err = r.waitForHW(ctx, r.hw); err != nil {
+			break LOOP
 		}
 		r.hw = hw
 		segments = r.cl.Segments()
-		hwIdx, hwPos, err := getHWPos(segments, r.hw)
-		if err != nil {
+		if hwIdx, hwPos, err = getHWPos(segments, r.hw); err != nil {
 			break
 		}
 		r.hwPos = hwPos
@@ -304,33 +1632,73 @@ LOOP:
 	return n, err
 }
 
-func (r *committedReader) waitForHW(ctx context.Context, hw int64) bool {
-	wait := r.cl.waitForHW(r, hw)
-	select {
-	case <-r.cl.closed:
-		r.cl.removeHWWaiter(r)
-		return false
-	case <-ctx.Done():
-		r.cl.removeHWWaiter(r)
-		return false
-	case <-wait:
-		return true
+func (r *committedReader) readView(n int64) ([]byte, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.seg == nil || (r.seg == r.hwSeg && r.pos+n > r.hwPos) {
+		return nil, false, nil
+	}
+	view, ok, err := r.seg.view(r.pos, n)
+	if ok {
+		r.pos += n
+	}
+	return view, ok, err
+}
+
+// waitForHW waits for the HW to differ from the given value and returns the
+// new HW. The HW is read under the same lock used to register the waiter, so
+// it is only read once per wakeup rather than being polled. This returns
+// ErrLogClosed if the log is closed or the context error if the context is
+// canceled.
+func (r *committedReader) waitForHW(ctx context.Context, hw int64) (int64, error) {
+	for {
+		wait, current := r.cl.waitForHW(r, hw)
+		if current != hw {
+			return current, nil
+		}
+		select {
+		case <-r.cl.closed:
+			r.cl.removeHWWaiter(r)
+			return 0, ErrLogClosed
+		case <-ctx.Done():
+			r.cl.removeHWWaiter(r)
+			return 0, ctx.Err()
+		case <-wait:
+		}
 	}
 }
 
 // newReaderCommitted returns a contextReader which reads only committed data
 // from the log starting at the given offset.
 func (l *commitLog) newReaderCommitted(offset int64) (contextReader, error) {
+	reader, err := l.newReaderCommittedAt(offset, l.HighWatermark())
+	if err != nil {
+		return nil, err
+	}
+	return reader, nil
+}
+
+// newReaderCommittedAt returns a contextReader which reads only committed data
+// from the log starting at the given offset using the given HW as the initial
+// HW.
+func (l *commitLog) newReaderCommittedAt(offset, hw int64) (*committedReader, error) {
 	var (
-		hw       = l.HighWatermark()
 		hwPos    = int64(-1)
 		segments = l.Segments()
 		hwSeg    *segment
 	)
 
 	// If offset exceeds HW, wait for the next message. This also covers the
-	// case when the log is empty.
+	// case when the log is empty. Readers are not created with offsets past
+	// the end of the log, but a reader's offset may be past it once the log
+	// is truncated, in which case it is capped to the message following the
+	// HW. Offsets within the log are kept so that messages before them are
+	// not returned once they are committed.
 	if offset > hw {
+		next := hw + 1
+		if offset <= l.NewestOffset()+1 {
+			next = offset
+		}
 		return &committedReader{
 			cl:    l,
 			seg:   nil,
@@ -338,6 +1706,7 @@ func (l *commitLog) newReaderCommitted(offset int64) (contextReader, error) {
 			hwSeg: hwSeg,
 			hwPos: hwPos,
 			hw:    hw,
+			next:  next,
 		}, nil
 	}
 
@@ -353,7 +1722,7 @@ func (l *commitLog) newReaderCommitted(offset int64) (contextReader, error) {
 	position := int64(0)
 	seg, contains := findSegmentContains(segments, offset)
 	if contains {
-		entry, err := seg.findEntry(offset)
+		entry, err := seg.findEntryOrRebuild(offset)
 		if err != nil {
 			return nil, err
 		}
@@ -374,7 +1743,7 @@ func getHWPos(segments []*segment, hw int64) (int, int64, error) {
 	if hwSeg == nil {
 		return 0, 0, ErrSegmentNotFound
 	}
-	hwEntry, err := hwSeg.findEntry(hw)
+	hwEntry, err := hwSeg.findEntry(hw, nil)
 	if err != nil {
 		return 0, 0, err
 	}
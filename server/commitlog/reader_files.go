@@ -0,0 +1,141 @@
+package commitlog
+
+import (
+	"container/list"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// segmentFiles holds a Reader's own handles to the log files of the inactive
+// segments it reads, keeping at most max open. Once more are opened, the least
+// recently read file is closed, and it is reopened if it is read again.
+// Inactive segments are never written to, so their files can be read without
+// the segment's lock. The active segment is always read through the log.
+type segmentFiles struct {
+	mu    sync.Mutex
+	log   *commitLog
+	max   int
+	order *list.List
+	files map[*segment]*list.Element
+}
+
+// segmentFile is a Reader's handle to a segment's log file.
+type segmentFile struct {
+	seg  *segment
+	file *os.File
+}
+
+func newSegmentFiles(log *commitLog, max int) *segmentFiles {
+	return &segmentFiles{
+		log:   log,
+		max:   max,
+		order: list.New(),
+		files: make(map[*segment]*list.Element),
+	}
+}
+
+// readerAt returns the io.ReaderAt to read the segment through. If f is nil,
+// this is the segment itself.
+func (f *segmentFiles) readerAt(seg *segment) io.ReaderAt {
+	if f == nil {
+		return seg
+	}
+	return &segmentFileReader{files: f, seg: seg}
+}
+
+// segmentFileReader reads a segment through a Reader's segmentFiles.
+type segmentFileReader struct {
+	files *segmentFiles
+	seg   *segment
+}
+
+// ReadAt reads from the Reader's own handle to the segment's log file if the
+// segment is inactive and is still the one whose file is at its path.
+// Otherwise, e.g. once it has been replaced or deleted, the segment is read
+// directly so that its errors are returned.
+func (r *segmentFileReader) ReadAt(p []byte, off int64) (int, error) {
+	if r.seg == r.files.log.activeSegment() {
+		return r.seg.ReadAt(p, off)
+	}
+	r.files.mu.Lock()
+	defer r.files.mu.Unlock()
+	file, err := r.files.open(r.seg)
+	if err != nil {
+		return 0, err
+	}
+	if file == nil {
+		return r.seg.ReadAt(p, off)
+	}
+	return file.ReadAt(p, off)
+}
+
+// open returns the handle to the segment's log file, opening it and closing
+// the least recently read files over the limit if needed. Nil is returned if
+// the segment is closed. This must be called with mu held.
+func (f *segmentFiles) open(seg *segment) (*os.File, error) {
+	if e, ok := f.files[seg]; ok {
+		f.order.MoveToFront(e)
+		handle := e.Value.(*segmentFile)
+		// The segment may have been swapped out since the file was opened.
+		seg.RLock()
+		closed := seg.closed
+		seg.RUnlock()
+		if !closed {
+			return handle.file, nil
+		}
+		f.remove(e)
+		return nil, nil
+	}
+
+	// Holding the segment's lock keeps its file from being replaced while it
+	// is opened since segments are closed before their files are moved.
+	seg.RLock()
+	if seg.closed {
+		seg.RUnlock()
+		return nil, nil
+	}
+	file, err := os.Open(seg.logPath())
+	seg.RUnlock()
+	if err != nil {
+		return nil, errors.Wrap(err, "open file failed")
+	}
+	f.files[seg] = f.order.PushFront(&segmentFile{seg: seg, file: file})
+	for f.order.Len() > f.max {
+		f.remove(f.order.Back())
+	}
+	return file, nil
+}
+
+// remove closes the file and stops tracking it. This must be called with mu
+// held.
+func (f *segmentFiles) remove(e *list.Element) {
+	handle := e.Value.(*segmentFile)
+	handle.file.Close() // nolint: errcheck
+	delete(f.files, handle.seg)
+	f.order.Remove(e)
+}
+
+// count returns the number of files open.
+func (f *segmentFiles) count() int {
+	if f == nil {
+		return 0
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.order.Len()
+}
+
+// close closes every open file.
+func (f *segmentFiles) close() {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for f.order.Len() > 0 {
+		f.remove(f.order.Back())
+	}
+}
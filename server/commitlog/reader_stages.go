@@ -0,0 +1,244 @@
+package commitlog
+
+import (
+	"context"
+	"errors"
+
+	pkgErrors "github.com/pkg/errors"
+)
+
+// record is a message read by a Reader along with its offset, timestamp, and
+// leader epoch as it passes through the Reader's stages.
+type record struct {
+	msg         SerializedMessage
+	stored      SerializedMessage // The message as stored, before any transform
+	offset      int64
+	timestamp   int64
+	leaderEpoch uint64
+}
+
+// readFunc reads the next record for a Reader. headersBuf and zeroCopy are as
+// for readMessage.
+type readFunc func(ctx context.Context, headersBuf []byte, zeroCopy bool) (*record, error)
+
+// readStage wraps a readFunc to implement a Reader option, such as filtering
+// or transforming messages, like ReaderMiddleware wraps a MessageSource.
+// Unlike ReaderMiddleware, stages see the leader epoch and can use the
+// Reader's state, which is reset when the Reader is repositioned. Stages
+// return errors from the stage they wrap unchanged.
+type readStage func(next readFunc) readFunc
+
+// errFillGap is returned by decode while the Reader is filling a gap in
+// offsets, so that the next read returns a placeholder.
+var errFillGap = errors.New("filling gap")
+
+// deadLetter is returned by a stage for a message which can't be returned,
+// e.g. because it is corrupt or failed to transform, so that the deadLetters
+// stage routes it.
+type deadLetter struct {
+	msg     SerializedMessage
+	offset  int64
+	err     error
+	corrupt bool
+}
+
+func (d *deadLetter) Error() string {
+	return d.err.Error()
+}
+
+// pipeline composes the stages implementing the Reader's options around
+// decode, which only decodes messages and positions the Reader. Messages flow
+// from decode through the stages in the order they are listed here, so e.g.
+// the transform only sees messages which passed every filter.
+func (r *Reader) pipeline() readFunc {
+	var stages []readStage
+	if r.opts.SkipAttributes != 0 {
+		stages = append(stages, r.skipAttributes)
+	}
+	if r.filter != nil {
+		stages = append(stages, r.filterMessages)
+	}
+	if r.epoch != nil {
+		stages = append(stages, r.filterEpoch)
+	}
+	if r.dedupe != nil {
+		stages = append(stages, r.dedupeKeys)
+	}
+	if r.sequences != nil {
+		stages = append(stages, r.verifySequences)
+	}
+	if r.opts.CommitFloor != nil {
+		stages = append(stages, r.holdForCommitFloor)
+	}
+	if r.opts.Transform != nil {
+		stages = append(stages, r.transform)
+	}
+	stages = append(stages, r.deadLetters)
+	read := r.decode
+	for _, stage := range stages {
+		read = stage(read)
+	}
+	return read
+}
+
+// skipAttributes skips messages with any of the SkipAttributes flags set.
+func (r *Reader) skipAttributes(next readFunc) readFunc {
+	return func(ctx context.Context, headersBuf []byte, zeroCopy bool) (*record, error) {
+		for {
+			rec, err := next(ctx, headersBuf, zeroCopy)
+			if err != nil || !rec.msg.HasAttributes(r.opts.SkipAttributes) {
+				return rec, err
+			}
+		}
+	}
+}
+
+// filterMessages skips messages rejected by the Reader's filter, e.g. one set
+// by NewReaderByAttribute.
+func (r *Reader) filterMessages(next readFunc) readFunc {
+	return func(ctx context.Context, headersBuf []byte, zeroCopy bool) (*record, error) {
+		for {
+			rec, err := next(ctx, headersBuf, zeroCopy)
+			if err != nil || r.filter(rec.msg, rec.offset) {
+				return rec, err
+			}
+		}
+	}
+}
+
+// filterEpoch skips messages from leader epochs before the Reader's epoch and
+// ends the stream at the first message from a later one.
+func (r *Reader) filterEpoch(next readFunc) readFunc {
+	return func(ctx context.Context, headersBuf []byte, zeroCopy bool) (*record, error) {
+		for {
+			rec, err := next(ctx, headersBuf, zeroCopy)
+			if err != nil || rec.leaderEpoch == *r.epoch {
+				return rec, err
+			}
+			if rec.leaderEpoch > *r.epoch {
+				// Leader epochs never decrease through the log, so no later
+				// message can have been written in the Reader's epoch.
+				return nil, r.endStream(&ErrEndOfStream{LastOffset: rec.offset - 1})
+			}
+		}
+	}
+}
+
+// dedupeKeys skips messages whose DedupeKeyFn key was seen within the dedupe
+// window.
+func (r *Reader) dedupeKeys(next readFunc) readFunc {
+	return func(ctx context.Context, headersBuf []byte, zeroCopy bool) (*record, error) {
+		for {
+			rec, err := next(ctx, headersBuf, zeroCopy)
+			if err != nil {
+				return nil, err
+			}
+			if key := r.opts.DedupeKeyFn(rec.msg); key == nil || !r.dedupe.seen(key, rec.offset, rec.timestamp) {
+				return rec, nil
+			}
+		}
+	}
+}
+
+// verifySequences returns an error for a message whose producer sequence does
+// not follow the previous one from the same producer. The message following a
+// gap is held and returned by the next read.
+func (r *Reader) verifySequences(next readFunc) readFunc {
+	return func(ctx context.Context, headersBuf []byte, zeroCopy bool) (*record, error) {
+		if rec := r.held; rec != nil {
+			r.held = nil
+			return rec, nil
+		}
+		rec, err := next(ctx, headersBuf, zeroCopy)
+		if err != nil {
+			return nil, err
+		}
+		if producerID, seq, ok := rec.msg.ProducerSequence(); ok {
+			expected, err := r.sequences.check(producerID, seq)
+			if err == ErrSequenceGap {
+				r.held = rec
+			}
+			if err != nil {
+				return nil, pkgErrors.Wrapf(err,
+					"producer %s sequence %d at offset %d, expected %d", producerID, seq, rec.offset, expected)
+			}
+		}
+		return rec, nil
+	}
+}
+
+// holdForCommitFloor waits until the CommitFloor reaches each message before
+// returning it.
+func (r *Reader) holdForCommitFloor(next readFunc) readFunc {
+	return func(ctx context.Context, headersBuf []byte, zeroCopy bool) (*record, error) {
+		rec := r.held
+		r.held = nil
+		if rec == nil {
+			var err error
+			if rec, err = next(ctx, headersBuf, zeroCopy); err != nil {
+				return nil, err
+			}
+		}
+		if rec.offset > r.opts.CommitFloor() {
+			// Hold the message so it is returned by a later read if this one
+			// returns before the floor reaches it.
+			r.held = rec
+			if err := r.waitCommitFloor(ctx, rec.offset); err != nil {
+				if r.deadlineExceeded() {
+					return nil, ErrDeadlineExceeded
+				}
+				return nil, err
+			}
+			r.held = nil
+		}
+		return rec, nil
+	}
+}
+
+// transform replaces each message with the result of the Transform option.
+// Messages which fail to transform are dead letters.
+func (r *Reader) transform(next readFunc) readFunc {
+	return func(ctx context.Context, headersBuf []byte, zeroCopy bool) (*record, error) {
+		rec, err := next(ctx, headersBuf, zeroCopy)
+		if err != nil {
+			return nil, err
+		}
+		transformed, err := r.opts.Transform(rec.msg)
+		if err != nil {
+			return nil, &deadLetter{
+				msg:    rec.msg,
+				offset: rec.offset,
+				err:    &ErrTransform{Offset: rec.offset, Err: err},
+			}
+		}
+		rec.msg = transformed
+		return rec, nil
+	}
+}
+
+// deadLetters routes dead letters to the DeadLetter callback and continues
+// with the next message. Without the callback, messages which failed to
+// transform are skipped under TransformSkip, corrupt data panics since the
+// server is then in an unrecoverable state, and otherwise the error is
+// returned.
+func (r *Reader) deadLetters(next readFunc) readFunc {
+	return func(ctx context.Context, headersBuf []byte, zeroCopy bool) (*record, error) {
+		for {
+			rec, err := next(ctx, headersBuf, zeroCopy)
+			dead, ok := err.(*deadLetter)
+			if !ok {
+				return rec, err
+			}
+			_, transformErr := dead.err.(*ErrTransform)
+			switch {
+			case r.opts.DeadLetter != nil:
+				r.opts.DeadLetter(dead.msg, dead.offset, dead.err)
+			case dead.corrupt:
+				panic(dead.err)
+			case transformErr && r.opts.TransformErrorPolicy == TransformSkip:
+			default:
+				return nil, dead.err
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package commitlog
+
+import "sync/atomic"
+
+// ReaderStats is a snapshot of the workload of a Reader.
+type ReaderStats struct {
+	// MessagesRead is the number of messages returned by the Reader.
+	MessagesRead int64
+
+	// BytesRead is the total size of the messages returned by the Reader,
+	// excluding their message-set headers.
+	BytesRead int64
+
+	// AvgMessageSize is the average size of the messages returned by the
+	// Reader, or 0 if none have been returned.
+	AvgMessageSize float64
+
+	// MaxMessageSize is the size of the largest message returned by the
+	// Reader.
+	MaxMessageSize int32
+
+	// Lag is the number of committed messages after the last message
+	// returned by the Reader.
+	Lag int64
+}
+
+// Stats returns a snapshot of the Reader's workload. Sizes are those of the
+// messages as returned, i.e. after any Transform, with messages inside
+// compressed batches counted individually. It is safe to call concurrently
+// with reads.
+func (r *Reader) Stats() ReaderStats {
+	r.statsMu.Lock()
+	stats := r.stats
+	r.statsMu.Unlock()
+	if stats.MessagesRead > 0 {
+		stats.AvgMessageSize = float64(stats.BytesRead) / float64(stats.MessagesRead)
+	}
+	lag := r.log.HighWatermark() - atomic.LoadInt64(&r.emitted)
+	if lag < 0 {
+		lag = 0
+	}
+	stats.Lag = lag
+	return stats
+}
+
+// recordStats records the size of a message returned by the Reader.
+func (r *Reader) recordStats(size int) {
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+	r.stats.MessagesRead++
+	r.stats.BytesRead += int64(size)
+	if int32(size) > r.stats.MaxMessageSize {
+		r.stats.MaxMessageSize = int32(size)
+	}
+}
@@ -0,0 +1,46 @@
+package commitlog
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Ensure Reader stats track the sizes of the messages read and the lag.
+func TestReaderStats(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1000,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	for _, value := range []string{"a", "bbbbbbbbbb", "ccc", "dd"} {
+		_, err := l.Append([]*Message{{Value: []byte(value)}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(3)
+
+	r, err := l.NewReader(0, false)
+	require.NoError(t, err)
+	require.Equal(t, ReaderStats{Lag: 4}, r.Stats())
+
+	var (
+		sizes   []int
+		total   int
+		headers = make([]byte, 28)
+	)
+	for i := 0; i < 3; i++ {
+		msg, _, _, _, err := r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+		sizes = append(sizes, len(msg))
+		total += len(msg)
+	}
+	stats := r.Stats()
+	require.Equal(t, int64(3), stats.MessagesRead)
+	require.Equal(t, int64(total), stats.BytesRead)
+	require.Equal(t, float64(total)/3, stats.AvgMessageSize)
+	require.Equal(t, int32(sizes[1]), stats.MaxMessageSize)
+	require.Equal(t, int64(1), stats.Lag)
+}
@@ -1,8 +1,11 @@
 package commitlog
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"io"
+	"os"
 	"strconv"
 	"testing"
 	"time"
@@ -82,7 +85,29 @@ func TestReaderUncommittedBlockCancel(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	go cancel()
 	_, _, _, _, err = r.ReadMessage(ctx, headers)
-	require.Equal(t, io.EOF, errors.Cause(err))
+	require.Equal(t, context.Canceled, errors.Cause(err))
+}
+
+// Ensure blocked readers return ErrLogClosed rather than a context error when
+// the log is closed.
+func TestReaderBlockLogClosed(t *testing.T) {
+	for _, uncommitted := range []bool{true, false} {
+		l, cleanup := setupWithOptions(t, Options{
+			Path:            tempDir(t),
+			MaxSegmentBytes: 10,
+		})
+
+		r, err := l.NewReader(0, uncommitted)
+		require.NoError(t, err)
+		go func() {
+			time.Sleep(5 * time.Millisecond)
+			l.Close()
+		}()
+		headers := make([]byte, 28)
+		_, _, _, _, err = r.ReadMessage(context.Background(), headers)
+		require.Equal(t, ErrLogClosed, errors.Cause(err))
+		cleanup()
+	}
 }
 
 func TestReaderUncommittedBlockForSegmentWrite(t *testing.T) {
@@ -138,6 +163,41 @@ func TestReaderUncommittedBlockForSegmentWrite(t *testing.T) {
 	<-done
 }
 
+// Ensure uncommitted readers move past sealed segments without registering
+// as waiters on them.
+func TestReaderUncommittedSkipsSealedSegments(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 50,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	numMsgs := 10
+	for i := 0; i < numMsgs; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	segments := l.Segments()
+	require.True(t, len(segments) > 1)
+
+	r, err := l.NewReader(0, true)
+	require.NoError(t, err)
+	headers := make([]byte, 28)
+	for i := 0; i < numMsgs; i++ {
+		_, offset, _, _, err := r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+		require.Equal(t, int64(i), offset)
+	}
+
+	for _, seg := range segments[:len(segments)-1] {
+		require.True(t, seg.Sealed())
+		seg.RLock()
+		require.Len(t, seg.waiters, 0)
+		seg.RUnlock()
+	}
+}
+
 func TestReaderUncommittedReadError(t *testing.T) {
 	l, cleanup := setupWithOptions(t, Options{
 		Path:            tempDir(t),
@@ -211,7 +271,7 @@ func TestReaderCommittedBlockCancel(t *testing.T) {
 	go cancel()
 	headers := make([]byte, 28)
 	_, _, _, _, err = r.ReadMessage(ctx, headers)
-	require.Equal(t, io.EOF, errors.Cause(err))
+	require.Equal(t, context.Canceled, errors.Cause(err))
 }
 
 func TestReaderCommittedReadError(t *testing.T) {
@@ -390,6 +450,108 @@ func TestReaderCommittedWaitForHW(t *testing.T) {
 	}
 }
 
+// Ensure committed Readers deliver every message exactly once when handing
+// off from reading the backlog up to the HW to waiting on the HW, including
+// when the HW advances right at the handoff.
+func TestReaderCommittedHandoff(t *testing.T) {
+	for _, test := range segmentSizeTests {
+		t.Run(test.name, func(t *testing.T) {
+			l, cleanup := setupWithOptions(t, Options{
+				Path:            tempDir(t),
+				MaxSegmentBytes: test.segmentSize,
+			})
+			defer cleanup()
+			defer l.Close()
+
+			appendMsg := func(i int) {
+				_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i)), Timestamp: int64(i)}})
+				require.NoError(t, err)
+			}
+			for i := 0; i < 5; i++ {
+				appendMsg(i)
+			}
+			l.SetHighWatermark(2)
+			r, err := l.NewReader(0, false)
+			require.NoError(t, err)
+
+			headers := make([]byte, 28)
+			read := func(expected int64) {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				msg, offset, _, _, err := r.ReadMessage(ctx, headers)
+				require.NoError(t, err)
+				require.Equal(t, expected, offset)
+				require.Equal(t, strconv.FormatInt(expected, 10), string(msg.Value()))
+			}
+			for i := int64(0); i <= 2; i++ {
+				read(i)
+			}
+
+			// The HW advances after the message at the original HW is read
+			// but before the reader waits on it.
+			l.SetHighWatermark(3)
+			read(3)
+
+			// The HW advances while the reader waits on it.
+			go func() {
+				time.Sleep(5 * time.Millisecond)
+				l.SetHighWatermark(4)
+			}()
+			read(4)
+
+			// Messages written and committed one at a time as the reader
+			// tails the log.
+			const numMsgs = 50
+			go func() {
+				for i := 5; i < numMsgs; i++ {
+					appendMsg(i)
+					l.SetHighWatermark(int64(i))
+				}
+			}()
+			for i := int64(5); i < numMsgs; i++ {
+				read(i)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			_, _, _, _, err = r.ReadMessage(ctx, headers)
+			require.Equal(t, context.DeadlineExceeded, errors.Cause(err))
+		})
+	}
+}
+
+// Ensure committed Readers starting at an offset in the log more than one
+// message past the HW wait for the HW to reach their start offset rather than
+// starting at the message following the HW.
+func TestReaderCommittedStartPastHW(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 30,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	for i := 0; i < 10; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(2)
+	r, err := l.NewReader(5, false)
+	require.NoError(t, err)
+
+	headers := make([]byte, 28)
+	l.SetHighWatermark(3)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, _, _, _, err = r.ReadMessage(ctx, headers)
+	require.Equal(t, context.DeadlineExceeded, errors.Cause(err))
+
+	l.SetHighWatermark(9)
+	_, offset, _, _, err := r.ReadMessage(context.Background(), headers)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), offset)
+}
+
 func TestReaderCommittedCancel(t *testing.T) {
 	var err error
 	l, cleanup := setupWithOptions(t, Options{
@@ -432,7 +594,7 @@ func TestReaderCommittedCancel(t *testing.T) {
 			compareMessages(t, msg, m)
 			count++
 		} else {
-			require.Equal(t, io.EOF, errors.Cause(err))
+			require.Equal(t, context.Canceled, errors.Cause(err))
 		}
 	}
 	require.Equal(t, 5, count)
@@ -446,6 +608,7 @@ func TestReaderCommittedCapOffset(t *testing.T) {
 		MaxSegmentBytes: 100,
 	})
 	defer cleanup()
+	defer l.Close()
 
 	msg1 := &Message{
 		Value:       []byte("hi"),
@@ -463,7 +626,7 @@ func TestReaderCommittedCapOffset(t *testing.T) {
 	require.NoError(t, err)
 	l.SetHighWatermark(0)
 
-	r, err := l.NewReader(5, false)
+	r, err := l.NewReader(1, false)
 	require.NoError(t, err)
 
 	go l.SetHighWatermark(1)
@@ -489,3 +652,1608 @@ func compareMessages(t *testing.T, exp *Message, act SerializedMessage) {
 		require.Equal(t, exp.Headers, act.Headers())
 	}
 }
+
+// Ensure NewReaderCompactedSnapshot returns the latest message for each key up
+// to the HW followed by every message committed after the snapshot.
+func TestReaderCompactedSnapshot(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	appendToLog(t, l, []keyValue{
+		{key: []byte("a"), value: []byte("1")},
+		{key: []byte("b"), value: []byte("1")},
+		{key: nil, value: []byte("keyless")},
+		{key: []byte("a"), value: []byte("2")},
+		{key: []byte("c"), value: []byte("1")},
+		{key: []byte("c"), value: nil},
+	}, true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	r, err := l.NewReaderCompactedSnapshot(ctx, nil)
+	require.NoError(t, err)
+
+	// Messages appended after the snapshot are all returned, including ones
+	// for keys already in the snapshot.
+	appendToLog(t, l, []keyValue{
+		{key: []byte("a"), value: []byte("3")},
+		{key: []byte("d"), value: []byte("1")},
+	}, true)
+
+	expected := []struct {
+		offset int64
+		key    string
+		value  string
+	}{
+		{1, "b", "1"},
+		{3, "a", "2"},
+		{6, "a", "3"},
+		{7, "d", "1"},
+	}
+	headers := make([]byte, 28)
+	for _, exp := range expected {
+		msg, offset, _, _, err := r.ReadMessage(ctx, headers)
+		require.NoError(t, err)
+		require.Equal(t, exp.offset, offset)
+		require.Equal(t, exp.key, string(msg.Key()))
+		require.Equal(t, exp.value, string(msg.Value()))
+	}
+}
+
+// Ensure committed readers return io.EOF after reading an end-of-stream marker
+// while uncommitted readers return the marker like any other message.
+func TestReaderCommittedEndOfStream(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	appendToLog(t, l, []keyValue{
+		{value: []byte("a")},
+		{value: []byte("b")},
+	}, true)
+	offset, err := l.CloseStream()
+	require.NoError(t, err)
+	require.Equal(t, int64(2), offset)
+	l.SetHighWatermark(offset)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	r, err := l.NewReader(0, false)
+	require.NoError(t, err)
+	headers := make([]byte, 28)
+	for i := 0; i < 2; i++ {
+		_, offset, _, _, err := r.ReadMessage(ctx, headers)
+		require.NoError(t, err)
+		require.Equal(t, int64(i), offset)
+	}
+	_, _, _, _, err = r.ReadMessage(ctx, headers)
+	require.Equal(t, io.EOF, err)
+	_, _, _, _, err = r.ReadMessage(ctx, headers)
+	require.Equal(t, io.EOF, err)
+	require.NoError(t, ctx.Err())
+
+	r, err = l.NewReader(2, true)
+	require.NoError(t, err)
+	msg, offset, _, _, err := r.ReadMessage(ctx, headers)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), offset)
+	require.True(t, msg.IsEndOfStream())
+}
+
+// Ensure OnLagWarning is invoked when the reader falls behind the HW by more
+// than the configured threshold.
+func TestReaderLagWarning(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	appendToLog(t, l, []keyValue{
+		{value: []byte("a")},
+		{value: []byte("b")},
+		{value: []byte("c")},
+		{value: []byte("d")},
+		{value: []byte("e")},
+	}, true)
+
+	var lags []int64
+	r, err := l.NewReaderWithOptions(0, ReaderOptions{
+		LagWarningThreshold: 2,
+		OnLagWarning: func(lag int64) {
+			lags = append(lags, lag)
+		},
+	})
+	require.NoError(t, err)
+
+	headers := make([]byte, 28)
+	for i := 0; i < 5; i++ {
+		_, _, _, _, err := r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+	}
+	require.Equal(t, []int64{4, 3}, lags)
+}
+
+// Ensure Channel delivers messages in order and closes the channel when the
+// context is canceled.
+func TestReaderChannel(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	numMsgs := 10
+	for i := 0; i < numMsgs; i++ {
+		appendToLog(t, l, []keyValue{{value: []byte(strconv.Itoa(i))}}, true)
+	}
+
+	r, err := l.NewReader(0, false)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := r.Channel(ctx, 2)
+	for i := 0; i < numMsgs; i++ {
+		select {
+		case result := <-ch:
+			require.NoError(t, result.Err)
+			require.Equal(t, int64(i), result.Offset)
+			require.Equal(t, []byte(strconv.Itoa(i)), result.Message.Value())
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected message")
+		}
+	}
+
+	cancel()
+	select {
+	case _, ok := <-ch:
+		require.False(t, ok)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected channel to be closed")
+	}
+}
+
+// Ensure Readers skip messages with any of the SkipAttributes flags set while
+// end-of-stream markers still end the stream.
+func TestReaderSkipAttributes(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	_, err := l.Append([]*Message{
+		{Value: []byte("a")},
+		{Value: []byte("control"), Attributes: AttrControl},
+		{Value: []byte("b")},
+	})
+	require.NoError(t, err)
+	offset, err := l.CloseStream()
+	require.NoError(t, err)
+	l.SetHighWatermark(offset)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	headers := make([]byte, 28)
+	r, err := l.NewReaderWithOptions(0, ReaderOptions{SkipAttributes: AttrControl})
+	require.NoError(t, err)
+	for _, exp := range []int64{0, 2} {
+		msg, offset, _, _, err := r.ReadMessage(ctx, headers)
+		require.NoError(t, err)
+		require.Equal(t, exp, offset)
+		require.False(t, msg.HasAttributes(AttrControl))
+	}
+	_, _, _, _, err = r.ReadMessage(ctx, headers)
+	require.Equal(t, io.EOF, err)
+
+	// Without SkipAttributes, control records are returned.
+	r, err = l.NewReader(1, false)
+	require.NoError(t, err)
+	msg, _, _, _, err := r.ReadMessage(ctx, headers)
+	require.NoError(t, err)
+	require.True(t, msg.HasAttributes(AttrControl|AttrEndOfStream))
+	require.Equal(t, AttrControl, msg.Attributes())
+}
+
+// Ensure NewReaderByAttribute only returns messages whose masked attributes
+// match.
+func TestNewReaderByAttribute(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	_, err := l.Append([]*Message{
+		{Value: []byte("a")},
+		{Value: []byte("control"), Attributes: AttrControl},
+		{Value: []byte("b")},
+		{Value: []byte("control"), Attributes: AttrControl},
+	})
+	require.NoError(t, err)
+	offset, err := l.CloseStream()
+	require.NoError(t, err)
+	l.SetHighWatermark(offset)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	headers := make([]byte, 28)
+	for _, tc := range []struct {
+		value    int8
+		expected []int64
+	}{
+		{0, []int64{0, 2}},
+		{AttrControl, []int64{1, 3}},
+	} {
+		r, err := l.NewReaderByAttribute(0, AttrControl, tc.value)
+		require.NoError(t, err)
+		for _, exp := range tc.expected {
+			msg, offset, _, _, err := r.ReadMessage(ctx, headers)
+			require.NoError(t, err)
+			require.Equal(t, exp, offset)
+			require.Equal(t, tc.value, msg.Attributes()&AttrControl)
+		}
+		_, _, _, _, err = r.ReadMessage(ctx, headers)
+		require.Equal(t, io.EOF, err)
+	}
+}
+
+// Ensure Readers created with NewReaderByEpoch skip messages from earlier
+// leader epochs and end at the first message from a later epoch.
+func TestNewReaderByEpoch(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	for _, epoch := range []uint64{1, 1, 2, 2, 2, 3} {
+		_, err := l.Append([]*Message{{Value: []byte("foo"), LeaderEpoch: epoch}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(5)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	headers := make([]byte, 28)
+	r, err := l.NewReaderByEpoch(0, 2)
+	require.NoError(t, err)
+	for _, exp := range []int64{2, 3, 4} {
+		_, offset, _, leaderEpoch, err := r.ReadMessage(ctx, headers)
+		require.NoError(t, err)
+		require.Equal(t, exp, offset)
+		require.Equal(t, uint64(2), leaderEpoch)
+	}
+	_, _, _, _, err = r.ReadMessage(ctx, headers)
+	require.Equal(t, &ErrEndOfStream{LastOffset: 4}, err)
+	_, _, _, _, err = r.ReadMessage(ctx, headers)
+	require.Equal(t, &ErrEndOfStream{LastOffset: 4}, err)
+}
+
+// Ensure Channel delivers the read error and closes the channel when the end
+// of the stream is reached.
+func TestReaderChannelEndOfStream(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	appendToLog(t, l, []keyValue{{value: []byte("a")}}, true)
+	offset, err := l.CloseStream()
+	require.NoError(t, err)
+	l.SetHighWatermark(offset)
+
+	r, err := l.NewReader(0, false)
+	require.NoError(t, err)
+	ch := r.Channel(context.Background(), 0)
+	result := <-ch
+	require.NoError(t, result.Err)
+	require.Equal(t, int64(0), result.Offset)
+	result = <-ch
+	require.Equal(t, io.EOF, result.Err)
+	_, ok := <-ch
+	require.False(t, ok)
+}
+
+// Ensure creating a reader with a negative offset or an offset beyond the log
+// end offset returns a descriptive error.
+func TestReaderInvalidOffset(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	appendToLog(t, l, []keyValue{{value: []byte("a")}, {value: []byte("b")}}, true)
+
+	_, err := l.NewReader(-1, true)
+	require.Equal(t, ErrInvalidOffset, errors.Cause(err))
+	require.Contains(t, err.Error(), "offset -1 is negative")
+
+	_, err = l.NewReader(-1, false)
+	require.Equal(t, ErrInvalidOffset, errors.Cause(err))
+
+	// Offsets beyond the log end offset are out of range for both kinds of
+	// readers, but the log end offset itself is valid.
+	for _, uncommitted := range []bool{true, false} {
+		_, err = l.NewReader(3, uncommitted)
+		require.Equal(t, ErrOffsetOutOfRange, errors.Cause(err))
+		require.Contains(t, err.Error(), "offset 3 is outside of the log's offsets [0, 2]")
+
+		_, err = l.NewReader(2, uncommitted)
+		require.NoError(t, err)
+	}
+}
+
+// Ensure creating a reader with an offset preceding the log's first segment,
+// e.g. one removed by retention, returns ErrOffsetOutOfRange.
+func TestReaderOffsetBelowOldest(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1,
+		MaxLogMessages:  2,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	for i := 0; i < 5; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(4)
+	require.NoError(t, l.Clean())
+	oldest := l.OldestOffset()
+	require.True(t, oldest > 0)
+
+	for _, uncommitted := range []bool{true, false} {
+		_, err := l.NewReader(oldest-1, uncommitted)
+		require.Equal(t, ErrOffsetOutOfRange, errors.Cause(err))
+
+		r, err := l.NewReader(oldest, uncommitted)
+		require.NoError(t, err)
+		_, offset, _, _, err := r.ReadMessage(context.Background(), make([]byte, 28))
+		require.NoError(t, err)
+		require.Equal(t, oldest, offset)
+	}
+}
+
+// Ensure the reader returns transformed messages and ErrTransform when the
+// transform fails using the abort policy.
+func TestReaderTransformAbort(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	appendToLog(t, l, []keyValue{
+		{value: []byte("a")},
+		{value: []byte("bad")},
+		{value: []byte("c")},
+	}, true)
+
+	transformErr := errors.New("transform failed")
+	r, err := l.NewReaderWithOptions(0, ReaderOptions{
+		Transform: func(msg SerializedMessage) (SerializedMessage, error) {
+			if string(msg.Value()) == "bad" {
+				return nil, transformErr
+			}
+			return SerializedMessage(append([]byte("redacted-"), msg.Value()...)), nil
+		},
+	})
+	require.NoError(t, err)
+
+	headers := make([]byte, 28)
+	msg, offset, _, _, err := r.ReadMessage(context.Background(), headers)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), offset)
+	require.Equal(t, "redacted-a", string(msg))
+
+	_, _, _, _, err = r.ReadMessage(context.Background(), headers)
+	require.Equal(t, &ErrTransform{Offset: 1, Err: transformErr}, err)
+	require.Equal(t, transformErr, errors.Cause(err))
+
+	// Reads resume after the failed message.
+	msg, offset, _, _, err = r.ReadMessage(context.Background(), headers)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), offset)
+	require.Equal(t, "redacted-c", string(msg))
+}
+
+// Ensure the reader skips messages whose transform fails using the skip
+// policy.
+func TestReaderTransformSkip(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	appendToLog(t, l, []keyValue{
+		{value: []byte("a")},
+		{value: []byte("bad")},
+		{value: []byte("c")},
+	}, true)
+
+	r, err := l.NewReaderWithOptions(0, ReaderOptions{
+		Transform: func(msg SerializedMessage) (SerializedMessage, error) {
+			if string(msg.Value()) == "bad" {
+				return nil, errors.New("transform failed")
+			}
+			return msg, nil
+		},
+		TransformErrorPolicy: TransformSkip,
+	})
+	require.NoError(t, err)
+
+	headers := make([]byte, 28)
+	for _, expected := range []int64{0, 2} {
+		_, offset, _, _, err := r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+		require.Equal(t, expected, offset)
+	}
+}
+
+// corruptMessage flips the last byte of the message at the given offset on
+// disk so that its CRC no longer matches.
+func corruptMessage(t *testing.T, l *commitLog, offset int64) {
+	baseOffset, position, err := l.ResolveOffset(offset)
+	require.NoError(t, err)
+	seg := findSegmentByBaseOffset(l.Segments(), baseOffset)
+	header := make(messageSet, msgSetHeaderLen)
+	_, err = seg.ReadAt(header, position)
+	require.NoError(t, err)
+	pos := position + msgSetHeaderLen + int64(header.Size()) - 1
+	b := make([]byte, 1)
+	_, err = seg.ReadAt(b, pos)
+	require.NoError(t, err)
+
+	f, err := os.OpenFile(seg.logPath(), os.O_RDWR, 0666)
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = f.WriteAt([]byte{^b[0]}, pos)
+	require.NoError(t, err)
+}
+
+// Ensure messages failing their CRC check are routed to the DeadLetter
+// callback and the reader continues with the next message.
+func TestReaderDeadLetterCorrupt(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	appendToLog(t, l, []keyValue{
+		{value: []byte("a")},
+		{value: []byte("b")},
+		{value: []byte("c")},
+	}, true)
+	corruptMessage(t, l, 1)
+
+	var deadOffsets []int64
+	r, err := l.NewReaderWithOptions(0, ReaderOptions{
+		DeadLetter: func(raw []byte, offset int64, err error) {
+			require.Error(t, err)
+			deadOffsets = append(deadOffsets, offset)
+		},
+	})
+	require.NoError(t, err)
+
+	headers := make([]byte, 28)
+	for _, expected := range []int64{0, 2} {
+		_, offset, _, _, err := r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+		require.Equal(t, expected, offset)
+	}
+	require.Equal(t, []int64{1}, deadOffsets)
+
+	// Without a DeadLetter callback, corrupted data panics.
+	r, err = l.NewReader(1, false)
+	require.NoError(t, err)
+	require.Panics(t, func() {
+		r.ReadMessage(context.Background(), headers) // nolint: errcheck
+	})
+}
+
+// Ensure compressed batch frames which fail to decompress, whether corrupt or
+// decompressing to more than the batch size limit, are routed to the
+// DeadLetter callback as a whole and the reader continues with the next
+// message, and that their reads fail without a DeadLetter callback.
+func TestReaderDeadLetterCorruptBatch(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	appendToLog(t, l, []keyValue{{value: []byte("a")}}, false)
+	corrupt, _, err := newMessageSetFromProto(1, 0, []*Message{
+		{Attributes: AttrCompressed, Value: []byte("not deflate")},
+	})
+	require.NoError(t, err)
+	_, err = l.AppendMessageSet(corrupt)
+	require.NoError(t, err)
+	inner, _, err := newMessageSetFromProto(2, 0, []*Message{
+		{Value: make([]byte, minMaxBatchBytes)},
+	})
+	require.NoError(t, err)
+	bomb, _, err := compressMessageSet(inner, 0)
+	require.NoError(t, err)
+	_, err = l.AppendMessageSet(bomb)
+	require.NoError(t, err)
+	appendToLog(t, l, []keyValue{{value: []byte("d")}}, true)
+
+	// Batches the limit would reject can't be appended.
+	_, err = l.AppendCompressed([]*Message{{Value: make([]byte, minMaxBatchBytes)}})
+	require.Error(t, err)
+
+	var deadOffsets []int64
+	r, err := l.NewReaderWithOptions(0, ReaderOptions{
+		DeadLetter: func(raw []byte, offset int64, err error) {
+			require.Error(t, err)
+			deadOffsets = append(deadOffsets, offset)
+		},
+	})
+	require.NoError(t, err)
+
+	headers := make([]byte, 28)
+	for _, expected := range []int64{0, 3} {
+		_, offset, _, _, err := r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+		require.Equal(t, expected, offset)
+	}
+	require.Equal(t, []int64{1, 2}, deadOffsets)
+
+	for _, offset := range []int64{1, 2} {
+		r, err = l.NewReader(offset, false)
+		require.NoError(t, err)
+		_, _, _, _, err = r.ReadMessage(context.Background(), headers)
+		require.Error(t, err)
+	}
+}
+
+// Ensure messages whose transform fails are routed to the DeadLetter callback
+// and the reader continues with the next message.
+func TestReaderDeadLetterTransform(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	appendToLog(t, l, []keyValue{
+		{value: []byte("a")},
+		{value: []byte("bad")},
+		{value: []byte("c")},
+	}, true)
+
+	transformErr := errors.New("transform failed")
+	var dead []string
+	r, err := l.NewReaderWithOptions(0, ReaderOptions{
+		Transform: func(msg SerializedMessage) (SerializedMessage, error) {
+			if string(msg.Value()) == "bad" {
+				return nil, transformErr
+			}
+			return msg, nil
+		},
+		DeadLetter: func(raw []byte, offset int64, err error) {
+			require.Equal(t, &ErrTransform{Offset: offset, Err: transformErr}, err)
+			dead = append(dead, string(SerializedMessage(raw).Value()))
+		},
+	})
+	require.NoError(t, err)
+
+	headers := make([]byte, 28)
+	for _, expected := range []int64{0, 2} {
+		_, offset, _, _, err := r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+		require.Equal(t, expected, offset)
+	}
+	require.Equal(t, []string{"bad"}, dead)
+}
+
+// Ensure WaitUntil blocks until the reader has consumed past the given offset.
+func TestReaderWaitUntil(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	numMsgs := 10
+	for i := 0; i < numMsgs; i++ {
+		appendToLog(t, l, []keyValue{{value: []byte(strconv.Itoa(i))}}, true)
+	}
+
+	r, err := l.NewReader(0, false)
+	require.NoError(t, err)
+
+	// Times out if the reader has not consumed the offset.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	err = r.WaitUntil(ctx, 0)
+	cancel()
+	require.Equal(t, context.DeadlineExceeded, err)
+
+	done := make(chan error)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		done <- r.WaitUntil(ctx, 5)
+	}()
+
+	headers := make([]byte, 28)
+	for i := 0; i < numMsgs; i++ {
+		_, _, _, _, err := r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+	}
+	require.NoError(t, <-done)
+
+	// Returns immediately once the offset has been consumed.
+	require.NoError(t, r.WaitUntil(context.Background(), int64(numMsgs-1)))
+}
+
+// Ensure creating a reader rebuilds a corrupt segment index rather than
+// positioning the reader incorrectly.
+func TestReaderRebuildsCorruptIndex(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1000,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	numMsgs := 5
+	for i := 0; i < numMsgs; i++ {
+		appendToLog(t, l, []keyValue{{value: []byte(strconv.Itoa(i))}}, true)
+	}
+
+	// Corrupt the position of the third index entry.
+	seg := l.activeSegment()
+	copy(seg.Index.mmap[2*entryWidth+offsetWidth+timestampWidth:], []byte{0, 0, 0, 0})
+
+	for _, uncommitted := range []bool{true, false} {
+		r, err := l.NewReader(2, uncommitted)
+		require.NoError(t, err)
+		msg, offset, _, _, err := r.ReadMessage(context.Background(), make([]byte, 28))
+		require.NoError(t, err)
+		require.Equal(t, int64(2), offset)
+		require.Equal(t, []byte("2"), msg.Value())
+	}
+}
+
+// Ensure NewReaderEffective only returns surviving versions of each key up to
+// the HW, keeps keyless messages, and skips deleted keys.
+func TestReaderEffective(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	appendToLog(t, l, []keyValue{
+		{key: []byte("a"), value: []byte("1")},
+		{key: []byte("b"), value: []byte("1")},
+		{key: nil, value: []byte("keyless")},
+		{key: []byte("a"), value: []byte("2")},
+		{key: []byte("b"), value: nil},
+		{key: []byte("c"), value: []byte("1")},
+	}, true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	r, err := l.NewReaderEffective(ctx, 1, nil)
+	require.NoError(t, err)
+
+	appendToLog(t, l, []keyValue{
+		{key: []byte("c"), value: nil},
+	}, true)
+
+	expected := []struct {
+		offset int64
+		key    string
+		value  string
+	}{
+		{2, "", "keyless"},
+		{3, "a", "2"},
+		{5, "c", "1"},
+		{6, "c", ""},
+	}
+	headers := make([]byte, 28)
+	for _, exp := range expected {
+		msg, offset, _, _, err := r.ReadMessage(ctx, headers)
+		require.NoError(t, err)
+		require.Equal(t, exp.offset, offset)
+		require.Equal(t, exp.key, string(msg.Key()))
+		require.Equal(t, exp.value, string(msg.Value()))
+	}
+}
+
+// Ensure OnLockHold is invoked with the lock hold time of each read.
+func TestReaderOnLockHold(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	appendToLog(t, l, []keyValue{{value: []byte("a")}, {value: []byte("b")}}, true)
+
+	for _, uncommitted := range []bool{true, false} {
+		var holds []time.Duration
+		r, err := l.NewReaderWithOptions(0, ReaderOptions{
+			Uncommitted: uncommitted,
+			OnLockHold: func(d time.Duration) {
+				holds = append(holds, d)
+			},
+		})
+		require.NoError(t, err)
+
+		headers := make([]byte, 28)
+		for i := 0; i < 2; i++ {
+			_, _, _, _, err := r.ReadMessage(context.Background(), headers)
+			require.NoError(t, err)
+		}
+		// Each message is read with one read for the header's version and
+		// length bytes, one for the rest of the header, and one for the
+		// payload.
+		require.Len(t, holds, 6)
+		for _, d := range holds {
+			require.True(t, d >= 0)
+		}
+	}
+}
+
+func TestReaderZeroCopy(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 50,
+	})
+	defer cleanup()
+
+	numMsgs := 10
+	for i := 0; i < numMsgs; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(int64(numMsgs - 1))
+	segments := l.Segments()
+	require.True(t, len(segments) > 1)
+
+	for _, uncommitted := range []bool{true, false} {
+		r, err := l.NewReaderWithOptions(0, ReaderOptions{
+			Uncommitted: uncommitted,
+			ZeroCopy:    true,
+		})
+		require.NoError(t, err)
+
+		headers := make([]byte, 28)
+		for i := 0; i < numMsgs; i++ {
+			msg, offset, _, _, err := r.ReadMessage(context.Background(), headers)
+			require.NoError(t, err)
+			require.Equal(t, int64(i), offset)
+			require.Equal(t, []byte(strconv.Itoa(i)), msg.Value())
+
+			seg, _ := findSegment(segments, offset)
+			seg.RLock()
+			isView := false
+			for j := range seg.logMmap {
+				if &seg.logMmap[j] == &msg[0] {
+					isView = true
+					break
+				}
+			}
+			seg.RUnlock()
+			// Only messages in sealed segments are views.
+			require.Equal(t, seg != l.activeSegment(), isView)
+		}
+	}
+
+	require.NoError(t, l.Close())
+	for _, seg := range segments {
+		require.Nil(t, seg.logMmap)
+	}
+}
+
+func TestReaderCompressedBatch(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1024,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	var batch []*Message
+	for i := 0; i < 5; i++ {
+		batch = append(batch, &Message{
+			Key:       []byte(strconv.Itoa(i)),
+			Value:     []byte("hello world"),
+			Headers:   map[string][]byte{"i": []byte(strconv.Itoa(i))},
+			Timestamp: int64(i + 1),
+		})
+	}
+	offsets, err := l.AppendCompressed(batch)
+	require.NoError(t, err)
+	require.Equal(t, []int64{0, 1, 2, 3, 4}, offsets)
+	_, err = l.Append([]*Message{{Value: []byte("plain"), Timestamp: 6}})
+	require.NoError(t, err)
+	offsets, err = l.AppendCompressed([]*Message{{Value: []byte("last"), Timestamp: 7}})
+	require.NoError(t, err)
+	require.Equal(t, []int64{6}, offsets)
+	require.Equal(t, int64(6), l.NewestOffset())
+	l.SetHighWatermark(6)
+
+	for _, uncommitted := range []bool{true, false} {
+		for _, start := range []int64{0, 2} {
+			r, err := l.NewReader(start, uncommitted)
+			require.NoError(t, err)
+			headers := make([]byte, 28)
+			for i := start; i < 7; i++ {
+				msg, offset, timestamp, _, err := r.ReadMessage(context.Background(), headers)
+				require.NoError(t, err)
+				require.Equal(t, i, offset)
+				require.Equal(t, i+1, timestamp)
+				require.False(t, msg.IsCompressed())
+				switch {
+				case i < 5:
+					require.Equal(t, []byte(strconv.Itoa(int(i))), msg.Key())
+					require.Equal(t, []byte("hello world"), msg.Value())
+					require.Equal(t, map[string][]byte{"i": []byte(strconv.Itoa(int(i)))}, msg.Headers())
+				case i == 5:
+					require.Equal(t, []byte("plain"), msg.Value())
+				default:
+					require.Equal(t, []byte("last"), msg.Value())
+				}
+			}
+		}
+	}
+}
+
+func TestAppendCompressedEmpty(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1024,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	_, err := l.AppendCompressed(nil)
+	require.Error(t, err)
+}
+
+// Ensure messages expanded from a compressed batch frame are returned with
+// their own message set headers so that they can be replicated like any other
+// message by writing the headers followed by the message.
+func TestReaderCompressedBatchReplication(t *testing.T) {
+	leader, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1024,
+	})
+	defer cleanup()
+	defer leader.Close()
+	follower, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1024,
+	})
+	defer cleanup()
+	defer follower.Close()
+
+	_, err := leader.Append([]*Message{{Value: []byte("0"), Timestamp: 1, LeaderEpoch: 1}})
+	require.NoError(t, err)
+	_, err = leader.AppendCompressed([]*Message{
+		{Value: []byte("1"), Timestamp: 2, LeaderEpoch: 1},
+		{Value: []byte("2"), Timestamp: 3, LeaderEpoch: 1},
+		{Value: []byte("3"), Timestamp: 4, LeaderEpoch: 1},
+	})
+	require.NoError(t, err)
+
+	r, err := leader.NewReader(0, true)
+	require.NoError(t, err)
+	var (
+		headers = make([]byte, 28)
+		ms      []byte
+	)
+	for i := int64(0); i < 4; i++ {
+		msg, offset, timestamp, leaderEpoch, err := r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+		require.Equal(t, i, offset)
+		require.Equal(t, offset, int64(encoding.Uint64(headers[offsetPos:])))
+		require.Equal(t, timestamp, int64(encoding.Uint64(headers[timestampPos:])))
+		require.Equal(t, leaderEpoch, encoding.Uint64(headers[leaderEpochPos:]))
+		require.Equal(t, uint32(len(msg)), encoding.Uint32(headers[sizePos:]))
+		ms = append(ms, headers...)
+		ms = append(ms, msg...)
+	}
+
+	offsets, err := follower.AppendMessageSet(ms)
+	require.NoError(t, err)
+	require.Equal(t, []int64{0, 1, 2, 3}, offsets)
+
+	r, err = follower.NewReader(0, true)
+	require.NoError(t, err)
+	for i := int64(0); i < 4; i++ {
+		msg, offset, timestamp, leaderEpoch, err := r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+		require.Equal(t, i, offset)
+		require.Equal(t, i+1, timestamp)
+		require.Equal(t, uint64(1), leaderEpoch)
+		require.Equal(t, []byte(strconv.FormatInt(i, 10)), msg.Value())
+	}
+}
+
+func TestReaderTotalDeadline(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	appendToLog(t, l, []keyValue{{value: []byte("a")}, {value: []byte("b")}}, true)
+
+	for _, uncommitted := range []bool{true, false} {
+		r, err := l.NewReaderWithOptions(0, ReaderOptions{
+			Uncommitted:   uncommitted,
+			TotalDeadline: time.Now().Add(100 * time.Millisecond),
+		})
+		require.NoError(t, err)
+
+		headers := make([]byte, 28)
+		_, offset, _, _, err := r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+		require.Equal(t, int64(0), offset)
+		_, offset, _, _, err = r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+		require.Equal(t, int64(1), offset)
+
+		// Waiting for data stops once the deadline passes.
+		_, _, _, _, err = r.ReadMessage(context.Background(), headers)
+		require.Equal(t, ErrDeadlineExceeded, err)
+	}
+
+	// Messages left to read are not returned once the deadline passes.
+	r, err := l.NewReaderWithOptions(0, ReaderOptions{
+		TotalDeadline: time.Now().Add(-time.Second),
+	})
+	require.NoError(t, err)
+	_, _, _, _, err = r.ReadMessage(context.Background(), make([]byte, 28))
+	require.Equal(t, ErrDeadlineExceeded, err)
+}
+
+func TestReaderReadVectored(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 50,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	numMsgs := 6
+	for i := 0; i < numMsgs; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(3)
+	segments := l.Segments()
+	require.True(t, len(segments) > 1)
+
+	// Gather the raw message sets up to the HW across segments.
+	var expected []byte
+	for _, seg := range segments {
+		data := make([]byte, seg.Position())
+		_, err := seg.ReadAt(data, 0)
+		require.NoError(t, err)
+		expected = append(expected, data...)
+	}
+	hwIdx, hwPos, err := getHWPos(segments, 3)
+	require.NoError(t, err)
+	committed := 0
+	for _, seg := range segments[:hwIdx] {
+		committed += int(seg.Position())
+	}
+	committed += int(hwPos)
+	expected = expected[:committed]
+
+	r, err := l.NewReader(0, false)
+	require.NoError(t, err)
+	bufs := [][]byte{
+		make([]byte, 10),
+		make([]byte, committed-20),
+		make([]byte, 10),
+	}
+	n, err := r.ReadVectored(context.Background(), bufs)
+	require.NoError(t, err)
+	require.Equal(t, committed, n)
+	var actual []byte
+	for _, buf := range bufs {
+		actual = append(actual, buf...)
+	}
+	require.Equal(t, expected, actual)
+
+	// Reading past the HW blocks until the context is canceled.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	n, err = r.ReadVectored(ctx, [][]byte{make([]byte, 10)})
+	require.Equal(t, context.DeadlineExceeded, err)
+	require.Equal(t, 0, n)
+}
+
+func TestReaderResetOnLoss(t *testing.T) {
+	type reset struct{ from, to int64 }
+	for _, uncommitted := range []bool{true, false} {
+		l, cleanup := setupWithOptions(t, Options{
+			Path:            tempDir(t),
+			MaxSegmentBytes: 100,
+		})
+		for i := 0; i < 10; i++ {
+			_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+			require.NoError(t, err)
+		}
+		l.SetHighWatermark(9)
+		require.True(t, len(l.Segments()) > 2)
+
+		var resets []reset
+		r, err := l.NewReaderWithOptions(0, ReaderOptions{
+			Uncommitted: uncommitted,
+			ResetOnLoss: true,
+			OnReset: func(from, to int64) {
+				resets = append(resets, reset{from, to})
+			},
+		})
+		require.NoError(t, err)
+		headers := make([]byte, 28)
+		_, _, _, _, err = r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+
+		// Retain only the active segment and the one before it.
+		segments := l.Segments()
+		limit := segments[len(segments)-1].Position() + segments[len(segments)-2].Position()
+		_, err = l.EnforceRetention(0, limit)
+		require.NoError(t, err)
+		oldest := l.OldestOffset()
+		require.True(t, oldest > 1)
+
+		// The reader resets to the oldest offset rather than failing.
+		_, offset, _, _, err := r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+		require.Equal(t, oldest, offset)
+		require.Equal(t, []reset{{1, oldest}}, resets)
+
+		require.NoError(t, l.Close())
+		cleanup()
+	}
+}
+
+func TestReaderSnapshot(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 50,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	// An empty log has nothing in the snapshot.
+	r, err := l.NewReaderWithOptions(0, ReaderOptions{Snapshot: true})
+	require.NoError(t, err)
+	headers := make([]byte, 28)
+	_, _, _, _, err = r.ReadMessage(context.Background(), headers)
+	require.Equal(t, &ErrEndOfStream{LastOffset: -1}, err)
+
+	for i := 0; i < 6; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(3)
+
+	r, err = l.NewReaderWithOptions(0, ReaderOptions{Snapshot: true})
+	require.NoError(t, err)
+
+	// Advancing the log does not extend the snapshot.
+	l.SetHighWatermark(5)
+	for i := 0; i < 5; i++ {
+		_, err := l.Append([]*Message{{Value: []byte("new")}})
+		require.NoError(t, err)
+	}
+
+	for i := 0; i < 4; i++ {
+		msg, offset, _, _, err := r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+		require.Equal(t, int64(i), offset)
+		require.Equal(t, []byte(strconv.Itoa(i)), msg.Value())
+	}
+	_, _, _, _, err = r.ReadMessage(context.Background(), headers)
+	require.Equal(t, &ErrEndOfStream{LastOffset: 3}, err)
+	_, _, _, _, err = r.ReadMessage(context.Background(), headers)
+	require.Equal(t, io.EOF, errors.Cause(err))
+}
+
+// Ensure BatchChannel delivers batches once they reach BatchBytes or
+// BatchLinger elapses and flushes the partial batch on end of stream.
+func TestReaderBatchChannel(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1000,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	for i := 0; i < 5; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(4)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Each message is its own batch by default.
+	r, err := l.NewReader(0, false)
+	require.NoError(t, err)
+	ch := r.BatchChannel(ctx, 0)
+	for i := 0; i < 5; i++ {
+		batch := <-ch
+		require.NoError(t, batch.Err)
+		require.Len(t, batch.Messages, 1)
+		require.Equal(t, int64(i), batch.Messages[0].Offset)
+	}
+
+	// Batches by size are delivered once they reach BatchBytes, with the
+	// remainder delivered once the linger elapses.
+	size := len(readMessageAt(t, l, 0))
+	r, err = l.NewReaderWithOptions(0, ReaderOptions{
+		BatchBytes:  2 * size,
+		BatchLinger: 50 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	ch = r.BatchChannel(ctx, 0)
+	for _, expected := range [][]int64{{0, 1}, {2, 3}, {4}} {
+		batch := <-ch
+		require.NoError(t, batch.Err)
+		offsets := []int64{}
+		for _, result := range batch.Messages {
+			offsets = append(offsets, result.Offset)
+		}
+		require.Equal(t, expected, offsets)
+	}
+
+	// The partial batch is flushed along with the end of stream.
+	_, err = l.Append([]*Message{{Value: []byte("5")}})
+	require.NoError(t, err)
+	offset, err := l.CloseStream()
+	require.NoError(t, err)
+	l.SetHighWatermark(offset)
+	batch := <-ch
+	require.Equal(t, io.EOF, batch.Err)
+	require.Len(t, batch.Messages, 1)
+	require.Equal(t, int64(5), batch.Messages[0].Offset)
+	_, ok := <-ch
+	require.False(t, ok)
+}
+
+// Ensure BatchChannel delivers the partial batch when the context is canceled
+// while the batch is lingering.
+func TestReaderBatchChannelCancel(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1000,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	for i := 0; i < 2; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, err := l.NewReaderWithOptions(0, ReaderOptions{
+		BatchBytes:  1 << 20,
+		BatchLinger: time.Hour,
+	})
+	require.NoError(t, err)
+	ch := r.BatchChannel(ctx, 0)
+
+	// Wait for both messages to be read and the reader to wait for more.
+	deadline := time.Now().Add(5 * time.Second)
+	for l.Stats().HWWaiters == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	require.Equal(t, 1, l.Stats().HWWaiters)
+	cancel()
+
+	batch := <-ch
+	require.Equal(t, context.Canceled, batch.Err)
+	require.Len(t, batch.Messages, 2)
+	require.Equal(t, int64(0), batch.Messages[0].Offset)
+	require.Equal(t, int64(1), batch.Messages[1].Offset)
+	_, ok := <-ch
+	require.False(t, ok)
+}
+
+func readMessageAt(t *testing.T, l *commitLog, offset int64) SerializedMessage {
+	r, err := l.NewReader(offset, true)
+	require.NoError(t, err)
+	msg, _, _, _, err := r.ReadMessage(context.Background(), make([]byte, 28))
+	require.NoError(t, err)
+	return msg
+}
+
+// Ensure CurrentSegmentModTime reports when the segment being read was last
+// written to.
+func TestReaderCurrentSegmentModTime(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	// A committed reader on an empty log is not positioned in a segment.
+	r, err := l.NewReader(0, false)
+	require.NoError(t, err)
+	require.True(t, r.CurrentSegmentModTime().IsZero())
+
+	before := time.Now()
+	for i := 0; i < 10; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(9)
+	require.True(t, len(l.Segments()) > 1)
+
+	r, err = l.NewReader(0, false)
+	require.NoError(t, err)
+	headers := make([]byte, 28)
+	_, _, _, _, err = r.ReadMessage(context.Background(), headers)
+	require.NoError(t, err)
+	first := r.CurrentSegmentModTime()
+	require.False(t, first.Before(before))
+	require.Equal(t, l.Segments()[0].ModTime(), first)
+
+	r, err = l.NewReader(9, true)
+	require.NoError(t, err)
+	active := l.activeSegment()
+	require.Equal(t, active.ModTime(), r.CurrentSegmentModTime())
+	require.False(t, active.ModTime().Before(first))
+}
+
+// Ensures Progress reports the fraction of messages read up to the HW at the
+// time the Reader was created.
+func TestReaderProgress(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	for i := 0; i < 10; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(9)
+
+	r, err := l.NewReader(5, false)
+	require.NoError(t, err)
+	require.Equal(t, float64(0), r.Progress())
+	headers := make([]byte, 28)
+	for i := 1; i <= 5; i++ {
+		_, _, _, _, err := r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+		require.InDelta(t, float64(i)/5, r.Progress(), 0.0001)
+	}
+
+	// Progress is relative to the HW at creation, so it stays at 1.
+	_, err = l.Append([]*Message{{Value: []byte("10")}})
+	require.NoError(t, err)
+	l.SetHighWatermark(10)
+	_, _, _, _, err = r.ReadMessage(context.Background(), headers)
+	require.NoError(t, err)
+	require.Equal(t, float64(1), r.Progress())
+
+	// A Reader starting past the HW has nothing to backfill.
+	r, err = l.NewReader(11, false)
+	require.NoError(t, err)
+	require.Equal(t, float64(1), r.Progress())
+}
+
+// Ensures SkipToNextSegment positions the Reader at the base offset of the
+// following segment and errors once there are no more segments.
+func TestReaderSkipToNextSegment(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	numMsgs := 10
+	for i := 0; i < numMsgs; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(int64(numMsgs - 1))
+	segments := l.Segments()
+	require.True(t, len(segments) > 2)
+
+	r, err := l.NewReader(0, false)
+	require.NoError(t, err)
+	headers := make([]byte, 28)
+	_, _, _, _, err = r.ReadMessage(context.Background(), headers)
+	require.NoError(t, err)
+
+	for _, seg := range segments[1:] {
+		base, err := r.SkipToNextSegment()
+		require.NoError(t, err)
+		require.Equal(t, seg.BaseOffset, base)
+		msg, offset, _, _, err := r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+		require.Equal(t, seg.BaseOffset, offset)
+		require.Equal(t, []byte(strconv.Itoa(int(offset))), msg.Value())
+	}
+
+	_, err = r.SkipToNextSegment()
+	require.Equal(t, ErrSegmentNotFound, errors.Cause(err))
+}
+
+// Ensures LastPosition returns the segment and position of the last message
+// read, matching the log's index, for both committed and uncommitted readers.
+func TestReaderLastPosition(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	numMsgs := 10
+	for i := 0; i < numMsgs; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(int64(numMsgs - 1))
+
+	for _, uncommitted := range []bool{false, true} {
+		r, err := l.NewReader(0, uncommitted)
+		require.NoError(t, err)
+		base, pos := r.LastPosition()
+		require.Equal(t, int64(-1), base)
+		require.Equal(t, int64(-1), pos)
+		headers := make([]byte, 28)
+		for i := 0; i < numMsgs; i++ {
+			_, offset, _, _, err := r.ReadMessage(context.Background(), headers)
+			require.NoError(t, err)
+			expectedBase, expectedPos, err := l.ResolveOffset(offset)
+			require.NoError(t, err)
+			base, pos := r.LastPosition()
+			require.Equal(t, expectedBase, base)
+			require.Equal(t, expectedPos, pos)
+		}
+	}
+}
+
+// Ensure FillGaps returns placeholders for offsets missing from the log and
+// refuses to fill gaps larger than MaxGapFill.
+func TestReaderFillGaps(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1000,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	appendAt := func(offset int64, value string) {
+		ms, _, err := newMessageSetFromProto(offset, 0, []*Message{{Value: []byte(value)}})
+		require.NoError(t, err)
+		_, err = l.AppendMessageSet(ms)
+		require.NoError(t, err)
+	}
+	appendAt(0, "0")
+	appendAt(3, "3")
+	appendAt(10, "10")
+	l.SetHighWatermark(10)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	headers := make([]byte, 28)
+	r, err := l.NewReaderWithOptions(0, ReaderOptions{
+		FillGaps: true,
+		PlaceholderFn: func(offset int64) *Message {
+			return &Message{Value: []byte("gap"), Timestamp: offset}
+		},
+		MaxGapFill: 5,
+	})
+	require.NoError(t, err)
+	for i := int64(0); i < 4; i++ {
+		msg, offset, timestamp, _, err := r.ReadMessage(ctx, headers)
+		require.NoError(t, err)
+		require.Equal(t, i, offset)
+		if i == 1 || i == 2 {
+			require.True(t, msg.HasAttributes(AttrPlaceholder))
+			require.Equal(t, []byte("gap"), msg.Value())
+			require.Equal(t, i, timestamp)
+		} else {
+			require.False(t, msg.HasAttributes(AttrPlaceholder))
+			require.Equal(t, []byte(strconv.FormatInt(i, 10)), msg.Value())
+		}
+	}
+
+	// The gap before offset 10 exceeds the cap, so it is reported once and
+	// the message following it is then returned.
+	_, _, _, _, err = r.ReadMessage(ctx, headers)
+	require.Equal(t, ErrGapTooLarge, errors.Cause(err))
+	msg, offset, _, _, err := r.ReadMessage(ctx, headers)
+	require.NoError(t, err)
+	require.Equal(t, int64(10), offset)
+	require.Equal(t, []byte("10"), msg.Value())
+
+	// Without FillGaps, gaps are skipped.
+	r, err = l.NewReader(1, false)
+	require.NoError(t, err)
+	_, offset, _, _, err = r.ReadMessage(ctx, headers)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), offset)
+}
+
+// Ensure uncommitted readers racing with appends never read torn messages.
+func TestReaderUncommittedConcurrentAppend(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 4096,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	numBatches := 500
+	go func() {
+		offset := 0
+		for i := 0; i < numBatches; i++ {
+			batch := make([]*Message, i%5+1)
+			for j := range batch {
+				batch[j] = &Message{Value: bytes.Repeat([]byte(strconv.Itoa(offset)), offset%50+1)}
+				offset++
+			}
+			if _, err := l.Append(batch); err != nil {
+				panic(err)
+			}
+		}
+	}()
+
+	var numMsgs int64
+	for i := 0; i < numBatches; i++ {
+		numMsgs += int64(i%5 + 1)
+	}
+	r, err := l.NewReaderWithOptions(0, ReaderOptions{
+		Uncommitted: true,
+		DeadLetter: func(raw []byte, offset int64, err error) {
+			panic(fmt.Sprintf("torn message at offset %d: %v", offset, err))
+		},
+	})
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	headers := make([]byte, 28)
+	for i := int64(0); i < numMsgs; i++ {
+		msg, offset, _, _, err := r.ReadMessage(ctx, headers)
+		require.NoError(t, err)
+		require.Equal(t, i, offset)
+		require.Equal(t, bytes.Repeat([]byte(strconv.FormatInt(i, 10)), int(i%50+1)), msg.Value())
+	}
+}
+
+// Ensure OnClose is invoked once with the last returned offset when the
+// Reader is closed or a read's context is canceled.
+func TestReaderOnClose(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	for i := 0; i < 3; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(2)
+
+	var closed []int64
+	onClose := func(lastOffset int64) {
+		closed = append(closed, lastOffset)
+	}
+	headers := make([]byte, 28)
+
+	// Closing a Reader which has not returned anything reports the offset
+	// preceding its start.
+	r, err := l.NewReaderWithOptions(1, ReaderOptions{OnClose: onClose})
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Equal(t, []int64{0}, closed)
+
+	// Canceling the context of a read waiting for data reports the last
+	// returned offset, and closing afterwards does not report again.
+	closed = nil
+	r, err = l.NewReaderWithOptions(0, ReaderOptions{OnClose: onClose})
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, _, _, _, err := r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, _, _, _, err = r.ReadMessage(ctx, headers)
+	require.Error(t, err)
+	require.Equal(t, []int64{2}, closed)
+	require.NoError(t, r.Close())
+	require.Equal(t, []int64{2}, closed)
+}
+
+// Ensure Readers with an EOF grace period return io.EOF once no data arrives
+// within the period and resume reading when more data is committed.
+func TestReaderEOFGrace(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	_, err := l.Append([]*Message{{Value: []byte("a")}})
+	require.NoError(t, err)
+	l.SetHighWatermark(0)
+
+	r, err := l.NewReaderWithOptions(0, ReaderOptions{EOFGrace: 20 * time.Millisecond})
+	require.NoError(t, err)
+	headers := make([]byte, 28)
+	_, offset, _, _, err := r.ReadMessage(context.Background(), headers)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), offset)
+
+	start := time.Now()
+	_, _, _, _, err = r.ReadMessage(context.Background(), headers)
+	require.Equal(t, io.EOF, err)
+	require.True(t, time.Since(start) >= 20*time.Millisecond)
+
+	// Data committed within the grace period is returned.
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		l.Append([]*Message{{Value: []byte("b")}})
+		l.SetHighWatermark(1)
+	}()
+	r.opts.EOFGrace = 5 * time.Second
+	_, offset, _, _, err = r.ReadMessage(context.Background(), headers)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), offset)
+
+	// A canceled context is still reported as such.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, _, _, err = r.ReadMessage(ctx, headers)
+	require.Error(t, err)
+	require.NotEqual(t, io.EOF, err)
+}
@@ -0,0 +1,73 @@
+package commitlog
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const defaultRetryBackoff = 10 * time.Millisecond
+
+// RetryPolicy determines how a Reader retries transient errors reading
+// segments, e.g. momentary failures of networked storage. Errors are
+// transient if their cause is ErrTemporary or implements a Temporary method
+// which returns true, as net.Error does. Other errors, such as corruption, are
+// returned immediately.
+type RetryPolicy struct {
+	// MaxRetries is the number of times a failed read is retried before its
+	// error is returned. Zero disables retries.
+	MaxRetries int
+
+	// Backoff is the time to wait before the first retry, which doubles with
+	// each subsequent retry. Defaults to 10ms.
+	Backoff time.Duration
+
+	// MaxBackoff, if positive, caps the time to wait between retries.
+	MaxBackoff time.Duration
+}
+
+// isTemporary indicates if the error is transient and can be retried.
+func isTemporary(err error) bool {
+	cause := errors.Cause(err)
+	if cause == ErrTemporary {
+		return true
+	}
+	temp, ok := cause.(interface{ Temporary() bool })
+	return ok && temp.Temporary()
+}
+
+// readAt reads from r at the given offset, retrying transient errors
+// according to the policy. Retries stop early if the context is canceled.
+func (p RetryPolicy) readAt(ctx context.Context, r io.ReaderAt, b []byte, off int64) (int, error) {
+	backoff := p.Backoff
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+	for retries := 0; ; retries++ {
+		n, err := r.ReadAt(b, off)
+		if err == nil || err == io.EOF || !isTemporary(err) {
+			return n, err
+		}
+		if n > 0 {
+			// Return the partial read so the caller reads the rest,
+			// retrying from where it left off.
+			return n, nil
+		}
+		if retries >= p.MaxRetries {
+			return n, err
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return n, err
+		}
+		backoff *= 2
+		if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package commitlog
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	pkgErrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyReaderAt returns err from its first failures reads.
+type flakyReaderAt struct {
+	data     []byte
+	failures int
+	err      error
+	reads    int
+}
+
+func (f *flakyReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	f.reads++
+	if f.failures > 0 {
+		f.failures--
+		return 0, f.err
+	}
+	return bytes.NewReader(f.data).ReadAt(p, off)
+}
+
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "blip" }
+func (temporaryError) Temporary() bool { return true }
+
+// Ensure transient errors are retried up to the policy's limit while other
+// errors are returned immediately.
+func TestRetryPolicyReadAt(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 3, Backoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+	buf := make([]byte, 3)
+
+	for _, err := range []error{pkgErrors.Wrap(ErrTemporary, "read failed"), temporaryError{}} {
+		r := &flakyReaderAt{data: []byte("abcdef"), failures: 3, err: err}
+		n, readErr := policy.readAt(context.Background(), r, buf, 2)
+		require.NoError(t, readErr)
+		require.Equal(t, 3, n)
+		require.Equal(t, []byte("cde"), buf)
+		require.Equal(t, 4, r.reads)
+	}
+
+	// Transient errors are returned once retries are exhausted.
+	r := &flakyReaderAt{data: []byte("abcdef"), failures: 4, err: ErrTemporary}
+	_, err := policy.readAt(context.Background(), r, buf, 0)
+	require.Equal(t, ErrTemporary, err)
+	require.Equal(t, 4, r.reads)
+
+	// Permanent errors are not retried.
+	permanent := errors.New("corrupt")
+	r = &flakyReaderAt{data: []byte("abcdef"), failures: 1, err: permanent}
+	_, err = policy.readAt(context.Background(), r, buf, 0)
+	require.Equal(t, permanent, err)
+	require.Equal(t, 1, r.reads)
+
+	// Retries stop once the context is canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r = &flakyReaderAt{data: []byte("abcdef"), failures: 2, err: ErrTemporary}
+	_, err = policy.readAt(ctx, r, buf, 0)
+	require.Equal(t, ErrTemporary, err)
+	require.Equal(t, 1, r.reads)
+}
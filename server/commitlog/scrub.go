@@ -0,0 +1,111 @@
+package commitlog
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// ScrubResult summarizes a scrub of the log.
+type ScrubResult struct {
+	// Scanned is the number of messages scanned.
+	Scanned int64
+
+	// Bad is the number of messages which failed verification.
+	Bad int64
+}
+
+// Scrub reads every committed message in the log and verifies that its
+// message set header is consistent, i.e. that offsets increase monotonically
+// and the size fits within the segment, and that its CRC matches. Problems
+// are reported to fn with the offset of the message, or for headers which
+// cannot be trusted, the offset of the last good message, and scrubbing
+// continues with the next message. If a message size is out of bounds, the
+// remainder of its segment cannot be framed and is skipped. This returns a
+// summary of the scrub along with an error if the context is canceled or the
+// log cannot be read.
+func (l *commitLog) Scrub(ctx context.Context, fn func(offset int64, err error)) (ScrubResult, error) {
+	var (
+		result   ScrubResult
+		segments = l.Segments()
+		buf      = make(messageSet, maxMsgSetHeaderLen)
+		hw       = l.HighWatermark()
+		last     = int64(-1)
+	)
+	if newest := l.NewestOffset(); hw > newest {
+		hw = newest
+	}
+	if hw < 0 {
+		return result, nil
+	}
+	hwIdx, hwPos, err := getHWPos(segments, hw)
+	if err != nil {
+		return result, errors.Wrap(err, "failed to find HW position")
+	}
+	for i, seg := range segments[:hwIdx+1] {
+		var (
+			position = int64(0)
+			end      = seg.Position()
+		)
+		if i == hwIdx {
+			end = hwPos
+		}
+		for position < end {
+			if err := ctx.Err(); err != nil {
+				return result, err
+			}
+			result.Scanned++
+			header, err := readHeaderAt(seg, buf, position)
+			if err == errInvalidHeader {
+				result.Bad++
+				fn(last, errors.Errorf("invalid message set header at position %d in segment %d",
+					position, seg.BaseOffset))
+				break
+			}
+			if err == io.ErrUnexpectedEOF || (err == nil && end-position < int64(len(header))) {
+				result.Bad++
+				fn(last, errors.Errorf("truncated message set header at position %d in segment %d",
+					position, seg.BaseOffset))
+				break
+			}
+			if err != nil {
+				return result, errors.Wrap(err, "failed to read message header")
+			}
+			var (
+				headerLen = int64(len(header))
+				offset    = header.Offset()
+				size      = int64(header.Size())
+			)
+			if size < 0 || position+headerLen+size > end {
+				result.Bad++
+				fn(last, errors.Errorf("message size %d at position %d out of bounds for segment %d",
+					size, position, seg.BaseOffset))
+				break
+			}
+			position += headerLen + size
+			if offset <= last || offset < seg.BaseOffset {
+				result.Bad++
+				fn(offset, errors.Errorf("offset %d out of order after offset %d in segment %d",
+					offset, last, seg.BaseOffset))
+				continue
+			}
+			last = offset
+			if size < 4 {
+				// The message is too small to contain its CRC.
+				result.Bad++
+				fn(offset, errors.Errorf("message size %d too small", size))
+				continue
+			}
+			msg := make(SerializedMessage, size)
+			if _, err := seg.ReadAt(msg, position-size); err != nil {
+				return result, errors.Wrap(err, "failed to read message")
+			}
+			if err := msg.checkCRC(); err != nil {
+				result.Bad++
+				fn(offset, err)
+			}
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,93 @@
+package commitlog
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Ensure Scrub scans every committed message and reports each corrupt message
+// without stopping.
+func TestScrub(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	noop := func(int64, error) {}
+	result, err := l.Scrub(context.Background(), noop)
+	require.NoError(t, err)
+	require.Equal(t, ScrubResult{}, result)
+
+	numMsgs := 10
+	for i := 0; i < numMsgs; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(7)
+	require.True(t, len(l.Segments()) > 1)
+
+	result, err = l.Scrub(context.Background(), noop)
+	require.NoError(t, err)
+	require.Equal(t, ScrubResult{Scanned: 8}, result)
+
+	corruptMessage(t, l, 2)
+	corruptMessage(t, l, 5)
+	// Uncommitted messages are not scrubbed.
+	corruptMessage(t, l, 9)
+
+	var bad []int64
+	result, err = l.Scrub(context.Background(), func(offset int64, err error) {
+		require.Error(t, err)
+		bad = append(bad, offset)
+	})
+	require.NoError(t, err)
+	require.Equal(t, ScrubResult{Scanned: 8, Bad: 2}, result)
+	require.Equal(t, []int64{2, 5}, bad)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = l.Scrub(ctx, noop)
+	require.Equal(t, context.Canceled, err)
+}
+
+// Ensure Scrub reports messages whose size is out of bounds and skips the
+// remainder of their segment.
+func TestScrubBadSize(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1000,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	numMsgs := 5
+	for i := 0; i < numMsgs; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(int64(numMsgs - 1))
+
+	// Overwrite the size of the message at offset 1.
+	_, position, err := l.ResolveOffset(1)
+	require.NoError(t, err)
+	seg := l.Segments()[0]
+	f, err := os.OpenFile(seg.logPath(), os.O_RDWR, 0666)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte{0x7f, 0xff, 0xff, 0xff}, position+sizePos)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	var bad []int64
+	result, err := l.Scrub(context.Background(), func(offset int64, err error) {
+		bad = append(bad, offset)
+	})
+	require.NoError(t, err)
+	require.Equal(t, ScrubResult{Scanned: 2, Bad: 1}, result)
+	require.Equal(t, []int64{0}, bad)
+}
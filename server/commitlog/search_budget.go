@@ -0,0 +1,54 @@
+package commitlog
+
+// SearchBudget bounds the work done by an explicit lookup, such as
+// ResolveOffsetWithBudget, to protect latency-sensitive callers from
+// pathological searches of a large log. Lookups which cannot complete within
+// the budget return ErrSearchBudgetExceeded. A zero field is unlimited.
+type SearchBudget struct {
+	MaxSegments int // Maximum number of segment indexes searched
+	MaxEntries  int // Maximum number of index entries read
+}
+
+// searchBudget tracks the segments and index entries examined by a lookup
+// against its SearchBudget. A nil searchBudget is unlimited.
+type searchBudget struct {
+	SearchBudget
+	segments int
+	entries  int
+	over     bool
+}
+
+func newSearchBudget(budget SearchBudget) *searchBudget {
+	return &searchBudget{SearchBudget: budget}
+}
+
+// segment charges the search of a segment's index to the budget and returns
+// false if this exceeds it.
+func (b *searchBudget) segment() bool {
+	if b == nil {
+		return true
+	}
+	b.segments++
+	if b.MaxSegments > 0 && b.segments > b.MaxSegments {
+		b.over = true
+	}
+	return !b.over
+}
+
+// entry charges the read of an index entry to the budget and returns false if
+// this exceeds it.
+func (b *searchBudget) entry() bool {
+	if b == nil {
+		return true
+	}
+	b.entries++
+	if b.MaxEntries > 0 && b.entries > b.MaxEntries {
+		b.over = true
+	}
+	return !b.over
+}
+
+// exceeded indicates if the budget has been exceeded.
+func (b *searchBudget) exceeded() bool {
+	return b != nil && b.over
+}
@@ -0,0 +1,65 @@
+package commitlog
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// SeekBy repositions the Reader at the first offset in the log which is not
+// less than target according to less, returning that offset. This
+// generalizes seeking to an offset to orderings other than the natural one,
+// e.g. a derived key looked up in a side index, where less(offset, target)
+// reports if the key of the message at offset orders before target. The
+// offsets in the log must be ordered consistently with less, since the
+// segments and their indexes are binary searched. If less is nil, offsets are
+// compared naturally. If every offset is less than target, the Reader is
+// positioned at the end of the log. The context is checked between
+// comparisons, so a long search can be canceled.
+func (r *Reader) SeekBy(ctx context.Context, target int64, less func(a, b int64) bool) (int64, error) {
+	if less == nil {
+		less = func(a, b int64) bool { return a < b }
+	}
+	pred := func(offset int64) bool {
+		// Once canceled, end the search as quickly as possible.
+		return ctx.Err() != nil || !less(offset, target)
+	}
+	offset := r.log.NewestOffset() + 1
+	if seg := findSegmentBy(r.log.Segments(), pred); seg != nil {
+		e, err := seg.findEntryBy(pred)
+		if err != nil && err != ErrEntryNotFound {
+			return 0, errors.Wrap(err, "failed to find log entry")
+		}
+		if err == nil {
+			offset = e.Offset
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if err := r.seek(offset); err != nil {
+		return 0, err
+	}
+	return offset, nil
+}
+
+// FastForward repositions the Reader forward to toOffset without reading the
+// messages in between. Unlike creating a new Reader, the Reader's unacked
+// deliveries are preserved. toOffset must be between the Reader's next offset
+// and the HW, or the log's newest offset for uncommitted Readers, inclusive.
+func (r *Reader) FastForward(toOffset int64) error {
+	current := atomic.LoadInt64(&r.offset)
+	max := r.log.HighWatermark()
+	if r.opts.Uncommitted {
+		max = r.log.NewestOffset()
+	}
+	if toOffset < current || toOffset > max {
+		return errors.Wrapf(ErrInvalidOffset,
+			"offset %d is not between the next offset %d and %d", toOffset, current, max)
+	}
+	if toOffset == current {
+		return nil
+	}
+	return r.seek(toOffset)
+}
@@ -0,0 +1,108 @@
+package commitlog
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// Ensure SeekBy positions the Reader using a comparator over a derived key and
+// defaults to natural offset ordering.
+func TestReaderSeekBy(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	// A side index maps each offset to a derived key.
+	keys := map[int64]int64{}
+	for i := int64(0); i < 20; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.FormatInt(i, 10))}})
+		require.NoError(t, err)
+		keys[i] = i * 10
+	}
+	l.SetHighWatermark(19)
+	require.True(t, len(l.Segments()) > 1)
+	less := func(offset, target int64) bool {
+		return keys[offset] < target
+	}
+
+	r, err := l.NewReader(0, false)
+	require.NoError(t, err)
+	headers := make([]byte, 28)
+	for _, tc := range []struct {
+		target   int64
+		less     func(a, b int64) bool
+		expected int64
+	}{
+		{45, less, 5},
+		{150, less, 15},
+		{0, less, 0},
+		{12, nil, 12},
+	} {
+		offset, err := r.SeekBy(context.Background(), tc.target, tc.less)
+		require.NoError(t, err)
+		require.Equal(t, tc.expected, offset)
+		msg, offset, _, _, err := r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+		require.Equal(t, tc.expected, offset)
+		require.Equal(t, []byte(strconv.FormatInt(tc.expected, 10)), msg.Value())
+	}
+
+	// Seeking past every key positions the Reader at the end of the log.
+	offset, err := r.SeekBy(context.Background(), 1000, less)
+	require.NoError(t, err)
+	require.Equal(t, int64(20), offset)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = r.SeekBy(ctx, 45, less)
+	require.Equal(t, context.Canceled, err)
+}
+
+// Ensure FastForward only moves the Reader forward up to the HW and preserves
+// its ack state.
+func TestReaderFastForward(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	for i := int64(0); i < 20; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.FormatInt(i, 10))}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(15)
+
+	r, err := l.NewReaderWithOptions(0, ReaderOptions{TrackAcks: true})
+	require.NoError(t, err)
+	headers := make([]byte, 28)
+	read := func(expected int64) {
+		msg, offset, _, _, err := r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+		require.Equal(t, expected, offset)
+		require.Equal(t, strconv.FormatInt(expected, 10), string(msg.Value()))
+	}
+	read(0)
+	read(1)
+
+	require.Equal(t, ErrInvalidOffset, errors.Cause(r.FastForward(1)))
+	require.Equal(t, ErrInvalidOffset, errors.Cause(r.FastForward(16)))
+
+	require.NoError(t, r.FastForward(2))
+	read(2)
+	require.NoError(t, r.FastForward(12))
+	read(12)
+	require.NoError(t, r.FastForward(15))
+	read(15)
+
+	// Deliveries from before the fast-forward are still unacked.
+	require.Equal(t, int64(0), r.LowestUnacked())
+}
@@ -7,8 +7,10 @@ import (
 	"path/filepath"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/nsip/gommap"
 	"github.com/pkg/errors"
 )
 
@@ -17,6 +19,8 @@ const (
 	logSuffix       = ".log"
 	cleanedSuffix   = ".cleaned"
 	truncatedSuffix = ".truncated"
+	mergedSuffix    = ".merged"
+	stagedSuffix    = ".staged"
 	indexSuffix     = ".index"
 )
 
@@ -38,11 +42,76 @@ var (
 	// new segment.
 	ErrSegmentReplaced = errors.New("segment was replaced")
 
+	// ErrOffsetRetained is returned when attempting to read from a segment
+	// that has been deleted due to the log retention policy. When this error
+	// is encountered, the data being read is no longer available.
+	ErrOffsetRetained = errors.New("offset was removed by log retention")
+
 	// timestamp returns the current time in Unix nanoseconds. This function
 	// exists for mocking purposes.
 	timestamp = func() int64 { return time.Now().UnixNano() }
 )
 
+// SegmentInfo is a point-in-time snapshot of a log segment.
+type SegmentInfo struct {
+	BaseOffset     int64 // Offset of the first message the segment can contain
+	NextOffset     int64 // Next offset to be assigned in the segment
+	Size           int64 // Size of the segment log file in bytes
+	MaxBytes       int64 // Max bytes the segment can contain before rolling
+	Remaining      int64 // Bytes remaining before the segment rolls
+	FirstWriteTime int64 // Unix nanosecond timestamp of the first write, 0 if empty
+	Sealed         bool  // Whether the segment is sealed and immutable
+}
+
+// Segment is a segment built outside of the log to be swapped into it with
+// SwapSegments, e.g. by an external compactor. Its files are staged in the
+// log's directory and are removed when the log is reopened if the segment was
+// never swapped in. A Segment is not safe for concurrent use.
+type Segment struct {
+	segment *segment
+	format  HeaderFormat
+	swapped bool
+}
+
+// BaseOffset returns the offset of the first message the segment can contain.
+func (s *Segment) BaseOffset() int64 {
+	return s.segment.BaseOffset
+}
+
+// Info returns a snapshot of the segment's current state.
+func (s *Segment) Info() SegmentInfo {
+	return s.segment.Info()
+}
+
+// Write appends the message to the segment at the given offset, which must
+// not be less than the segment's next offset. Gaps between offsets are
+// allowed, e.g. for messages removed by compaction.
+func (s *Segment) Write(offset int64, msg *Message) error {
+	if s.swapped {
+		return errors.New("segment has been swapped in")
+	}
+	if next := s.segment.NextOffset(); offset < next {
+		return errors.Errorf("offset %d is less than next offset %d", offset, next)
+	}
+	ms, entries, err := newMessageSetFromProto(offset, s.segment.Position(), []*Message{msg})
+	if err != nil {
+		return err
+	}
+	if s.format != HeaderFormatFixed {
+		ms, entries = encodeMessageSet(ms, s.format, entries[0].Position)
+	}
+	return s.segment.WriteMessageSet(ms, entries)
+}
+
+// Delete removes the segment's files. This is used to discard a segment which
+// will not be swapped in and fails once it has been.
+func (s *Segment) Delete() error {
+	if s.swapped {
+		return errors.New("segment has been swapped in")
+	}
+	return s.segment.Delete()
+}
+
 type segment struct {
 	writer         io.Writer
 	reader         io.Reader
@@ -53,14 +122,22 @@ type segment struct {
 	lastOffset     int64
 	firstWriteTime int64
 	lastWriteTime  int64
+	modTime        time.Time
 	position       int64
 	maxBytes       int64
 	path           string
 	suffix         string
 	waiters        map[interface{}]chan struct{}
+	logMmap        gommap.MMap
 	sealed         bool
 	closed         bool
 	replaced       bool
+	expired        bool
+	unloaded       bool
+	metaLoaded     bool
+	entries        int
+	loader         *segmentLoader
+	pins           int32
 
 	sync.RWMutex
 }
@@ -79,20 +156,190 @@ func newSegment(path string, baseOffset, maxBytes int64, isNew bool, suffix stri
 	if isNew && exists(s.logPath()) {
 		return nil, ErrSegmentExists
 	}
+	if err := s.load(); err != nil {
+		return s, err
+	}
+	return s, nil
+}
+
+// newLazySegment returns an existing segment whose files are not opened and
+// whose index is not read until it is first accessed. The loader bounds the
+// number of such segments which are loaded. The size of the segment's log is
+// known up front, so its position is available without loading it.
+func newLazySegment(path string, baseOffset, maxBytes, size int64, loader *segmentLoader) *segment {
+	return &segment{
+		maxBytes:    maxBytes,
+		BaseOffset:  baseOffset,
+		firstOffset: -1,
+		lastOffset:  -1,
+		position:    size,
+		path:        path,
+		waiters:     make(map[interface{}]chan struct{}),
+		unloaded:    true,
+		loader:      loader,
+	}
+}
+
+// load opens the segment's log file and sets up its index.
+func (s *segment) load() error {
 	log, err := os.OpenFile(s.logPath(), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
 	if err != nil {
-		return nil, errors.Wrap(err, "open file failed")
+		return errors.Wrap(err, "open file failed")
 	}
 	info, err := log.Stat()
 	if err != nil {
-		return nil, errors.Wrap(err, "stat file failed")
+		return errors.Wrap(err, "stat file failed")
 	}
 	s.log = log
 	s.position = info.Size()
+	s.modTime = info.ModTime()
 	s.writer = log
 	s.reader = log
-	err = s.setupIndex()
-	return s, err
+	if err := s.setupIndex(); err != nil {
+		return err
+	}
+	s.metaLoaded = true
+	return nil
+}
+
+// pin loads the segment if it is unloaded, records the access with its
+// loader, and stops the segment from being unloaded until it is unpinned.
+// This must not be called while holding the segment's lock.
+func (s *segment) pin() error {
+	s.Lock()
+	if s.unloaded && !s.closed {
+		if err := s.load(); err != nil {
+			s.Unlock()
+			return errors.Wrapf(err, "failed to load segment %s", s.logPath())
+		}
+		s.unloaded = false
+	}
+	atomic.AddInt32(&s.pins, 1)
+	loader := s.loader
+	s.Unlock()
+	if loader != nil {
+		loader.touch(s)
+	}
+	return nil
+}
+
+// unpin allows the segment to be unloaded again.
+func (s *segment) unpin() {
+	atomic.AddInt32(&s.pins, -1)
+}
+
+// rlock read locks the segment, first loading it if it is unloaded. Accesses
+// to lazily loaded segments are recorded with their loader, which can't be
+// done while holding the lock, so the segment is pinned until the lock is
+// held to keep it from being unloaded in between. If the segment fails to
+// load, the error is returned and the lock is not held.
+func (s *segment) rlock() error {
+	s.RLock()
+	if s.closed || (!s.unloaded && s.loader == nil) {
+		return nil
+	}
+	s.RUnlock()
+	if err := s.pin(); err != nil {
+		return err
+	}
+	s.RLock()
+	s.unpin()
+	return nil
+}
+
+// rlockMeta read locks the segment like rlock for reading its metadata. The
+// metadata is kept when a segment is unloaded, so a segment is only loaded if
+// it has not been loaded before. The lock is held even if the segment fails to
+// load, in which case its metadata is that of an empty segment and the error
+// is returned by the operations reading its data.
+func (s *segment) rlockMeta() {
+	s.RLock()
+	if s.unloaded && s.metaLoaded {
+		return
+	}
+	s.RUnlock()
+	if err := s.rlock(); err != nil {
+		s.RLock()
+	}
+}
+
+// lock write locks the segment, first loading it if it is unloaded. If the
+// segment fails to load, the error is returned and the lock is not held.
+func (s *segment) lock() error {
+	s.Lock()
+	if s.closed || !s.unloaded {
+		return nil
+	}
+	s.Unlock()
+	if err := s.pin(); err != nil {
+		return err
+	}
+	s.Lock()
+	s.unpin()
+	return nil
+}
+
+// lockMeta write locks the segment like lock for updating its metadata. Like
+// rlockMeta, the lock is held even if the segment fails to load.
+func (s *segment) lockMeta() {
+	if err := s.lock(); err != nil {
+		s.Lock()
+	}
+}
+
+// unload closes and unmaps the segment's files until it is next accessed and
+// stops tracking it with its loader, returning whether it was unloaded. Its
+// metadata is kept. Segments which are pinned or have memory-mapped views of
+// their log are not unloaded since that would invalidate the views.
+func (s *segment) unload() (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.unloaded || s.closed || s.loader == nil || s.logMmap != nil ||
+		atomic.LoadInt32(&s.pins) > 0 {
+		return false, nil
+	}
+	if err := s.log.Close(); err != nil {
+		return false, err
+	}
+	entries := s.entryCount()
+	if err := s.Index.unmap(); err != nil {
+		return false, err
+	}
+	s.Index = nil
+	s.entries = entries
+	s.unloaded = true
+	s.loader.remove(s)
+	return true, nil
+}
+
+// detach loads the segment if it is unloaded and stops it from being
+// unloaded, e.g. because it became the active segment.
+func (s *segment) detach() error {
+	s.Lock()
+	if s.unloaded && !s.closed {
+		if err := s.load(); err != nil {
+			s.Unlock()
+			return err
+		}
+		s.unloaded = false
+	}
+	loader := s.loader
+	s.loader = nil
+	s.Unlock()
+	if loader != nil {
+		loader.remove(s)
+	}
+	return nil
+}
+
+// entryCount returns the number of entries in the segment's index. For an
+// unloaded segment, this is the count when it was unloaded, so a segment which
+// was closed before it was ever loaded has none.
+func (s *segment) entryCount() int {
+	if s.Index == nil {
+		return s.entries
+	}
+	return int(s.Index.Position() / entryWidth)
 }
 
 // setupIndex creates and initializes an index.
@@ -109,6 +356,10 @@ func (s *segment) setupIndex() (err error) {
 		return err
 	}
 	lastEntry, err := s.Index.InitializePosition()
+	if err == errIndexCorrupt {
+		// Attempt to recover the index from the log.
+		return s.rebuildIndex()
+	}
 	if err != nil {
 		return err
 	}
@@ -131,7 +382,7 @@ func (s *segment) setupIndex() (err error) {
 // because this segment is full or LogRollTime has passed since the first
 // message was written to the segment.
 func (s *segment) CheckSplit(logRollTime time.Duration) bool {
-	s.RLock()
+	s.rlockMeta()
 	defer s.RUnlock()
 	if s.position >= s.maxBytes {
 		return true
@@ -149,7 +400,7 @@ func (s *segment) CheckSplit(logRollTime time.Duration) bool {
 // segment after a new segment is rolled. This is a no-op if the segment is
 // already sealed.
 func (s *segment) Seal() {
-	s.Lock()
+	s.lockMeta()
 	defer s.Unlock()
 	if s.sealed {
 		return
@@ -157,12 +408,45 @@ func (s *segment) Seal() {
 	s.sealed = true
 	// Notify any readers waiting for data.
 	s.notifyWaiters()
-	s.Index.Shrink() // nolint: errcheck
+	if s.Index != nil {
+		s.Index.Shrink() // nolint: errcheck
+	}
 }
 
-func (s *segment) NextOffset() int64 {
+// Sealed indicates if the segment has been sealed, meaning it is immutable
+// and no more data will be written to it.
+func (s *segment) Sealed() bool {
 	s.RLock()
 	defer s.RUnlock()
+	return s.sealed
+}
+
+// Info returns a snapshot of the segment's current state.
+func (s *segment) Info() SegmentInfo {
+	s.rlockMeta()
+	defer s.RUnlock()
+	nextOffset := s.BaseOffset
+	if s.lastOffset != -1 {
+		nextOffset = s.lastOffset + 1
+	}
+	remaining := s.maxBytes - s.position
+	if remaining < 0 {
+		remaining = 0
+	}
+	return SegmentInfo{
+		BaseOffset:     s.BaseOffset,
+		NextOffset:     nextOffset,
+		Size:           s.position,
+		MaxBytes:       s.maxBytes,
+		Remaining:      remaining,
+		FirstWriteTime: s.firstWriteTime,
+		Sealed:         s.sealed,
+	}
+}
+
+func (s *segment) NextOffset() int64 {
+	s.rlockMeta()
+	defer s.RUnlock()
 	// If the segment hasn't been written to, the next offset should be the
 	// base offset.
 	if s.lastOffset == -1 {
@@ -172,37 +456,55 @@ func (s *segment) NextOffset() int64 {
 }
 
 func (s *segment) FirstOffset() int64 {
-	s.RLock()
+	s.rlockMeta()
 	defer s.RUnlock()
 	return s.firstOffset
 }
 
 func (s *segment) LastOffset() int64 {
-	s.RLock()
+	s.rlockMeta()
 	defer s.RUnlock()
 	return s.lastOffset
 }
 
+// LastWriteTime returns the timestamp of the last message in the segment, or
+// 0 if it is empty.
+func (s *segment) LastWriteTime() int64 {
+	s.rlockMeta()
+	defer s.RUnlock()
+	return s.lastWriteTime
+}
+
+// Position returns the size of the segment's log. This does not load an
+// unloaded segment since its size is known before it is first loaded.
 func (s *segment) Position() int64 {
 	s.RLock()
+	if s.unloaded {
+		defer s.RUnlock()
+		return s.position
+	}
+	s.RUnlock()
+	s.rlockMeta()
 	defer s.RUnlock()
 	return s.position
 }
 
 func (s *segment) IsEmpty() bool {
-	s.RLock()
+	s.rlockMeta()
 	defer s.RUnlock()
 	return s.firstOffset == -1
 }
 
 func (s *segment) MessageCount() int64 {
-	s.RLock()
+	s.rlockMeta()
 	defer s.RUnlock()
-	return s.Index.CountEntries()
+	return int64(s.entryCount())
 }
 
 func (s *segment) WriteMessageSet(ms []byte, entries []*entry) error {
-	s.Lock()
+	if err := s.lock(); err != nil {
+		return err
+	}
 	defer s.Unlock()
 	if _, err := s.write(ms, entries); err != nil {
 		return err
@@ -221,7 +523,8 @@ func (s *segment) write(p []byte, entries []*entry) (n int, err error) {
 		return n, errors.Wrap(err, "log write failed")
 	}
 	s.position += int64(n)
-	if s.firstWriteTime == 0 {
+	s.modTime = time.Now()
+	if s.firstOffset == -1 {
 		first := entries[0]
 		s.firstOffset = first.Offset
 		s.firstWriteTime = first.Timestamp
@@ -233,15 +536,44 @@ func (s *segment) write(p []byte, entries []*entry) (n int, err error) {
 	return n, nil
 }
 
+// ModTime returns the wall-clock time the segment was last written to, or the
+// modification time of its log file if it has not been written to since it
+// was opened.
+func (s *segment) ModTime() time.Time {
+	s.rlockMeta()
+	defer s.RUnlock()
+	return s.modTime
+}
+
 func (s *segment) ReadAt(p []byte, off int64) (n int, err error) {
-	s.RLock()
+	if err := s.rlock(); err != nil {
+		return 0, err
+	}
 	defer s.RUnlock()
 	if s.closed {
 		if s.replaced {
 			return 0, ErrSegmentReplaced
 		}
+		if s.expired {
+			return 0, ErrOffsetRetained
+		}
 		return 0, ErrSegmentClosed
 	}
+	// Only expose bytes up to the position, which is advanced once a whole
+	// message set has been written, so readers never see partial writes.
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if off >= s.position {
+		return 0, io.EOF
+	}
+	if max := s.position - off; int64(len(p)) > max {
+		n, err = s.log.ReadAt(p[:max], off)
+		if err == nil {
+			err = io.EOF
+		}
+		return n, err
+	}
 	return s.log.ReadAt(p, off)
 }
 
@@ -253,7 +585,7 @@ func (s *segment) notifyWaiters() {
 }
 
 func (s *segment) WaitForLEO(waiter interface{}, leo int64) <-chan struct{} {
-	s.Lock()
+	s.lockMeta()
 	defer s.Unlock()
 	if s.lastOffset != leo {
 		ch := make(chan struct{})
@@ -263,7 +595,7 @@ func (s *segment) WaitForLEO(waiter interface{}, leo int64) <-chan struct{} {
 	return s.waitForData(waiter, s.position)
 }
 func (s *segment) WaitForData(waiter interface{}, pos int64) <-chan struct{} {
-	s.Lock()
+	s.lockMeta()
 	ch := s.waitForData(waiter, pos)
 	s.Unlock()
 	return ch
@@ -291,6 +623,13 @@ func (s *segment) removeWaiter(waiter interface{}) {
 	s.Unlock()
 }
 
+func (s *segment) isWaiter(waiter interface{}) bool {
+	s.RLock()
+	defer s.RUnlock()
+	_, ok := s.waiters[waiter]
+	return ok
+}
+
 // Close a segment such that it can no longer be read from or written to. This
 // operation is idempotent.
 func (s *segment) Close() error {
@@ -303,6 +642,19 @@ func (s *segment) close() error {
 	if s.closed {
 		return nil
 	}
+	if s.loader != nil {
+		s.loader.remove(s)
+	}
+	if s.unloaded {
+		s.closed = true
+		return nil
+	}
+	if s.logMmap != nil {
+		if err := s.logMmap.UnsafeUnmap(); err != nil {
+			return err
+		}
+		s.logMmap = nil
+	}
 	if err := s.log.Close(); err != nil {
 		return err
 	}
@@ -313,11 +665,60 @@ func (s *segment) close() error {
 	return nil
 }
 
+// view returns n bytes of the segment's log starting at the given position as
+// a slice backed by a read-only memory mapping of the log file rather than a
+// copy. The log is mapped lazily on first use. Views are only available for
+// sealed segments since the mapping does not grow with writes, so this
+// returns false if a view is not available. Views are invalid once the
+// segment is closed, e.g. due to compaction, retention, or truncation, and
+// accessing one after that point will crash the process.
+func (s *segment) view(pos, n int64) ([]byte, bool, error) {
+	if err := s.rlock(); err != nil {
+		return nil, false, err
+	}
+	if s.closed || !s.sealed || s.position == 0 || pos+n > s.position {
+		s.RUnlock()
+		return nil, false, nil
+	}
+	if s.logMmap != nil {
+		view := s.logMmap[pos : pos+n : pos+n]
+		s.RUnlock()
+		return view, true, nil
+	}
+	s.RUnlock()
+
+	s.Lock()
+	defer s.Unlock()
+	if s.closed || s.unloaded {
+		return nil, false, nil
+	}
+	if s.logMmap == nil {
+		mmap, err := gommap.Map(s.log.Fd(), gommap.PROT_READ, gommap.MAP_SHARED)
+		if err != nil {
+			return nil, false, errors.Wrap(err, "mmap log file failed")
+		}
+		s.logMmap = mmap
+	}
+	return s.logMmap[pos : pos+n : pos+n], true, nil
+}
+
 // Cleaned creates a cleaned segment for this segment.
 func (s *segment) Cleaned() (*segment, error) {
 	return newSegment(s.path, s.BaseOffset, s.maxBytes, false, cleanedSuffix)
 }
 
+// Merged creates a new segment into which this segment and the ones following
+// it are merged. Any leftover files from a previous merge are removed.
+func (s *segment) Merged() (*segment, error) {
+	merged := &segment{path: s.path, BaseOffset: s.BaseOffset, suffix: mergedSuffix}
+	for _, path := range []string{merged.logPath(), merged.indexPath()} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return newSegment(s.path, s.BaseOffset, s.maxBytes, true, mergedSuffix)
+}
+
 // Truncated creates a truncated segment for this segment.
 func (s *segment) Truncated() (*segment, error) {
 	return newSegment(s.path, s.BaseOffset, s.maxBytes, false, truncatedSuffix)
@@ -355,18 +756,30 @@ func (s *segment) Replace(old *segment) error {
 }
 
 // findEntry returns the first entry whose offset is greater than or equal to
-// the given offset.
-func (s *segment) findEntry(offset int64) (e *entry, err error) {
-	s.RLock()
+// the given offset. ErrSearchBudgetExceeded is returned if the search exceeds
+// the budget, which may be nil for an unlimited search.
+func (s *segment) findEntry(offset int64, budget *searchBudget) (e *entry, err error) {
+	if err := s.rlock(); err != nil {
+		return nil, err
+	}
 	defer s.RUnlock()
+	if !budget.segment() {
+		return nil, ErrSearchBudgetExceeded
+	}
 	e = &entry{}
-	n := int(s.Index.Position() / entryWidth)
+	n := s.entryCount()
 	idx := sort.Search(n, func(i int) bool {
+		if !budget.entry() {
+			return true
+		}
 		if err := s.Index.ReadEntryAtFileOffset(e, int64(i*entryWidth)); err != nil {
 			panic(err)
 		}
 		return e.Offset >= offset
 	})
+	if budget.exceeded() {
+		return nil, ErrSearchBudgetExceeded
+	}
 	if idx == n {
 		return nil, ErrEntryNotFound
 	}
@@ -374,19 +787,213 @@ func (s *segment) findEntry(offset int64) (e *entry, err error) {
 	return e, err
 }
 
+// findEntryBy is like findEntry but generalized to an arbitrary ordering. It
+// returns the first entry whose offset satisfies pred, which must be false
+// for a prefix of the entries and true for the rest.
+func (s *segment) findEntryBy(pred func(offset int64) bool) (e *entry, err error) {
+	if err := s.rlock(); err != nil {
+		return nil, err
+	}
+	defer s.RUnlock()
+	e = &entry{}
+	n := s.entryCount()
+	idx := sort.Search(n, func(i int) bool {
+		if err := s.Index.ReadEntryAtFileOffset(e, int64(i*entryWidth)); err != nil {
+			panic(err)
+		}
+		return pred(e.Offset)
+	})
+	if idx == n {
+		return nil, ErrEntryNotFound
+	}
+	err = s.Index.ReadEntryAtFileOffset(e, int64(idx*entryWidth))
+	return e, err
+}
+
+// searchIndex returns the position in the segment's index of the first entry
+// whose offset is greater than or equal to the given offset along with the
+// number of entries in the index.
+func (s *segment) searchIndex(offset int64) (int, int) {
+	if err := s.rlock(); err != nil {
+		// Like the metadata of a segment which fails to load, it is searched
+		// as though it were empty.
+		return 0, 0
+	}
+	defer s.RUnlock()
+	var (
+		e = &entry{}
+		n = s.entryCount()
+	)
+	idx := sort.Search(n, func(i int) bool {
+		if err := s.Index.ReadEntryAtFileOffset(e, int64(i*entryWidth)); err != nil {
+			panic(err)
+		}
+		return e.Offset >= offset
+	})
+	return idx, n
+}
+
+// entryAt returns the entry at the given position in the segment's index.
+func (s *segment) entryAt(i int) (*entry, error) {
+	if err := s.rlock(); err != nil {
+		return nil, err
+	}
+	defer s.RUnlock()
+	if s.Index == nil {
+		return nil, ErrSegmentClosed
+	}
+	e := &entry{}
+	if err := s.Index.ReadEntryAtFileOffset(e, int64(i*entryWidth)); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// entriesRange returns the index entries for the messages in the segment
+// whose offsets are between the given offsets, inclusive.
+func (s *segment) entriesRange(from, to int64) ([]*entry, error) {
+	if err := s.rlock(); err != nil {
+		return nil, err
+	}
+	defer s.RUnlock()
+	if s.closed {
+		return nil, ErrSegmentClosed
+	}
+	var (
+		entries []*entry
+		e       = &entry{}
+		n       = s.entryCount()
+	)
+	start := sort.Search(n, func(i int) bool {
+		if err := s.Index.ReadEntryAtFileOffset(e, int64(i*entryWidth)); err != nil {
+			panic(err)
+		}
+		return e.Offset >= from
+	})
+	for i := start; i < n; i++ {
+		e := &entry{}
+		if err := s.Index.ReadEntryAtFileOffset(e, int64(i*entryWidth)); err != nil {
+			return nil, err
+		}
+		if e.Offset > to {
+			break
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// findEntryOrRebuild is like findEntry but also verifies the returned entry
+// against the log. If the entry does not match the log, indicating the index
+// is corrupt, the index is rebuilt and the search is retried.
+func (s *segment) findEntryOrRebuild(offset int64) (*entry, error) {
+	e, err := s.findEntry(offset, nil)
+	if err == ErrEntryNotFound {
+		return nil, err
+	}
+	if err == nil && s.matchesLog(e) {
+		return e, nil
+	}
+	if err := s.RebuildIndex(); err != nil {
+		return nil, errors.Wrap(err, "failed to rebuild corrupt index")
+	}
+	return s.findEntry(offset, nil)
+}
+
+// matchesLog indicates if the message set header at the entry's position in
+// the log has the entry's offset.
+func (s *segment) matchesLog(e *entry) bool {
+	header, err := readHeaderAt(s, make(messageSet, maxMsgSetHeaderLen), e.Position)
+	if err != nil {
+		return false
+	}
+	return header.Offset() == e.Offset
+}
+
+// RebuildIndex reconstructs the segment's index by sequentially scanning the
+// message headers in its log file. This recovers from a corrupted index
+// without losing the underlying data. A trailing partially written message is
+// not indexed.
+func (s *segment) RebuildIndex() error {
+	if err := s.lock(); err != nil {
+		return err
+	}
+	defer s.Unlock()
+	if s.closed {
+		return ErrSegmentClosed
+	}
+	return s.rebuildIndex()
+}
+
+func (s *segment) rebuildIndex() error {
+	var (
+		entries  []*entry
+		buf      = make(messageSet, maxMsgSetHeaderLen)
+		position = int64(0)
+	)
+	for position < s.position {
+		header, err := readHeaderAt(s.log, buf, position)
+		if err == io.ErrUnexpectedEOF || err == errInvalidHeader {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read message header")
+		}
+		size := int64(len(header)) + int64(header.Size())
+		if position+size > s.position {
+			break
+		}
+		entries = append(entries, &entry{
+			Offset:      header.Offset(),
+			Timestamp:   header.Timestamp(),
+			LeaderEpoch: header.LeaderEpoch(),
+			Position:    position,
+			Size:        int32(size),
+		})
+		position += size
+	}
+	if err := s.Index.reset(entries); err != nil {
+		return err
+	}
+	s.firstOffset = -1
+	s.lastOffset = -1
+	s.firstWriteTime = 0
+	s.lastWriteTime = 0
+	if len(entries) > 0 {
+		first, last := entries[0], entries[len(entries)-1]
+		s.firstOffset = first.Offset
+		s.firstWriteTime = first.Timestamp
+		s.lastOffset = last.Offset
+		s.lastWriteTime = last.Timestamp
+	}
+	return nil
+}
+
 // findEntryByTimestamp returns the first entry whose timestamp is greater than
-// or equal to the given offset.
-func (s *segment) findEntryByTimestamp(timestamp int64) (e *entry, err error) {
-	s.RLock()
+// or equal to the given offset. Like findEntry, the search is limited by the
+// budget.
+func (s *segment) findEntryByTimestamp(timestamp int64, budget *searchBudget) (e *entry, err error) {
+	if err := s.rlock(); err != nil {
+		return nil, err
+	}
 	defer s.RUnlock()
+	if !budget.segment() {
+		return nil, ErrSearchBudgetExceeded
+	}
 	e = &entry{}
-	n := int(s.Index.Position() / entryWidth)
+	n := s.entryCount()
 	idx := sort.Search(n, func(i int) bool {
+		if !budget.entry() {
+			return true
+		}
 		if err := s.Index.ReadEntryAtFileOffset(e, int64(i*entryWidth)); err != nil {
 			panic(err)
 		}
 		return e.Timestamp >= timestamp
 	})
+	if budget.exceeded() {
+		return nil, ErrSearchBudgetExceeded
+	}
 	if idx == n {
 		return nil, ErrEntryNotFound
 	}
@@ -394,6 +1001,49 @@ func (s *segment) findEntryByTimestamp(timestamp int64) (e *entry, err error) {
 	return e, err
 }
 
+// findEntryByTimestampFloor returns the last entry whose timestamp is less
+// than or equal to the given timestamp. Like findEntry, the search is limited
+// by the budget.
+func (s *segment) findEntryByTimestampFloor(timestamp int64, budget *searchBudget) (e *entry, err error) {
+	if err := s.rlock(); err != nil {
+		return nil, err
+	}
+	defer s.RUnlock()
+	if !budget.segment() {
+		return nil, ErrSearchBudgetExceeded
+	}
+	e = &entry{}
+	n := s.entryCount()
+	idx := sort.Search(n, func(i int) bool {
+		if !budget.entry() {
+			return true
+		}
+		if err := s.Index.ReadEntryAtFileOffset(e, int64(i*entryWidth)); err != nil {
+			panic(err)
+		}
+		return e.Timestamp > timestamp
+	})
+	if budget.exceeded() {
+		return nil, ErrSearchBudgetExceeded
+	}
+	if idx == 0 {
+		return nil, ErrEntryNotFound
+	}
+	err = s.Index.ReadEntryAtFileOffset(e, int64((idx-1)*entryWidth))
+	return e, err
+}
+
+// Expire deletes the segment because it was removed by the log retention
+// policy. Readers subsequently reading from the segment will receive
+// ErrOffsetRetained, and any readers waiting on the segment are notified.
+func (s *segment) Expire() error {
+	s.Lock()
+	s.expired = true
+	s.notifyWaiters()
+	s.Unlock()
+	return s.Delete()
+}
+
 // Delete closes the segment and then deletes its log and index files.
 func (s *segment) Delete() error {
 	if err := s.Close(); err != nil {
@@ -401,46 +1051,45 @@ func (s *segment) Delete() error {
 	}
 	s.Lock()
 	defer s.Unlock()
-	if exists(s.log.Name()) {
-		if err := os.Remove(s.log.Name()); err != nil {
-			return err
-		}
-	}
-	if exists(s.Index.Name()) {
-		if err := os.Remove(s.Index.Name()); err != nil {
-			return err
+	// The segment may never have been loaded, so its files may not have
+	// been opened.
+	for _, path := range []string{s.logPath(), s.indexPath()} {
+		if exists(path) {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
 }
 
 type segmentScanner struct {
-	s  *segment
-	is *indexScanner
+	s    *segment
+	next int
 }
 
 func newSegmentScanner(segment *segment) *segmentScanner {
-	return &segmentScanner{s: segment, is: newIndexScanner(segment.Index)}
+	return &segmentScanner{s: segment}
 }
 
 // Scan should be called repeatedly to iterate over the messages in the
-// segment, it will return io.EOF when there are no more messages.
+// segment, it will return io.EOF when there are no more messages. Each index
+// entry is read through the segment, so the segment may be unloaded between
+// scans.
 func (s *segmentScanner) Scan() (messageSet, *entry, error) {
-	entry, err := s.is.Scan()
+	entry, err := s.s.entryAt(s.next)
 	if err != nil {
 		return nil, nil, err
 	}
-	header := make(messageSet, msgSetHeaderLen)
-	_, err = s.s.ReadAt(header, entry.Position)
-	if err != nil {
-		return nil, nil, err
+	if entry.Offset == 0 && s.next != 0 {
+		return nil, nil, io.EOF
 	}
-	payload := make([]byte, header.Size())
-	_, err = s.s.ReadAt(payload, entry.Position+msgSetHeaderLen)
+	s.next++
+	msgSet := make(messageSet, entry.Size)
+	_, err = s.s.ReadAt(msgSet, entry.Position)
 	if err != nil {
 		return nil, nil, err
 	}
-	msgSet := append(header, payload...)
 	return msgSet, entry, nil
 }
 
@@ -0,0 +1,79 @@
+package commitlog
+
+import (
+	"container/list"
+	"sync"
+)
+
+// segmentLoader bounds the number of lazily loaded segments whose files are
+// open. Segments are marked as used each time they are accessed, and once
+// more than max are loaded, the least recently used ones are unloaded,
+// closing their files until they are next accessed.
+type segmentLoader struct {
+	mu       sync.Mutex
+	max      int
+	order    *list.List
+	segments map[*segment]*list.Element
+}
+
+func newSegmentLoader(max int) *segmentLoader {
+	return &segmentLoader{
+		max:      max,
+		order:    list.New(),
+		segments: make(map[*segment]*list.Element),
+	}
+}
+
+// touch marks the loaded segment as the most recently used and unloads the
+// least recently used segments over the limit. Segments which can't be
+// unloaded, e.g. because they are pinned, stay tracked so that later accesses
+// retry them, and the next least recently used segments are unloaded instead.
+// This must not be called while holding a segment's lock.
+func (l *segmentLoader) touch(s *segment) {
+	l.mu.Lock()
+	if e, ok := l.segments[s]; ok {
+		l.order.MoveToFront(e)
+	} else {
+		l.segments[s] = l.order.PushFront(s)
+	}
+	excess := l.order.Len() - l.max
+	var candidates []*segment
+	if excess > 0 {
+		for e := l.order.Back(); e != nil; e = e.Prev() {
+			if seg := e.Value.(*segment); seg != s {
+				candidates = append(candidates, seg)
+			}
+		}
+	}
+	l.mu.Unlock()
+
+	// Segments are unloaded outside of the loader's lock since unloading
+	// takes the segment's lock, which may be held by a goroutine removing
+	// the segment from the loader. A segment stops being tracked once it is
+	// unloaded.
+	for _, seg := range candidates {
+		if excess == 0 {
+			break
+		}
+		if unloaded, _ := seg.unload(); unloaded {
+			excess--
+		}
+	}
+}
+
+// loaded returns the number of segments tracked as loaded.
+func (l *segmentLoader) loaded() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.order.Len()
+}
+
+// remove stops tracking the segment, e.g. because it was closed.
+func (l *segmentLoader) remove(s *segment) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e, ok := l.segments[s]; ok {
+		delete(l.segments, s)
+		l.order.Remove(e)
+	}
+}
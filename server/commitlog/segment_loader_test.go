@@ -0,0 +1,207 @@
+package commitlog
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Ensure logs opened with MaxLoadedSegments load segments on first access,
+// keep a bounded number of them loaded, and never unload the active segment.
+func TestLazySegmentLoading(t *testing.T) {
+	opts := Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+	numMsgs := 20
+	for i := 0; i < numMsgs; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(int64(numMsgs - 1))
+	require.NoError(t, l.Close())
+
+	opts.MaxLoadedSegments = 3
+	l, _ = setupWithOptions(t, opts)
+	require.Len(t, l.Segments(), numMsgs)
+	// Only the active segment and the first one, which is read to recover
+	// the leader epoch cache, are loaded.
+	require.Equal(t, 2, l.OpenSegmentCount())
+	require.Equal(t, int64(0), l.OldestOffset())
+	require.Equal(t, int64(numMsgs-1), l.NewestOffset())
+
+	headers := make([]byte, 28)
+	read := func(r *Reader, expected int64) {
+		msg, offset, _, _, err := r.ReadMessage(context.Background(), headers)
+		require.NoError(t, err)
+		require.Equal(t, expected, offset)
+		require.Equal(t, strconv.FormatInt(expected, 10), string(msg.Value()))
+	}
+	r, err := l.NewReader(int64(numMsgs-2), false)
+	require.NoError(t, err)
+	read(r, int64(numMsgs-2))
+	read(r, int64(numMsgs-1))
+	require.True(t, l.OpenSegmentCount() <= 4)
+
+	r, err = l.NewReader(0, false)
+	require.NoError(t, err)
+	for i := 0; i < numMsgs; i++ {
+		read(r, int64(i))
+	}
+	require.True(t, l.OpenSegmentCount() <= 4)
+
+	// Truncating never loaded segments deletes them, and the preceding
+	// segment becoming the active one is never unloaded.
+	require.NoError(t, l.Close())
+	l, _ = setupWithOptions(t, opts)
+	defer l.Close()
+	require.NoError(t, l.Truncate(10))
+	l.OverrideHighWatermark(9)
+	require.Len(t, l.Segments(), 10)
+	active := l.activeSegment()
+	require.Equal(t, int64(9), active.BaseOffset)
+	r, err = l.NewReader(0, false)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		read(r, int64(i))
+	}
+	active.RLock()
+	require.False(t, active.unloaded)
+	active.RUnlock()
+
+	_, err = l.Append([]*Message{{Value: []byte("10")}})
+	require.NoError(t, err)
+	l.SetHighWatermark(10)
+	read(r, 10)
+}
+
+// Ensure unloading a segment closes and unmaps its index, that pinned segments
+// are not unloaded, and that a segment which fails to load returns the error
+// rather than panicking.
+func TestLazySegmentUnload(t *testing.T) {
+	opts := Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+	for i := 0; i < 5; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	require.NoError(t, l.Close())
+
+	opts.MaxLoadedSegments = 1
+	l, _ = setupWithOptions(t, opts)
+	defer l.Close()
+	segments := l.Segments()
+
+	seg := segments[1]
+	require.Equal(t, int64(1), seg.NextOffset()-seg.BaseOffset)
+	seg.RLock()
+	require.False(t, seg.unloaded)
+	seg.RUnlock()
+
+	// A pinned segment is not unloaded.
+	require.NoError(t, seg.pin())
+	unloaded, err := seg.unload()
+	require.NoError(t, err)
+	require.False(t, unloaded)
+	seg.RLock()
+	require.False(t, seg.unloaded)
+	seg.RUnlock()
+	seg.unpin()
+
+	// Loading another segment unloads the least recently used one.
+	require.Equal(t, int64(3), segments[2].NextOffset())
+	seg.RLock()
+	require.True(t, seg.unloaded)
+	require.Nil(t, seg.Index)
+	seg.RUnlock()
+
+	// The unloaded segment is loaded again when scanned.
+	ms, _, err := newSegmentScanner(seg).Scan()
+	require.NoError(t, err)
+	require.Equal(t, int64(1), ms.Offset())
+
+	// A segment whose files can't be opened returns the error.
+	broken := segments[3]
+	require.NoError(t, os.Remove(broken.logPath()))
+	require.NoError(t, os.Mkdir(broken.logPath(), 0755))
+	_, err = broken.ReadAt(make([]byte, 1), 0)
+	require.Error(t, err)
+	_, err = broken.findEntry(3, nil)
+	require.Error(t, err)
+	require.Equal(t, broken.BaseOffset, broken.NextOffset())
+	require.NoError(t, os.Remove(broken.logPath()))
+}
+
+// Ensure segments which can't be unloaded stay tracked and are unloaded by a
+// later access once they can be, and that reading the metadata of unloaded
+// segments, e.g. for stats, does not load them.
+func TestLazySegmentLoaderBound(t *testing.T) {
+	opts := Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1,
+	}
+	l, cleanup := setupWithOptions(t, opts)
+	defer cleanup()
+	numMsgs := 6
+	for i := 0; i < numMsgs; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i)), Timestamp: int64(i + 1)}})
+		require.NoError(t, err)
+	}
+	require.NoError(t, l.Close())
+
+	opts.MaxLoadedSegments = 2
+	l, _ = setupWithOptions(t, opts)
+	defer l.Close()
+	segments := l.Segments()
+
+	// Stats doesn't load segments since their sizes are known.
+	open := l.OpenSegmentCount()
+	var totalBytes int64
+	for _, seg := range segments {
+		info, err := os.Stat(seg.logPath())
+		require.NoError(t, err)
+		totalBytes += info.Size()
+	}
+	require.Equal(t, totalBytes, l.Stats().TotalBytes)
+	require.Equal(t, open, l.OpenSegmentCount())
+
+	// Pinned segments over the limit stay loaded and tracked.
+	for _, seg := range segments[1:4] {
+		require.NoError(t, seg.pin())
+	}
+	require.Equal(t, 3, l.loader.loaded())
+	for _, seg := range segments[1:4] {
+		seg.unpin()
+	}
+
+	// The next access unloads the least recently used segments over the
+	// limit.
+	require.Equal(t, int64(5), segments[4].NextOffset())
+	require.Equal(t, 2, l.loader.loaded())
+	for _, seg := range segments[1:3] {
+		seg.RLock()
+		require.True(t, seg.unloaded)
+		seg.RUnlock()
+	}
+
+	// The metadata of unloaded segments is kept, so reading it doesn't load
+	// them again.
+	seg := segments[1]
+	require.Equal(t, int64(2), seg.NextOffset())
+	require.Equal(t, int64(1), seg.MessageCount())
+	require.Equal(t, int64(2), seg.LastWriteTime())
+	require.False(t, seg.IsEmpty())
+	seg.RLock()
+	require.True(t, seg.unloaded)
+	seg.RUnlock()
+	require.Equal(t, 2, l.loader.loaded())
+}
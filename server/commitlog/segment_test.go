@@ -72,6 +72,17 @@ func TestSegmentCheckSplitLogRollTimeExceeded(t *testing.T) {
 	require.True(t, s.CheckSplit(1))
 }
 
+// Ensure Sealed returns true only once the segment has been sealed.
+func TestSegmentSealed(t *testing.T) {
+	dir := tempDir(t)
+	defer remove(t, dir)
+
+	s := createSegment(t, dir, 0, 100)
+	require.False(t, s.Sealed())
+	s.Seal()
+	require.True(t, s.Sealed())
+}
+
 type mockContextReader struct{}
 
 func (m *mockContextReader) Read(ctx context.Context, buf []byte) (int, error) {
@@ -135,3 +146,39 @@ func TestSegmentSealIdempotent(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, int64(256), stats.Size())
 }
+
+// Ensure RebuildIndex reconstructs the index from the log.
+func TestSegmentRebuildIndex(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1000,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	numMsgs := 5
+	for i := 0; i < numMsgs; i++ {
+		_, err := l.Append([]*Message{{Value: []byte("hello"), Timestamp: int64(i + 1)}})
+		require.NoError(t, err)
+	}
+	seg := l.activeSegment()
+	expected := make([]entry, numMsgs)
+	for i := range expected {
+		require.NoError(t, seg.Index.ReadEntryAtLogOffset(&expected[i], int64(i)))
+	}
+
+	// Wipe the index.
+	for i := range seg.Index.mmap {
+		seg.Index.mmap[i] = 0
+	}
+
+	require.NoError(t, seg.RebuildIndex())
+	require.Equal(t, int64(numMsgs), seg.MessageCount())
+	for i := range expected {
+		var e entry
+		require.NoError(t, seg.Index.ReadEntryAtLogOffset(&e, int64(i)))
+		require.Equal(t, expected[i], e)
+	}
+	require.Equal(t, int64(0), seg.FirstOffset())
+	require.Equal(t, int64(numMsgs-1), seg.LastOffset())
+}
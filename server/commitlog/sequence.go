@@ -0,0 +1,101 @@
+package commitlog
+
+import "container/list"
+
+// Producer sequence header keys.
+const (
+	// ProducerIDHeader is the header containing the ID of the producer which
+	// published the message.
+	ProducerIDHeader = "producer_id"
+
+	// ProducerSequenceHeader is the header containing the message's sequence
+	// number within its producer, encoded as a big-endian int64.
+	ProducerSequenceHeader = "producer_seq"
+)
+
+const defaultMaxProducers = 1000
+
+// SetProducerSequence sets the producer ID and sequence number headers on the
+// message so that readers can detect duplicate and missing messages from the
+// producer.
+func (m *Message) SetProducerSequence(producerID []byte, seq int64) {
+	if m.Headers == nil {
+		m.Headers = make(map[string][]byte, 2)
+	}
+	buf := make([]byte, 8)
+	encoding.PutUint64(buf, uint64(seq))
+	m.Headers[ProducerIDHeader] = producerID
+	m.Headers[ProducerSequenceHeader] = buf
+}
+
+// ProducerSequence returns the producer ID and sequence number headers of the
+// message. The returned bool indicates if the message has valid producer
+// sequence headers.
+func (m SerializedMessage) ProducerSequence() ([]byte, int64, bool) {
+	headers := m.Headers()
+	producerID, ok := headers[ProducerIDHeader]
+	if !ok {
+		return nil, 0, false
+	}
+	seq, ok := headers[ProducerSequenceHeader]
+	if !ok || len(seq) != 8 {
+		return nil, 0, false
+	}
+	return producerID, int64(encoding.Uint64(seq)), true
+}
+
+// producerSequences tracks the last sequence number read for recently seen
+// producers. Producers are evicted in least-recently-seen order once more than
+// max are tracked, so the memory used is bounded. An evicted producer is
+// treated as new the next time it is seen.
+type producerSequences struct {
+	max       int
+	order     *list.List
+	producers map[string]*list.Element
+}
+
+type producerEntry struct {
+	producerID string
+	seq        int64
+}
+
+func newProducerSequences(max int) *producerSequences {
+	if max <= 0 {
+		max = defaultMaxProducers
+	}
+	return &producerSequences{
+		max:       max,
+		order:     list.New(),
+		producers: make(map[string]*list.Element),
+	}
+}
+
+// check compares the sequence number with the one expected for the producer,
+// i.e. the one following the last sequence number seen, and returns
+// ErrSequenceDuplicate if it was already seen or ErrSequenceGap if sequence
+// numbers were skipped. Unless the sequence number is a duplicate, it is
+// recorded as the producer's last sequence number.
+func (p *producerSequences) check(producerID []byte, seq int64) (expected int64, err error) {
+	e, ok := p.producers[string(producerID)]
+	if !ok {
+		e = p.order.PushFront(&producerEntry{producerID: string(producerID), seq: seq})
+		p.producers[string(producerID)] = e
+		if p.order.Len() > p.max {
+			back := p.order.Back()
+			delete(p.producers, back.Value.(*producerEntry).producerID)
+			p.order.Remove(back)
+		}
+		return seq, nil
+	}
+	p.order.MoveToFront(e)
+	entry := e.Value.(*producerEntry)
+	expected = entry.seq + 1
+	switch {
+	case seq < expected:
+		return expected, ErrSequenceDuplicate
+	case seq > expected:
+		err = ErrSequenceGap
+	}
+	entry.seq = seq
+	return expected, err
+}
@@ -0,0 +1,83 @@
+package commitlog
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// Ensure Readers verifying producer sequences report duplicates and gaps and
+// still return the message following a gap.
+func TestReaderVerifyProducerSequence(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1000,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	sequences := []struct {
+		producer string
+		seq      int64
+	}{
+		{"a", 0}, {"b", 0}, {"a", 1}, {"a", 1}, {"", 0}, {"b", 1}, {"a", 3}, {"a", 4},
+	}
+	for _, s := range sequences {
+		msg := &Message{Value: []byte(s.producer)}
+		if s.producer != "" {
+			msg.SetProducerSequence([]byte(s.producer), s.seq)
+		}
+		_, err := l.Append([]*Message{msg})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(int64(len(sequences) - 1))
+
+	r, err := l.NewReaderWithOptions(0, ReaderOptions{VerifyProducerSequence: true})
+	require.NoError(t, err)
+	headers := make([]byte, 28)
+	read := func() (int64, error) {
+		_, offset, _, _, err := r.ReadMessage(context.Background(), headers)
+		return offset, errors.Cause(err)
+	}
+	for _, expected := range []struct {
+		offset int64
+		err    error
+	}{
+		{0, nil},
+		{1, nil},
+		{2, nil},
+		{0, ErrSequenceDuplicate},
+		{4, nil},
+		{5, nil},
+		{0, ErrSequenceGap},
+		{6, nil},
+		{7, nil},
+	} {
+		offset, err := read()
+		require.Equal(t, expected.err, err)
+		require.Equal(t, expected.offset, offset)
+	}
+}
+
+// Ensure producer sequences only track the most recently seen producers.
+func TestProducerSequencesEviction(t *testing.T) {
+	sequences := newProducerSequences(2)
+	_, err := sequences.check([]byte("a"), 0)
+	require.NoError(t, err)
+	_, err = sequences.check([]byte("b"), 0)
+	require.NoError(t, err)
+	_, err = sequences.check([]byte("a"), 1)
+	require.NoError(t, err)
+
+	// Seeing c evicts b, the least recently seen producer.
+	_, err = sequences.check([]byte("c"), 0)
+	require.NoError(t, err)
+	_, err = sequences.check([]byte("b"), 5)
+	require.NoError(t, err)
+
+	expected, err := sequences.check([]byte("b"), 5)
+	require.Equal(t, ErrSequenceDuplicate, err)
+	require.Equal(t, int64(6), expected)
+}
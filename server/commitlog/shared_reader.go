@@ -0,0 +1,47 @@
+package commitlog
+
+import (
+	"context"
+)
+
+// SharedReader is a committed Reader which is safe for concurrent use. Each
+// call to Next returns a distinct message, allowing a pool of workers to
+// process a single partition in parallel without each needing its own Reader.
+// Messages are handed out in offset order, but because workers process them
+// concurrently, no ordering is guaranteed across workers.
+type SharedReader struct {
+	reader  *Reader
+	sem     chan struct{}
+	headers []byte
+}
+
+// NewSharedReader creates a new SharedReader starting at the given offset
+// which only returns committed messages.
+func (l *commitLog) NewSharedReader(offset int64) (*SharedReader, error) {
+	reader, err := l.NewReader(offset, false)
+	if err != nil {
+		return nil, err
+	}
+	return &SharedReader{
+		reader:  reader,
+		sem:     make(chan struct{}, 1),
+		headers: make([]byte, msgSetHeaderLen),
+	}, nil
+}
+
+// Next returns the next message not yet returned to any caller along with its
+// offset and timestamp, blocking until one is available or the context is
+// canceled, in which case the context error is returned. It is safe to call Next from
+// multiple goroutines.
+func (s *SharedReader) Next(ctx context.Context) (SerializedMessage, int64, int64, error) {
+	// Use a channel rather than a mutex so that callers waiting for another
+	// caller's read can still be canceled.
+	select {
+	case s.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, 0, 0, ctx.Err()
+	}
+	defer func() { <-s.sem }()
+	msg, offset, timestamp, _, err := s.reader.ReadMessage(ctx, s.headers)
+	return msg, offset, timestamp, err
+}
@@ -0,0 +1,97 @@
+package commitlog
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// Ensure concurrent calls to Next each receive a distinct message and that
+// every message is returned exactly once.
+func TestSharedReaderNextConcurrent(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	numMsgs := 100
+	for i := 0; i < numMsgs; i++ {
+		appendToLog(t, l, []keyValue{{value: []byte(strconv.Itoa(i))}}, true)
+	}
+
+	r, err := l.NewSharedReader(0)
+	require.NoError(t, err)
+
+	var (
+		mu      sync.Mutex
+		seen    = make(map[int64]int)
+		wg      sync.WaitGroup
+		workers = 8
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < numMsgs/workers; j++ {
+				msg, offset, _, err := r.Next(ctx)
+				require.NoError(t, err)
+				require.Equal(t, []byte(strconv.FormatInt(offset, 10)), msg.Value())
+				mu.Lock()
+				seen[offset]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Read the remainder.
+	for len(seen) < numMsgs {
+		_, offset, _, err := r.Next(ctx)
+		require.NoError(t, err)
+		seen[offset]++
+	}
+	for i := 0; i < numMsgs; i++ {
+		require.Equal(t, 1, seen[int64(i)])
+	}
+}
+
+// Ensure Next returns when the context is canceled while waiting on another
+// caller.
+func TestSharedReaderNextCancel(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	r, err := l.NewSharedReader(0)
+	require.NoError(t, err)
+
+	// Block one caller waiting for data.
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+	done := make(chan error)
+	go func() {
+		_, _, _, err := r.Next(ctx1)
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	cancel2()
+	_, _, _, err = r.Next(ctx2)
+	require.Equal(t, context.Canceled, err)
+
+	cancel1()
+	require.Equal(t, context.Canceled, errors.Cause(<-done))
+}
@@ -0,0 +1,132 @@
+package commitlog
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SubscriptionRegistry tracks committed Readers by subscription ID so that a
+// client which reconnects, e.g. through a gateway proxying many clients, can
+// resume with the exact Reader it was using before. Subscriptions which go
+// unused for longer than the registry's TTL are considered abandoned and are
+// removed and their Readers closed. A subscription is used when it is
+// resumed or when its Reader advances. SubscriptionRegistries are safe for concurrent use, but each
+// Reader should only be used by one client at a time.
+type SubscriptionRegistry struct {
+	log  *commitLog
+	ttl  time.Duration
+	now  func() time.Time
+	mu   sync.Mutex
+	subs map[string]*subscription
+}
+
+type subscription struct {
+	reader   *Reader
+	offset   int64
+	lastUsed time.Time
+}
+
+// NewSubscriptionRegistry creates a new SubscriptionRegistry for the log which
+// removes subscriptions left unused for longer than the given TTL. A TTL of
+// zero or less disables expiration.
+func (l *commitLog) NewSubscriptionRegistry(ttl time.Duration) *SubscriptionRegistry {
+	return &SubscriptionRegistry{
+		log:  l,
+		ttl:  ttl,
+		now:  time.Now,
+		subs: make(map[string]*subscription),
+	}
+}
+
+// Resume returns the Reader for the subscription with the given ID. If the
+// subscription does not exist, e.g. because it is new or it expired, a new
+// committed Reader is created for it starting at the last committed offset,
+// i.e. the HW, or the beginning of the log if nothing has been committed.
+// Resuming also removes any expired subscriptions.
+func (s *SubscriptionRegistry) Resume(id string) (*Reader, error) {
+	s.mu.Lock()
+	now := s.now()
+	expired := s.sweep(now)
+	defer closeReaders(expired)
+	defer s.mu.Unlock()
+	if sub, ok := s.subs[id]; ok {
+		sub.lastUsed = now
+		return sub.reader, nil
+	}
+	offset := s.log.HighWatermark()
+	if offset < 0 {
+		offset = 0
+	}
+	reader, err := s.log.NewReader(offset, false)
+	if err != nil {
+		return nil, err
+	}
+	s.subs[id] = &subscription{
+		reader:   reader,
+		offset:   offset,
+		lastUsed: now,
+	}
+	return reader, nil
+}
+
+// Remove removes the subscription with the given ID, e.g. because its client
+// unsubscribed, and closes its Reader.
+func (s *SubscriptionRegistry) Remove(id string) {
+	s.mu.Lock()
+	sub, ok := s.subs[id]
+	delete(s.subs, id)
+	s.mu.Unlock()
+	if ok {
+		sub.reader.Close() // nolint: errcheck
+	}
+}
+
+// Len returns the number of subscriptions in the registry.
+func (s *SubscriptionRegistry) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.subs)
+}
+
+// Sweep removes subscriptions which have been unused for longer than the TTL,
+// closes their Readers, and returns the number removed.
+func (s *SubscriptionRegistry) Sweep() int {
+	s.mu.Lock()
+	expired := s.sweep(s.now())
+	s.mu.Unlock()
+	closeReaders(expired)
+	return len(expired)
+}
+
+// sweep removes expired subscriptions and returns their Readers, which the
+// caller must close once it has released the registry lock, since closing a
+// Reader runs its OnClose hook and may commit its cursor. This must be called
+// while holding the registry lock.
+func (s *SubscriptionRegistry) sweep(now time.Time) []*Reader {
+	if s.ttl <= 0 {
+		return nil
+	}
+	var expired []*Reader
+	for id, sub := range s.subs {
+		// A Reader which has advanced since it was last checked is still in
+		// use even if its subscription has not been resumed.
+		if offset := atomic.LoadInt64(&sub.reader.offset); offset != sub.offset {
+			sub.offset = offset
+			sub.lastUsed = now
+			continue
+		}
+		if now.Sub(sub.lastUsed) > s.ttl {
+			delete(s.subs, id)
+			expired = append(expired, sub.reader)
+		}
+	}
+	return expired
+}
+
+// closeReaders closes the given Readers.
+func closeReaders(readers []*Reader) {
+	for _, reader := range readers {
+		reader.Close() // nolint: errcheck
+	}
+}
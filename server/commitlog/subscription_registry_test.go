@@ -0,0 +1,116 @@
+package commitlog
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscriptionRegistryResume(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	appendToLog(t, l, []keyValue{
+		{value: []byte("a")},
+		{value: []byte("b")},
+		{value: []byte("c")},
+	}, true)
+
+	registry := l.NewSubscriptionRegistry(time.Minute)
+	r, err := registry.Resume("foo")
+	require.NoError(t, err)
+
+	// New subscriptions start at the last committed offset.
+	headers := make([]byte, 28)
+	_, offset, _, _, err := r.ReadMessage(context.Background(), headers)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), offset)
+
+	// Resuming returns the same Reader.
+	resumed, err := registry.Resume("foo")
+	require.NoError(t, err)
+	require.True(t, r == resumed)
+	require.Equal(t, 1, registry.Len())
+
+	registry.Remove("foo")
+	require.Equal(t, 0, registry.Len())
+	resumed, err = registry.Resume("foo")
+	require.NoError(t, err)
+	require.False(t, r == resumed)
+}
+
+func TestSubscriptionRegistryExpire(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	appendToLog(t, l, []keyValue{{value: []byte("a")}, {value: []byte("b")}}, true)
+
+	var (
+		registry = l.NewSubscriptionRegistry(time.Minute)
+		now      = time.Now()
+	)
+	registry.now = func() time.Time { return now }
+
+	_, err := registry.Resume("idle")
+	require.NoError(t, err)
+	active, err := registry.Resume("active")
+	require.NoError(t, err)
+
+	// Advancing the Reader keeps the subscription alive.
+	now = now.Add(50 * time.Second)
+	headers := make([]byte, 28)
+	_, _, _, _, err = active.ReadMessage(context.Background(), headers)
+	require.NoError(t, err)
+	now = now.Add(20 * time.Second)
+	require.Equal(t, 1, registry.Sweep())
+	require.Equal(t, 1, registry.Len())
+
+	now = now.Add(2 * time.Minute)
+	require.Equal(t, 1, registry.Sweep())
+	require.Equal(t, 0, registry.Len())
+}
+
+// Ensure the Readers of expired and removed subscriptions are closed, running
+// their OnClose hooks.
+func TestSubscriptionRegistryCloseReaders(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	appendToLog(t, l, []keyValue{{value: []byte("a")}}, true)
+
+	var (
+		registry = l.NewSubscriptionRegistry(time.Minute)
+		now      = time.Now()
+		closed   []string
+	)
+	registry.now = func() time.Time { return now }
+	for _, id := range []string{"expired", "removed"} {
+		id := id
+		r, err := registry.Resume(id)
+		require.NoError(t, err)
+		r.opts.OnClose = func(int64) { closed = append(closed, id) }
+	}
+
+	registry.Remove("removed")
+	require.Equal(t, []string{"removed"}, closed)
+
+	now = now.Add(2 * time.Minute)
+	_, err := registry.Resume("new")
+	require.NoError(t, err)
+	require.Equal(t, []string{"removed", "expired"}, closed)
+	require.Equal(t, 1, registry.Len())
+}
@@ -0,0 +1,113 @@
+package commitlog
+
+import "time"
+
+// ProducerTimestampHeader is the header containing the timestamp set by the
+// producer of the message, encoded as a big-endian int64 in any unit.
+const ProducerTimestampHeader = "producer_ts"
+
+// TimestampSource determines which timestamp a Reader returns for messages.
+type TimestampSource int
+
+const (
+	// TimestampLogAppend causes a Reader to return the timestamp stored in
+	// the log with the message, which is usually the time it was appended.
+	TimestampLogAppend TimestampSource = iota
+
+	// TimestampProducer causes a Reader to return the timestamp set by the
+	// producer in the ProducerTimestampHeader. Messages without the header
+	// fall back to the timestamp stored in the log.
+	TimestampProducer
+)
+
+// SetProducerTimestamp sets the producer timestamp header on the message.
+func (m *Message) SetProducerTimestamp(timestamp int64) {
+	if m.Headers == nil {
+		m.Headers = make(map[string][]byte, 1)
+	}
+	buf := make([]byte, 8)
+	encoding.PutUint64(buf, uint64(timestamp))
+	m.Headers[ProducerTimestampHeader] = buf
+}
+
+// ProducerTimestamp returns the producer timestamp header of the message. The
+// returned bool indicates if the message has a valid producer timestamp.
+func (m SerializedMessage) ProducerTimestamp() (int64, bool) {
+	ts, ok := m.Headers()[ProducerTimestampHeader]
+	if !ok || len(ts) != 8 {
+		return 0, false
+	}
+	return int64(encoding.Uint64(ts)), true
+}
+
+// timestampUnit infers the unit of a Unix timestamp from its magnitude.
+// Timestamps are assumed to be from after 1973, when millisecond timestamps
+// exceed the largest second timestamps distinguishable from them, and before
+// the year 5138, when second timestamps reach 1e11.
+func timestampUnit(timestamp int64) time.Duration {
+	switch {
+	case timestamp < 1e11:
+		return time.Second
+	case timestamp < 1e14:
+		return time.Millisecond
+	case timestamp < 1e17:
+		return time.Microsecond
+	default:
+		return time.Nanosecond
+	}
+}
+
+// TimeShift configures a Reader to rewrite returned timestamps to replay
+// historical data as if it were happening now, e.g. for load testing. The
+// first message read is given the current time and each following message
+// the current time at the first read plus its original timestamp's delta from
+// the first message's, scaled by Factor. Combined with ControlSetRateLimit,
+// this replays a log as realistic traffic.
+type TimeShift struct {
+	// Factor scales the deltas between original timestamps, e.g. 0.5 replays
+	// with half the spacing, i.e. at twice the speed. Defaults to 1.
+	Factor float64
+}
+
+// shiftState is a Reader's reference point for shifting timestamps.
+type shiftState struct {
+	first int64 // Original timestamp of the first message read
+	now   int64 // Current time at the first read, in the same unit
+}
+
+// timestamp returns the timestamp to return for the message according to the
+// Reader's TimestampSource, TimestampUnit, and TimeShift.
+func (r *Reader) timestamp(msg SerializedMessage, stored int64) int64 {
+	timestamp := stored
+	if r.opts.TimestampSource == TimestampProducer {
+		if ts, ok := msg.ProducerTimestamp(); ok {
+			timestamp = ts
+		}
+	}
+	if r.opts.TimestampUnit > 0 && timestamp > 0 {
+		timestamp = timestamp * int64(timestampUnit(timestamp)) / int64(r.opts.TimestampUnit)
+	}
+	if r.opts.TimeShift != nil {
+		timestamp = r.shiftTimestamp(timestamp)
+	}
+	return timestamp
+}
+
+// shiftTimestamp shifts the timestamp relative to the current time at the
+// first read according to the Reader's TimeShift. The current time is
+// expressed in the TimestampUnit or, if not set, the unit inferred from the
+// first timestamp.
+func (r *Reader) shiftTimestamp(ts int64) int64 {
+	if r.shift == nil {
+		unit := r.opts.TimestampUnit
+		if unit <= 0 {
+			unit = timestampUnit(ts)
+		}
+		r.shift = &shiftState{first: ts, now: timestamp() / int64(unit)}
+	}
+	factor := r.opts.TimeShift.Factor
+	if factor <= 0 {
+		factor = 1
+	}
+	return r.shift.now + int64(float64(ts-r.shift.first)*factor)
+}
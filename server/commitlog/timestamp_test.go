@@ -0,0 +1,105 @@
+package commitlog
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Ensure Readers normalize timestamps in different units and return producer
+// timestamps when configured to.
+func TestReaderTimestampNormalization(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1000,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	var (
+		ts       = time.Unix(1600000000, 123456789)
+		producer = []int64{ts.Unix(), ts.UnixNano() / 1e6, ts.UnixNano() / 1e3, ts.UnixNano(), 0}
+	)
+	for _, p := range producer {
+		msg := &Message{Value: []byte("v"), Timestamp: ts.UnixNano()}
+		if p != 0 {
+			msg.SetProducerTimestamp(p)
+		}
+		_, err := l.Append([]*Message{msg})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(int64(len(producer) - 1))
+
+	read := func(opts ReaderOptions) []int64 {
+		r, err := l.NewReaderWithOptions(0, opts)
+		require.NoError(t, err)
+		headers := make([]byte, 28)
+		timestamps := make([]int64, len(producer))
+		for i := range timestamps {
+			_, _, timestamp, _, err := r.ReadMessage(context.Background(), headers)
+			require.NoError(t, err)
+			timestamps[i] = timestamp
+		}
+		return timestamps
+	}
+
+	// By default, stored timestamps are returned unchanged.
+	nanos := ts.UnixNano()
+	require.Equal(t, []int64{nanos, nanos, nanos, nanos, nanos}, read(ReaderOptions{}))
+
+	// Producer timestamps are returned in their own units unless normalized.
+	require.Equal(t, []int64{producer[0], producer[1], producer[2], producer[3], nanos},
+		read(ReaderOptions{TimestampSource: TimestampProducer}))
+
+	millis := nanos / 1e6
+	require.Equal(t, []int64{ts.Unix() * 1e3, millis, millis, millis, millis},
+		read(ReaderOptions{TimestampSource: TimestampProducer, TimestampUnit: time.Millisecond}))
+}
+
+// Ensure TimeShift rewrites timestamps relative to the current time while
+// preserving their scaled spacing.
+func TestReaderTimeShift(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	before := timestamp
+	timestamp = func() int64 { return now.UnixNano() }
+	defer func() {
+		timestamp = before
+	}()
+
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1000,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	start := time.Unix(1600000000, 0)
+	deltas := []time.Duration{0, time.Second, 3 * time.Second}
+	for _, d := range deltas {
+		_, err := l.Append([]*Message{{Value: []byte("v"), Timestamp: start.Add(d).UnixNano()}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(int64(len(deltas) - 1))
+
+	headers := make([]byte, 28)
+	for _, tc := range []struct {
+		opts  ReaderOptions
+		scale float64
+		unit  time.Duration
+	}{
+		{ReaderOptions{TimeShift: &TimeShift{}}, 1, time.Nanosecond},
+		{ReaderOptions{TimeShift: &TimeShift{Factor: 0.5}}, 0.5, time.Nanosecond},
+		{ReaderOptions{TimeShift: &TimeShift{}, TimestampUnit: time.Millisecond}, 1, time.Millisecond},
+	} {
+		r, err := l.NewReaderWithOptions(0, tc.opts)
+		require.NoError(t, err)
+		for _, d := range deltas {
+			_, _, ts, _, err := r.ReadMessage(context.Background(), headers)
+			require.NoError(t, err)
+			expected := now.Add(time.Duration(float64(d)*tc.scale)).UnixNano() / int64(tc.unit)
+			require.Equal(t, expected, ts)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package commitlog
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// Txn is a batch of messages read by Reader.ReadTxn which is either committed
+// or aborted as a unit.
+type Txn struct {
+	// Messages contains the messages read in the transaction.
+	Messages []ReadResult
+
+	reader   *Reader
+	start    int64
+	checksum uint64
+	done     bool
+}
+
+// ReadTxn reads up to max messages from the Reader in a transaction, blocking
+// until max messages are read or the context is canceled or a read fails
+// after at least one message was read, in which case the transaction contains
+// the messages read so far and the error is returned by the next read. If no
+// message could be read, the error is returned. While the transaction is in
+// progress, the Reader's cursor is not committed. Committing the transaction
+// commits the cursor past its messages, while aborting it rewinds the Reader
+// to before them so that they are read again. Only one transaction can be in
+// progress at a time, and the Reader must not be used until it is committed
+// or aborted.
+func (r *Reader) ReadTxn(ctx context.Context, max int) (*Txn, error) {
+	if r.txn != nil {
+		return nil, errors.New("transaction already in progress")
+	}
+	txn := &Txn{
+		reader:   r,
+		start:    atomic.LoadInt64(&r.offset),
+		checksum: r.checksum,
+	}
+	r.txn = txn
+	headers := make([]byte, msgSetHeaderLen)
+	for len(txn.Messages) < max {
+		msg, offset, timestamp, leaderEpoch, err := r.ReadMessage(ctx, headers)
+		if err != nil {
+			if len(txn.Messages) > 0 {
+				break
+			}
+			r.txn = nil
+			return nil, err
+		}
+		txn.Messages = append(txn.Messages, ReadResult{
+			Message:     msg,
+			Offset:      offset,
+			Timestamp:   timestamp,
+			LeaderEpoch: leaderEpoch,
+		})
+	}
+	return txn, nil
+}
+
+// Commit ends the transaction, committing the Reader's cursor, if it has one,
+// past the messages read in the transaction.
+func (t *Txn) Commit() error {
+	if t.done {
+		return errors.New("transaction already finished")
+	}
+	t.done = true
+	r := t.reader
+	r.txn = nil
+	if r.opts.Cursor != nil {
+		return r.commitCursor()
+	}
+	return nil
+}
+
+// Abort ends the transaction, rewinding the Reader to the position it was at
+// before the transaction so that its messages are read again.
+func (t *Txn) Abort() error {
+	if t.done {
+		return errors.New("transaction already finished")
+	}
+	t.done = true
+	r := t.reader
+	r.txn = nil
+	if err := r.reposition(t.start); err != nil {
+		return errors.Wrap(err, "failed to rewind reader")
+	}
+	// The rewound messages are removed from the checksum rather than
+	// restarting it.
+	r.checksum = t.checksum
+	atomic.StoreInt64(&r.emitted, t.start-1)
+	return nil
+}
@@ -0,0 +1,123 @@
+package commitlog
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Ensure aborting a transaction rewinds the Reader and committing one commits
+// the cursor past its messages.
+func TestReaderReadTxn(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	for i := 0; i < 6; i++ {
+		_, err := l.Append([]*Message{{Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(5)
+
+	dir := tempDir(t)
+	defer remove(t, dir)
+	cursor := NewFileCursorStore(filepath.Join(dir, "cursor"))
+	r, err := l.NewReaderWithOptions(0, ReaderOptions{Cursor: cursor})
+	require.NoError(t, err)
+
+	offsets := func(txn *Txn) []int64 {
+		offsets := []int64{}
+		for _, result := range txn.Messages {
+			require.Equal(t, []byte(strconv.FormatInt(result.Offset, 10)), result.Message.Value())
+			offsets = append(offsets, result.Offset)
+		}
+		return offsets
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	txn, err := r.ReadTxn(ctx, 3)
+	require.NoError(t, err)
+	require.Equal(t, []int64{0, 1, 2}, offsets(txn))
+	_, err = r.ReadTxn(ctx, 3)
+	require.Error(t, err)
+
+	// The cursor is not committed until the transaction is.
+	stored, err := cursor.Load()
+	require.NoError(t, err)
+	require.Equal(t, int64(-1), stored)
+
+	require.NoError(t, txn.Abort())
+	require.Error(t, txn.Commit())
+
+	txn, err = r.ReadTxn(ctx, 3)
+	require.NoError(t, err)
+	require.Equal(t, []int64{0, 1, 2}, offsets(txn))
+	require.NoError(t, txn.Commit())
+	stored, err = cursor.Load()
+	require.NoError(t, err)
+	require.Equal(t, int64(3), stored)
+
+	// A read failure ends the transaction early with the messages read.
+	shortCtx, shortCancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer shortCancel()
+	txn, err = r.ReadTxn(shortCtx, 10)
+	require.NoError(t, err)
+	require.Equal(t, []int64{3, 4, 5}, offsets(txn))
+	require.NoError(t, txn.Abort())
+
+	// The aborted messages are read again.
+	txn, err = r.ReadTxn(shortCtx, 10)
+	require.NoError(t, err)
+	require.Equal(t, []int64{3, 4, 5}, offsets(txn))
+}
+
+// Ensure messages rewound by an aborted transaction or by seeking backward are
+// not skipped as duplicates by Readers with DedupeKeyFn.
+func TestReaderRewindDedupe(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	for i := 0; i < 3; i++ {
+		_, err := l.Append([]*Message{{Key: []byte(strconv.Itoa(i)), Value: []byte(strconv.Itoa(i))}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(2)
+
+	r, err := l.NewReaderWithOptions(0, ReaderOptions{
+		DedupeKeyFn: func(msg SerializedMessage) []byte { return msg.Key() },
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	txn, err := r.ReadTxn(ctx, 3)
+	require.NoError(t, err)
+	require.Len(t, txn.Messages, 3)
+	require.NoError(t, txn.Abort())
+
+	txn, err = r.ReadTxn(ctx, 3)
+	require.NoError(t, err)
+	require.Len(t, txn.Messages, 3)
+	require.Equal(t, int64(0), txn.Messages[0].Offset)
+	require.NoError(t, txn.Commit())
+
+	offset, err := r.SeekBy(ctx, 1, nil)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), offset)
+	headers := make([]byte, 28)
+	_, offset, _, _, err = r.ReadMessage(ctx, headers)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), offset)
+}
@@ -0,0 +1,99 @@
+//go:build !go1.18
+// +build !go1.18
+
+package commitlog
+
+import (
+	"context"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// DecodeFn decodes a message value into an application-defined type, e.g. by
+// unmarshaling JSON or Avro.
+type DecodeFn func(value []byte) (interface{}, error)
+
+// TypedMessage is a message read by a TypedReader whose value has been
+// decoded.
+type TypedMessage struct {
+	Value       interface{}
+	Key         []byte
+	Headers     map[string][]byte
+	Offset      int64
+	Timestamp   int64
+	LeaderEpoch uint64
+}
+
+// TypedReader wraps a Reader and decodes the value of each message it reads
+// using a DecodeFn. This allows consuming messages with arbitrary payload
+// encodings as values of the application's own type, which can be recovered
+// with a type assertion. Generics are not available before Go 1.18, so this
+// is the untyped fallback for the generic TypedReader. Like Reader, it should
+// not be used concurrently.
+type TypedReader struct {
+	reader  *Reader
+	decode  DecodeFn
+	headers []byte
+}
+
+// NewTypedReader creates a new TypedReader which reads messages from the given
+// Reader and decodes their values using decode.
+func NewTypedReader(reader *Reader, decode DecodeFn) *TypedReader {
+	return &TypedReader{
+		reader:  reader,
+		decode:  decode,
+		headers: make([]byte, msgSetHeaderLen),
+	}
+}
+
+// Read reads the next message, blocking until one is available, and decodes
+// its value. If the value cannot be decoded, an error is returned and the
+// message is skipped, so the next call to Read continues with the following
+// message.
+func (t *TypedReader) Read(ctx context.Context) (*TypedMessage, error) {
+	msg, offset, timestamp, leaderEpoch, err := t.reader.ReadMessage(ctx, t.headers)
+	if err != nil {
+		return nil, err
+	}
+	value, err := t.decode(msg.Value())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decode message at offset %d", offset)
+	}
+	return newTypedMessage(msg, value, offset, timestamp, leaderEpoch), nil
+}
+
+// ReadMessages reads and decodes up to max messages like Reader.ReadMessages.
+// Messages whose value cannot be decoded, like those whose transform fails,
+// do not fail the batch but are returned as MessageErrors, so a batch consumer
+// makes progress past messages it can't decode.
+func (t *TypedReader) ReadMessages(ctx context.Context, max int) ([]*TypedMessage, []*MessageError, error) {
+	results, failures, err := t.reader.ReadMessages(ctx, max)
+	messages := make([]*TypedMessage, 0, len(results))
+	for _, result := range results {
+		value, decodeErr := t.decode(result.Message.Value())
+		if decodeErr != nil {
+			failures = append(failures, &MessageError{Offset: result.Offset, Err: decodeErr})
+			continue
+		}
+		messages = append(messages, newTypedMessage(
+			result.Message, value, result.Offset, result.Timestamp, result.LeaderEpoch))
+	}
+	sort.Slice(failures, func(i, j int) bool {
+		return failures[i].Offset < failures[j].Offset
+	})
+	return messages, failures, err
+}
+
+func newTypedMessage(msg SerializedMessage, value interface{}, offset, timestamp int64,
+	leaderEpoch uint64) *TypedMessage {
+
+	return &TypedMessage{
+		Value:       value,
+		Key:         msg.Key(),
+		Headers:     msg.Headers(),
+		Offset:      offset,
+		Timestamp:   timestamp,
+		LeaderEpoch: leaderEpoch,
+	}
+}
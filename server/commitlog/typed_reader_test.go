@@ -0,0 +1,137 @@
+//go:build go1.18
+// +build go1.18
+
+package commitlog
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type typedValue struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// Ensure TypedReader decodes message values and skips values which cannot be
+// decoded.
+func TestTypedReader(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1000,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	values := []string{`{"name":"a","count":1}`, `not json`, `{"name":"b","count":2}`}
+	for i, value := range values {
+		_, err := l.Append([]*Message{{
+			Key:       []byte("key"),
+			Value:     []byte(value),
+			Timestamp: int64(i + 1),
+		}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(int64(len(values) - 1))
+
+	reader, err := l.NewReader(0, false)
+	require.NoError(t, err)
+	r := NewTypedReader(reader, func(value []byte) (interface{}, error) {
+		v := typedValue{}
+		err := json.Unmarshal(value, &v)
+		return v, err
+	})
+
+	msg, err := r.Read(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, typedValue{Name: "a", Count: 1}, msg.Value)
+	require.Equal(t, []byte("key"), msg.Key)
+	require.Equal(t, int64(0), msg.Offset)
+	require.Equal(t, int64(1), msg.Timestamp)
+
+	_, err = r.Read(context.Background())
+	require.Error(t, err)
+
+	msg, err = r.Read(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, typedValue{Name: "b", Count: 2}, msg.Value.(typedValue))
+	require.Equal(t, int64(2), msg.Offset)
+}
+
+// Ensure TypedReader.ReadMessages returns messages which cannot be decoded as
+// message errors without failing the batch.
+func TestTypedReaderReadMessages(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1000,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	values := []string{`{"name":"a","count":1}`, `not json`, `{"name":"b","count":2}`}
+	for _, value := range values {
+		_, err := l.Append([]*Message{{Value: []byte(value)}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(int64(len(values) - 1))
+
+	reader, err := l.NewReader(0, false)
+	require.NoError(t, err)
+	r := NewTypedReader(reader, func(value []byte) (interface{}, error) {
+		v := typedValue{}
+		err := json.Unmarshal(value, &v)
+		return v, err
+	})
+
+	messages, failures, err := r.ReadMessages(context.Background(), len(values))
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	require.Equal(t, typedValue{Name: "a", Count: 1}, messages[0].Value)
+	require.Equal(t, int64(0), messages[0].Offset)
+	require.Equal(t, typedValue{Name: "b", Count: 2}, messages[1].Value)
+	require.Equal(t, int64(2), messages[1].Offset)
+	require.Len(t, failures, 1)
+	require.Equal(t, int64(1), failures[0].Offset)
+	require.Error(t, failures[0].Err)
+}
+
+// Ensure a TypedReader with a DecodeFn yielding a concrete type returns values
+// of that type without type assertions.
+func TestTypedReaderGeneric(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 1000,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	values := []string{`{"name":"a","count":1}`, `not json`, `{"name":"b","count":2}`}
+	for _, value := range values {
+		_, err := l.Append([]*Message{{Value: []byte(value)}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(int64(len(values) - 1))
+
+	reader, err := l.NewReader(0, false)
+	require.NoError(t, err)
+	r := NewTypedReader(reader, func(value []byte) (typedValue, error) {
+		var v typedValue
+		err := json.Unmarshal(value, &v)
+		return v, err
+	})
+
+	msg, err := r.Read(context.Background())
+	require.NoError(t, err)
+	var value typedValue = msg.Value
+	require.Equal(t, typedValue{Name: "a", Count: 1}, value)
+
+	messages, failures, err := r.ReadMessages(context.Background(), 2)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	require.Equal(t, 2, messages[0].Value.Count)
+	require.Len(t, failures, 1)
+	require.Equal(t, int64(1), failures[0].Offset)
+}
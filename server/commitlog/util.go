@@ -19,6 +19,27 @@ func findSegment(segments []*segment, offset int64) (*segment, int) {
 	return segments[idx], idx
 }
 
+// findSegmentBy is like findSegment but generalized to an arbitrary ordering.
+// It returns the first non-empty segment whose last offset satisfies pred,
+// which must be false for a prefix of the segments and true for the rest.
+// Returns nil if there is no such segment.
+func findSegmentBy(segments []*segment, pred func(offset int64) bool) *segment {
+	nonEmpty := make([]*segment, 0, len(segments))
+	for _, seg := range segments {
+		if !seg.IsEmpty() {
+			nonEmpty = append(nonEmpty, seg)
+		}
+	}
+	n := len(nonEmpty)
+	idx := sort.Search(n, func(i int) bool {
+		return pred(nonEmpty[i].NextOffset() - 1)
+	})
+	if idx == n {
+		return nil
+	}
+	return nonEmpty[idx]
+}
+
 // findSegmentContains returns the first segment whose next assignable offset
 // is greater than the given offset and a bool indicating if the returned
 // segment contains the offset, meaning the offset is between the segment's
@@ -36,16 +57,24 @@ func findSegmentContains(segments []*segment, offset int64) (*segment, bool) {
 // findSegmentIndexByTimestamp returns the index of the first segment whose
 // base timestamp is greater than the given timestamp. Returns the index where
 // the segment would be if there is no segment whose base timestamp is greater,
-// i.e. the length of the slice.
-func findSegmentIndexByTimestamp(segments []*segment, timestamp int64) (int, error) {
+// i.e. the length of the slice. Each segment's first index entry read is
+// charged to the budget, which may be nil, and ErrSearchBudgetExceeded is
+// returned if it is exceeded.
+func findSegmentIndexByTimestamp(segments []*segment, timestamp int64,
+	budget *searchBudget) (int, error) {
+
 	var (
 		n   = len(segments)
 		err error
 	)
 	idx := sort.Search(n, func(i int) bool {
+		if !budget.entry() {
+			err = ErrSearchBudgetExceeded
+			return true
+		}
 		// Read the first entry in the segment to determine the base timestamp.
-		var entry entry
-		if e := segments[i].Index.ReadEntryAtLogOffset(&entry, 0); e != nil {
+		entry, e := segments[i].entryAt(0)
+		if e != nil {
 			err = e
 			return true
 		}
@@ -0,0 +1,89 @@
+package commitlog
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// WindowedReader is a committed Reader which groups messages into fixed,
+// non-overlapping time windows based on their timestamps. This is useful for
+// consumers which aggregate a stream over time, e.g. counting messages per
+// minute. WindowedReaders should not be used concurrently.
+type WindowedReader struct {
+	ctx     context.Context
+	reader  *Reader
+	window  int64
+	headers []byte
+	pending *Message
+}
+
+// NewWindowedReader creates a new WindowedReader starting at the given offset
+// which groups committed messages into windows of the given duration. Windows
+// are aligned to multiples of the duration since the Unix epoch. The context
+// governs all reads performed by the WindowedReader.
+func (l *commitLog) NewWindowedReader(ctx context.Context, offset int64,
+	window time.Duration) (*WindowedReader, error) {
+
+	if window <= 0 {
+		return nil, errors.New("window must be positive")
+	}
+	reader, err := l.NewReader(offset, false)
+	if err != nil {
+		return nil, err
+	}
+	return &WindowedReader{
+		ctx:     ctx,
+		reader:  reader,
+		window:  int64(window),
+		headers: make([]byte, msgSetHeaderLen),
+	}, nil
+}
+
+// NextWindow returns the messages in the next time window along with the
+// window's start time in Unix nanoseconds. A window is only closed once a
+// message whose timestamp falls into a later window is read, so NextWindow
+// blocks until that happens. Windows without messages are skipped. Messages
+// are grouped in offset order, so a message whose timestamp falls into an
+// earlier window than its predecessor is included in the current window.
+//
+// If a read fails, e.g. because the context is canceled or an end-of-stream
+// marker is read, the messages of the incomplete window are returned along
+// with the error so that they can be flushed. Subsequent calls return only
+// the error.
+func (w *WindowedReader) NextWindow() ([]*Message, int64, error) {
+	var (
+		msgs        []*Message
+		windowStart int64
+	)
+	if w.pending != nil {
+		msgs = append(msgs, w.pending)
+		windowStart = w.windowStart(w.pending.Timestamp)
+		w.pending = nil
+	}
+	for {
+		msg, _, timestamp, leaderEpoch, err := w.reader.ReadMessage(w.ctx, w.headers)
+		if err != nil {
+			return msgs, windowStart, err
+		}
+		m := msg.toMessage(timestamp, leaderEpoch)
+		start := w.windowStart(timestamp)
+		if len(msgs) == 0 {
+			windowStart = start
+		} else if start > windowStart {
+			w.pending = m
+			return msgs, windowStart, nil
+		}
+		msgs = append(msgs, m)
+	}
+}
+
+// windowStart returns the start of the window containing the given
+// timestamp.
+func (w *WindowedReader) windowStart(timestamp int64) int64 {
+	start := timestamp - timestamp%w.window
+	if timestamp < 0 && start != timestamp {
+		start -= w.window
+	}
+	return start
+}
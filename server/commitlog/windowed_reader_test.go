@@ -0,0 +1,74 @@
+package commitlog
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWindowedReader(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	minute := int64(time.Minute)
+	timestamps := []int64{
+		minute, minute + 1, 2*minute - 1, // Window 1
+		2 * minute,    // Window 2
+		4*minute + 10, // Window 4
+		5 * minute,    // Window 5
+	}
+	for _, ts := range timestamps {
+		_, err := l.Append([]*Message{{Value: []byte("v"), Timestamp: ts}})
+		require.NoError(t, err)
+	}
+	l.SetHighWatermark(int64(len(timestamps) - 1))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r, err := l.NewWindowedReader(ctx, 0, time.Minute)
+	require.NoError(t, err)
+
+	expected := []struct {
+		start int64
+		count int
+	}{
+		{minute, 3},
+		{2 * minute, 1},
+		{4 * minute, 1},
+	}
+	for _, exp := range expected {
+		msgs, start, err := r.NextWindow()
+		require.NoError(t, err)
+		require.Equal(t, exp.start, start)
+		require.Len(t, msgs, exp.count)
+		for _, msg := range msgs {
+			require.Equal(t, []byte("v"), msg.Value)
+			require.True(t, msg.Timestamp >= start && msg.Timestamp < start+minute)
+		}
+	}
+
+	// The last window is incomplete, so it's returned with the error once
+	// the context is canceled.
+	cancel()
+	msgs, start, err := r.NextWindow()
+	require.Error(t, err)
+	require.Equal(t, 5*minute, start)
+	require.Len(t, msgs, 1)
+}
+
+func TestWindowedReaderInvalidWindow(t *testing.T) {
+	l, cleanup := setupWithOptions(t, Options{
+		Path:            tempDir(t),
+		MaxSegmentBytes: 100,
+	})
+	defer cleanup()
+	defer l.Close()
+
+	_, err := l.NewWindowedReader(context.Background(), 0, 0)
+	require.Error(t, err)
+}
@@ -62,6 +62,24 @@ var (
 	crc32cTable = crc32.MakeTable(crc32.Castagnoli)
 )
 
+// ErrUnsupportedSchema is returned when an envelope is encoded with a protocol
+// version that is not supported.
+type ErrUnsupportedSchema struct {
+	Version byte
+}
+
+// Error returns a string describing the unsupported protocol version.
+func (e *ErrUnsupportedSchema) Error() string {
+	return fmt.Sprintf("unsupported envelope protocol version: %v", e.Version)
+}
+
+// SchemaValidator is invoked when an envelope is encoded with a protocol
+// version other than the current one. It returns the payload to unmarshal,
+// which may be migrated to the current schema, or an error, typically
+// ErrUnsupportedSchema, if the version cannot be handled. This allows rolling
+// upgrades to handle mixed-version data.
+type SchemaValidator func(version byte, payload []byte) ([]byte, error)
+
 // MarshalPublish serializes a protobuf publish message into the Liftbridge
 // envelope wire format.
 func MarshalPublish(msg *client.Message) ([]byte, error) {
@@ -198,6 +216,22 @@ func UnmarshalPublish(data []byte) (*client.Message, error) {
 	return msg, err
 }
 
+// UnmarshalPublishWithSchema deserializes a Liftbridge publish envelope into a
+// protobuf message, using the given SchemaValidator to reject or migrate
+// envelopes encoded with an unexpected protocol version. If validator is nil,
+// this behaves like UnmarshalPublish.
+func UnmarshalPublishWithSchema(data []byte, validator SchemaValidator) (*client.Message, error) {
+	payload, err := checkEnvelopeWithSchema(data, msgTypePublish, validator)
+	if err != nil {
+		return nil, err
+	}
+	msg := new(client.Message)
+	if err := pb.Unmarshal(payload, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
 // UnmarshalAck deserializes a Liftbridge ack envelope into a protobuf message.
 func UnmarshalAck(data []byte) (*client.Ack, error) {
 	var (
@@ -359,14 +393,19 @@ func unmarshalEnvelope(data []byte, msg pb.Message, msgType msgType) error {
 }
 
 func checkEnvelope(data []byte, expectedType msgType) ([]byte, error) {
+	return checkEnvelopeWithSchema(data, expectedType, nil)
+}
+
+func checkEnvelopeWithSchema(data []byte, expectedType msgType, validator SchemaValidator) ([]byte, error) {
 	if len(data) < envelopeMinHeaderLen {
 		return nil, errors.New("data missing envelope header")
 	}
 	if !bytes.Equal(data[:envelopeMagicNumberLen], envelopeMagicNumber) {
 		return nil, errors.New("unexpected envelope magic number")
 	}
-	if data[4] != envelopeProtoV0 {
-		return nil, fmt.Errorf("unknown envelope protocol: %v", data[4])
+	version := data[4]
+	if version != envelopeProtoV0 && validator == nil {
+		return nil, &ErrUnsupportedSchema{Version: version}
 	}
 
 	var (
@@ -392,6 +431,10 @@ func checkEnvelope(data []byte, expectedType msgType) ([]byte, error) {
 		}
 	}
 
+	if version != envelopeProtoV0 {
+		return validator(version, payload)
+	}
+
 	return payload, nil
 }
 
@@ -285,6 +285,49 @@ func TestUnmarshalEnvelopeUnexpectedProtoVersion(t *testing.T) {
 	require.Error(t, err)
 }
 
+// Ensure UnmarshalPublishWithSchema returns ErrUnsupportedSchema when the
+// SchemaValidator rejects the protocol version.
+func TestUnmarshalPublishWithSchemaUnsupported(t *testing.T) {
+	msg, err := MarshalPublish(new(client.Message))
+	require.NoError(t, err)
+	msg[4] = 0x02
+
+	_, err = UnmarshalPublishWithSchema(msg, nil)
+	require.Equal(t, &ErrUnsupportedSchema{Version: 0x02}, err)
+
+	_, err = UnmarshalPublishWithSchema(msg, func(version byte, payload []byte) ([]byte, error) {
+		return nil, &ErrUnsupportedSchema{Version: version}
+	})
+	require.Equal(t, &ErrUnsupportedSchema{Version: 0x02}, err)
+}
+
+// Ensure UnmarshalPublishWithSchema uses the payload returned by the
+// SchemaValidator to migrate envelopes with an older protocol version.
+func TestUnmarshalPublishWithSchemaMigrate(t *testing.T) {
+	old, err := MarshalPublish(&client.Message{Value: []byte("old")})
+	require.NoError(t, err)
+	old[4] = 0x01
+	migrated, err := MarshalPublish(&client.Message{Value: []byte("migrated")})
+	require.NoError(t, err)
+
+	var seenVersion byte
+	msg, err := UnmarshalPublishWithSchema(old, func(version byte, payload []byte) ([]byte, error) {
+		seenVersion = version
+		return migrated[envelopeMinHeaderLen:], nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, byte(0x01), seenVersion)
+	require.Equal(t, []byte("migrated"), msg.Value)
+
+	// The validator is not invoked for the current protocol version.
+	msg, err = UnmarshalPublishWithSchema(migrated, func(version byte, payload []byte) ([]byte, error) {
+		t.Fatal("Unexpected call to validator")
+		return nil, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []byte("migrated"), msg.Value)
+}
+
 // Ensure unmarshalEnvelope returns an error if the CRC flag is set but no CRC
 // is present.
 func TestUnmarshalEnvelopeMissingCRC(t *testing.T) {
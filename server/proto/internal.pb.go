@@ -2,34 +2,37 @@
 // source: server/proto/internal.proto
 
 /*
-	Package proto is a generated protocol buffer package.
-
-	It is generated from these files:
-		server/proto/internal.proto
-
-	It has these top-level messages:
-		ServerState
-		RaftLog
-		CreatePartitionOp
-		ShrinkISROp
-		ExpandISROp
-		ReportLeaderOp
-		ChangeLeaderOp
-		Partition
-		RaftJoinRequest
-		RaftJoinResponse
-		MetadataSnapshot
-		ReplicationRequest
-		LeaderEpochOffsetRequest
-		LeaderEpochOffsetResponse
-		PropagatedRequest
-		Error
-		PropagatedResponse
-		ServerInfoRequest
-		ServerInfoResponse
-		PartitionStatusRequest
-		PartitionStatusResponse
-		PartitionNotification
+Package proto is a generated protocol buffer package.
+
+It is generated from these files:
+
+	server/proto/internal.proto
+
+It has these top-level messages:
+
+	ServerState
+	RaftLog
+	CreatePartitionOp
+	ShrinkISROp
+	ExpandISROp
+	ReportLeaderOp
+	ChangeLeaderOp
+	Partition
+	RaftJoinRequest
+	RaftJoinResponse
+	MetadataSnapshot
+	ReplicationRequest
+	LeaderEpochOffsetRequest
+	LeaderEpochOffsetResponse
+	PropagatedRequest
+	Error
+	PropagatedResponse
+	ServerInfoRequest
+	ServerInfoResponse
+	PartitionStatusRequest
+	PartitionStatusResponse
+	PartitionNotification
+	Cursor
 */
 package proto
 
@@ -80,6 +83,30 @@ func (x Op) String() string {
 }
 func (Op) EnumDescriptor() ([]byte, []int) { return fileDescriptorInternal, []int{0} }
 
+type ReaderMode int32
+
+const (
+	ReaderMode_COMMITTED   ReaderMode = 0
+	ReaderMode_UNCOMMITTED ReaderMode = 1
+	ReaderMode_SNAPSHOT    ReaderMode = 2
+)
+
+var ReaderMode_name = map[int32]string{
+	0: "COMMITTED",
+	1: "UNCOMMITTED",
+	2: "SNAPSHOT",
+}
+var ReaderMode_value = map[string]int32{
+	"COMMITTED":   0,
+	"UNCOMMITTED": 1,
+	"SNAPSHOT":    2,
+}
+
+func (x ReaderMode) String() string {
+	return proto1.EnumName(ReaderMode_name, int32(x))
+}
+func (ReaderMode) EnumDescriptor() ([]byte, []int) { return fileDescriptorInternal, []int{1} }
+
 type ServerState struct {
 	ServerID string `protobuf:"bytes,1,opt,name=serverID,proto3" json:"serverID,omitempty"`
 }
@@ -715,10 +742,12 @@ type PartitionStatusResponse struct {
 	IsLeader bool `protobuf:"varint,2,opt,name=isLeader,proto3" json:"isLeader,omitempty"`
 }
 
-func (m *PartitionStatusResponse) Reset()                    { *m = PartitionStatusResponse{} }
-func (m *PartitionStatusResponse) String() string            { return proto1.CompactTextString(m) }
-func (*PartitionStatusResponse) ProtoMessage()               {}
-func (*PartitionStatusResponse) Descriptor() ([]byte, []int) { return fileDescriptorInternal, []int{20} }
+func (m *PartitionStatusResponse) Reset()         { *m = PartitionStatusResponse{} }
+func (m *PartitionStatusResponse) String() string { return proto1.CompactTextString(m) }
+func (*PartitionStatusResponse) ProtoMessage()    {}
+func (*PartitionStatusResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptorInternal, []int{20}
+}
 
 func (m *PartitionStatusResponse) GetExists() bool {
 	if m != nil {
@@ -758,6 +787,57 @@ func (m *PartitionNotification) GetPartition() int32 {
 	return 0
 }
 
+// Cursor is a resume token for a commit log reader. It can be constructed and
+// interpreted by clients in any language in order to resume reading a
+// partition at a given position.
+type Cursor struct {
+	Version       uint32     `protobuf:"varint,1,opt,name=version,proto3" json:"version,omitempty"`
+	Offset        int64      `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"`
+	SegmentBase   int64      `protobuf:"varint,3,opt,name=segmentBase,proto3" json:"segmentBase,omitempty"`
+	HighWatermark int64      `protobuf:"varint,4,opt,name=highWatermark,proto3" json:"highWatermark,omitempty"`
+	Mode          ReaderMode `protobuf:"varint,5,opt,name=mode,proto3,enum=proto.ReaderMode" json:"mode,omitempty"`
+}
+
+func (m *Cursor) Reset()                    { *m = Cursor{} }
+func (m *Cursor) String() string            { return proto1.CompactTextString(m) }
+func (*Cursor) ProtoMessage()               {}
+func (*Cursor) Descriptor() ([]byte, []int) { return fileDescriptorInternal, []int{22} }
+
+func (m *Cursor) GetVersion() uint32 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+func (m *Cursor) GetOffset() int64 {
+	if m != nil {
+		return m.Offset
+	}
+	return 0
+}
+
+func (m *Cursor) GetSegmentBase() int64 {
+	if m != nil {
+		return m.SegmentBase
+	}
+	return 0
+}
+
+func (m *Cursor) GetHighWatermark() int64 {
+	if m != nil {
+		return m.HighWatermark
+	}
+	return 0
+}
+
+func (m *Cursor) GetMode() ReaderMode {
+	if m != nil {
+		return m.Mode
+	}
+	return ReaderMode_COMMITTED
+}
+
 func init() {
 	proto1.RegisterType((*ServerState)(nil), "proto.ServerState")
 	proto1.RegisterType((*RaftLog)(nil), "proto.RaftLog")
@@ -781,7 +861,9 @@ func init() {
 	proto1.RegisterType((*PartitionStatusRequest)(nil), "proto.PartitionStatusRequest")
 	proto1.RegisterType((*PartitionStatusResponse)(nil), "proto.PartitionStatusResponse")
 	proto1.RegisterType((*PartitionNotification)(nil), "proto.PartitionNotification")
+	proto1.RegisterType((*Cursor)(nil), "proto.Cursor")
 	proto1.RegisterEnum("proto.Op", Op_name, Op_value)
+	proto1.RegisterEnum("proto.ReaderMode", ReaderMode_name, ReaderMode_value)
 }
 func (m *ServerState) Marshal() (dAtA []byte, err error) {
 	size := m.Size()
@@ -1602,6 +1684,49 @@ func (m *PartitionNotification) MarshalTo(dAtA []byte) (int, error) {
 	return i, nil
 }
 
+func (m *Cursor) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *Cursor) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if m.Version != 0 {
+		dAtA[i] = 0x8
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(m.Version))
+	}
+	if m.Offset != 0 {
+		dAtA[i] = 0x10
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(m.Offset))
+	}
+	if m.SegmentBase != 0 {
+		dAtA[i] = 0x18
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(m.SegmentBase))
+	}
+	if m.HighWatermark != 0 {
+		dAtA[i] = 0x20
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(m.HighWatermark))
+	}
+	if m.Mode != 0 {
+		dAtA[i] = 0x28
+		i++
+		i = encodeVarintInternal(dAtA, i, uint64(m.Mode))
+	}
+	return i, nil
+}
+
 func encodeVarintInternal(dAtA []byte, offset int, v uint64) int {
 	for v >= 1<<7 {
 		dAtA[offset] = uint8(v&0x7f | 0x80)
@@ -1974,6 +2099,27 @@ func (m *PartitionNotification) Size() (n int) {
 	return n
 }
 
+func (m *Cursor) Size() (n int) {
+	var l int
+	_ = l
+	if m.Version != 0 {
+		n += 1 + sovInternal(uint64(m.Version))
+	}
+	if m.Offset != 0 {
+		n += 1 + sovInternal(uint64(m.Offset))
+	}
+	if m.SegmentBase != 0 {
+		n += 1 + sovInternal(uint64(m.SegmentBase))
+	}
+	if m.HighWatermark != 0 {
+		n += 1 + sovInternal(uint64(m.HighWatermark))
+	}
+	if m.Mode != 0 {
+		n += 1 + sovInternal(uint64(m.Mode))
+	}
+	return n
+}
+
 func sovInternal(x uint64) (n int) {
 	for {
 		n++
@@ -4699,6 +4845,151 @@ func (m *PartitionNotification) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
+func (m *Cursor) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowInternal
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: Cursor: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: Cursor: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Version", wireType)
+			}
+			m.Version = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Version |= (uint32(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Offset", wireType)
+			}
+			m.Offset = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Offset |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SegmentBase", wireType)
+			}
+			m.SegmentBase = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.SegmentBase |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 4:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field HighWatermark", wireType)
+			}
+			m.HighWatermark = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.HighWatermark |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 5:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Mode", wireType)
+			}
+			m.Mode = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternal
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Mode |= (ReaderMode(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		default:
+			iNdEx = preIndex
+			skippy, err := skipInternal(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthInternal
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
 func skipInternal(dAtA []byte) (n int, err error) {
 	l := len(dAtA)
 	iNdEx := 0
@@ -4807,63 +5098,70 @@ var (
 func init() { proto1.RegisterFile("server/proto/internal.proto", fileDescriptorInternal) }
 
 var fileDescriptorInternal = []byte{
-	// 915 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xcc, 0x56, 0x4f, 0x73, 0x1b, 0x35,
-	0x14, 0xef, 0xae, 0xe3, 0x24, 0xfb, 0xdc, 0xba, 0x6b, 0x4d, 0x1b, 0xb6, 0x90, 0xc9, 0x78, 0xc4,
-	0x25, 0x30, 0x90, 0x32, 0x81, 0x0b, 0x33, 0x70, 0x30, 0xc9, 0x96, 0xba, 0x38, 0xb6, 0x47, 0xf6,
-	0x81, 0x13, 0x41, 0xf5, 0xca, 0xf6, 0x42, 0xb2, 0x12, 0x92, 0xd2, 0xe9, 0xb7, 0xe0, 0x08, 0xc3,
-	0xad, 0x27, 0xbe, 0x0a, 0x47, 0x3e, 0x02, 0x13, 0xbe, 0x08, 0x23, 0xad, 0xf6, 0x8f, 0xed, 0x0e,
-	0x33, 0x38, 0x17, 0x4e, 0xd6, 0xef, 0xe9, 0xe9, 0xbd, 0xdf, 0xfb, 0x3d, 0xe9, 0xad, 0xe1, 0x3d,
-	0xc5, 0xe4, 0x2b, 0x26, 0x9f, 0x0a, 0xc9, 0x35, 0x7f, 0x9a, 0x66, 0x9a, 0xc9, 0x8c, 0x5e, 0x9d,
-	0x58, 0x88, 0x9a, 0xf6, 0x07, 0x7f, 0x00, 0xad, 0x89, 0xf5, 0x9a, 0x68, 0xaa, 0x19, 0x7a, 0x17,
-	0xf6, 0xf3, 0x43, 0xfd, 0xf3, 0xc8, 0xeb, 0x7a, 0xc7, 0x01, 0x29, 0x31, 0xfe, 0xc5, 0x87, 0x3d,
-	0x42, 0xe7, 0x7a, 0xc0, 0x17, 0xe8, 0x09, 0xf8, 0x5c, 0x58, 0x8f, 0xf6, 0x69, 0x90, 0x47, 0x3c,
-	0x19, 0x09, 0xe2, 0x73, 0x81, 0x9e, 0x41, 0x67, 0x26, 0x19, 0xd5, 0x6c, 0x4c, 0xa5, 0x4e, 0x75,
-	0xca, 0xb3, 0x91, 0x88, 0xfc, 0xae, 0x77, 0xdc, 0x3a, 0x8d, 0x9c, 0xe7, 0xd9, 0xfa, 0x3e, 0xd9,
-	0x3c, 0x82, 0x3e, 0x83, 0x96, 0x5a, 0xca, 0x34, 0xfb, 0xb1, 0x3f, 0x21, 0x23, 0x11, 0x35, 0x6c,
-	0x04, 0xe4, 0x22, 0x4c, 0xaa, 0x1d, 0x52, 0x77, 0x43, 0x5f, 0x42, 0x7b, 0xb6, 0xa4, 0xd9, 0x82,
-	0x0d, 0x18, 0x4d, 0x98, 0x1c, 0x89, 0x68, 0xc7, 0x1e, 0x7c, 0x5c, 0xa4, 0x5e, 0xd9, 0x24, 0x6b,
-	0xce, 0x26, 0x29, 0x7b, 0x2d, 0x68, 0x96, 0xe4, 0x49, 0x9b, 0x2b, 0x49, 0xe3, 0x6a, 0x87, 0xd4,
-	0xdd, 0xf0, 0x19, 0x74, 0x36, 0x4a, 0x42, 0x27, 0x10, 0x88, 0x02, 0x5a, 0xa5, 0x5a, 0xa7, 0xa1,
-	0x0b, 0x54, 0xba, 0x91, 0xca, 0x05, 0xff, 0xee, 0x41, 0xab, 0x56, 0x16, 0x3a, 0x80, 0x5d, 0xa5,
-	0x25, 0xa3, 0xd7, 0xae, 0x11, 0x0e, 0xa1, 0xc3, 0x7a, 0x5c, 0xa3, 0x6b, 0xb3, 0x16, 0x05, 0x1d,
-	0xc3, 0x43, 0xc9, 0xc4, 0x55, 0x3a, 0xa3, 0x53, 0x4e, 0xd8, 0x35, 0x7f, 0xc5, 0xac, 0x72, 0x01,
-	0x59, 0x37, 0x9b, 0xf8, 0x57, 0xb6, 0x6c, 0xab, 0x50, 0x40, 0x1c, 0x42, 0x5d, 0x68, 0xe5, 0xab,
-	0x58, 0xf0, 0xd9, 0xd2, 0x4a, 0xb0, 0x43, 0xea, 0x26, 0xfc, 0xc6, 0x83, 0x56, 0x4d, 0x8b, 0x2d,
-	0x99, 0x62, 0xb8, 0x5f, 0x52, 0xea, 0x25, 0x89, 0xa3, 0xb9, 0x62, 0xbb, 0x03, 0xc7, 0xdf, 0x3c,
-	0x68, 0x13, 0x26, 0xb8, 0xd4, 0x65, 0x6f, 0xb7, 0xa3, 0x19, 0xc1, 0x9e, 0xa3, 0xe4, 0x18, 0x16,
-	0xf0, 0x0e, 0xe4, 0xbe, 0x83, 0xf6, 0xea, 0x3d, 0xdc, 0x92, 0x5b, 0xc5, 0xa0, 0x51, 0x67, 0x80,
-	0x7f, 0xf6, 0x21, 0x18, 0xd7, 0x2b, 0x50, 0x37, 0x2f, 0x7f, 0x60, 0x33, 0xed, 0x82, 0x17, 0xb0,
-	0x96, 0xd5, 0x5f, 0xc9, 0xda, 0x06, 0x3f, 0xcd, 0x1b, 0xd2, 0x24, 0x7e, 0x9a, 0xa0, 0x47, 0xd0,
-	0x5c, 0x48, 0x7e, 0x23, 0x5c, 0xa1, 0x39, 0x40, 0x1f, 0x41, 0xc7, 0x49, 0x61, 0xd2, 0x3c, 0xa3,
-	0x33, 0xcd, 0xa5, 0xad, 0xb6, 0x49, 0x36, 0x37, 0xcc, 0x64, 0x71, 0x46, 0x15, 0xed, 0x76, 0x1b,
-	0x66, 0xb2, 0x14, 0xb8, 0x56, 0xc7, 0xde, 0x8a, 0x92, 0x21, 0x34, 0x52, 0x25, 0xa3, 0x7d, 0xeb,
-	0x6e, 0x96, 0xeb, 0xda, 0x06, 0x1b, 0xda, 0x1a, 0xae, 0xcc, 0xee, 0x81, 0xdd, 0xcb, 0x01, 0x8e,
-	0xe1, 0xa1, 0x19, 0x5d, 0x2f, 0x78, 0x9a, 0x11, 0xf6, 0xd3, 0x0d, 0x53, 0xb6, 0xf8, 0x8c, 0x27,
-	0xac, 0x1c, 0x74, 0x0e, 0x19, 0xa2, 0x66, 0xd5, 0x4b, 0x12, 0xe9, 0x64, 0x29, 0x31, 0x3e, 0x86,
-	0xb0, 0x0a, 0xa3, 0x04, 0xcf, 0x14, 0xb3, 0x09, 0xa5, 0xe4, 0xd2, 0x85, 0xc9, 0x01, 0x3e, 0x87,
-	0xf0, 0x82, 0x69, 0x9a, 0x50, 0x4d, 0x27, 0x19, 0x15, 0x6a, 0xc9, 0x35, 0xfa, 0x04, 0xa0, 0xec,
-	0x9d, 0x8a, 0xbc, 0x6e, 0xe3, 0xad, 0x23, 0xa1, 0xe6, 0x83, 0x5f, 0x00, 0x22, 0x95, 0x92, 0x05,
-	0xf3, 0x43, 0x08, 0x9c, 0x74, 0x25, 0xf9, 0xca, 0x60, 0xea, 0xe2, 0xf3, 0xb9, 0x62, 0xda, 0xb2,
-	0x6f, 0x10, 0x87, 0xf0, 0x17, 0x10, 0x0d, 0x2a, 0x9d, 0x46, 0xd6, 0x58, 0x44, 0x5c, 0x93, 0xd5,
-	0xdb, 0xbc, 0xb2, 0x9f, 0xc3, 0x93, 0xb7, 0x9c, 0x76, 0x12, 0x1c, 0x42, 0xc0, 0xb2, 0x24, 0x37,
-	0xda, 0xc3, 0x0d, 0x52, 0x19, 0xf0, 0x1b, 0x1f, 0x3a, 0x63, 0xc9, 0x05, 0x5d, 0x50, 0xcd, 0x92,
-	0x22, 0xe5, 0xff, 0xf9, 0x0b, 0x22, 0x57, 0x06, 0xc7, 0xda, 0x17, 0x64, 0x75, 0xaa, 0x90, 0x35,
-	0xe7, 0x2d, 0xbf, 0x20, 0x1f, 0x43, 0x33, 0x36, 0xf7, 0x06, 0x21, 0xd8, 0x99, 0xf1, 0x84, 0x59,
-	0x61, 0x1e, 0x10, 0xbb, 0x36, 0xcf, 0xe0, 0x5a, 0x2d, 0xdc, 0x65, 0x34, 0x4b, 0x3c, 0x01, 0x54,
-	0x57, 0xd4, 0xb5, 0xe1, 0x5f, 0x24, 0xc5, 0xc5, 0x25, 0xcd, 0x65, 0xbc, 0x5f, 0xf0, 0x31, 0xb6,
-	0xe2, 0xca, 0xbe, 0x0f, 0x9d, 0xfc, 0xaf, 0x40, 0x3f, 0x9b, 0xf3, 0xa2, 0x4d, 0xf9, 0x28, 0xc8,
-	0x2f, 0x99, 0x9f, 0x26, 0x78, 0x00, 0xa8, 0xee, 0xe4, 0x32, 0xaf, 0x79, 0x99, 0x2a, 0x96, 0x5c,
-	0x69, 0x47, 0xd9, 0xae, 0x8d, 0xcd, 0x08, 0xe5, 0xc6, 0x8a, 0x5d, 0xe3, 0x21, 0x1c, 0x94, 0x0d,
-	0x33, 0x7f, 0x40, 0x6e, 0x54, 0xed, 0x75, 0xfe, 0xf7, 0x81, 0x88, 0x2f, 0xe0, 0x9d, 0x8d, 0x78,
-	0x8e, 0xe2, 0x01, 0xec, 0xb2, 0xd7, 0xa9, 0xd2, 0xca, 0x06, 0xdc, 0x27, 0x0e, 0x99, 0xe7, 0x9e,
-	0xaa, 0xbc, 0x7b, 0x36, 0xde, 0x3e, 0x29, 0x31, 0xbe, 0x80, 0xc7, 0x65, 0xb8, 0x21, 0xd7, 0xe9,
-	0xdc, 0x3d, 0xc4, 0xed, 0xd8, 0x7d, 0xf8, 0x3d, 0xf8, 0x23, 0x81, 0x1e, 0x41, 0x78, 0x46, 0xe2,
-	0xde, 0x34, 0xbe, 0x1c, 0xf7, 0xc8, 0xb4, 0x3f, 0xed, 0x8f, 0x86, 0xe1, 0x3d, 0xd4, 0x06, 0x98,
-	0x3c, 0x27, 0xfd, 0xe1, 0x37, 0x97, 0xfd, 0x09, 0x09, 0x3d, 0xd4, 0x81, 0x07, 0x24, 0x1e, 0x8f,
-	0xc8, 0xf4, 0x72, 0x10, 0xf7, 0xce, 0x63, 0x12, 0xfa, 0xc6, 0x74, 0xf6, 0xbc, 0x37, 0xfc, 0x3a,
-	0x2e, 0x4c, 0x0d, 0x73, 0x2a, 0xfe, 0x76, 0xdc, 0x1b, 0x9e, 0xdb, 0x53, 0x3b, 0x5f, 0x85, 0x7f,
-	0xdc, 0x1e, 0x79, 0x7f, 0xde, 0x1e, 0x79, 0x7f, 0xdd, 0x1e, 0x79, 0xbf, 0xfe, 0x7d, 0x74, 0xef,
-	0xe5, 0xae, 0x6d, 0xf4, 0xa7, 0xff, 0x04, 0x00, 0x00, 0xff, 0xff, 0xe7, 0xa9, 0x08, 0x9a, 0x0c,
-	0x0a, 0x00, 0x00,
+	// 1028 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xcc, 0x56, 0x4f, 0x6f, 0xe3, 0x44,
+	0x14, 0xaf, 0x9d, 0xa6, 0xad, 0x5f, 0xda, 0xd4, 0x19, 0xed, 0x16, 0x2f, 0x54, 0x55, 0x65, 0x40,
+	0x2a, 0x2b, 0xe8, 0xa2, 0xc2, 0x05, 0x04, 0x87, 0x6c, 0xea, 0xa5, 0x59, 0x9a, 0x38, 0x1a, 0x07,
+	0xc1, 0x89, 0x32, 0x1b, 0x4f, 0x13, 0xb3, 0x8d, 0xc7, 0xcc, 0x4c, 0xab, 0xfd, 0x16, 0x1c, 0x41,
+	0xdc, 0xf6, 0x84, 0xf8, 0x26, 0x1c, 0xf9, 0x08, 0xa8, 0x7c, 0x11, 0x34, 0xe3, 0xf1, 0x9f, 0x24,
+	0x0b, 0x12, 0xdd, 0x0b, 0xa7, 0xcc, 0xef, 0xcd, 0x9b, 0xf7, 0x7e, 0xef, 0x37, 0x6f, 0x9e, 0x03,
+	0x6f, 0x09, 0xca, 0x6f, 0x28, 0x7f, 0x94, 0x71, 0x26, 0xd9, 0xa3, 0x24, 0x95, 0x94, 0xa7, 0xe4,
+	0xea, 0x58, 0x43, 0xd4, 0xd4, 0x3f, 0xfe, 0x7b, 0xd0, 0x8a, 0xb4, 0x57, 0x24, 0x89, 0xa4, 0xe8,
+	0x4d, 0xd8, 0xca, 0x0f, 0xf5, 0x4f, 0x3d, 0xeb, 0xd0, 0x3a, 0x72, 0x70, 0x89, 0xfd, 0x9f, 0x6c,
+	0xd8, 0xc4, 0xe4, 0x52, 0x9e, 0xb3, 0x29, 0x7a, 0x00, 0x36, 0xcb, 0xb4, 0x47, 0xfb, 0xc4, 0xc9,
+	0x23, 0x1e, 0x87, 0x19, 0xb6, 0x59, 0x86, 0x9e, 0x40, 0x67, 0xc2, 0x29, 0x91, 0x74, 0x44, 0xb8,
+	0x4c, 0x64, 0xc2, 0xd2, 0x30, 0xf3, 0xec, 0x43, 0xeb, 0xa8, 0x75, 0xe2, 0x19, 0xcf, 0xde, 0xf2,
+	0x3e, 0x5e, 0x3d, 0x82, 0x3e, 0x86, 0x96, 0x98, 0xf1, 0x24, 0x7d, 0xde, 0x8f, 0x70, 0x98, 0x79,
+	0x0d, 0x1d, 0x01, 0x99, 0x08, 0x51, 0xb5, 0x83, 0xeb, 0x6e, 0xe8, 0x73, 0x68, 0x4f, 0x66, 0x24,
+	0x9d, 0xd2, 0x73, 0x4a, 0x62, 0xca, 0xc3, 0xcc, 0x5b, 0xd7, 0x07, 0xef, 0x17, 0xa9, 0x17, 0x36,
+	0xf1, 0x92, 0xb3, 0x4a, 0x4a, 0x5f, 0x64, 0x24, 0x8d, 0xf3, 0xa4, 0xcd, 0x85, 0xa4, 0x41, 0xb5,
+	0x83, 0xeb, 0x6e, 0x7e, 0x0f, 0x3a, 0x2b, 0x25, 0xa1, 0x63, 0x70, 0xb2, 0x02, 0x6a, 0xa5, 0x5a,
+	0x27, 0xae, 0x09, 0x54, 0xba, 0xe1, 0xca, 0xc5, 0xff, 0xd5, 0x82, 0x56, 0xad, 0x2c, 0xb4, 0x07,
+	0x1b, 0x42, 0x72, 0x4a, 0xe6, 0xe6, 0x22, 0x0c, 0x42, 0xfb, 0xf5, 0xb8, 0x4a, 0xd7, 0x66, 0x2d,
+	0x0a, 0x3a, 0x82, 0x5d, 0x4e, 0xb3, 0xab, 0x64, 0x42, 0xc6, 0x0c, 0xd3, 0x39, 0xbb, 0xa1, 0x5a,
+	0x39, 0x07, 0x2f, 0x9b, 0x55, 0xfc, 0x2b, 0x5d, 0xb6, 0x56, 0xc8, 0xc1, 0x06, 0xa1, 0x43, 0x68,
+	0xe5, 0xab, 0x20, 0x63, 0x93, 0x99, 0x96, 0x60, 0x1d, 0xd7, 0x4d, 0xfe, 0x4b, 0x0b, 0x5a, 0x35,
+	0x2d, 0xee, 0xc8, 0xd4, 0x87, 0xed, 0x92, 0x52, 0x37, 0x8e, 0x0d, 0xcd, 0x05, 0xdb, 0x6b, 0x70,
+	0xfc, 0xc5, 0x82, 0x36, 0xa6, 0x19, 0xe3, 0xb2, 0xbc, 0xdb, 0xbb, 0xd1, 0xf4, 0x60, 0xd3, 0x50,
+	0x32, 0x0c, 0x0b, 0xf8, 0x1a, 0xe4, 0xbe, 0x85, 0xf6, 0x62, 0x1f, 0xde, 0x91, 0x5b, 0xc5, 0xa0,
+	0x51, 0x67, 0xe0, 0xff, 0x68, 0x83, 0x33, 0xaa, 0x57, 0x20, 0xae, 0x9f, 0x7d, 0x4f, 0x27, 0xd2,
+	0x04, 0x2f, 0x60, 0x2d, 0xab, 0xbd, 0x90, 0xb5, 0x0d, 0x76, 0x92, 0x5f, 0x48, 0x13, 0xdb, 0x49,
+	0x8c, 0xee, 0x41, 0x73, 0xca, 0xd9, 0x75, 0x66, 0x0a, 0xcd, 0x01, 0x7a, 0x1f, 0x3a, 0x46, 0x0a,
+	0x95, 0xe6, 0x09, 0x99, 0x48, 0xc6, 0x75, 0xb5, 0x4d, 0xbc, 0xba, 0xa1, 0x26, 0x8b, 0x31, 0x0a,
+	0x6f, 0xe3, 0xb0, 0xa1, 0x26, 0x4b, 0x81, 0x6b, 0x75, 0x6c, 0x2e, 0x28, 0xe9, 0x42, 0x23, 0x11,
+	0xdc, 0xdb, 0xd2, 0xee, 0x6a, 0xb9, 0xac, 0xad, 0xb3, 0xa2, 0xad, 0xe2, 0x4a, 0xf5, 0x1e, 0xe8,
+	0xbd, 0x1c, 0xf8, 0x01, 0xec, 0xaa, 0xd1, 0xf5, 0x94, 0x25, 0x29, 0xa6, 0x3f, 0x5c, 0x53, 0xa1,
+	0x8b, 0x4f, 0x59, 0x4c, 0xcb, 0x41, 0x67, 0x90, 0x22, 0xaa, 0x56, 0xdd, 0x38, 0xe6, 0x46, 0x96,
+	0x12, 0xfb, 0x47, 0xe0, 0x56, 0x61, 0x44, 0xc6, 0x52, 0x41, 0x75, 0x42, 0xce, 0x19, 0x37, 0x61,
+	0x72, 0xe0, 0x9f, 0x82, 0x3b, 0xa0, 0x92, 0xc4, 0x44, 0x92, 0x28, 0x25, 0x99, 0x98, 0x31, 0x89,
+	0x3e, 0x04, 0x28, 0xef, 0x4e, 0x78, 0xd6, 0x61, 0xe3, 0x95, 0x23, 0xa1, 0xe6, 0xe3, 0x3f, 0x05,
+	0x84, 0x2b, 0x25, 0x0b, 0xe6, 0xfb, 0xe0, 0x18, 0xe9, 0x4a, 0xf2, 0x95, 0x41, 0xd5, 0xc5, 0x2e,
+	0x2f, 0x05, 0x95, 0x9a, 0x7d, 0x03, 0x1b, 0xe4, 0x7f, 0x06, 0xde, 0x79, 0xa5, 0x53, 0xa8, 0x8d,
+	0x45, 0xc4, 0x25, 0x59, 0xad, 0xd5, 0x96, 0xfd, 0x04, 0x1e, 0xbc, 0xe2, 0xb4, 0x91, 0x60, 0x1f,
+	0x1c, 0x9a, 0xc6, 0xb9, 0x51, 0x1f, 0x6e, 0xe0, 0xca, 0xe0, 0xbf, 0xb4, 0xa1, 0x33, 0xe2, 0x2c,
+	0x23, 0x53, 0x22, 0x69, 0x5c, 0xa4, 0xfc, 0x3f, 0x7f, 0x41, 0xf8, 0xc2, 0xe0, 0x58, 0xfa, 0x82,
+	0x2c, 0x4e, 0x15, 0xbc, 0xe4, 0x7c, 0xc7, 0x2f, 0xc8, 0x07, 0xd0, 0x0c, 0x54, 0xdf, 0x20, 0x04,
+	0xeb, 0x13, 0x16, 0x53, 0x2d, 0xcc, 0x0e, 0xd6, 0x6b, 0xf5, 0x0c, 0xe6, 0x62, 0x6a, 0x9a, 0x51,
+	0x2d, 0xfd, 0x08, 0x50, 0x5d, 0x51, 0x73, 0x0d, 0xff, 0x22, 0xa9, 0x5f, 0x34, 0x69, 0x2e, 0xe3,
+	0x76, 0xc1, 0x47, 0xd9, 0x8a, 0x96, 0x7d, 0x1b, 0x3a, 0xf9, 0x5f, 0x81, 0x7e, 0x7a, 0xc9, 0x8a,
+	0x6b, 0xca, 0x47, 0x41, 0xde, 0x64, 0x76, 0x12, 0xfb, 0xe7, 0x80, 0xea, 0x4e, 0x26, 0xf3, 0x92,
+	0x97, 0xaa, 0x62, 0xc6, 0x84, 0x34, 0x94, 0xf5, 0x5a, 0xd9, 0x94, 0x50, 0x66, 0xac, 0xe8, 0xb5,
+	0x3f, 0x84, 0xbd, 0xf2, 0xc2, 0xd4, 0x1f, 0x90, 0x6b, 0x51, 0x7b, 0x9d, 0xff, 0x7d, 0x20, 0xfa,
+	0x03, 0x78, 0x63, 0x25, 0x9e, 0xa1, 0xb8, 0x07, 0x1b, 0xf4, 0x45, 0x22, 0xa4, 0xd0, 0x01, 0xb7,
+	0xb0, 0x41, 0xea, 0xb9, 0x27, 0x22, 0xbf, 0x3d, 0x1d, 0x6f, 0x0b, 0x97, 0xd8, 0x1f, 0xc0, 0xfd,
+	0x32, 0xdc, 0x90, 0xc9, 0xe4, 0xd2, 0x3c, 0xc4, 0x3b, 0xb2, 0xfb, 0xcd, 0x82, 0x8d, 0xde, 0x35,
+	0x17, 0x8c, 0xab, 0x99, 0x7c, 0x43, 0xb9, 0x28, 0xfe, 0x1a, 0xec, 0xe0, 0x02, 0xfe, 0xd3, 0xf3,
+	0x55, 0x4f, 0x54, 0xd0, 0xe9, 0x9c, 0xa6, 0xf2, 0x31, 0x11, 0xf9, 0x47, 0xbd, 0x81, 0xeb, 0x26,
+	0xf4, 0x0e, 0xec, 0xcc, 0x92, 0xe9, 0xec, 0x6b, 0x22, 0x29, 0x9f, 0x13, 0xfe, 0x5c, 0xf7, 0x6d,
+	0x03, 0x2f, 0x1a, 0xd1, 0xbb, 0xb0, 0x3e, 0x57, 0x0d, 0xd6, 0xd4, 0x6d, 0xd2, 0x29, 0x9b, 0x5a,
+	0x15, 0x3c, 0x60, 0x31, 0xc5, 0x7a, 0xfb, 0xe1, 0x77, 0x60, 0x87, 0x19, 0xba, 0x07, 0x6e, 0x0f,
+	0x07, 0xdd, 0x71, 0x70, 0x31, 0xea, 0xe2, 0x71, 0x7f, 0xdc, 0x0f, 0x87, 0xee, 0x1a, 0x6a, 0x03,
+	0x44, 0x67, 0xb8, 0x3f, 0xfc, 0xf2, 0xa2, 0x1f, 0x61, 0xd7, 0x42, 0x1d, 0xd8, 0xc1, 0xc1, 0x28,
+	0xc4, 0xe3, 0x8b, 0xf3, 0xa0, 0x7b, 0x1a, 0x60, 0xd7, 0x56, 0xa6, 0xde, 0x59, 0x77, 0xf8, 0x45,
+	0x50, 0x98, 0x1a, 0xea, 0x54, 0xf0, 0xcd, 0xa8, 0x3b, 0x3c, 0xd5, 0xa7, 0xd6, 0x1f, 0x7e, 0x0a,
+	0x50, 0x65, 0x45, 0x3b, 0xe0, 0xf4, 0xc2, 0xc1, 0xa0, 0x3f, 0x1e, 0x07, 0xa7, 0xee, 0x1a, 0xda,
+	0x85, 0xd6, 0x57, 0xc3, 0xca, 0x60, 0xa1, 0x6d, 0xd8, 0x8a, 0x86, 0xdd, 0x51, 0x74, 0x16, 0x8e,
+	0x5d, 0xfb, 0xb1, 0xfb, 0xfb, 0xed, 0x81, 0xf5, 0xc7, 0xed, 0x81, 0xf5, 0xe7, 0xed, 0x81, 0xf5,
+	0xf3, 0x5f, 0x07, 0x6b, 0xcf, 0x36, 0x74, 0x1d, 0x1f, 0xfd, 0x3d, 0x00, 0x6c, 0x98, 0x9c, 0x64,
+	0xf4, 0x0a, 0x00, 0x00,
 }
@@ -108,16 +108,21 @@ func (r *replicator) start(stop <-chan struct{}) {
 			continue
 		}
 
-		// Create a log reader starting at the requested offset.
+		// Create a log reader starting at the requested offset, or the
+		// earliest offset if the replica is behind the retained log.
 		ctx, cancel := context.WithCancel(context.Background())
 		defer cancel()
 
-		reader, err := r.partition.log.NewReader(req.Offset+1, true)
+		start := req.Offset + 1
+		if start < earliest {
+			start = earliest
+		}
+		reader, err := r.partition.log.NewReader(start, true)
 		if err != nil {
 			r.partition.srv.logger.Errorf(
 				"Failed to create replication reader for partition %s "+
 					"and replica %s (requested offset %d, earliest %d, latest %d): %v",
-				r.partition, r.replica, req.Offset+1, earliest, latest, err)
+				r.partition, r.replica, start, earliest, latest, err)
 			// Send a response to short-circuit request timeout.
 			if err := r.sendHW(req.request); err != nil {
 				r.partition.srv.logger.Errorf("Failed to send HW for partition %s to replica %s: %v",